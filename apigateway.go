@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigateway"
 	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -42,16 +44,103 @@ const (
 	OutputAPIGatewayURL = "APIGatewayURL"
 )
 
-func corsMethodResponseParams(api *API) map[string]bool {
+// corsHeaders returns the CORS headers for the API. User defined Headers take
+// precedence over the AllowOrigins, AllowMethods, AllowHeaders, and MaxAge
+// values, which default to the defaultCORSHeaders.
+func corsHeaders(api *API) map[string]interface{} {
+	if api == nil || api.CORSOptions == nil {
+		return defaultCORSHeaders
+	}
+	corsOptions := api.CORSOptions
+	if len(corsOptions.Headers) != 0 {
+		return corsOptions.Headers
+	}
+	headers := make(map[string]interface{}, len(defaultCORSHeaders))
+	for eachHeader, eachValue := range defaultCORSHeaders {
+		headers[eachHeader] = eachValue
+	}
+	if len(corsOptions.AllowOrigins) != 0 {
+		// Multiple origins are echoed by corsOriginOverride
+		headers["Access-Control-Allow-Origin"] = corsOptions.AllowOrigins[0]
+	}
+	if len(corsOptions.AllowOrigins) > 1 {
+		// The response depends on the request Origin, so caches
+		// must not share it across origins
+		headers["Vary"] = "Origin"
+	}
+	if len(corsOptions.AllowMethods) != 0 {
+		headers["Access-Control-Allow-Methods"] = strings.Join(corsOptions.AllowMethods, ",")
+	}
+	if len(corsOptions.AllowHeaders) != 0 {
+		headers["Access-Control-Allow-Headers"] = strings.Join(corsOptions.AllowHeaders, ",")
+	}
+	if corsOptions.MaxAge > 0 {
+		headers["Access-Control-Max-Age"] = strconv.FormatInt(corsOptions.MaxAge, 10)
+	}
+	return headers
+}
+
+// corsOriginOverride returns the VTL that sets the Access-Control-Allow-Origin
+// response header to the request Origin iff it's one of multiple AllowOrigins.
+// The header only supports a single origin, so this is the only way to
+// allow more than one.
+func corsOriginOverride(api *API) string {
+	if api == nil ||
+		api.CORSOptions == nil ||
+		len(api.CORSOptions.Headers) != 0 ||
+		len(api.CORSOptions.AllowOrigins) <= 1 {
+		return ""
+	}
+	originTests := make([]string, 0, len(api.CORSOptions.AllowOrigins))
+	for _, eachOrigin := range api.CORSOptions.AllowOrigins {
+		originTests = append(originTests, fmt.Sprintf(`$origin == "%s"`, eachOrigin))
+	}
+	return fmt.Sprintf(`#set($origin = $input.params().header.get("Origin"))
+#if($origin == "")#set($origin = $input.params().header.get("origin"))#end
+#if(%s)#set($context.responseOverride.header.Access-Control-Allow-Origin = $origin)#end
+`, strings.Join(originTests, " || "))
+}
 
-	var userDefinedHeaders map[string]interface{}
-	if api != nil &&
-		api.CORSOptions != nil {
-		userDefinedHeaders = api.CORSOptions.Headers
+// corsResponseTemplates returns the integration response templates
+// including the corsOriginOverride, if any
+func corsResponseTemplates(api *API, templates map[string]string) map[string]string {
+	originOverride := corsOriginOverride(api)
+	if originOverride == "" || len(templates) == 0 {
+		return templates
 	}
-	if len(userDefinedHeaders) <= 0 {
-		userDefinedHeaders = defaultCORSHeaders
+	overrideTemplates := make(map[string]string, len(templates))
+	for eachContentType, eachTemplate := range templates {
+		overrideTemplates[eachContentType] = originOverride + eachTemplate
 	}
+	return overrideTemplates
+}
+
+// validateCORSOptions ensures the AllowOrigins can be represented
+// by the Access-Control-Allow-Origin header
+func validateCORSOptions(api *API) error {
+	if api.CORSOptions == nil {
+		return nil
+	}
+	for _, eachOrigin := range api.CORSOptions.AllowOrigins {
+		if eachOrigin == "" {
+			return errors.New("CORSOptions.AllowOrigins must not include an empty origin")
+		}
+		if eachOrigin == "*" && len(api.CORSOptions.AllowOrigins) != 1 {
+			return errors.New("CORSOptions.AllowOrigins wildcard (*) cannot be combined with other origins")
+		}
+		if strings.ContainsAny(eachOrigin, `"'$#`) {
+			return errors.Errorf("CORSOptions.AllowOrigins origin %s contains invalid characters", eachOrigin)
+		}
+	}
+	if api.CORSOptions.MaxAge < 0 {
+		return errors.Errorf("CORSOptions.MaxAge (%d) must not be negative", api.CORSOptions.MaxAge)
+	}
+	return nil
+}
+
+func corsMethodResponseParams(api *API) map[string]bool {
+
+	userDefinedHeaders := corsHeaders(api)
 	responseParams := make(map[string]bool)
 	for eachHeader := range userDefinedHeaders {
 		keyName := fmt.Sprintf("method.response.header.%s", eachHeader)
@@ -62,14 +151,7 @@ func corsMethodResponseParams(api *API) map[string]bool {
 
 func corsIntegrationResponseParams(api *API) map[string]interface{} {
 
-	var userDefinedHeaders map[string]interface{}
-	if api != nil &&
-		api.CORSOptions != nil {
-		userDefinedHeaders = api.CORSOptions.Headers
-	}
-	if len(userDefinedHeaders) <= 0 {
-		userDefinedHeaders = defaultCORSHeaders
-	}
+	userDefinedHeaders := corsHeaders(api)
 	responseParams := make(map[string]interface{})
 	for eachHeader, eachHeaderValue := range userDefinedHeaders {
 		keyName := fmt.Sprintf("method.response.header.%s", eachHeader)
@@ -122,14 +204,16 @@ func integrationResponses(api *API, userResponses map[int]*IntegrationResponse,
 	// We've already populated this entire map in the NewMethod call
 	for eachHTTPStatusCode, eachMethodIntegrationResponse := range userResponses {
 		responseParameters := eachMethodIntegrationResponse.Parameters
+		responseTemplates := eachMethodIntegrationResponse.Templates
 		if corsEnabled {
 			for eachKey, eachValue := range corsIntegrationResponseParams(api) {
 				responseParameters[eachKey] = eachValue
 			}
+			responseTemplates = corsResponseTemplates(api, responseTemplates)
 		}
 
 		integrationResponse := gocf.APIGatewayMethodIntegrationResponse{
-			ResponseTemplates: eachMethodIntegrationResponse.Templates,
+			ResponseTemplates: responseTemplates,
 			SelectionPattern:  gocf.String(eachMethodIntegrationResponse.SelectionPattern),
 			StatusCode:        gocf.String(strconv.Itoa(eachHTTPStatusCode)),
 		}
@@ -142,6 +226,76 @@ func integrationResponses(api *API, userResponses map[int]*IntegrationResponse,
 	return &integrationResponses
 }
 
+// binaryMediaTypeMatches returns true if the contentType is included
+// in the mediaType, which may be a wildcard (eg, image/*)
+func binaryMediaTypeMatches(mediaType string, contentType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	contentType = strings.ToLower(contentType)
+	if mediaType == "*/*" || mediaType == contentType {
+		return true
+	}
+	if strings.HasSuffix(mediaType, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(mediaType, "*"))
+	}
+	return false
+}
+
+// validateBinaryMediaTypes ensures that every binary Content-Type
+// returned by a method is declared in the API's BinaryMediaTypes.
+// Otherwise API Gateway returns the base64 encoded body as text and the
+// response is corrupted.
+func validateBinaryMediaTypes(api *API) error {
+	var errorText []string
+	for _, eachResource := range api.resources {
+		for eachMethodName, eachMethod := range eachResource.Methods {
+			for _, eachContentType := range eachMethod.BinaryResponseContentTypes {
+				matched := false
+				for _, eachMediaType := range api.BinaryMediaTypes {
+					if binaryMediaTypeMatches(eachMediaType, eachContentType) {
+						matched = true
+						break
+					}
+				}
+				if !matched {
+					errorText = append(errorText,
+						fmt.Sprintf("Method %s %s returns binary Content-Type %s that isn't included in the API BinaryMediaTypes",
+							eachMethodName,
+							eachResource.pathPart,
+							eachContentType))
+				}
+			}
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// binaryIntegrationResponses updates the integration responses to decode
+// the base64 encoded body returned by the function. The default output
+// mapping returns the body as a quoted JSON value, which
+// isn't valid base64.
+func binaryIntegrationResponses(integrationResponses *gocf.APIGatewayMethodIntegrationResponseList) {
+	for eachIndex := range *integrationResponses {
+		integrationResponse := &(*integrationResponses)[eachIndex]
+		integrationResponse.ContentHandling = gocf.String("CONVERT_TO_BINARY")
+		responseTemplates, responseTemplatesOk := integrationResponse.ResponseTemplates.(map[string]string)
+		if !responseTemplatesOk {
+			continue
+		}
+		binaryTemplates := make(map[string]string)
+		for eachContentType, eachTemplate := range responseTemplates {
+			binaryTemplates[eachContentType] = strings.Replace(eachTemplate,
+				"$input.json('$.body')",
+				"$input.path('$.body')",
+				1)
+		}
+		integrationResponse.ResponseTemplates = binaryTemplates
+	}
+}
+
 func methodRequestTemplates(method *Method) (map[string]string, error) {
 	supportedTemplates := map[string]string{
 		"application/json":                  _escFSMustString(false, "/resources/provision/apigateway/inputmapping_json.vtl"),
@@ -172,10 +326,10 @@ func corsOptionsGatewayMethod(api *API, restAPIID gocf.Stringable, resourceID go
 	}
 
 	integrationResponse := gocf.APIGatewayMethodIntegrationResponse{
-		ResponseTemplates: map[string]string{
+		ResponseTemplates: corsResponseTemplates(api, map[string]string{
 			"application/*": "",
 			"text/*":        "",
-		},
+		}),
 		StatusCode:         gocf.String("200"),
 		ResponseParameters: corsIntegrationResponseParams(api),
 	}
@@ -427,6 +581,13 @@ type Method struct {
 
 	// Integration response map
 	Integration Integration
+
+	// BinaryResponseContentTypes are the binary Content-Types (eg, image/png)
+	// returned by the method. The handler must return the body as a
+	// base64 encoded string (see apigateway.NewBinaryResponse), which
+	// API Gateway decodes. Each value must be included in the API's
+	// BinaryMediaTypes.
+	BinaryResponseContentTypes []string
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -459,7 +620,20 @@ type CORSOptions struct {
 	// Headers represent the CORS headers that should be used for an OPTIONS
 	// preflight request. These should be of the form key-value as in:
 	// "Access-Control-Allow-Headers"="Content-Type,X-Amz-Date,Authorization,X-Api-Key"
+	// If non-empty, Headers supersedes the Allow* and MaxAge values.
 	Headers map[string]interface{}
+	// AllowOrigins are the origins (eg, https://example.com) allowed to
+	// make cross origin requests. Defaults to "*". If there is more than one
+	// origin, the response includes the request Origin iff it's allowed.
+	AllowOrigins []string
+	// AllowMethods are the HTTP methods allowed in cross origin requests.
+	// Defaults to "*".
+	AllowMethods []string
+	// AllowHeaders are the request headers allowed in cross origin requests.
+	// Defaults to Content-Type,X-Amz-Date,Authorization,X-Api-Key.
+	AllowHeaders []string
+	// MaxAge is the number of seconds the preflight response may be cached
+	MaxAge int64
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -486,6 +660,27 @@ type API struct {
 	CORSOptions *CORSOptions
 	// Endpoint configuration information
 	EndpointConfiguration *gocf.APIGatewayRestAPIEndpointConfiguration
+	// BinaryMediaTypes are the media types (eg, image/png, image/*)
+	// that API Gateway treats as binary payloads
+	BinaryMediaTypes []string
+	// Tags are applied to the RestApi and to the Stage when it's created.
+	// They are merged with the service-level WorkflowHooks.StackTags
+	// and take precedence over them.
+	Tags map[string]string
+	// Service-level tags that are inherited by the API
+	serviceTags map[string]string
+}
+
+// apiTags returns the merged service and API tags
+func (api *API) apiTags() map[string]string {
+	tags := make(map[string]string)
+	for eachKey, eachValue := range api.serviceTags {
+		tags[eachKey] = eachValue
+	}
+	for eachKey, eachValue := range api.Tags {
+		tags[eachKey] = eachValue
+	}
+	return tags
 }
 
 // LogicalResourceName returns the CloudFormation logical
@@ -583,6 +778,25 @@ func (api *API) Marshal(serviceName string,
 	} else {
 		apiGatewayRes.Description = gocf.String(api.Description)
 	}
+	binaryMediaTypesErr := validateBinaryMediaTypes(api)
+	if binaryMediaTypesErr != nil {
+		return binaryMediaTypesErr
+	}
+	corsErr := validateCORSOptions(api)
+	if corsErr != nil {
+		return corsErr
+	}
+	if len(api.BinaryMediaTypes) != 0 {
+		binaryMediaTypes := make([]gocf.Stringable, 0, len(api.BinaryMediaTypes))
+		for _, eachMediaType := range api.BinaryMediaTypes {
+			binaryMediaTypes = append(binaryMediaTypes, gocf.String(eachMediaType))
+		}
+		apiGatewayRes.BinaryMediaTypes = gocf.StringList(binaryMediaTypes...)
+	}
+	apiTags := api.apiTags()
+	if len(apiTags) != 0 {
+		apiGatewayRes.Tags = templateTagList(apiTags)
+	}
 	apiGatewayResName := api.LogicalResourceName()
 	// Is there an endpoint type?
 	if api.EndpointConfiguration != nil {
@@ -690,6 +904,9 @@ func (api *API) Marshal(serviceName string,
 			apiGatewayMethod.Integration.IntegrationResponses = integrationResponses(api,
 				eachMethodDef.Integration.Responses,
 				api.corsEnabled())
+			if len(eachMethodDef.BinaryResponseContentTypes) != 0 {
+				binaryIntegrationResponses(apiGatewayMethod.Integration.IntegrationResponses)
+			}
 
 			// Add outbound method responses
 			apiGatewayMethod.MethodResponses = methodResponses(api,
@@ -737,6 +954,9 @@ func (api *API) Marshal(serviceName string,
 				apiDeployment.StageDescription.CacheClusterSize =
 					gocf.String(api.stage.CacheClusterSize)
 			}
+			if len(apiTags) != 0 {
+				apiDeployment.StageDescription.Tags = templateTagList(apiTags)
+			}
 			deployment := template.AddResource(apiDeploymentResName, apiDeployment)
 			deployment.DependsOn = append(deployment.DependsOn, apiMethodCloudFormationResources...)
 			deployment.DependsOn = append(deployment.DependsOn, apiGatewayResName)
@@ -2,13 +2,17 @@ package sparta
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	awsLambdaEvents "github.com/aws/aws-lambda-go/events"
 	spartaAPIGateway "github.com/mweagle/Sparta/aws/apigateway"
 	spartaAWSEvents "github.com/mweagle/Sparta/aws/events"
+	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/sirupsen/logrus"
 )
 
@@ -104,3 +108,344 @@ func TestAPIV2Gateway(t *testing.T) {
 		false,
 		nil)
 }
+
+func TestAPIGatewayTags(t *testing.T) {
+	api := NewAPIGateway("SpartaTagsAPI", nil)
+	api.serviceTags = map[string]string{
+		"team":       "platform",
+		"costCenter": "shared",
+	}
+	api.Tags = map[string]string{
+		"costCenter": "api",
+	}
+	template := gocf.NewTemplate()
+	marshalErr := api.Marshal("SpartaTagsService",
+		nil,
+		"",
+		"",
+		"",
+		nil,
+		template,
+		true,
+		logrus.New())
+	if marshalErr != nil {
+		t.Fatalf("Failed to marshal API: %s", marshalErr)
+	}
+	restAPI, restAPIOk := template.Resources[api.LogicalResourceName()].Properties.(*gocf.APIGatewayRestAPI)
+	if !restAPIOk || restAPI.Tags == nil {
+		t.Fatalf("Expected tagged RestApi resource")
+	}
+	tags := make(map[string]string)
+	for _, eachTag := range *restAPI.Tags {
+		tags[eachTag.Key.Literal] = eachTag.Value.Literal
+	}
+	if len(tags) != 2 || tags["costCenter"] != "api" || tags["team"] != "platform" {
+		t.Fatalf("Unexpected RestApi tags: %#v", tags)
+	}
+}
+
+func TestAPIGatewayBinaryMediaTypes(t *testing.T) {
+	apiGateway := NewAPIGateway("SpartaBinaryAPIGateway", nil)
+	lambdaFn, _ := NewAWSLambda(LambdaName(mockLambda1),
+		mockLambda1,
+		IAMRoleDefinition{})
+	apiGatewayResource, _ := apiGateway.NewResource("/image", lambdaFn)
+	method, _ := apiGatewayResource.NewMethod("GET", http.StatusOK)
+	method.BinaryResponseContentTypes = []string{"image/png"}
+
+	marshalAPI := func() (*gocf.Template, error) {
+		template := gocf.NewTemplate()
+		marshalErr := apiGateway.Marshal("SpartaBinaryService",
+			nil,
+			"",
+			"",
+			"",
+			nil,
+			template,
+			true,
+			logrus.New())
+		return template, marshalErr
+	}
+	_, marshalErr := marshalAPI()
+	if marshalErr == nil {
+		t.Fatalf("Failed to reject binary Content-Type without BinaryMediaTypes")
+	}
+	t.Logf("Expected error: %s", marshalErr)
+
+	apiGateway.BinaryMediaTypes = []string{"image/*"}
+	template, marshalErr := marshalAPI()
+	if marshalErr != nil {
+		t.Fatalf("Failed to marshal binary API: %s", marshalErr)
+	}
+	for _, eachResource := range template.Resources {
+		apiMethod, apiMethodOk := eachResource.Properties.(*gocf.APIGatewayMethod)
+		if !apiMethodOk {
+			continue
+		}
+		for _, eachResponse := range *apiMethod.Integration.IntegrationResponses {
+			if eachResponse.ContentHandling == nil ||
+				eachResponse.ContentHandling.Literal != "CONVERT_TO_BINARY" {
+				t.Fatalf("Expected CONVERT_TO_BINARY integration response content handling")
+			}
+		}
+	}
+}
+
+func TestAPIV2HTTPGateway(t *testing.T) {
+	stage, _ := NewAPIV2Stage("v1")
+	apiGateway, _ := NewAPIV2(HTTP,
+		"sample",
+		"",
+		stage)
+	lambdaFn, _ := NewAWSLambda("helloHTTP",
+		func(ctx context.Context,
+			request awsLambdaEvents.APIGatewayV2HTTPRequest) (awsLambdaEvents.APIGatewayV2HTTPResponse, error) {
+			return awsLambdaEvents.APIGatewayV2HTTPResponse{StatusCode: http.StatusOK}, nil
+		},
+		IAMRoleDefinition{})
+	_, routeErr := apiGateway.NewAPIV2HTTPRoute("get", "/hello/{name}", lambdaFn)
+	if routeErr != nil {
+		t.Fatalf("Failed to create HTTP route: %s", routeErr)
+	}
+	if _, invalidErr := apiGateway.NewAPIV2HTTPRoute("FETCH", "/hello", lambdaFn); invalidErr == nil {
+		t.Fatalf("Failed to reject invalid HTTP route method")
+	}
+	if _, invalidErr := apiGateway.NewAPIV2HTTPRoute("GET", "hello", lambdaFn); invalidErr == nil {
+		t.Fatalf("Failed to reject invalid HTTP route path")
+	}
+	if signatureErrs := apiGateway.validateProxySignatures(); len(signatureErrs) != 0 {
+		t.Fatalf("Unexpected proxy signature errors: %v", signatureErrs)
+	}
+	template := gocf.NewTemplate()
+	marshalErr := apiGateway.Marshal("TestAPIV2HTTPGateway",
+		nil,
+		"testBucket",
+		"testKey",
+		"",
+		nil,
+		template,
+		true,
+		logrus.New())
+	if marshalErr != nil {
+		t.Fatalf("Failed to marshal HTTP API: %s", marshalErr)
+	}
+	templateJSON, templateJSONErr := json.Marshal(template)
+	if templateJSONErr != nil {
+		t.Fatalf("Failed to marshal template: %s", templateJSONErr)
+	}
+	for _, eachExpected := range []string{`"ProtocolType":"HTTP"`,
+		`"RouteKey":"GET /hello/{name}"`,
+		`"PayloadFormatVersion":"2.0"`,
+		`"https://"`} {
+		if !strings.Contains(string(templateJSON), eachExpected) {
+			t.Fatalf("Expected %s in HTTP API template: %s", eachExpected, templateJSON)
+		}
+	}
+	if strings.Contains(string(templateJSON), "RouteSelectionExpression") {
+		t.Fatalf("Unexpected RouteSelectionExpression in HTTP API template: %s", templateJSON)
+	}
+
+	websocketAPI, _ := NewAPIV2(Websocket, "sample", "$request.body.message", stage)
+	if _, websocketErr := websocketAPI.NewAPIV2HTTPRoute("GET", "/hello", lambdaFn); websocketErr == nil {
+		t.Fatalf("Failed to reject HTTP route for WebSocket API")
+	}
+}
+
+func TestAPIGatewayCORSOptions(t *testing.T) {
+	api := NewAPIGateway("SpartaCORSAPI", nil)
+	api.CORSOptions = &CORSOptions{
+		AllowOrigins: []string{"https://app.example.com", "https://admin.example.com"},
+		AllowMethods: []string{"GET", "POST"},
+		AllowHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:       600,
+	}
+	lambdaFn, _ := NewAWSLambda(LambdaName(mockLambda1),
+		mockLambda1,
+		IAMRoleDefinition{})
+	apiResource, _ := api.NewResource("/hello", lambdaFn)
+	_, methodErr := apiResource.NewMethod("GET", http.StatusOK)
+	if methodErr != nil {
+		t.Fatalf("Failed to create method: %s", methodErr)
+	}
+	template := gocf.NewTemplate()
+	marshalErr := api.Marshal("SpartaCORSService",
+		nil,
+		"",
+		"",
+		"",
+		nil,
+		template,
+		true,
+		logrus.New())
+	if marshalErr != nil {
+		t.Fatalf("Failed to marshal API: %s", marshalErr)
+	}
+	var optionsMethod *gocf.APIGatewayMethod
+	for _, eachResource := range template.Resources {
+		method, methodOk := eachResource.Properties.(*gocf.APIGatewayMethod)
+		if methodOk && method.HTTPMethod.Literal == "OPTIONS" {
+			optionsMethod = method
+		}
+	}
+	if optionsMethod == nil {
+		t.Fatalf("Expected OPTIONS method resource")
+	}
+	methodJSON, methodJSONErr := json.Marshal(optionsMethod)
+	if methodJSONErr != nil {
+		t.Fatalf("Failed to marshal OPTIONS method: %s", methodJSONErr)
+	}
+	for _, eachExpected := range []string{`"Type":"MOCK"`,
+		`"method.response.header.Access-Control-Allow-Origin":true`,
+		`"method.response.header.Access-Control-Allow-Origin":"'https://app.example.com'"`,
+		`"method.response.header.Access-Control-Allow-Methods":"'GET,POST'"`,
+		`"method.response.header.Access-Control-Allow-Headers":"'Content-Type,Authorization'"`,
+		`"method.response.header.Access-Control-Max-Age":"'600'"`,
+		`"method.response.header.Vary":"'Origin'"`,
+		`$origin == \"https://admin.example.com\"`} {
+		if !strings.Contains(string(methodJSON), eachExpected) {
+			t.Fatalf("Expected %s in OPTIONS method: %s", eachExpected, methodJSON)
+		}
+	}
+
+	api.CORSOptions.AllowOrigins = []string{"https://app.example.com"}
+	if _, varyExists := corsHeaders(api)["Vary"]; varyExists {
+		t.Fatalf("Expected Vary header only for multiple AllowOrigins")
+	}
+	api.CORSOptions.AllowOrigins = []string{"*", "https://app.example.com"}
+	if marshalErr := api.Marshal("SpartaCORSService",
+		nil,
+		"",
+		"",
+		"",
+		nil,
+		gocf.NewTemplate(),
+		true,
+		logrus.New()); marshalErr == nil {
+		t.Fatalf("Failed to reject wildcard combined with other origins")
+	}
+}
+
+func TestAPIV2WebsocketRoutes(t *testing.T) {
+	stage, _ := NewAPIV2Stage("v1")
+	apiGateway, _ := NewAPIV2(Websocket,
+		"sample",
+		"$request.body.action",
+		stage)
+	connectionFn, _ := NewAWSLambda(LambdaName(mockLambda1),
+		mockLambda1,
+		IAMRoleDefinition{})
+	messageFn, _ := NewAWSLambda(LambdaName(mockLambda2),
+		mockLambda2,
+		IAMRoleDefinition{})
+	for _, eachRoute := range []struct {
+		routeKey APIV2RouteSelectionExpression
+		lambdaFn *LambdaAWSInfo
+	}{
+		{WebsocketRouteConnect, connectionFn},
+		{WebsocketRouteDisconnect, connectionFn},
+		{WebsocketRouteDefault, messageFn},
+		{"sendmessage", messageFn},
+	} {
+		if _, routeErr := apiGateway.NewAPIV2Route(eachRoute.routeKey, eachRoute.lambdaFn); routeErr != nil {
+			t.Fatalf("Failed to create route %s: %s", eachRoute.routeKey, routeErr)
+		}
+	}
+	for _, eachLambda := range []*LambdaAWSInfo{connectionFn, messageFn} {
+		manageConnectionsCount := 0
+		for _, eachPrivilege := range eachLambda.RoleDefinition.Privileges {
+			if strings.Join(eachPrivilege.Actions, ",") == "execute-api:ManageConnections" {
+				manageConnectionsCount++
+			}
+		}
+		if manageConnectionsCount != 1 {
+			t.Fatalf("Expected a single ManageConnections privilege for %s, got: %d",
+				eachLambda.lambdaFunctionName(),
+				manageConnectionsCount)
+		}
+	}
+	template := gocf.NewTemplate()
+	marshalErr := apiGateway.Marshal("TestAPIV2WebsocketRoutes",
+		nil,
+		"testBucket",
+		"testKey",
+		"",
+		nil,
+		template,
+		true,
+		logrus.New())
+	if marshalErr != nil {
+		t.Fatalf("Failed to marshal WebSocket API: %s", marshalErr)
+	}
+	templateJSON, templateJSONErr := json.Marshal(template)
+	if templateJSONErr != nil {
+		t.Fatalf("Failed to marshal template: %s", templateJSONErr)
+	}
+	for _, eachExpected := range []string{`"ProtocolType":"WEBSOCKET"`,
+		`"RouteKey":"$connect"`,
+		`"RouteKey":"$disconnect"`,
+		`"RouteKey":"$default"`,
+		`"RouteKey":"sendmessage"`,
+		`"wss://"`} {
+		if !strings.Contains(string(templateJSON), eachExpected) {
+			t.Fatalf("Expected %s in WebSocket API template: %s", eachExpected, templateJSON)
+		}
+	}
+}
+
+// wsResponse is the WebSocket response from the APIV2 Gateway documentation
+type wsResponse struct {
+	StatusCode int    `json:"statusCode"`
+	Body       string `json:"body"`
+}
+
+func connectWorld(ctx context.Context, request awsLambdaEvents.APIGatewayWebsocketProxyRequest) (*wsResponse, error) {
+	return &wsResponse{StatusCode: 200}, nil
+}
+
+func disconnectWorld(ctx context.Context, request awsLambdaEvents.APIGatewayWebsocketProxyRequest) error {
+	return nil
+}
+
+func TestAPIV2WebsocketSignatures(t *testing.T) {
+	newAPIV2 := func(protocol APIV2Protocol, handler interface{}) *APIV2 {
+		stage, _ := NewAPIV2Stage("v1")
+		apiGateway, _ := NewAPIV2(protocol,
+			"sample",
+			"$request.body.message",
+			stage)
+		lambdaFn, lambdaFnErr := NewAWSLambda(LambdaName(handler), handler, IAMRoleDefinition{})
+		if lambdaFnErr != nil {
+			t.Fatalf("Failed to create lambda function: %s", lambdaFnErr)
+		}
+		_, routeErr := apiGateway.NewAPIV2Route(WebsocketRouteConnect, lambdaFn)
+		if routeErr != nil {
+			t.Fatalf("Failed to create route: %s", routeErr)
+		}
+		return apiGateway
+	}
+	validHandlers := []interface{}{
+		connectWorld,
+		disconnectWorld,
+		func(ctx context.Context, request awsLambdaEvents.APIGatewayWebsocketProxyRequest) (wsResponse, error) {
+			return wsResponse{}, nil
+		},
+		func(ctx context.Context, request awsLambdaEvents.APIGatewayWebsocketProxyRequest) (awsLambdaEvents.APIGatewayProxyResponse, error) {
+			return awsLambdaEvents.APIGatewayProxyResponse{}, nil
+		},
+	}
+	for _, eachHandler := range validHandlers {
+		if signatureErrs := newAPIV2(Websocket, eachHandler).validateProxySignatures(); len(signatureErrs) != 0 {
+			t.Fatalf("Failed to accept WebSocket handler %T: %v", eachHandler, signatureErrs)
+		}
+	}
+	invalidHandler := func(ctx context.Context, request awsLambdaEvents.APIGatewayWebsocketProxyRequest) (string, error) {
+		return "", nil
+	}
+	if signatureErrs := newAPIV2(Websocket, invalidHandler).validateProxySignatures(); len(signatureErrs) == 0 {
+		t.Fatalf("Failed to reject WebSocket handler that returns a string")
+	}
+	// HTTP APIs still require a proxy response
+	if signatureErrs := newAPIV2(HTTP, connectWorld).validateProxySignatures(); len(signatureErrs) == 0 {
+		t.Fatalf("Failed to reject HTTP handler that returns a *wsResponse")
+	}
+}
@@ -2,6 +2,7 @@ package sparta
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	gocf "github.com/mweagle/go-cloudformation"
@@ -18,10 +19,36 @@ type APIV2RouteSelectionExpression string
 type APIV2Protocol string
 
 const (
-	// Websocket represents the only supported V2 protocol
+	// WebsocketRouteConnect is the route invoked when a client connects
+	// to a WebSocket API
+	WebsocketRouteConnect APIV2RouteSelectionExpression = "$connect"
+	// WebsocketRouteDisconnect is the route invoked when a client, or the
+	// server, disconnects from a WebSocket API
+	WebsocketRouteDisconnect APIV2RouteSelectionExpression = "$disconnect"
+	// WebsocketRouteDefault is the route invoked when the route selection
+	// expression doesn't match any other route
+	WebsocketRouteDefault APIV2RouteSelectionExpression = "$default"
+)
+
+const (
+	// Websocket represents a WebSocket API
 	Websocket APIV2Protocol = "WEBSOCKET"
+	// HTTP represents an HTTP API. HTTP APIs are lower cost and lower
+	// latency alternatives to REST APIs
+	HTTP APIV2Protocol = "HTTP"
 )
 
+// apiV2HTTPMethods are the methods that can be used in an
+// HTTP API route key
+var apiV2HTTPMethods = []string{"ANY",
+	"DELETE",
+	"GET",
+	"HEAD",
+	"OPTIONS",
+	"PATCH",
+	"POST",
+	"PUT"}
+
 // APIV2 contains the information necessary for the routes in here.
 // Please tell me they can use the same routes...
 // They cannot
@@ -167,7 +194,27 @@ func (apiv2 *APIV2) NewConnectionTableDecorator(envTableNameKey string,
 	}, nil
 }
 
-// NewAPIV2Route returns a new Route
+// manageConnectionsPrivilege returns the privilege that allows a function
+// to post messages to, and disconnect, the WebSocket API's clients
+func (apiv2 *APIV2) manageConnectionsPrivilege() IAMRolePrivilege {
+	return IAMRolePrivilege{
+		Actions: []string{"execute-api:ManageConnections"},
+		Resource: gocf.Join("",
+			gocf.String("arn:"),
+			gocf.Ref("AWS::Partition"),
+			gocf.String(":execute-api:"),
+			gocf.Ref("AWS::Region"),
+			gocf.String(":"),
+			gocf.Ref("AWS::AccountId"),
+			gocf.String(":"),
+			gocf.Ref(apiv2.LogicalResourceName()),
+			gocf.String("/*")),
+	}
+}
+
+// NewAPIV2Route returns a new Route. Functions attached to a WebSocket API
+// route with a Sparta-generated IAM role are granted the
+// execute-api:ManageConnections privilege for the API.
 func (apiv2 *APIV2) NewAPIV2Route(routeKey APIV2RouteSelectionExpression,
 	lambdaFn *LambdaAWSInfo) (*APIV2Route, error) {
 
@@ -176,6 +223,21 @@ func (apiv2 *APIV2) NewAPIV2Route(routeKey APIV2RouteSelectionExpression,
 		return nil, errors.Errorf("APIV2 Route for expression `%s` already exists",
 			routeKey)
 	}
+	if apiv2.protocol != HTTP &&
+		lambdaFn != nil &&
+		lambdaFn.RoleDefinition != nil {
+		privilegeExists := false
+		for _, eachRoute := range apiv2.routes {
+			if eachRoute.lambdaFn == lambdaFn {
+				privilegeExists = true
+				break
+			}
+		}
+		if !privilegeExists {
+			lambdaFn.RoleDefinition.Privileges = append(lambdaFn.RoleDefinition.Privileges,
+				apiv2.manageConnectionsPrivilege())
+		}
+	}
 	route := &APIV2Route{
 		routeKey: routeKey,
 		lambdaFn: lambdaFn,
@@ -187,6 +249,69 @@ func (apiv2 *APIV2) NewAPIV2Route(routeKey APIV2RouteSelectionExpression,
 	return route, nil
 }
 
+// NewAPIV2HTTPRoute returns a new Route for an HTTP API that maps the
+// method and path to the lambda function using a Lambda proxy
+// integration. The method may be ANY to match every method and the
+// path may include path parameters (eg, /hello/{name}) or a greedy
+// path variable (eg, /{proxy+}).
+func (apiv2 *APIV2) NewAPIV2HTTPRoute(method string,
+	path string,
+	lambdaFn *LambdaAWSInfo) (*APIV2Route, error) {
+
+	if apiv2.protocol != HTTP {
+		return nil, errors.Errorf("APIV2 HTTP routes require the %s protocol, not %s",
+			HTTP,
+			apiv2.protocol)
+	}
+	upperMethod := strings.ToUpper(method)
+	validMethod := false
+	for _, eachMethod := range apiV2HTTPMethods {
+		if eachMethod == upperMethod {
+			validMethod = true
+			break
+		}
+	}
+	if !validMethod {
+		return nil, errors.Errorf("APIV2 HTTP route method `%s` is invalid. Must be one of: %s",
+			method,
+			strings.Join(apiV2HTTPMethods, ", "))
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errors.Errorf("APIV2 HTTP route path `%s` must begin with /", path)
+	}
+	route, routeErr := apiv2.NewAPIV2Route(APIV2RouteSelectionExpression(fmt.Sprintf("%s %s", upperMethod, path)),
+		lambdaFn)
+	if routeErr != nil {
+		return nil, routeErr
+	}
+	route.Integration.PayloadFormatVersion = "2.0"
+	return route, nil
+}
+
+// validateProxySignatures ensures that the functions attached to
+// AWS_PROXY routes return a compatible proxy response. WEBSOCKET
+// routes also accept struct and error-only responses.
+func (apiv2 *APIV2) validateProxySignatures() []string {
+	validateSignature := ensureValidProxySignature
+	if apiv2.protocol == "" || apiv2.protocol == Websocket {
+		validateSignature = ensureValidWebsocketSignature
+	}
+	var errorText []string
+	for _, eachRoute := range apiv2.routes {
+		if eachRoute.lambdaFn == nil ||
+			eachRoute.Integration == nil ||
+			eachRoute.Integration.IntegrationType != "AWS_PROXY" {
+			continue
+		}
+		signatureErr := validateSignature(eachRoute.lambdaFn.userSuppliedFunctionName,
+			eachRoute.lambdaFn.handlerSymbol)
+		if signatureErr != nil {
+			errorText = append(errorText, signatureErr.Error())
+		}
+	}
+	return errorText
+}
+
 // LogicalResourceName returns the logical resoource name of this API V2 Gateway
 // instance
 func (apiv2 *APIV2) LogicalResourceName() string {
@@ -251,12 +376,16 @@ func (apiv2 *APIV2) Marshal(serviceName string,
 	noop bool,
 	logger *logrus.Logger) error {
 
+	protocol := apiv2.protocol
+	if protocol == "" {
+		protocol = Websocket
+	}
 	apiV2Entry := &gocf.APIGatewayV2API{
 		APIKeySelectionExpression: marshalString(apiv2.APIKeySelectionExpression),
 		Description:               marshalString(apiv2.Description),
 		DisableSchemaValidation:   marshalBool(apiv2.DisableSchemaValidation),
 		Name:                      marshalString(apiv2.name),
-		ProtocolType:              marshalString(string(Websocket)),
+		ProtocolType:              marshalString(string(protocol)),
 		RouteSelectionExpression:  marshalString(apiv2.routeSelectionExpression),
 		Version:                   marshalString(apiv2.Version),
 	}
@@ -307,7 +436,8 @@ func (apiv2 *APIV2) Marshal(serviceName string,
 				gocf.String(":lambda:path/2015-03-31/functions/"),
 				gocf.GetAtt(eachRoute.lambdaFn.LogicalResourceName(), "Arn"),
 				gocf.String("/invocations")),
-			PassthroughBehavior: marshalString(eachRoute.Integration.PassthroughBehavior),
+			PassthroughBehavior:  marshalString(eachRoute.Integration.PassthroughBehavior),
+			PayloadFormatVersion: marshalString(eachRoute.Integration.PayloadFormatVersion),
 			// TODO - auto create this...
 			RequestParameters:           marshalInterface(eachRoute.Integration.RequestParameters),
 			RequestTemplates:            marshalInterface(eachRoute.Integration.RequestTemplates),
@@ -359,10 +489,16 @@ func (apiv2 *APIV2) Marshal(serviceName string,
 	template.AddResource(stageResourceName, stageResource)
 
 	// Outputs...
+	urlScheme := "wss://"
+	urlDescription := "API Gateway Websocket URL"
+	if protocol == HTTP {
+		urlScheme = "https://"
+		urlDescription = "API Gateway HTTP API URL"
+	}
 	template.Outputs[OutputAPIGatewayURL] = &gocf.Output{
-		Description: "API Gateway Websocket URL",
+		Description: urlDescription,
 		Value: gocf.Join("",
-			gocf.String("wss://"),
+			gocf.String(urlScheme),
 			gocf.Ref(apiv2.LogicalResourceName()),
 			gocf.String(".execute-api."),
 			gocf.Ref("AWS::Region"),
@@ -372,7 +508,8 @@ func (apiv2 *APIV2) Marshal(serviceName string,
 	return nil
 }
 
-// NewAPIV2 returns a new API V2 Gateway instance
+// NewAPIV2 returns a new API V2 Gateway instance. HTTP APIs may use an empty
+// routeSelectionExpression, which defaults to `$request.method $request.path`.
 func NewAPIV2(protocol APIV2Protocol,
 	name string,
 	routeSelectionExpression string,
@@ -423,7 +560,8 @@ func NewAPIV2Stage(stageName string) (*APIV2Stage, error) {
 }
 
 // APIV2Integration is the integration type for an APIV2Route
-// entry
+// entry. The PayloadFormatVersion of routes created by NewAPIV2HTTPRoute
+// defaults to 2.0.
 type APIV2Integration struct {
 	//ApiID                       string
 	ConnectionType          string
@@ -434,6 +572,7 @@ type APIV2Integration struct {
 	IntegrationType         string
 	//IntegrationUri              string
 	PassthroughBehavior         string
+	PayloadFormatVersion        string
 	RequestParameters           interface{}
 	RequestTemplates            interface{}
 	TemplateSelectionExpression string
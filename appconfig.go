@@ -0,0 +1,115 @@
+package sparta
+
+import (
+	"fmt"
+	"os"
+
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+const (
+	// EnvVarAppConfigApplication is the AppConfig application identifier
+	// published into the function environment
+	EnvVarAppConfigApplication = "SPARTA_APPCONFIG_APPLICATION"
+	// EnvVarAppConfigEnvironment is the AppConfig environment identifier
+	// published into the function environment
+	EnvVarAppConfigEnvironment = "SPARTA_APPCONFIG_ENVIRONMENT"
+	// EnvVarAppConfigConfigurationProfile is the AppConfig configuration
+	// profile identifier published into the function environment
+	EnvVarAppConfigConfigurationProfile = "SPARTA_APPCONFIG_CONFIGURATION_PROFILE"
+	// envVarAppConfigPollInterval is the extension's polling interval
+	envVarAppConfigPollInterval = "AWS_APPCONFIG_EXTENSION_POLL_INTERVAL_SECONDS"
+	// envVarAppConfigHTTPPort is the extension's local HTTP port
+	envVarAppConfigHTTPPort = "AWS_APPCONFIG_EXTENSION_HTTP_PORT"
+	// appConfigDefaultHTTPPort is the default port for the extension
+	appConfigDefaultHTTPPort = "2772"
+)
+
+// AppConfigOptions configures the AWS AppConfig Lambda extension for
+// a function. The extension layer is added to the function, the profile
+// identifiers are published into the environment and the function's
+// IAM role is granted permission to read the configuration.
+// Ref: https://docs.aws.amazon.com/appconfig/latest/userguide/appconfig-integration-lambda-extensions.html
+type AppConfigOptions struct {
+	// LayerArn is the region specific ARN of the AWS AppConfig
+	// Lambda extension layer
+	LayerArn gocf.Stringable
+	// Application is the AppConfig application ID
+	Application gocf.Stringable
+	// Environment is the AppConfig environment ID
+	Environment gocf.Stringable
+	// ConfigurationProfile is the AppConfig configuration profile ID
+	ConfigurationProfile gocf.Stringable
+	// PollIntervalSeconds is the optional interval at which the extension
+	// polls AppConfig for updates. Zero uses the extension default.
+	PollIntervalSeconds int64
+}
+
+func (appConfig *AppConfigOptions) validate() error {
+	if appConfig.LayerArn == nil {
+		return fmt.Errorf("AppConfig LayerArn is required")
+	}
+	if appConfig.Application == nil ||
+		appConfig.Environment == nil ||
+		appConfig.ConfigurationProfile == nil {
+		return fmt.Errorf("AppConfig Application, Environment and ConfigurationProfile are required")
+	}
+	if appConfig.PollIntervalSeconds < 0 {
+		return fmt.Errorf("AppConfig PollIntervalSeconds must be non-negative")
+	}
+	return nil
+}
+
+// environment adds the AppConfig values to the function environment
+func (appConfig *AppConfigOptions) environment(env map[string]*gocf.StringExpr) {
+	env[EnvVarAppConfigApplication] = appConfig.Application.String()
+	env[EnvVarAppConfigEnvironment] = appConfig.Environment.String()
+	env[EnvVarAppConfigConfigurationProfile] = appConfig.ConfigurationProfile.String()
+	if appConfig.PollIntervalSeconds != 0 {
+		env[envVarAppConfigPollInterval] = gocf.String(fmt.Sprintf("%d", appConfig.PollIntervalSeconds))
+	}
+}
+
+// iamStatement returns the statement that grants access to the
+// configuration profile
+func (appConfig *AppConfigOptions) iamStatement() spartaIAM.PolicyStatement {
+	return spartaIAM.PolicyStatement{
+		Effect: "Allow",
+		Action: []string{"appconfig:StartConfigurationSession",
+			"appconfig:GetLatestConfiguration"},
+		Resource: gocf.Join("",
+			gocf.String("arn:aws:appconfig:"),
+			gocf.Ref("AWS::Region"),
+			gocf.String(":"),
+			gocf.Ref("AWS::AccountId"),
+			gocf.String(":application/"),
+			appConfig.Application.String(),
+			gocf.String("/environment/"),
+			appConfig.Environment.String(),
+			gocf.String("/configuration/"),
+			appConfig.ConfigurationProfile.String()),
+	}
+}
+
+// AppConfigURL returns the local AppConfig extension URL for the
+// configuration profile published into the function environment
+// by AppConfigOptions. The configuration is available via an HTTP GET
+// to the returned URL.
+func AppConfigURL() (string, error) {
+	application := os.Getenv(EnvVarAppConfigApplication)
+	environment := os.Getenv(EnvVarAppConfigEnvironment)
+	profile := os.Getenv(EnvVarAppConfigConfigurationProfile)
+	if application == "" || environment == "" || profile == "" {
+		return "", fmt.Errorf("AppConfig environment variables not found. Was AppConfigOptions set?")
+	}
+	port := os.Getenv(envVarAppConfigHTTPPort)
+	if port == "" {
+		port = appConfigDefaultHTTPPort
+	}
+	return fmt.Sprintf("http://localhost:%s/applications/%s/environments/%s/configurations/%s",
+		port,
+		application,
+		environment,
+		profile), nil
+}
@@ -59,6 +59,39 @@ func (at *archetypeTest) OnEventBridgeBroadcast(ctx context.Context,
 	return nil, nil
 }
 
+// The ReactorFunc reactors are package functions rather than method
+// values, since the method value names exceed the Lambda function
+// name limit
+func onS3(ctx context.Context,
+	event awsLambdaEvents.S3Event) (interface{}, error) {
+	return nil, nil
+}
+
+func onSNS(ctx context.Context,
+	snsEvent awsLambdaEvents.SNSEvent) (interface{}, error) {
+	return nil, nil
+}
+
+func onCloudWatch(ctx context.Context,
+	cwEvent awsLambdaEvents.CloudwatchLogsEvent) (interface{}, error) {
+	return nil, nil
+}
+
+func onDynamo(ctx context.Context,
+	dynamoEvent awsLambdaEvents.DynamoDBEvent) (interface{}, error) {
+	return nil, nil
+}
+
+func onKinesis(ctx context.Context,
+	kinesisEvent awsLambdaEvents.KinesisEvent) (interface{}, error) {
+	return nil, nil
+}
+
+func onBroadcast(ctx context.Context,
+	msg json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 /*
   ___ ____
@@ -78,7 +111,7 @@ func TestS3Archetype(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewS3Reactor(S3ReactorFunc(testStruct.OnS3Event),
+	lambdaFn, lambdaFnErr = NewS3Reactor(S3ReactorFunc(onS3),
 		gocf.String("s3Bucket"),
 		nil)
 	if lambdaFnErr != nil {
@@ -99,7 +132,7 @@ func TestS3ScopedArchetype(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewS3ScopedReactor(S3ReactorFunc(testStruct.OnS3Event),
+	lambdaFn, lambdaFnErr = NewS3ScopedReactor(S3ReactorFunc(onS3),
 		gocf.String("s3Bucket"),
 		"/input",
 		nil)
@@ -129,7 +162,7 @@ func TestSNSArchetype(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewSNSReactor(SNSReactorFunc(testStruct.OnSNSEvent),
+	lambdaFn, lambdaFnErr = NewSNSReactor(SNSReactorFunc(onSNS),
 		gocf.String("s3Bucket"),
 		nil)
 	if lambdaFnErr != nil {
@@ -162,7 +195,7 @@ func TestDynamoDBArchetype(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewDynamoDBReactor(DynamoDBReactorFunc(testStruct.OnDynamoEvent),
+	lambdaFn, lambdaFnErr = NewDynamoDBReactor(DynamoDBReactorFunc(onDynamo),
 		gocf.String("arn:dynamo"),
 		"TRIM_HORIZON",
 		10,
@@ -194,7 +227,7 @@ func TestKinesisArchetype(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewKinesisReactor(KinesisReactorFunc(testStruct.OnKinesisMessage),
+	lambdaFn, lambdaFnErr = NewKinesisReactor(KinesisReactorFunc(onKinesis),
 		gocf.String("arn:kinesis"),
 		"TRIM_HORIZON",
 		10,
@@ -228,7 +261,7 @@ func TestCloudWatchEvented(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewCloudWatchEventedReactor(CloudWatchReactorFunc(testStruct.OnCloudWatchMessage),
+	lambdaFn, lambdaFnErr = NewCloudWatchEventedReactor(CloudWatchReactorFunc(onCloudWatch),
 		map[string]map[string]interface{}{
 			"events": {
 				"source":      []string{"aws.ec2"},
@@ -254,7 +287,7 @@ func TestCloudWatchScheduled(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewCloudWatchScheduledReactor(CloudWatchReactorFunc(testStruct.OnCloudWatchMessage),
+	lambdaFn, lambdaFnErr = NewCloudWatchScheduledReactor(CloudWatchReactorFunc(onCloudWatch),
 		map[string]string{
 			"every5Mins": "rate(5 minutes)",
 		},
@@ -285,7 +318,7 @@ func TestEventBridgeScheduled(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewEventBridgeScheduledReactor(EventBridgeReactorFunc(testStruct.OnEventBridgeBroadcast),
+	lambdaFn, lambdaFnErr = NewEventBridgeScheduledReactor(EventBridgeReactorFunc(onBroadcast),
 		"rate(5 minutes)",
 		nil)
 	if lambdaFnErr != nil {
@@ -307,7 +340,7 @@ func TestEventBridgeEvented(t *testing.T) {
 	}
 	spartaTesting.Provision(t, []*sparta.LambdaAWSInfo{lambdaFn}, nil)
 
-	lambdaFn, lambdaFnErr = NewEventBridgeEventReactor(EventBridgeReactorFunc(testStruct.OnEventBridgeBroadcast),
+	lambdaFn, lambdaFnErr = NewEventBridgeEventReactor(EventBridgeReactorFunc(onBroadcast),
 		map[string]interface{}{
 			"source": []string{"aws.ec2"},
 		},
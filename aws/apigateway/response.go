@@ -1,6 +1,7 @@
 package apigateway
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -63,23 +64,28 @@ type Response struct {
 	Code    int               `json:"code,omitempty"`
 	Body    interface{}       `json:"body,omitempty"`
 	Headers map[string]string `json:"headers,omitempty"`
+	// IsBase64Encoded is true if the Body is a base64 encoded binary
+	// payload. See NewBinaryResponse.
+	IsBase64Encoded bool `json:"isBase64Encoded,omitempty"`
 }
 
 // canonicalResponse is the type for the canonicalized response with the
 // headers lowercased to match any API-Gateway case sensitive whitelist
 // matching
 type canonicalResponse struct {
-	Code    int               `json:"code,omitempty"`
-	Body    interface{}       `json:"body,omitempty"`
-	Headers map[string]string `json:"headers,omitempty"`
+	Code            int               `json:"code,omitempty"`
+	Body            interface{}       `json:"body,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	IsBase64Encoded bool              `json:"isBase64Encoded,omitempty"`
 }
 
 // MarshalJSON is a custom marshaller to ensure that the marshalled
 // headers are always lowercase
 func (resp *Response) MarshalJSON() ([]byte, error) {
 	canonicalResponse := canonicalResponse{
-		Code: resp.Code,
-		Body: resp.Body,
+		Code:            resp.Code,
+		Body:            resp.Body,
+		IsBase64Encoded: resp.IsBase64Encoded,
 	}
 	if len(resp.Headers) != 0 {
 		canonicalResponse.Headers = make(map[string]string)
@@ -106,3 +112,18 @@ func NewResponse(code int, body interface{}, headers ...map[string]string) *Resp
 	}
 	return response
 }
+
+// NewBinaryResponse returns an API Gateway response object for a binary
+// payload. The body is base64 encoded and the Content-Type header is set
+// to contentType. The method must declare contentType in its
+// BinaryResponseContentTypes and the API must include it in its
+// BinaryMediaTypes so that API Gateway decodes the body.
+func NewBinaryResponse(code int, body []byte, contentType string, headers ...map[string]string) *Response {
+	response := NewResponse(code, base64.StdEncoding.EncodeToString(body), headers...)
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	response.Headers["Content-Type"] = contentType
+	response.IsBase64Encoded = true
+	return response
+}
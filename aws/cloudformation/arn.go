@@ -0,0 +1,59 @@
+package cloudformation
+
+import (
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+// scopedArnAttribute is the GetAtt attribute that returns the ARN
+// for resource types that expose one
+var scopedArnAttribute = map[string]string{
+	"AWS::DynamoDB::Table":                 "Arn",
+	"AWS::Kinesis::Stream":                 "Arn",
+	"AWS::SQS::Queue":                      "Arn",
+	"AWS::Lambda::Function":                "Arn",
+	"AWS::Logs::LogGroup":                  "Arn",
+	"AWS::Events::Rule":                    "Arn",
+	"AWS::KinesisFirehose::DeliveryStream": "Arn",
+	"AWS::KMS::Key":                        "Arn",
+	"AWS::IAM::Role":                       "Arn",
+	"AWS::StepFunctions::Activity":         "Arn",
+}
+
+// scopedArnRef is the set of resource types whose Ref value is the ARN
+var scopedArnRef = map[string]bool{
+	"AWS::SNS::Topic":                  true,
+	"AWS::StepFunctions::StateMachine": true,
+	"AWS::SecretsManager::Secret":      true,
+}
+
+// ScopedArn returns the least-privilege ARN expression for the resource
+// with the given CloudFormation type and logical name in the same template.
+// It's intended to replace wildcard ("*") IAM statement resources
+// with the ARN of the specific resource.
+func ScopedArn(resourceType string, logicalName string) (*gocf.StringExpr, error) {
+	attrName, attrExists := scopedArnAttribute[resourceType]
+	if attrExists {
+		return gocf.GetAtt(logicalName, attrName), nil
+	}
+	if scopedArnRef[resourceType] {
+		return gocf.Ref(logicalName).String(), nil
+	}
+	switch resourceType {
+	case "AWS::S3::Bucket":
+		return S3ArnForBucket(gocf.Ref(logicalName)), nil
+	case "AWS::SSM::Parameter":
+		return gocf.Join("",
+			gocf.String("arn:"),
+			gocf.Ref("AWS::Partition"),
+			gocf.String(":ssm:"),
+			gocf.Ref("AWS::Region"),
+			gocf.String(":"),
+			gocf.Ref("AWS::AccountId"),
+			gocf.String(":parameter/"),
+			gocf.Ref(logicalName)), nil
+	}
+	return nil, errors.Errorf("Unsupported resource type for scoped ARN: %s (%s)",
+		resourceType,
+		logicalName)
+}
@@ -0,0 +1,44 @@
+package cloudformation
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestScopedArn(t *testing.T) {
+	testCases := []struct {
+		resourceType string
+		expected     string
+	}{
+		{"AWS::DynamoDB::Table", `{"Fn::GetAtt":["Resource","Arn"]}`},
+		{"AWS::Kinesis::Stream", `{"Fn::GetAtt":["Resource","Arn"]}`},
+		{"AWS::SQS::Queue", `{"Fn::GetAtt":["Resource","Arn"]}`},
+		{"AWS::KMS::Key", `{"Fn::GetAtt":["Resource","Arn"]}`},
+		{"AWS::SNS::Topic", `{"Ref":"Resource"}`},
+		{"AWS::StepFunctions::StateMachine", `{"Ref":"Resource"}`},
+		{"AWS::SecretsManager::Secret", `{"Ref":"Resource"}`},
+		{"AWS::S3::Bucket", `{"Fn::Join":["",["arn:aws:s3:::",{"Ref":"Resource"}]]}`},
+		{"AWS::SSM::Parameter", `{"Fn::Join":["",["arn:",{"Ref":"AWS::Partition"},":ssm:",{"Ref":"AWS::Region"},":",{"Ref":"AWS::AccountId"},":parameter/",{"Ref":"Resource"}]]}`},
+	}
+	for _, eachTestCase := range testCases {
+		t.Run(eachTestCase.resourceType, func(t *testing.T) {
+			scopedArn, scopedArnErr := ScopedArn(eachTestCase.resourceType, "Resource")
+			if scopedArnErr != nil {
+				t.Fatalf("Failed to create scoped ARN: %s", scopedArnErr)
+			}
+			jsonBytes, jsonBytesErr := json.Marshal(scopedArn)
+			if jsonBytesErr != nil {
+				t.Fatalf("Failed to marshal scoped ARN: %s", jsonBytesErr)
+			}
+			if string(jsonBytes) != eachTestCase.expected {
+				t.Fatalf("Unexpected scoped ARN. Expected: %s, Found: %s",
+					eachTestCase.expected,
+					string(jsonBytes))
+			}
+		})
+	}
+	_, scopedArnErr := ScopedArn("AWS::EC2::Instance", "Resource")
+	if scopedArnErr == nil {
+		t.Fatalf("Expected error for unsupported resource type")
+	}
+}
@@ -0,0 +1,92 @@
+package cloudformation
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDriftDetectionTimeout is the cause of the error returned when stack
+// drift detection doesn't complete before the timeout
+var ErrDriftDetectionTimeout = errors.New("stack drift detection timed out")
+
+// StackDriftAPI is the drift detection subset of the CloudFormation API
+type StackDriftAPI interface {
+	DetectStackDrift(*cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error)
+	DescribeStackDriftDetectionStatus(*cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error)
+	DescribeStackResourceDrifts(*cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error)
+}
+
+// StackResourceDrifts starts drift detection for the stack, polls every
+// pollInterval until it completes, and returns every page of resource
+// drifts with one of the statusFilters (eg, MODIFIED). An empty
+// statusFilters value returns all resources. If detection doesn't
+// complete within the timeout the returned error's cause is
+// ErrDriftDetectionTimeout.
+func StackResourceDrifts(cfSvc StackDriftAPI,
+	stackName string,
+	statusFilters []string,
+	pollInterval time.Duration,
+	timeout time.Duration,
+	logger *logrus.Logger) ([]*cloudformation.StackResourceDrift, error) {
+
+	detectResp, detectRespErr := cfSvc.DetectStackDrift(&cloudformation.DetectStackDriftInput{
+		StackName: aws.String(stackName),
+	})
+	if detectRespErr != nil {
+		return nil, errors.Wrapf(detectRespErr, "Failed to start drift detection for stack %s", stackName)
+	}
+	statusParams := &cloudformation.DescribeStackDriftDetectionStatusInput{
+		StackDriftDetectionId: detectResp.StackDriftDetectionId,
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		statusResp, statusRespErr := cfSvc.DescribeStackDriftDetectionStatus(statusParams)
+		if statusRespErr != nil {
+			return nil, errors.Wrapf(statusRespErr, "Failed to describe drift detection for stack %s", stackName)
+		}
+		detectionStatus := aws.StringValue(statusResp.DetectionStatus)
+		if detectionStatus == cloudformation.StackDriftDetectionStatusDetectionFailed {
+			// Some resources don't support drift detection. The resources
+			// that were checked are still reported.
+			logger.WithFields(logrus.Fields{
+				"Reason": aws.StringValue(statusResp.DetectionStatusReason),
+			}).Warn("Stack drift detection failed for some resources")
+		}
+		if detectionStatus != cloudformation.StackDriftDetectionStatusDetectionInProgress {
+			break
+		}
+		if time.Now().Add(pollInterval).After(deadline) {
+			return nil, errors.Wrapf(ErrDriftDetectionTimeout,
+				"Drift detection %s for stack %s did not complete within %s",
+				aws.StringValue(detectResp.StackDriftDetectionId),
+				stackName,
+				timeout)
+		}
+		logger.WithField("Status", detectionStatus).Debug("Waiting for stack drift detection to complete")
+		time.Sleep(pollInterval)
+	}
+
+	drifts := make([]*cloudformation.StackResourceDrift, 0)
+	driftParams := &cloudformation.DescribeStackResourceDriftsInput{
+		StackName: aws.String(stackName),
+	}
+	if len(statusFilters) != 0 {
+		driftParams.StackResourceDriftStatusFilters = aws.StringSlice(statusFilters)
+	}
+	for {
+		driftResp, driftRespErr := cfSvc.DescribeStackResourceDrifts(driftParams)
+		if driftRespErr != nil {
+			return nil, errors.Wrapf(driftRespErr, "Failed to describe resource drift for stack %s", stackName)
+		}
+		drifts = append(drifts, driftResp.StackResourceDrifts...)
+		if driftResp.NextToken == nil {
+			break
+		}
+		driftParams.NextToken = driftResp.NextToken
+	}
+	return drifts, nil
+}
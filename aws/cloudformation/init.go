@@ -0,0 +1,215 @@
+package cloudformation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+// InitMetadataKey is the resource Metadata key read by cfn-init
+const InitMetadataKey = "AWS::CloudFormation::Init"
+
+// initServiceManagers are the supported service managers
+var initServiceManagers = map[string]bool{
+	"sysvinit": true,
+	"windows":  true,
+	"systemd":  true,
+}
+
+// initPackageManagers are the supported package managers
+var initPackageManagers = map[string]bool{
+	"apt":      true,
+	"msi":      true,
+	"python":   true,
+	"rpm":      true,
+	"rubygems": true,
+	"yum":      true,
+}
+
+// InitFile is a file created by cfn-init. Exactly one of Content
+// or Source must be defined.
+type InitFile struct {
+	Content  *gocf.StringExpr `json:"content,omitempty"`
+	Source   *gocf.StringExpr `json:"source,omitempty"`
+	Encoding string           `json:"encoding,omitempty"`
+	Mode     string           `json:"mode,omitempty"`
+	Owner    string           `json:"owner,omitempty"`
+	Group    string           `json:"group,omitempty"`
+}
+
+// InitCommand is a command run by cfn-init. Commands are run in
+// alphabetical order of their keys.
+type InitCommand struct {
+	Command      *gocf.StringExpr  `json:"command"`
+	Env          map[string]string `json:"env,omitempty"`
+	Cwd          string            `json:"cwd,omitempty"`
+	Test         string            `json:"test,omitempty"`
+	IgnoreErrors bool              `json:"ignoreErrors,omitempty"`
+}
+
+// InitService is a service that cfn-init enables or starts
+type InitService struct {
+	Enabled       bool     `json:"enabled"`
+	EnsureRunning bool     `json:"ensureRunning"`
+	Files         []string `json:"files,omitempty"`
+	Sources       []string `json:"sources,omitempty"`
+	Commands      []string `json:"commands,omitempty"`
+}
+
+// InitConfig is a single cfn-init config. The sections are processed
+// in the order packages, files, commands, services.
+type InitConfig struct {
+	// Packages is package manager -> package name -> versions
+	Packages map[string]map[string][]string `json:"packages,omitempty"`
+	// Files is the absolute path -> file
+	Files map[string]*InitFile `json:"files,omitempty"`
+	// Commands is the command name -> command
+	Commands map[string]*InitCommand `json:"commands,omitempty"`
+	// Services is service manager -> service name -> service
+	Services map[string]map[string]*InitService `json:"services,omitempty"`
+}
+
+// InitMetadata is the AWS::CloudFormation::Init metadata for a resource
+// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-resource-init.html
+type InitMetadata struct {
+	// ConfigSets is the config set name -> ordered config names
+	ConfigSets map[string][]string
+	// Configs is the config name -> config
+	Configs map[string]*InitConfig
+}
+
+// Validate ensures that the config sets reference defined configs and
+// that each config is well formed
+func (metadata *InitMetadata) Validate() error {
+	var errorText []string
+	if len(metadata.Configs) == 0 {
+		return errors.New("InitMetadata must define at least one config")
+	}
+	for eachSetName, eachSet := range metadata.ConfigSets {
+		if len(eachSet) == 0 {
+			errorText = append(errorText,
+				fmt.Sprintf("Config set %s is empty", eachSetName))
+		}
+		for _, eachConfigName := range eachSet {
+			if _, exists := metadata.Configs[eachConfigName]; !exists {
+				errorText = append(errorText,
+					fmt.Sprintf("Config set %s references undefined config: %s",
+						eachSetName,
+						eachConfigName))
+			}
+		}
+	}
+	for eachConfigName, eachConfig := range metadata.Configs {
+		if eachConfigName == "configSets" {
+			errorText = append(errorText, "Config name configSets is reserved")
+		}
+		if eachConfig == nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Config %s is nil", eachConfigName))
+			continue
+		}
+		for eachManager := range eachConfig.Packages {
+			if !initPackageManagers[eachManager] {
+				errorText = append(errorText,
+					fmt.Sprintf("Config %s uses an unsupported package manager: %s",
+						eachConfigName,
+						eachManager))
+			}
+		}
+		for eachPath, eachFile := range eachConfig.Files {
+			if eachFile == nil || (eachFile.Content == nil) == (eachFile.Source == nil) {
+				errorText = append(errorText,
+					fmt.Sprintf("Config %s file %s must define exactly one of content or source",
+						eachConfigName,
+						eachPath))
+			}
+		}
+		for eachCommandName, eachCommand := range eachConfig.Commands {
+			if eachCommand == nil || eachCommand.Command == nil {
+				errorText = append(errorText,
+					fmt.Sprintf("Config %s command %s must define a command",
+						eachConfigName,
+						eachCommandName))
+			}
+		}
+		for eachManager := range eachConfig.Services {
+			if !initServiceManagers[eachManager] {
+				errorText = append(errorText,
+					fmt.Sprintf("Config %s uses an unsupported service manager: %s",
+						eachConfigName,
+						eachManager))
+			}
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// metadataValue returns the AWS::CloudFormation::Init metadata value
+func (metadata *InitMetadata) metadataValue() map[string]interface{} {
+	value := make(map[string]interface{})
+	if len(metadata.ConfigSets) != 0 {
+		value["configSets"] = metadata.ConfigSets
+	}
+	for eachConfigName, eachConfig := range metadata.Configs {
+		value[eachConfigName] = eachConfig
+	}
+	return value
+}
+
+// AddInitMetadata validates the metadata and adds it to the resource's
+// AWS::CloudFormation::Init Metadata key
+func AddInitMetadata(resource *gocf.Resource, metadata *InitMetadata) error {
+	validateErr := metadata.Validate()
+	if validateErr != nil {
+		return errors.Wrapf(validateErr, "Invalid %s metadata", InitMetadataKey)
+	}
+	if resource.Metadata == nil {
+		resource.Metadata = make(map[string]interface{})
+	}
+	resource.Metadata[InitMetadataKey] = metadata.metadataValue()
+	return nil
+}
+
+// InitUserData returns the Base64 encoded UserData script that runs
+// cfn-init for the config sets defined by the initResourceName
+// resource's metadata, and then reports the result with cfn-signal
+// to the signalResourceName resource. signalResourceName must
+// define a CreationPolicy, see InitCreationPolicy.
+func InitUserData(initResourceName string,
+	signalResourceName string,
+	configSets []string) *gocf.StringExpr {
+	configSetsArg := ""
+	if len(configSets) != 0 {
+		configSetsArg = fmt.Sprintf(" --configsets %s", strings.Join(configSets, ","))
+	}
+	return gocf.Base64(gocf.Join("",
+		gocf.String("#!/bin/bash -xe\n"),
+		gocf.String("/opt/aws/bin/cfn-init -v --stack "),
+		gocf.Ref("AWS::StackName"),
+		gocf.String(fmt.Sprintf(" --resource %s%s --region ", initResourceName, configSetsArg)),
+		gocf.Ref("AWS::Region"),
+		gocf.String("\n"),
+		gocf.String("/opt/aws/bin/cfn-signal -e $? --stack "),
+		gocf.Ref("AWS::StackName"),
+		gocf.String(fmt.Sprintf(" --resource %s --region ", signalResourceName)),
+		gocf.Ref("AWS::Region"),
+		gocf.String("\n")))
+}
+
+// InitCreationPolicy returns the CreationPolicy that waits for count
+// cfn-signal calls. The timeout is an ISO8601 duration (eg, PT15M).
+func InitCreationPolicy(count int64, timeout string) *gocf.CreationPolicy {
+	return &gocf.CreationPolicy{
+		ResourceSignal: &gocf.CreationPolicyResourceSignal{
+			Count:   gocf.Integer(count),
+			Timeout: gocf.String(timeout),
+		},
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	awsLambdaCtx "github.com/aws/aws-lambda-go/lambdacontext"
 	"github.com/aws/aws-sdk-go/aws"
@@ -30,6 +31,17 @@ const (
 	// @enum CloudFormationOperation
 	UpdateOperation = "Update"
 )
+const (
+	// customResourceResponseSizeLimit is the maximum size of the response
+	// object sent to the presigned URL.
+	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/crpg-ref-responses.html
+	customResourceResponseSizeLimit = 4096
+	// customResourceResponseMaxAttempts is the number of times a response
+	// is sent before giving up
+	customResourceResponseMaxAttempts = 3
+	// customResourceResponseTimeout is the timeout for a single attempt
+	customResourceResponseTimeout = 30 * time.Second
+)
 const (
 	// CustomResourceTypePrefix is the known custom resource
 	// type prefix
@@ -55,6 +67,10 @@ var (
 	S3ArtifactPublisher = cloudFormationResourceType("S3ArtifactPublisher")
 )
 
+// customResourceResponseRetryDelay is the base delay between attempts
+// to send the response
+var customResourceResponseRetryDelay = 2 * time.Second
+
 func customTypeProvider(resourceType string) gocf.ResourceProperties {
 	switch resourceType {
 	case HelloWorld:
@@ -119,19 +135,29 @@ func (proxy *logrusProxy) Log(args ...interface{}) {
 
 // CloudFormationLambdaEvent is the event to a resource
 type CloudFormationLambdaEvent struct {
-	RequestType           string
-	RequestID             string `json:"RequestId"`
-	ResponseURL           string
-	ResourceType          string
-	StackID               string `json:"StackId"`
-	LogicalResourceID     string `json:"LogicalResourceId"`
+	RequestType       string
+	RequestID         string `json:"RequestId"`
+	ResponseURL       string
+	ResourceType      string
+	StackID           string `json:"StackId"`
+	LogicalResourceID string `json:"LogicalResourceId"`
+	// PhysicalResourceID is the resource's current physical ID for Update
+	// and Delete requests. It's empty for Create requests. The value is
+	// sent as the PhysicalResourceId in the response, so a handler may set
+	// it to a stable identifier during Create. Changing the value during
+	// an Update signals CloudFormation to replace the resource, which
+	// causes a subsequent Delete request for the previous ID.
+	PhysicalResourceID    string `json:"PhysicalResourceId,omitempty"`
 	ResourceProperties    json.RawMessage
 	OldResourceProperties json.RawMessage
 }
 
 // SendCloudFormationResponse sends the given response
 // to the CloudFormation URL that was submitted together
-// with this event
+// with this event. The response PhysicalResourceId is the
+// event.PhysicalResourceID value, which echoes the incoming ID for Update
+// and Delete requests. If it's empty, an ID derived from the Lambda log
+// stream name is used.
 func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 	event *CloudFormationLambdaEvent,
 	results map[string]interface{},
@@ -162,7 +188,10 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 	// and can be up to 1 Kb in size. The value must be a non-empty string and
 	// must be identical for all responses for the same resource.
 	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/crpg-ref-requesttypes-create.html
-	physicalResourceID := fmt.Sprintf("LogStreamName: %s", logStreamName)
+	physicalResourceID := event.PhysicalResourceID
+	if physicalResourceID == "" {
+		physicalResourceID = fmt.Sprintf("LogStreamName: %s", logStreamName)
+	}
 	responseData := map[string]interface{}{
 		"Status":             status,
 		"Reason":             reasonText,
@@ -189,6 +218,55 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 	if nil != jsonError {
 		return errors.Wrap(jsonError, "Attempting to marshal Cloudformation response")
 	}
+	// If the response is too large, CloudFormation will reject it and
+	// the stack operation stalls until the resource times out. Send a
+	// FAILED response in its place so that the error is visible.
+	var sizeErr error
+	if len(jsonData) > customResourceResponseSizeLimit {
+		sizeErr = errors.Errorf("CloudFormation custom resource response size (%d bytes) exceeds the %d byte limit. "+
+			"Consider storing large results elsewhere (eg, S3) and returning a reference.",
+			len(jsonData),
+			customResourceResponseSizeLimit)
+		responseData["Status"] = "FAILED"
+		responseData["Reason"] = sizeErr.Error()
+		responseData["Data"] = map[string]interface{}{}
+		jsonData, jsonError = json.Marshal(responseData)
+		if nil != jsonError {
+			return errors.Wrap(jsonError, "Attempting to marshal Cloudformation response")
+		}
+	}
+
+	var sendErr error
+	for attempt := 1; attempt <= customResourceResponseMaxAttempts; attempt++ {
+		var retryable bool
+		retryable, sendErr = sendCloudFormationResponseData(event,
+			jsonData,
+			responseData,
+			logger)
+		if sendErr == nil || !retryable {
+			break
+		}
+		logger.WithFields(logrus.Fields{
+			"Attempt": attempt,
+			"Error":   sendErr,
+		}).Warn("Failed to send CloudFormation response")
+		if attempt < customResourceResponseMaxAttempts {
+			time.Sleep(time.Duration(attempt) * customResourceResponseRetryDelay)
+		}
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	return sizeErr
+}
+
+// sendCloudFormationResponseData performs a single PUT of the response
+// to the presigned URL. The boolean return value indicates whether
+// the error is transient and the request can be retried.
+func sendCloudFormationResponseData(event *CloudFormationLambdaEvent,
+	jsonData []byte,
+	responseData map[string]interface{},
+	logger *logrus.Logger) (bool, error) {
 
 	responseBuffer := strings.NewReader(string(jsonData))
 	req, httpErr := http.NewRequest("PUT",
@@ -196,7 +274,7 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 		responseBuffer)
 
 	if nil != httpErr {
-		return httpErr
+		return false, httpErr
 	}
 	// Need to use the Opaque field b/c Go will parse inline encoded values
 	// which are supposed to be roundtripped to AWS.
@@ -229,11 +307,14 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 	// Ref: http://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-properties-lambda-function-code.html
 	req.Header.Set("content-type", "")
 
-	client := &http.Client{}
+	client := &http.Client{
+		Timeout: customResourceResponseTimeout,
+	}
 	resp, httpErr := client.Do(req)
 	if httpErr != nil {
-		return errors.Wrapf(httpErr, "Sending CloudFormation response")
+		return true, errors.Wrapf(httpErr, "Sending CloudFormation response")
 	}
+	defer resp.Body.Close()
 	logger.WithFields(logrus.Fields{
 		"LogicalResourceId":  event.LogicalResourceID,
 		"Result":             responseData["Status"],
@@ -246,10 +327,10 @@ func SendCloudFormationResponse(lambdaCtx *awsLambdaCtx.LambdaContext,
 			logger.Warn("Unable to read body: " + bodyErr.Error())
 			body = []byte{}
 		}
-		return errors.Errorf("Error sending response: %d. Data: %s", resp.StatusCode, string(body))
+		return resp.StatusCode >= 500,
+			errors.Errorf("Error sending response: %d. Data: %s", resp.StatusCode, string(body))
 	}
-	defer resp.Body.Close()
-	return nil
+	return false, nil
 }
 
 // Returns an AWS Session (https://github.com/aws/aws-sdk-go/wiki/Getting-Started-Configuration)
@@ -322,6 +403,9 @@ func CloudFormationLambdaCustomResourceHandler(command CustomResourceCommand, lo
 			"RequestType":      event.RequestType,
 		}).Debug("CustomResource Request")
 
+		// The command may change the PhysicalResourceID, but only Create
+		// and Update requests can do so
+		requestPhysicalResourceID := event.PhysicalResourceID
 		if opErr == nil && executeOperation {
 			switch event.RequestType {
 			case CreateOperation:
@@ -332,6 +416,18 @@ func CloudFormationLambdaCustomResourceHandler(command CustomResourceCommand, lo
 				opResults, opErr = command.Update(customResourceSession, &event, logger)
 			}
 		}
+		if event.PhysicalResourceID != requestPhysicalResourceID &&
+			requestPhysicalResourceID != "" {
+			if event.RequestType == UpdateOperation && opErr == nil {
+				logger.WithFields(logrus.Fields{
+					"LogicalResourceId":          event.LogicalResourceID,
+					"PreviousPhysicalResourceId": requestPhysicalResourceID,
+					"PhysicalResourceId":         event.PhysicalResourceID,
+				}).Info("Custom resource PhysicalResourceId changed. CloudFormation will delete the previous resource")
+			} else {
+				event.PhysicalResourceID = requestPhysicalResourceID
+			}
+		}
 		// Notify CloudFormation of the result
 		if event.ResponseURL != "" {
 			sendErr := SendCloudFormationResponse(lambdaCtx,
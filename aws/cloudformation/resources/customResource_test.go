@@ -0,0 +1,167 @@
+package resources
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSendCloudFormationResponsePhysicalResourceID(t *testing.T) {
+	var response map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		response = nil
+		if unmarshalErr := json.Unmarshal(body, &response); unmarshalErr != nil {
+			t.Errorf("Failed to unmarshal response: %s", unmarshalErr)
+		}
+	}))
+	defer server.Close()
+
+	event := &CloudFormationLambdaEvent{
+		RequestType:        UpdateOperation,
+		RequestID:          "request",
+		ResponseURL:        server.URL + "/response",
+		StackID:            "stack",
+		LogicalResourceID:  "logicalID",
+		PhysicalResourceID: "stable-id",
+	}
+	// Update responses echo the incoming PhysicalResourceId
+	sendErr := SendCloudFormationResponse(nil, event, nil, nil, logrus.New())
+	if sendErr != nil {
+		t.Fatalf("Failed to send response: %s", sendErr)
+	}
+	if response["PhysicalResourceId"] != "stable-id" {
+		t.Fatalf("Expected stable PhysicalResourceId. Found: %#v", response["PhysicalResourceId"])
+	}
+
+	// Create responses without a handler supplied ID get a generated one
+	event.RequestType = CreateOperation
+	event.PhysicalResourceID = ""
+	sendErr = SendCloudFormationResponse(nil, event, nil, nil, logrus.New())
+	if sendErr != nil {
+		t.Fatalf("Failed to send response: %s", sendErr)
+	}
+	physicalID, _ := response["PhysicalResourceId"].(string)
+	if !strings.HasPrefix(physicalID, "LogStreamName: ") {
+		t.Fatalf("Expected generated PhysicalResourceId. Found: %#v", response["PhysicalResourceId"])
+	}
+}
+
+func TestSendCloudFormationResponseRetry(t *testing.T) {
+	savedDelay := customResourceResponseRetryDelay
+	customResourceResponseRetryDelay = time.Millisecond
+	defer func() {
+		customResourceResponseRetryDelay = savedDelay
+	}()
+
+	largeResults := map[string]interface{}{
+		"Value": strings.Repeat("x", customResourceResponseSizeLimit),
+	}
+	testCases := []struct {
+		name             string
+		statusCodes      []int
+		results          map[string]interface{}
+		expectedAttempts int
+		expectedStatus   string
+		expectError      bool
+	}{
+		{
+			name:             "success",
+			statusCodes:      []int{http.StatusOK},
+			expectedAttempts: 1,
+			expectedStatus:   "SUCCESS",
+		},
+		{
+			name: "5xx then 200",
+			statusCodes: []int{http.StatusInternalServerError,
+				http.StatusServiceUnavailable,
+				http.StatusOK},
+			expectedAttempts: 3,
+			expectedStatus:   "SUCCESS",
+		},
+		{
+			name: "5xx exhausts attempts",
+			statusCodes: []int{http.StatusInternalServerError,
+				http.StatusInternalServerError,
+				http.StatusInternalServerError},
+			expectedAttempts: 3,
+			expectedStatus:   "SUCCESS",
+			expectError:      true,
+		},
+		{
+			name:             "4xx is not retried",
+			statusCodes:      []int{http.StatusForbidden},
+			expectedAttempts: 1,
+			expectedStatus:   "SUCCESS",
+			expectError:      true,
+		},
+		{
+			name:             "response too large",
+			statusCodes:      []int{http.StatusOK},
+			results:          largeResults,
+			expectedAttempts: 1,
+			expectedStatus:   "FAILED",
+			expectError:      true,
+		},
+	}
+	for _, eachTestCase := range testCases {
+		t.Run(eachTestCase.name, func(t *testing.T) {
+			var responses []map[string]interface{}
+			var bodySizes []int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				var response map[string]interface{}
+				if unmarshalErr := json.Unmarshal(body, &response); unmarshalErr != nil {
+					t.Errorf("Failed to unmarshal response: %s", unmarshalErr)
+				}
+				statusCode := http.StatusOK
+				if len(responses) < len(eachTestCase.statusCodes) {
+					statusCode = eachTestCase.statusCodes[len(responses)]
+				}
+				responses = append(responses, response)
+				bodySizes = append(bodySizes, len(body))
+				w.WriteHeader(statusCode)
+			}))
+			defer server.Close()
+
+			event := &CloudFormationLambdaEvent{
+				RequestType:       CreateOperation,
+				RequestID:         "request",
+				ResponseURL:       server.URL + "/response",
+				StackID:           "stack",
+				LogicalResourceID: "logicalID",
+			}
+			sendErr := SendCloudFormationResponse(nil,
+				event,
+				eachTestCase.results,
+				nil,
+				logrus.New())
+			if eachTestCase.expectError != (sendErr != nil) {
+				t.Fatalf("Unexpected error result: %v", sendErr)
+			}
+			if len(responses) != eachTestCase.expectedAttempts {
+				t.Fatalf("Expected %d attempts, found: %d",
+					eachTestCase.expectedAttempts,
+					len(responses))
+			}
+			for index, eachResponse := range responses {
+				if eachResponse["Status"] != eachTestCase.expectedStatus {
+					t.Fatalf("Expected %s status, found: %#v",
+						eachTestCase.expectedStatus,
+						eachResponse["Status"])
+				}
+				if bodySizes[index] > customResourceResponseSizeLimit {
+					t.Fatalf("Response size (%d) exceeds the %d byte limit",
+						bodySizes[index],
+						customResourceResponseSizeLimit)
+				}
+			}
+		})
+	}
+}
@@ -194,7 +194,7 @@ func cloudformationPollingDelay() time.Duration {
 }
 
 func updateStackViaChangeSet(serviceName string,
-	cfTemplate *gocf.Template,
+	capabilities []*string,
 	cfTemplateURL string,
 	awsTags []*cloudformation.Tag,
 	awsCloudFormation *cloudformation.CloudFormation,
@@ -202,9 +202,9 @@ func updateStackViaChangeSet(serviceName string,
 
 	// Create a change set name...
 	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sChangeSet", serviceName))
-	_, changesErr := CreateStackChangeSet(changeSetRequestName,
+	_, changesErr := createStackChangeSet(changeSetRequestName,
 		serviceName,
-		cfTemplate,
+		capabilities,
 		cfTemplateURL,
 		awsTags,
 		awsCloudFormation,
@@ -326,7 +326,9 @@ func parseFnJoinExpr(data map[string]interface{}) (*gocf.StringExpr, error) {
 	return nil, fmt.Errorf("unsupported AWS Function detected: %#v", data)
 }
 
-func stackCapabilities(template *gocf.Template) []*string {
+// StackCapabilities returns the capabilities that must be acknowledged
+// to create or update a stack with the template
+func StackCapabilities(template *gocf.Template) []*string {
 	capabilitiesMap := make(map[string]bool)
 
 	// Only require IAM capability if the definition requires it.
@@ -482,6 +484,21 @@ type WaitForStackOperationCompleteResult struct {
 	stackInfo           *cloudformation.Stack
 }
 
+// WaitOptions defines the polling behavior used to wait for a stack
+// operation to complete
+type WaitOptions struct {
+	// MaxAttempts is the maximum number of times the stack status is
+	// polled. If zero, the stack is polled until the operation completes.
+	MaxAttempts int
+	// Delay is the time to wait between polling attempts. If zero, a
+	// randomized delay between 11 and 24 seconds is used.
+	Delay time.Duration
+}
+
+// waitOptionsWarningPercent is the percentage of MaxAttempts after which
+// a warning is logged that the waiter is approaching its limit
+const waitOptionsWarningPercent = 80
+
 // WaitForStackOperationComplete is a blocking, polling based call that
 // periodically fetches the stackID set of events and uses the state value
 // to determine if an operation is complete
@@ -489,7 +506,26 @@ func WaitForStackOperationComplete(stackID string,
 	pollingMessage string,
 	awsCloudFormation *cloudformation.CloudFormation,
 	logger *logrus.Logger) (*WaitForStackOperationCompleteResult, error) {
+	return WaitForStackOperationCompleteWithOptions(stackID,
+		pollingMessage,
+		awsCloudFormation,
+		nil,
+		logger)
+}
+
+// WaitForStackOperationCompleteWithOptions is a blocking, polling based call
+// that periodically fetches the stackID state using the supplied
+// WaitOptions. An error is returned if the operation does not complete
+// within the maximum number of attempts.
+func WaitForStackOperationCompleteWithOptions(stackID string,
+	pollingMessage string,
+	awsCloudFormation *cloudformation.CloudFormation,
+	waitOptions *WaitOptions,
+	logger *logrus.Logger) (*WaitForStackOperationCompleteResult, error) {
 
+	if waitOptions == nil {
+		waitOptions = &WaitOptions{}
+	}
 	result := &WaitForStackOperationCompleteResult{}
 
 	startTime := time.Now()
@@ -512,7 +548,21 @@ func WaitForStackOperationComplete(stackID string,
 	describeStacksInput := &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackID),
 	}
-	for waitComplete := false; !waitComplete; {
+	warningAttempt := (waitOptions.MaxAttempts * waitOptionsWarningPercent) / 100
+	for attempt, waitComplete := 1, false; !waitComplete; attempt++ {
+		if waitOptions.MaxAttempts > 0 && attempt > waitOptions.MaxAttempts {
+			return nil, fmt.Errorf("stack operation for %s did not complete after %d polling attempts (elapsed: %s)",
+				stackID,
+				waitOptions.MaxAttempts,
+				time.Since(startTime).String())
+		}
+		if waitOptions.MaxAttempts > 0 && attempt == warningAttempt {
+			logger.WithFields(logrus.Fields{
+				"Attempt":     attempt,
+				"MaxAttempts": waitOptions.MaxAttempts,
+				"Elapsed":     time.Since(startTime).String(),
+			}).Warn("Stack operation is approaching the maximum number of polling attempts")
+		}
 		// Startup the spinner if needed...
 		switch logger.Formatter.(type) {
 		case *logrus.JSONFormatter:
@@ -532,7 +582,10 @@ func WaitForStackOperationComplete(stackID string,
 		}
 
 		// Then sleep and figure out if things are done...
-		sleepDuration := time.Duration(11+rand.Int31n(13)) * time.Second
+		sleepDuration := waitOptions.Delay
+		if sleepDuration <= 0 {
+			sleepDuration = time.Duration(11+rand.Int31n(13)) * time.Second
+		}
 		time.Sleep(sleepDuration)
 
 		describeStacksOutput, err := awsCloudFormation.DescribeStacks(describeStacksInput)
@@ -686,8 +739,25 @@ func CreateStackChangeSet(changeSetRequestName string,
 	awsTags []*cloudformation.Tag,
 	awsCloudFormation *cloudformation.CloudFormation,
 	logger *logrus.Logger) (*cloudformation.DescribeChangeSetOutput, error) {
+	return createStackChangeSet(changeSetRequestName,
+		serviceName,
+		StackCapabilities(cfTemplate),
+		templateURL,
+		awsTags,
+		awsCloudFormation,
+		logger)
+}
+
+// createStackChangeSet creates the change set with the capabilities and
+// waits for it to be created
+func createStackChangeSet(changeSetRequestName string,
+	serviceName string,
+	capabilities []*string,
+	templateURL string,
+	awsTags []*cloudformation.Tag,
+	awsCloudFormation *cloudformation.CloudFormation,
+	logger *logrus.Logger) (*cloudformation.DescribeChangeSetOutput, error) {
 
-	capabilities := stackCapabilities(cfTemplate)
 	changeSetInput := &cloudformation.CreateChangeSetInput{
 		Capabilities:  capabilities,
 		ChangeSetName: aws.String(changeSetRequestName),
@@ -837,6 +907,61 @@ func ConvergeStackState(serviceName string,
 	outputsDividerChar string,
 	dividerWidth int,
 	logger *logrus.Logger) (*cloudformation.Stack, error) {
+	return ConvergeStackStateWithOptions(serviceName,
+		cfTemplate,
+		templateURL,
+		tags,
+		startTime,
+		operationTimeout,
+		awsSession,
+		outputsDividerChar,
+		dividerWidth,
+		nil,
+		logger)
+}
+
+// ConvergeStackStateWithOptions ensures that the serviceName converges to the
+// template state defined by cfTemplate, using the supplied waitOptions
+// to poll for the operation result.
+func ConvergeStackStateWithOptions(serviceName string,
+	cfTemplate *gocf.Template,
+	templateURL string,
+	tags map[string]string,
+	startTime time.Time,
+	operationTimeout time.Duration,
+	awsSession *session.Session,
+	outputsDividerChar string,
+	dividerWidth int,
+	waitOptions *WaitOptions,
+	logger *logrus.Logger) (*cloudformation.Stack, error) {
+	return ConvergeStackStateWithCapabilities(serviceName,
+		StackCapabilities(cfTemplate),
+		templateURL,
+		tags,
+		startTime,
+		operationTimeout,
+		awsSession,
+		outputsDividerChar,
+		dividerWidth,
+		waitOptions,
+		logger)
+}
+
+// ConvergeStackStateWithCapabilities is ConvergeStackStateWithOptions for a
+// template that's only available at templateURL, such as a template
+// built by a separate process. The capabilities are acknowledged as
+// supplied rather than determined from the template resources.
+func ConvergeStackStateWithCapabilities(serviceName string,
+	capabilities []*string,
+	templateURL string,
+	tags map[string]string,
+	startTime time.Time,
+	operationTimeout time.Duration,
+	awsSession *session.Session,
+	outputsDividerChar string,
+	dividerWidth int,
+	waitOptions *WaitOptions,
+	logger *logrus.Logger) (*cloudformation.Stack, error) {
 
 	awsCloudFormation := cloudformation.New(awsSession)
 	// Update the tags
@@ -857,7 +982,7 @@ func ConvergeStackState(serviceName string,
 	stackID := ""
 	if exists {
 		updateErr := updateStackViaChangeSet(serviceName,
-			cfTemplate,
+			capabilities,
 			templateURL,
 			awsTags,
 			awsCloudFormation,
@@ -874,7 +999,7 @@ func ConvergeStackState(serviceName string,
 			TemplateURL:      aws.String(templateURL),
 			TimeoutInMinutes: aws.Int64(int64(operationTimeout.Minutes())),
 			OnFailure:        aws.String(cloudformation.OnFailureDelete),
-			Capabilities:     stackCapabilities(cfTemplate),
+			Capabilities:     capabilities,
 		}
 		if len(awsTags) != 0 {
 			createStackInput.Tags = awsTags
@@ -891,9 +1016,10 @@ func ConvergeStackState(serviceName string,
 	}
 	// Wait for the operation to succeed
 	pollingMessage := "Waiting for CloudFormation operation to complete"
-	convergeResult, convergeErr := WaitForStackOperationComplete(stackID,
+	convergeResult, convergeErr := WaitForStackOperationCompleteWithOptions(stackID,
 		pollingMessage,
 		awsCloudFormation,
+		waitOptions,
 		logger)
 	if nil != convergeErr {
 		return nil, convergeErr
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	spartaAWS "github.com/mweagle/Sparta/aws"
+	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/sirupsen/logrus"
 )
 
@@ -163,3 +164,38 @@ func TestPlatformScopedName(t *testing.T) {
 		t.Fatalf("Failed to get `user` AWS account name for Stack")
 	}
 }
+
+func TestInitMetadata(t *testing.T) {
+	metadata := &InitMetadata{
+		ConfigSets: map[string][]string{
+			"default": {"install"},
+		},
+		Configs: map[string]*InitConfig{
+			"install": {
+				Packages: map[string]map[string][]string{
+					"yum": {"httpd": {}},
+				},
+				Files: map[string]*InitFile{
+					"/var/www/html/index.html": {
+						Content: gocf.String("Hello"),
+						Mode:    "000644",
+					},
+				},
+				Services: map[string]map[string]*InitService{
+					"sysvinit": {"httpd": {Enabled: true, EnsureRunning: true}},
+				},
+			},
+		},
+	}
+	resource := &gocf.Resource{}
+	if err := AddInitMetadata(resource, metadata); err != nil {
+		t.Fatalf("Failed to add valid init metadata: %s", err)
+	}
+	if _, exists := resource.Metadata[InitMetadataKey]; !exists {
+		t.Fatalf("Failed to set %s metadata", InitMetadataKey)
+	}
+	metadata.ConfigSets["default"] = append(metadata.ConfigSets["default"], "missing")
+	if err := AddInitMetadata(resource, metadata); err == nil {
+		t.Fatalf("Failed to reject config set with undefined config")
+	}
+}
@@ -16,7 +16,7 @@ func testLambdaData(t *testing.T) []*sparta.LambdaAWSInfo {
 		"BuildId": sparta.StampedBuildID,
 	})
 
-	lambdaFn, lambdaFnErr := sparta.NewAWSLambda(sparta.LambdaName(mockLambda),
+	lambdaFn, lambdaFnErr := sparta.NewAWSLambda("mockLambda",
 		mockLambda,
 		sparta.IAMRoleDefinition{})
 	if lambdaFnErr != nil {
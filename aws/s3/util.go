@@ -2,15 +2,22 @@ package s3
 
 import (
 	"fmt"
+	"io"
 	"mime"
 	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -53,6 +60,50 @@ func CreateS3RollbackFunc(awsSession *session.Session, s3ArtifactURL string) Rol
 	}
 }
 
+// UploadOptions defines the S3 multipart upload settings used to
+// upload local files
+type UploadOptions struct {
+	// PartSize is the size in bytes of each uploaded part. If zero, the
+	// s3manager.DefaultUploadPartSize is used.
+	PartSize int64
+	// Concurrency is the number of parts uploaded in parallel. If zero, the
+	// s3manager.DefaultUploadConcurrency value is used.
+	Concurrency int
+	// MaxRetries is the number of times an individual part request is
+	// retried in the event of a transient error. If zero, the
+	// DefaultUploadMaxRetries value is used. Set a negative value to
+	// disable retries.
+	MaxRetries int
+	// Metadata is the optional user-defined metadata stored
+	// with the uploaded object
+	Metadata map[string]*string
+}
+
+// DefaultUploadMaxRetries is the default number of times an individual
+// part request is retried
+const DefaultUploadMaxRetries = 5
+
+// maxRetries returns the number of part request retries
+func (uploadOptions *UploadOptions) maxRetries() int {
+	switch {
+	case uploadOptions.MaxRetries == 0:
+		return DefaultUploadMaxRetries
+	case uploadOptions.MaxRetries < 0:
+		return 0
+	default:
+		return uploadOptions.MaxRetries
+	}
+}
+
+// DefaultUploadOptions returns the default UploadOptions
+func DefaultUploadOptions() *UploadOptions {
+	return &UploadOptions{
+		PartSize:    s3manager.DefaultUploadPartSize,
+		Concurrency: s3manager.DefaultUploadConcurrency,
+		MaxRetries:  DefaultUploadMaxRetries,
+	}
+}
+
 // UploadLocalFileToS3 takes a local path and uploads the content at localPath
 // to the given S3Bucket and KeyPrefix.  The final S3 keyname is the S3KeyPrefix+
 // the basename of the localPath.
@@ -61,6 +112,29 @@ func UploadLocalFileToS3(localPath string,
 	S3Bucket string,
 	S3KeyName string,
 	logger *logrus.Logger) (string, error) {
+	return UploadLocalFileToS3WithOptions(localPath,
+		awsSession,
+		S3Bucket,
+		S3KeyName,
+		DefaultUploadOptions(),
+		logger)
+}
+
+// UploadLocalFileToS3WithOptions uploads the content at localPath to the
+// given S3Bucket and S3KeyName using the supplied multipart upload options.
+// Individual part requests are retried on transient errors. If the upload
+// fails the multipart upload is aborted so that no orphaned parts are left
+// in the bucket.
+func UploadLocalFileToS3WithOptions(localPath string,
+	awsSession *session.Session,
+	S3Bucket string,
+	S3KeyName string,
+	uploadOptions *UploadOptions,
+	logger *logrus.Logger) (string, error) {
+
+	if uploadOptions == nil {
+		uploadOptions = DefaultUploadOptions()
+	}
 
 	// Then do the actual work
 	/* #nosec */
@@ -73,6 +147,7 @@ func UploadLocalFileToS3(localPath string,
 		Key:         &S3KeyName,
 		ContentType: aws.String(mime.TypeByExtension(path.Ext(localPath))),
 		Body:        reader,
+		Metadata:    uploadOptions.Metadata,
 	}
 	// If we can get the current working directory, let's try and strip
 	// it from the path just to keep the log statement a bit shorter
@@ -96,11 +171,75 @@ func UploadLocalFileToS3(localPath string,
 		"Size":   humanize.Bytes(uint64(stat.Size())),
 	}).Info("Uploading local file to S3")
 
-	uploader := s3manager.NewUploader(awsSession)
+	return upload(uploadInput, awsSession, stat.Size(), uploadOptions, logger)
+}
+
+// UploadReaderToS3WithOptions streams the reader contents to the given
+// S3Bucket and S3KeyName using a multipart upload, so that the content
+// doesn't need to be buffered to a local file. Individual part requests
+// are retried on transient errors. If the upload fails the multipart
+// upload is aborted so that no orphaned parts are left in the bucket.
+func UploadReaderToS3WithOptions(reader io.Reader,
+	awsSession *session.Session,
+	S3Bucket string,
+	S3KeyName string,
+	uploadOptions *UploadOptions,
+	logger *logrus.Logger) (string, error) {
+
+	if uploadOptions == nil {
+		uploadOptions = DefaultUploadOptions()
+	}
+	uploadInput := &s3manager.UploadInput{
+		Bucket:      &S3Bucket,
+		Key:         &S3KeyName,
+		ContentType: aws.String(mime.TypeByExtension(path.Ext(S3KeyName))),
+		Body:        reader,
+		Metadata:    uploadOptions.Metadata,
+	}
+	logger.WithFields(logrus.Fields{
+		"Bucket": S3Bucket,
+		"Key":    S3KeyName,
+	}).Info("Streaming upload to S3")
+	return upload(uploadInput, awsSession, -1, uploadOptions, logger)
+}
+
+// upload performs the multipart upload. The size is only used to report
+// the throughput and is negative if it's unknown.
+func upload(uploadInput *s3manager.UploadInput,
+	awsSession *session.Session,
+	size int64,
+	uploadOptions *UploadOptions,
+	logger *logrus.Logger) (string, error) {
+
+	uploader := s3manager.NewUploader(awsSession, func(u *s3manager.Uploader) {
+		u.PartSize = uploadOptions.PartSize
+		u.Concurrency = uploadOptions.Concurrency
+		// Ensure AbortMultipartUpload is called on failure
+		u.LeavePartsOnError = false
+		u.RequestOptions = append(u.RequestOptions, func(r *request.Request) {
+			r.Retryer = client.DefaultRetryer{
+				NumMaxRetries: uploadOptions.maxRetries(),
+			}
+		})
+	})
+	uploadStart := time.Now()
 	result, err := uploader.Upload(uploadInput)
 	if nil != err {
+		multiUploadErr, multiUploadErrOk := err.(s3manager.MultiUploadFailure)
+		if multiUploadErrOk {
+			logger.WithFields(logrus.Fields{
+				"UploadID": multiUploadErr.UploadID(),
+			}).Warn("Multipart upload failed and was aborted")
+		}
 		return "", errors.Wrapf(err, "Failed to upload object to S3")
 	}
+	uploadDuration := time.Since(uploadStart)
+	if uploadDuration.Seconds() > 0 && size >= 0 {
+		logger.WithFields(logrus.Fields{
+			"Duration":   uploadDuration.String(),
+			"Throughput": fmt.Sprintf("%s/s", humanize.Bytes(uint64(float64(size)/uploadDuration.Seconds()))),
+		}).Info("S3 upload throughput")
+	}
 	if result.VersionID != nil {
 		logger.WithFields(logrus.Fields{
 			"URL":       result.Location,
@@ -156,3 +295,226 @@ func BucketRegion(awsSession *session.Session,
 		S3Bucket,
 		regionHint)
 }
+
+// ExistingObjectURL returns the URL, including the versionId if the bucket
+// is versioned, of an existing S3 object. An empty string is
+// returned if the object doesn't exist.
+func ExistingObjectURL(awsSession *session.Session,
+	S3Bucket string,
+	S3Key string,
+	logger *logrus.Logger) (string, error) {
+	return existingObjectURL(s3.New(awsSession), S3Bucket, S3Key, nil, logger)
+}
+
+// ExistingObjectURLWithMetadata returns the URL of an existing S3 object
+// whose user-defined metadata includes every expectedMetadata value. An
+// empty string is returned if the object doesn't exist or if its
+// metadata doesn't match. Metadata keys are compared case-insensitively.
+func ExistingObjectURLWithMetadata(awsSession *session.Session,
+	S3Bucket string,
+	S3Key string,
+	expectedMetadata map[string]string,
+	logger *logrus.Logger) (string, error) {
+	return existingObjectURL(s3.New(awsSession), S3Bucket, S3Key, expectedMetadata, logger)
+}
+
+func existingObjectURL(s3Svc s3iface.S3API,
+	S3Bucket string,
+	S3Key string,
+	expectedMetadata map[string]string,
+	logger *logrus.Logger) (string, error) {
+	headOutput, headErr := s3Svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(S3Bucket),
+		Key:    aws.String(S3Key),
+	})
+	if headErr != nil {
+		awsErr, awsErrOk := headErr.(awserr.RequestFailure)
+		if awsErrOk && awsErr.StatusCode() == 404 {
+			return "", nil
+		}
+		return "", headErr
+	}
+	for eachKey, eachValue := range expectedMetadata {
+		matched := false
+		for eachObjectKey, eachObjectValue := range headOutput.Metadata {
+			if strings.EqualFold(eachKey, eachObjectKey) &&
+				aws.StringValue(eachObjectValue) == eachValue {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			logger.WithFields(logrus.Fields{
+				"Bucket":      S3Bucket,
+				"Key":         S3Key,
+				"MetadataKey": eachKey,
+			}).Debug("Existing S3 object metadata doesn't match")
+			return "", nil
+		}
+	}
+	objectURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", S3Bucket, S3Key)
+	if headOutput.VersionId != nil && *headOutput.VersionId != "null" {
+		objectURL = fmt.Sprintf("%s?versionId=%s", objectURL, *headOutput.VersionId)
+	}
+	logger.WithFields(logrus.Fields{
+		"URL": objectURL,
+	}).Debug("Found existing S3 object")
+	return objectURL, nil
+}
+
+// bucketNameMaxLength is the maximum S3 bucket name length
+const bucketNameMaxLength = 63
+
+var reInvalidBucketNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ArtifactBucketOptions are the settings used to create a missing
+// artifact bucket
+type ArtifactBucketOptions struct {
+	// ExpirationDays is the number of days after which noncurrent
+	// versions of build artifacts are deleted. Current versions, such
+	// as the deployed code archive, are kept. Zero disables
+	// the lifecycle rule.
+	ExpirationDays int64
+}
+
+// DefaultArtifactBucketOptions returns the default artifact bucket options
+func DefaultArtifactBucketOptions() *ArtifactBucketOptions {
+	return &ArtifactBucketOptions{
+		ExpirationDays: 30,
+	}
+}
+
+// ArtifactBucketName returns the deterministic name of the artifact bucket
+// for the service in the given account and region
+func ArtifactBucketName(serviceName string, accountID string, region string) string {
+	suffix := fmt.Sprintf("-%s-%s", accountID, region)
+	prefix := reInvalidBucketNameChars.ReplaceAllString(strings.ToLower(serviceName), "-")
+	prefix = strings.Trim(prefix, "-")
+	if len(prefix)+len(suffix) > bucketNameMaxLength {
+		prefix = strings.TrimRight(prefix[0:bucketNameMaxLength-len(suffix)], "-")
+	}
+	if prefix == "" {
+		prefix = "sparta"
+	}
+	return prefix + suffix
+}
+
+// EnsureArtifactBucket creates the S3 bucket if it doesn't exist. New
+// buckets have versioning and default encryption enabled, block all
+// public access, and expire noncurrent artifact versions. Returns true
+// if the bucket was created.
+func EnsureArtifactBucket(awsSession *session.Session,
+	S3Bucket string,
+	options *ArtifactBucketOptions,
+	logger *logrus.Logger) (bool, error) {
+	return ensureArtifactBucket(s3.New(awsSession),
+		aws.StringValue(awsSession.Config.Region),
+		S3Bucket,
+		options,
+		logger)
+}
+
+func ensureArtifactBucket(s3Svc s3iface.S3API,
+	region string,
+	S3Bucket string,
+	options *ArtifactBucketOptions,
+	logger *logrus.Logger) (bool, error) {
+	if options == nil {
+		options = DefaultArtifactBucketOptions()
+	}
+	_, headErr := s3Svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket: aws.String(S3Bucket),
+	})
+	if headErr == nil {
+		return false, nil
+	}
+	awsErr, awsErrOk := headErr.(awserr.RequestFailure)
+	if !awsErrOk || awsErr.StatusCode() != 404 {
+		return false, headErr
+	}
+	createInput := &s3.CreateBucketInput{
+		Bucket: aws.String(S3Bucket),
+	}
+	// us-east-1 doesn't accept a LocationConstraint
+	if region != "" && region != "us-east-1" {
+		createInput.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	_, createErr := s3Svc.CreateBucket(createInput)
+	if createErr != nil {
+		return false, errors.Wrapf(createErr, "Failed to create S3 bucket %s", S3Bucket)
+	}
+	waitErr := s3Svc.WaitUntilBucketExists(&s3.HeadBucketInput{
+		Bucket: aws.String(S3Bucket),
+	})
+	if waitErr != nil {
+		return true, waitErr
+	}
+	_, publicAccessErr := s3Svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(S3Bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if publicAccessErr != nil {
+		return true, errors.Wrapf(publicAccessErr, "Failed to block public access")
+	}
+	_, encryptionErr := s3Svc.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+		Bucket: aws.String(S3Bucket),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{
+				{
+					ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+						SSEAlgorithm: aws.String(s3.ServerSideEncryptionAes256),
+					},
+				},
+			},
+		},
+	})
+	if encryptionErr != nil {
+		return true, errors.Wrapf(encryptionErr, "Failed to enable default encryption")
+	}
+	_, versioningErr := s3Svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket: aws.String(S3Bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(s3.BucketVersioningStatusEnabled),
+		},
+	})
+	if versioningErr != nil {
+		return true, errors.Wrapf(versioningErr, "Failed to enable versioning")
+	}
+	if options.ExpirationDays > 0 {
+		_, lifecycleErr := s3Svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket: aws.String(S3Bucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+				Rules: []*s3.LifecycleRule{
+					{
+						ID:     aws.String("ExpireBuildArtifacts"),
+						Status: aws.String(s3.ExpirationStatusEnabled),
+						Filter: &s3.LifecycleRuleFilter{
+							Prefix: aws.String(""),
+						},
+						NoncurrentVersionExpiration: &s3.NoncurrentVersionExpiration{
+							NoncurrentDays: aws.Int64(options.ExpirationDays),
+						},
+						AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+							DaysAfterInitiation: aws.Int64(1),
+						},
+					},
+				},
+			},
+		})
+		if lifecycleErr != nil {
+			return true, errors.Wrapf(lifecycleErr, "Failed to set lifecycle configuration")
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"Bucket":         S3Bucket,
+		"ExpirationDays": options.ExpirationDays,
+	}).Info("Created S3 artifact bucket")
+	return true, nil
+}
@@ -0,0 +1,171 @@
+package s3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/sirupsen/logrus"
+)
+
+func TestUploadMaxRetries(t *testing.T) {
+	testCases := []struct {
+		maxRetries int
+		expected   int
+	}{
+		{0, DefaultUploadMaxRetries},
+		{-1, 0},
+		{3, 3},
+	}
+	for _, eachTest := range testCases {
+		uploadOptions := &UploadOptions{
+			MaxRetries: eachTest.maxRetries,
+		}
+		if uploadOptions.maxRetries() != eachTest.expected {
+			t.Fatalf("Expected %d retries for MaxRetries %d, got %d",
+				eachTest.expected,
+				eachTest.maxRetries,
+				uploadOptions.maxRetries())
+		}
+	}
+}
+
+type headObjectS3API struct {
+	s3iface.S3API
+	objects map[string]*s3.HeadObjectOutput
+}
+
+func (api *headObjectS3API) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	headOutput, headOutputExists := api.objects[aws.StringValue(input.Key)]
+	if !headOutputExists {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "requestID")
+	}
+	return headOutput, nil
+}
+
+func TestExistingObjectURLWithMetadata(t *testing.T) {
+	s3API := &headObjectS3API{
+		objects: map[string]*s3.HeadObjectOutput{
+			"service/code.zip": {
+				VersionId: aws.String("v1"),
+				Metadata: map[string]*string{
+					"Binary-Sha256": aws.String("abc"),
+				},
+			},
+		},
+	}
+	logger := logrus.New()
+	testCases := []struct {
+		key      string
+		metadata map[string]string
+		expected string
+	}{
+		{"service/code.zip", nil, "https://bucket.s3.amazonaws.com/service/code.zip?versionId=v1"},
+		{"service/code.zip", map[string]string{"binary-sha256": "abc"}, "https://bucket.s3.amazonaws.com/service/code.zip?versionId=v1"},
+		{"service/code.zip", map[string]string{"binary-sha256": "def"}, ""},
+		{"service/code.zip", map[string]string{"other": "abc"}, ""},
+		{"service/missing.zip", nil, ""},
+	}
+	for _, eachTest := range testCases {
+		objectURL, objectURLErr := existingObjectURL(s3API,
+			"bucket",
+			eachTest.key,
+			eachTest.metadata,
+			logger)
+		if objectURLErr != nil {
+			t.Fatalf("Failed to check for existing object: %s", objectURLErr)
+		}
+		if objectURL != eachTest.expected {
+			t.Fatalf("Expected URL %q for %s %v, got %q",
+				eachTest.expected,
+				eachTest.key,
+				eachTest.metadata,
+				objectURL)
+		}
+	}
+}
+
+type artifactBucketS3API struct {
+	s3iface.S3API
+	exists          bool
+	createInput     *s3.CreateBucketInput
+	lifecycleConfig *s3.BucketLifecycleConfiguration
+}
+
+func (api *artifactBucketS3API) HeadBucket(input *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	if !api.exists {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "requestID")
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (api *artifactBucketS3API) CreateBucket(input *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	api.createInput = input
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (api *artifactBucketS3API) WaitUntilBucketExists(input *s3.HeadBucketInput) error {
+	return nil
+}
+
+func (api *artifactBucketS3API) PutPublicAccessBlock(input *s3.PutPublicAccessBlockInput) (*s3.PutPublicAccessBlockOutput, error) {
+	return &s3.PutPublicAccessBlockOutput{}, nil
+}
+
+func (api *artifactBucketS3API) PutBucketEncryption(input *s3.PutBucketEncryptionInput) (*s3.PutBucketEncryptionOutput, error) {
+	return &s3.PutBucketEncryptionOutput{}, nil
+}
+
+func (api *artifactBucketS3API) PutBucketVersioning(input *s3.PutBucketVersioningInput) (*s3.PutBucketVersioningOutput, error) {
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (api *artifactBucketS3API) PutBucketLifecycleConfiguration(input *s3.PutBucketLifecycleConfigurationInput) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	api.lifecycleConfig = input.LifecycleConfiguration
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func TestEnsureArtifactBucket(t *testing.T) {
+	logger := logrus.New()
+	s3API := &artifactBucketS3API{}
+	created, createdErr := ensureArtifactBucket(s3API, "us-west-2", "bucket", nil, logger)
+	if createdErr != nil || !created {
+		t.Fatalf("Failed to create artifact bucket: %v", createdErr)
+	}
+	if aws.StringValue(s3API.createInput.CreateBucketConfiguration.LocationConstraint) != "us-west-2" {
+		t.Fatalf("Unexpected bucket location: %v", s3API.createInput)
+	}
+	if s3API.lifecycleConfig == nil || len(s3API.lifecycleConfig.Rules) != 1 {
+		t.Fatalf("Expected a single lifecycle rule: %v", s3API.lifecycleConfig)
+	}
+	// Current versions, such as the deployed code archive, must not expire
+	lifecycleRule := s3API.lifecycleConfig.Rules[0]
+	if lifecycleRule.Expiration != nil {
+		t.Fatalf("Expected current artifact versions to be kept: %v", lifecycleRule)
+	}
+	if aws.Int64Value(lifecycleRule.NoncurrentVersionExpiration.NoncurrentDays) != 30 {
+		t.Fatalf("Unexpected noncurrent version expiration: %v", lifecycleRule)
+	}
+
+	// Zero ExpirationDays disables the lifecycle rule
+	s3API = &artifactBucketS3API{}
+	_, createdErr = ensureArtifactBucket(s3API,
+		"us-east-1",
+		"bucket",
+		&ArtifactBucketOptions{},
+		logger)
+	if createdErr != nil ||
+		s3API.lifecycleConfig != nil ||
+		s3API.createInput.CreateBucketConfiguration != nil {
+		t.Fatalf("Unexpected us-east-1 bucket configuration: %v", s3API.createInput)
+	}
+
+	// Existing buckets aren't modified
+	s3API = &artifactBucketS3API{exists: true}
+	created, createdErr = ensureArtifactBucket(s3API, "us-west-2", "bucket", nil, logger)
+	if createdErr != nil || created || s3API.createInput != nil {
+		t.Fatalf("Expected the existing bucket to be used: %v", createdErr)
+	}
+}
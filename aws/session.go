@@ -1,6 +1,9 @@
 package aws
 
 import (
+	"sort"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -77,3 +80,103 @@ func NewSessionWithConfigLevel(awsConfig *aws.Config,
 	}).Debug("AWS SDK Info")
 	return sess
 }
+
+// RequestLimiter caps the number of concurrent AWS API requests made by
+// the sessions it's attached to and counts the requests per service.
+// Each attempt, including retries, holds a slot for the duration of
+// the HTTP request.
+type RequestLimiter struct {
+	semaphore chan struct{}
+	mutex     sync.Mutex
+	counts    map[string]int64
+}
+
+// NewRequestLimiter returns a RequestLimiter that allows at most
+// maxConcurrentRequests in flight. A value <= 0 only counts the requests.
+func NewRequestLimiter(maxConcurrentRequests int) *RequestLimiter {
+	limiter := &RequestLimiter{
+		counts: make(map[string]int64),
+	}
+	if maxConcurrentRequests > 0 {
+		limiter.semaphore = make(chan struct{}, maxConcurrentRequests)
+	}
+	return limiter
+}
+
+// Attach applies the limiter to all requests made by services created
+// from the session
+func (limiter *RequestLimiter) Attach(sess *session.Session) {
+	if sess == nil {
+		return
+	}
+	sess.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: "sparta.RequestLimiterAcquire",
+		Fn: func(r *request.Request) {
+			if limiter.semaphore != nil {
+				limiter.semaphore <- struct{}{}
+			}
+			limiter.mutex.Lock()
+			limiter.counts[r.ClientInfo.ServiceName]++
+			limiter.mutex.Unlock()
+		},
+	})
+	// CompleteAttempt runs after every attempt's Send handlers
+	sess.Handlers.CompleteAttempt.PushBackNamed(request.NamedHandler{
+		Name: "sparta.RequestLimiterRelease",
+		Fn: func(r *request.Request) {
+			if limiter.semaphore != nil {
+				<-limiter.semaphore
+			}
+		},
+	})
+}
+
+// AttachContext applies the ctx to all requests made by services created
+// from the session that aren't made with an explicit context (eg, via a
+// WithContext API). Cancelling the ctx cancels the in-flight request
+// and fails any subsequent requests.
+func AttachContext(sess *session.Session, ctx aws.Context) {
+	if sess == nil || ctx == nil {
+		return
+	}
+	sess.Handlers.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "sparta.RequestContext",
+		Fn: func(r *request.Request) {
+			if r.Context() == aws.BackgroundContext() {
+				r.SetContext(ctx)
+			}
+		},
+	})
+}
+
+// Counts returns the number of requests made per service
+func (limiter *RequestLimiter) Counts() map[string]int64 {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	counts := make(map[string]int64, len(limiter.counts))
+	for eachService, eachCount := range limiter.counts {
+		counts[eachService] = eachCount
+	}
+	return counts
+}
+
+// LogSummary logs the number of requests made per service
+func (limiter *RequestLimiter) LogSummary(logger *logrus.Logger) {
+	counts := limiter.Counts()
+	serviceNames := make([]string, 0, len(counts))
+	total := int64(0)
+	for eachService, eachCount := range counts {
+		serviceNames = append(serviceNames, eachService)
+		total += eachCount
+	}
+	sort.Strings(serviceNames)
+	for _, eachService := range serviceNames {
+		logger.WithFields(logrus.Fields{
+			"Requests": counts[eachService],
+		}).Info(eachService)
+	}
+	logger.WithFields(logrus.Fields{
+		"Requests":      total,
+		"MaxConcurrent": cap(limiter.semaphore),
+	}).Info("Total AWS API requests")
+}
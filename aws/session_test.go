@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	var inFlight int32
+	var maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			observed := atomic.LoadInt32(&maxInFlight)
+			if current <= observed ||
+				atomic.CompareAndSwapInt32(&maxInFlight, observed, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListAllMyBucketsResult></ListAllMyBucketsResult>`))
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	sess := NewSessionWithConfig(&aws.Config{
+		Region:           aws.String("us-west-2"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}, logger)
+	limiter := NewRequestLimiter(2)
+	limiter.Attach(sess)
+
+	s3Svc := s3.New(sess)
+	requestCount := 8
+	var wg sync.WaitGroup
+	for i := 0; i < requestCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, listErr := s3Svc.ListBuckets(&s3.ListBucketsInput{})
+			if listErr != nil {
+				t.Errorf("Failed to list buckets: %s", listErr)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight > 2 {
+		t.Fatalf("Expected at most 2 concurrent requests, observed %d", maxInFlight)
+	}
+	if count := limiter.Counts()[s3.ServiceName]; count != int64(requestCount) {
+		t.Fatalf("Expected %d s3 requests, got %d", requestCount, count)
+	}
+	limiter.LogSummary(logger)
+}
+
+func TestAttachContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(`<ListAllMyBucketsResult></ListAllMyBucketsResult>`))
+	}))
+	defer server.Close()
+
+	sess := NewSessionWithConfig(&aws.Config{
+		Region:           aws.String("us-west-2"),
+		Endpoint:         aws.String(server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}, logrus.New())
+	ctx, cancel := context.WithCancel(context.Background())
+	AttachContext(sess, ctx)
+	s3Svc := s3.New(sess)
+	_, listErr := s3Svc.ListBuckets(&s3.ListBucketsInput{})
+	if listErr != nil {
+		t.Fatalf("Failed to list buckets: %s", listErr)
+	}
+	cancel()
+	_, listErr = s3Svc.ListBuckets(&s3.ListBucketsInput{})
+	awsErr, awsErrOk := listErr.(awserr.Error)
+	if !awsErrOk || awsErr.Code() != request.CanceledErrorCode {
+		t.Fatalf("Expected canceled request error. Found: %v", listErr)
+	}
+}
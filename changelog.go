@@ -0,0 +1,300 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// permissionResourceTypes are the resource types reported as
+// permission changes
+var permissionResourceTypes = map[string]bool{
+	"AWS::Lambda::Permission":             true,
+	"AWS::IAM::Role":                      true,
+	"AWS::IAM::Policy":                    true,
+	"AWS::IAM::ManagedPolicy":             true,
+	"AWS::SNS::TopicPolicy":               true,
+	"AWS::SQS::QueuePolicy":               true,
+	"AWS::S3::BucketPolicy":               true,
+	"AWS::Lambda::LayerVersionPermission": true,
+}
+
+// changelogIgnoredFunctionProperties are function properties that
+// change with every build and aren't reported
+var changelogIgnoredFunctionProperties = map[string]bool{
+	"Code": true,
+}
+
+// changelogResource is the subset of a template resource that's compared
+type changelogResource struct {
+	Type       string
+	Properties map[string]interface{}
+	Metadata   map[string]interface{}
+}
+
+// name returns the user facing name of the resource
+func (resource *changelogResource) name(logicalName string) string {
+	if golangFunc, ok := resource.Metadata["golangFunc"].(string); ok && golangFunc != "" {
+		return golangFunc
+	}
+	return logicalName
+}
+
+// TemplateChangelogEntry is a single changed resource
+type TemplateChangelogEntry struct {
+	// Name is the function name or the resource logical name
+	Name string
+	// ResourceType is the CloudFormation resource type
+	ResourceType string
+	// Properties are the names of the changed properties
+	Properties []string
+}
+
+// TemplateChangelog is a human readable summary of the resource changes
+// between two versions of a service template
+type TemplateChangelog struct {
+	StackName          string
+	FunctionsAdded     []*TemplateChangelogEntry
+	FunctionsRemoved   []*TemplateChangelogEntry
+	FunctionsChanged   []*TemplateChangelogEntry
+	PermissionsAdded   []*TemplateChangelogEntry
+	PermissionsRemoved []*TemplateChangelogEntry
+	PermissionsChanged []*TemplateChangelogEntry
+	ResourcesAdded     []*TemplateChangelogEntry
+	ResourcesRemoved   []*TemplateChangelogEntry
+	ResourcesChanged   []*TemplateChangelogEntry
+}
+
+// Empty returns true if there are no changes
+func (changelog *TemplateChangelog) Empty() bool {
+	return len(changelog.FunctionsAdded) == 0 &&
+		len(changelog.FunctionsRemoved) == 0 &&
+		len(changelog.FunctionsChanged) == 0 &&
+		len(changelog.PermissionsAdded) == 0 &&
+		len(changelog.PermissionsRemoved) == 0 &&
+		len(changelog.PermissionsChanged) == 0 &&
+		len(changelog.ResourcesAdded) == 0 &&
+		len(changelog.ResourcesRemoved) == 0 &&
+		len(changelog.ResourcesChanged) == 0
+}
+
+// String returns the changelog as Markdown suitable for release notes
+func (changelog *TemplateChangelog) String() string {
+	var output []string
+	output = append(output, fmt.Sprintf("## %s changes", changelog.StackName))
+	if changelog.Empty() {
+		return strings.Join(append(output, "", "No resource changes."), "\n")
+	}
+	writeSection := func(title string, verb string, entries []*TemplateChangelogEntry) {
+		if len(entries) == 0 {
+			return
+		}
+		output = append(output, "", fmt.Sprintf("### %s %s", title, verb))
+		for _, eachEntry := range entries {
+			line := fmt.Sprintf("- %s (%s)", eachEntry.Name, eachEntry.ResourceType)
+			if len(eachEntry.Properties) != 0 {
+				line = fmt.Sprintf("%s: %s", line, strings.Join(eachEntry.Properties, ", "))
+			}
+			output = append(output, line)
+		}
+	}
+	writeSection("Functions", "added", changelog.FunctionsAdded)
+	writeSection("Functions", "removed", changelog.FunctionsRemoved)
+	writeSection("Functions", "changed", changelog.FunctionsChanged)
+	writeSection("Permissions", "added", changelog.PermissionsAdded)
+	writeSection("Permissions", "removed", changelog.PermissionsRemoved)
+	writeSection("Permissions", "changed", changelog.PermissionsChanged)
+	writeSection("Resources", "added", changelog.ResourcesAdded)
+	writeSection("Resources", "removed", changelog.ResourcesRemoved)
+	writeSection("Resources", "changed", changelog.ResourcesChanged)
+	return strings.Join(output, "\n")
+}
+
+// changelogResources parses the resources from the JSON or YAML template body
+func changelogResources(templateBody []byte) (map[string]*changelogResource, error) {
+	jsonBody, jsonBodyErr := jsonTemplateBody(templateBody)
+	if jsonBodyErr != nil {
+		return nil, jsonBodyErr
+	}
+	var template struct {
+		Resources map[string]*changelogResource
+	}
+	unmarshalErr := json.Unmarshal(jsonBody, &template)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse template")
+	}
+	if template.Resources == nil {
+		template.Resources = make(map[string]*changelogResource)
+	}
+	return template.Resources, nil
+}
+
+// changedProperties returns the sorted names of the properties that differ
+func changedProperties(previous *changelogResource, current *changelogResource) []string {
+	propertyNames := make(map[string]bool)
+	for eachName := range previous.Properties {
+		propertyNames[eachName] = true
+	}
+	for eachName := range current.Properties {
+		propertyNames[eachName] = true
+	}
+	var changed []string
+	for eachName := range propertyNames {
+		if current.Type == "AWS::Lambda::Function" &&
+			changelogIgnoredFunctionProperties[eachName] {
+			continue
+		}
+		if !reflect.DeepEqual(previous.Properties[eachName], current.Properties[eachName]) {
+			changed = append(changed, eachName)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// NewTemplateChangelog returns the changelog between the previous and
+// current JSON or YAML template bodies. An empty previous template body means
+// that every resource was added.
+func NewTemplateChangelog(stackName string,
+	previousTemplateBody []byte,
+	currentTemplateBody []byte) (*TemplateChangelog, error) {
+	previousResources := make(map[string]*changelogResource)
+	if len(previousTemplateBody) != 0 {
+		parsedResources, parsedResourcesErr := changelogResources(previousTemplateBody)
+		if parsedResourcesErr != nil {
+			return nil, parsedResourcesErr
+		}
+		previousResources = parsedResources
+	}
+	currentResources, currentResourcesErr := changelogResources(currentTemplateBody)
+	if currentResourcesErr != nil {
+		return nil, currentResourcesErr
+	}
+	changelog := &TemplateChangelog{
+		StackName: stackName,
+	}
+	appendEntry := func(resource *changelogResource,
+		entry *TemplateChangelogEntry,
+		functions *[]*TemplateChangelogEntry,
+		permissions *[]*TemplateChangelogEntry,
+		resources *[]*TemplateChangelogEntry) {
+		switch {
+		case resource.Type == "AWS::Lambda::Function":
+			*functions = append(*functions, entry)
+		case permissionResourceTypes[resource.Type]:
+			*permissions = append(*permissions, entry)
+		default:
+			*resources = append(*resources, entry)
+		}
+	}
+	// Sort the names s.t. the output is stable
+	logicalNames := make([]string, 0, len(previousResources)+len(currentResources))
+	for eachName := range previousResources {
+		logicalNames = append(logicalNames, eachName)
+	}
+	for eachName := range currentResources {
+		if _, exists := previousResources[eachName]; !exists {
+			logicalNames = append(logicalNames, eachName)
+		}
+	}
+	sort.Strings(logicalNames)
+
+	for _, eachName := range logicalNames {
+		previous, previousExists := previousResources[eachName]
+		current, currentExists := currentResources[eachName]
+		switch {
+		case !previousExists:
+			appendEntry(current,
+				&TemplateChangelogEntry{
+					Name:         current.name(eachName),
+					ResourceType: current.Type,
+				},
+				&changelog.FunctionsAdded,
+				&changelog.PermissionsAdded,
+				&changelog.ResourcesAdded)
+		case !currentExists:
+			appendEntry(previous,
+				&TemplateChangelogEntry{
+					Name:         previous.name(eachName),
+					ResourceType: previous.Type,
+				},
+				&changelog.FunctionsRemoved,
+				&changelog.PermissionsRemoved,
+				&changelog.ResourcesRemoved)
+		default:
+			properties := changedProperties(previous, current)
+			if previous.Type != current.Type {
+				properties = append([]string{"Type"}, properties...)
+			}
+			if len(properties) != 0 {
+				appendEntry(current,
+					&TemplateChangelogEntry{
+						Name:         current.name(eachName),
+						ResourceType: current.Type,
+						Properties:   properties,
+					},
+					&changelog.FunctionsChanged,
+					&changelog.PermissionsChanged,
+					&changelog.ResourcesChanged)
+			}
+		}
+	}
+	return changelog, nil
+}
+
+// StackChangelog returns the changelog between the template of the
+// currently deployed serviceName stack and the new template. If
+// the stack doesn't exist, every resource is reported as added.
+func StackChangelog(serviceName string,
+	template *gocf.Template,
+	awsSession *session.Session,
+	logger *logrus.Logger) (*TemplateChangelog, error) {
+	currentTemplateBody, currentTemplateBodyErr := json.Marshal(template)
+	if currentTemplateBodyErr != nil {
+		return nil, errors.Wrapf(currentTemplateBodyErr, "Failed to marshal template")
+	}
+	exists, existsErr := spartaCF.StackExists(serviceName, awsSession, logger)
+	if existsErr != nil {
+		return nil, existsErr
+	}
+	var previousTemplateBody []byte
+	if exists {
+		awsCloudFormation := cloudformation.New(awsSession)
+		templateOutput, templateErr := awsCloudFormation.GetTemplate(&cloudformation.GetTemplateInput{
+			StackName:     aws.String(serviceName),
+			TemplateStage: aws.String(cloudformation.TemplateStageOriginal),
+		})
+		if templateErr != nil {
+			return nil, errors.Wrapf(templateErr, "Failed to get template for stack %s", serviceName)
+		}
+		if templateOutput.TemplateBody != nil {
+			previousTemplateBody = []byte(*templateOutput.TemplateBody)
+		}
+	}
+	changelog, changelogErr := NewTemplateChangelog(serviceName,
+		previousTemplateBody,
+		currentTemplateBody)
+	if changelogErr != nil {
+		return nil, changelogErr
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName":        serviceName,
+		"StackExists":      exists,
+		"FunctionsAdded":   len(changelog.FunctionsAdded),
+		"FunctionsRemoved": len(changelog.FunctionsRemoved),
+		"FunctionsChanged": len(changelog.FunctionsChanged),
+	}).Debug("Computed stack changelog")
+	return changelog, nil
+}
@@ -0,0 +1,194 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// lambdaPricing is the published, on-demand, x86 pricing (USD) used
+// by EstimateCost. Ref: https://aws.amazon.com/lambda/pricing/
+type lambdaPricing struct {
+	// per 1M requests
+	requests float64
+	// per GB-second of duration
+	duration float64
+	// per GB-second of provisioned concurrency
+	provisionedConcurrency float64
+	// per GB-second of duration with provisioned concurrency
+	provisionedDuration float64
+	// per GB of CloudWatch Logs ingestion
+	logsIngestion float64
+}
+
+const (
+	// costEstimateHoursPerMonth is the number of hours in the average month
+	costEstimateHoursPerMonth = 730
+	// costEstimateDisclaimer is included in every CostEstimate
+	costEstimateDisclaimer = "ESTIMATE ONLY. Based on published on-demand pricing, excluding the free tier, data transfer and any resources other than the functions."
+)
+
+var defaultLambdaPricing = lambdaPricing{
+	requests:               0.20,
+	duration:               0.0000166667,
+	provisionedConcurrency: 0.0000041667,
+	provisionedDuration:    0.0000097222,
+	logsIngestion:          0.50,
+}
+
+// regionalLambdaPricing are the regions whose pricing differs from
+// the default
+var regionalLambdaPricing = map[string]lambdaPricing{
+	"af-south-1": {
+		requests:               0.28,
+		duration:               0.0000221,
+		provisionedConcurrency: 0.0000055,
+		provisionedDuration:    0.0000129,
+		logsIngestion:          0.66,
+	},
+	"ap-east-1": {
+		requests:               0.28,
+		duration:               0.00002291,
+		provisionedConcurrency: 0.0000057,
+		provisionedDuration:    0.0000134,
+		logsIngestion:          0.70,
+	},
+	"me-south-1": {
+		requests:               0.25,
+		duration:               0.0000206,
+		provisionedConcurrency: 0.0000052,
+		provisionedDuration:    0.0000121,
+		logsIngestion:          0.60,
+	},
+}
+
+// FunctionUsage is the assumed monthly usage of a function
+type FunctionUsage struct {
+	// InvocationsPerMonth is the expected number of invocations
+	InvocationsPerMonth float64
+	// AverageDuration is the expected duration of each invocation
+	AverageDuration time.Duration
+	// LogBytesPerInvocation is the expected log output of each invocation
+	LogBytesPerInvocation int64
+	// ProvisionedConcurrency is the number of provisioned environments
+	ProvisionedConcurrency int64
+}
+
+// CostEstimateOptions are the inputs to EstimateCost
+type CostEstimateOptions struct {
+	// Region is the AWS region to price. Defaults to us-east-1 pricing.
+	Region string
+	// DefaultUsage is the usage assumed for functions that
+	// aren't in FunctionUsage
+	DefaultUsage FunctionUsage
+	// FunctionUsage is the optional per-function usage, keyed by
+	// the function name
+	FunctionUsage map[string]*FunctionUsage
+}
+
+// FunctionCostEstimate is the estimated monthly cost (USD) of a function
+type FunctionCostEstimate struct {
+	FunctionName               string
+	MemorySize                 int64
+	RequestCost                float64
+	DurationCost               float64
+	ProvisionedConcurrencyCost float64
+	LogsCost                   float64
+	Total                      float64
+}
+
+// CostEstimate is the estimated monthly cost (USD) of the functions
+// in a service
+type CostEstimate struct {
+	Region     string
+	Functions  []*FunctionCostEstimate
+	Total      float64
+	Disclaimer string
+}
+
+// roundCurrency rounds to the nearest cent
+func roundCurrency(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// EstimateCost returns a rough monthly cost estimate of the functions
+// given the assumed usage. It uses a built-in pricing table rather than
+// the AWS Pricing API, so the result is only an estimate.
+func EstimateCost(lambdaAWSInfos []*LambdaAWSInfo,
+	options *CostEstimateOptions) (*CostEstimate, error) {
+	if options == nil {
+		return nil, errors.New("EstimateCost requires CostEstimateOptions")
+	}
+	pricing, regionalPricing := regionalLambdaPricing[options.Region]
+	if !regionalPricing {
+		pricing = defaultLambdaPricing
+	}
+	estimate := &CostEstimate{
+		Region:     options.Region,
+		Disclaimer: costEstimateDisclaimer,
+	}
+	for _, eachLambda := range lambdaAWSInfos {
+		functionName := eachLambda.lambdaFunctionName()
+		usage := &options.DefaultUsage
+		functionUsage, functionUsageExists := options.FunctionUsage[functionName]
+		if functionUsageExists && functionUsage != nil {
+			usage = functionUsage
+		}
+		if usage.InvocationsPerMonth < 0 ||
+			usage.AverageDuration < 0 ||
+			usage.LogBytesPerInvocation < 0 ||
+			usage.ProvisionedConcurrency < 0 {
+			return nil, errors.Errorf("EstimateCost usage for %s must not be negative", functionName)
+		}
+		memorySize := int64(lambdaMinMemorySize)
+		if eachLambda.Options != nil {
+			if eachLambda.Options.MemorySizeParameter != nil {
+				memorySize = eachLambda.Options.MemorySizeParameter.Default
+			} else if eachLambda.Options.MemorySize != 0 {
+				memorySize = eachLambda.Options.MemorySize
+			}
+		}
+		memoryGB := float64(memorySize) / 1024
+		// Duration is billed in 1ms increments
+		billedSeconds := math.Ceil(float64(usage.AverageDuration)/float64(time.Millisecond)) / 1000
+		durationGBSeconds := usage.InvocationsPerMonth * billedSeconds * memoryGB
+
+		functionEstimate := &FunctionCostEstimate{
+			FunctionName: functionName,
+			MemorySize:   memorySize,
+			RequestCost:  usage.InvocationsPerMonth / 1000000 * pricing.requests,
+			LogsCost: usage.InvocationsPerMonth *
+				float64(usage.LogBytesPerInvocation) /
+				(1024 * 1024 * 1024) *
+				pricing.logsIngestion,
+		}
+		if usage.ProvisionedConcurrency != 0 {
+			provisionedGBSeconds := float64(usage.ProvisionedConcurrency) *
+				memoryGB *
+				costEstimateHoursPerMonth * 3600
+			functionEstimate.ProvisionedConcurrencyCost = provisionedGBSeconds * pricing.provisionedConcurrency
+			functionEstimate.DurationCost = durationGBSeconds * pricing.provisionedDuration
+		} else {
+			functionEstimate.DurationCost = durationGBSeconds * pricing.duration
+		}
+		functionEstimate.RequestCost = roundCurrency(functionEstimate.RequestCost)
+		functionEstimate.DurationCost = roundCurrency(functionEstimate.DurationCost)
+		functionEstimate.ProvisionedConcurrencyCost = roundCurrency(functionEstimate.ProvisionedConcurrencyCost)
+		functionEstimate.LogsCost = roundCurrency(functionEstimate.LogsCost)
+		functionEstimate.Total = roundCurrency(functionEstimate.RequestCost +
+			functionEstimate.DurationCost +
+			functionEstimate.ProvisionedConcurrencyCost +
+			functionEstimate.LogsCost)
+		estimate.Functions = append(estimate.Functions, functionEstimate)
+		estimate.Total += functionEstimate.Total
+	}
+	estimate.Total = roundCurrency(estimate.Total)
+	sort.Slice(estimate.Functions, func(i, j int) bool {
+		return estimate.Functions[i].FunctionName < estimate.Functions[j].FunctionName
+	})
+	return estimate, nil
+}
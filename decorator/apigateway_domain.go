@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/session"
 	sparta "github.com/mweagle/Sparta"
-	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -17,9 +18,168 @@ const (
 	APIGatewayMappingEntry = "APIGatewayMappings"
 )
 
+const (
+	// OutputAPIGatewayCustomDomain is the keyname used in the CloudFormation
+	// Output that stores the custom domain name
+	// @enum OutputKey
+	OutputAPIGatewayCustomDomain = "APIGatewayCustomDomain"
+	// OutputAPIGatewayCustomDomainTarget is the keyname used in the
+	// CloudFormation Output that stores the DNS name that the custom domain
+	// name should alias
+	// @enum OutputKey
+	OutputAPIGatewayCustomDomainTarget = "APIGatewayCustomDomainTarget"
+)
+
+// edgeCertificateRegion is the region that must issue the ACM
+// certificate for an edge-optimized custom domain
+const edgeCertificateRegion = "us-east-1"
+
+// APIGatewayDomainOptions are the custom domain name settings for an
+// API Gateway
+type APIGatewayDomainOptions struct {
+	// DomainName is the custom domain name (eg, api.example.com)
+	DomainName string
+	// CertificateArn is the ACM certificate for the DomainName. Edge-optimized
+	// APIs require a certificate issued in us-east-1. Regional APIs require
+	// a certificate issued in the stack's region.
+	CertificateArn gocf.Stringable
+	// BasePath is the optional path under the DomainName to which the API
+	// is mapped
+	BasePath string
+	// HostedZoneID is the optional Route53 hosted zone in which to create
+	// an alias record for the DomainName
+	HostedZoneID gocf.Stringable
+}
+
+// apiGatewayDomainType returns the endpoint type of the API. APIs
+// without an EndpointConfiguration are edge-optimized.
+func apiGatewayDomainType(apiGateway *sparta.API) (string, error) {
+	apiGWEndpointConfiguration := apiGateway.EndpointConfiguration
+	if apiGWEndpointConfiguration == nil || apiGWEndpointConfiguration.Types == nil {
+		return "EDGE", nil
+	}
+	typesList := apiGWEndpointConfiguration.Types
+	if len(typesList.Literal) != 1 {
+		return "", errors.Errorf("Invalid API GW types provided to decorator: %#v",
+			apiGWEndpointConfiguration.Types)
+	}
+	return typesList.Literal[0].Literal, nil
+}
+
+// warnCertificateRegion logs a warning if a literal certificate ARN is
+// from a region that can't be used for the custom domain
+func warnCertificateRegion(apiGatewayType string,
+	acmCertARN gocf.Stringable,
+	awsSession *session.Session,
+	logger *logrus.Logger) {
+	certExpr := acmCertARN.String()
+	if certExpr.Func != nil {
+		return
+	}
+	parsedArn, parsedArnErr := arn.Parse(certExpr.Literal)
+	if parsedArnErr != nil {
+		logger.WithFields(logrus.Fields{
+			"CertificateArn": certExpr.Literal,
+			"Error":          parsedArnErr,
+		}).Warn("Failed to parse API Gateway custom domain certificate ARN")
+		return
+	}
+	expectedRegion := edgeCertificateRegion
+	if apiGatewayType != "EDGE" {
+		if awsSession == nil || awsSession.Config.Region == nil {
+			return
+		}
+		expectedRegion = aws.StringValue(awsSession.Config.Region)
+	}
+	if parsedArn.Region != expectedRegion {
+		logger.WithFields(logrus.Fields{
+			"CertificateArn":  certExpr.Literal,
+			"CertificateType": apiGatewayType,
+			"ExpectedRegion":  expectedRegion,
+		}).Warn("API Gateway custom domain certificate must be issued in the expected region")
+	}
+}
+
+// addAPIGatewayDomain adds the DomainName and BasePathMapping resources for
+// the API and returns the alias target for the domain
+func addAPIGatewayDomain(apiGateway *sparta.API,
+	options *APIGatewayDomainOptions,
+	template *gocf.Template,
+	awsSession *session.Session,
+	logger *logrus.Logger) (*gocf.Route53RecordSetAliasTarget, error) {
+
+	if options.CertificateArn == nil {
+		return nil, errors.Errorf("APIGatewayDomainOptions.CertificateArn is required for domain: %s",
+			options.DomainName)
+	}
+	apiGatewayType, apiGatewayTypeErr := apiGatewayDomainType(apiGateway)
+	if apiGatewayTypeErr != nil {
+		return nil, apiGatewayTypeErr
+	}
+	// Resource names
+	domainInfoResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(),
+		"Domain")
+	basePathMappingResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(), "BasePathMapping")
+
+	// Then add all the resources
+	domainInfo := &gocf.APIGatewayDomainName{
+		DomainName: gocf.String(options.DomainName),
+	}
+	dnsNameAttr := ""
+	hostedZoneIDAttr := ""
+	switch apiGatewayType {
+	case "REGIONAL":
+		{
+			domainInfo.RegionalCertificateArn = options.CertificateArn.String()
+			domainInfo.EndpointConfiguration = &gocf.APIGatewayDomainNameEndpointConfiguration{
+				Types: gocf.StringList(gocf.String("REGIONAL")),
+			}
+			dnsNameAttr = "RegionalDomainName"
+			hostedZoneIDAttr = "RegionalHostedZoneId"
+		}
+	case "EDGE":
+		{
+			domainInfo.CertificateArn = options.CertificateArn.String()
+			domainInfo.EndpointConfiguration = &gocf.APIGatewayDomainNameEndpointConfiguration{
+				Types: gocf.StringList(gocf.String("EDGE")),
+			}
+			dnsNameAttr = "DistributionDomainName"
+			hostedZoneIDAttr = "DistributionHostedZoneId"
+		}
+	default:
+		return nil, errors.Errorf("Unsupported API Gateway type: %#v", apiGatewayType)
+	}
+	warnCertificateRegion(apiGatewayType, options.CertificateArn, awsSession, logger)
+	template.AddResource(domainInfoResourceName, domainInfo)
+
+	basePathMapping := gocf.APIGatewayBasePathMapping{
+		BasePath:   gocf.String(options.BasePath),
+		DomainName: gocf.Ref(domainInfoResourceName).String(),
+		RestAPIID:  gocf.Ref(apiGateway.LogicalResourceName()).String(),
+	}
+	mappingResource := template.AddResource(basePathMappingResourceName, basePathMapping)
+	mappingResource.DependsOn = []string{domainInfoResourceName,
+		apiGateway.LogicalResourceName()}
+
+	// Add the outputs...
+	template.Outputs[OutputAPIGatewayCustomDomain] = &gocf.Output{
+		Description: "Custom API Gateway Domain",
+		Value:       gocf.String(options.DomainName),
+	}
+	template.Outputs[OutputAPIGatewayCustomDomainTarget] = &gocf.Output{
+		Description: "Custom API Gateway Domain alias target",
+		Value:       gocf.GetAtt(domainInfoResourceName, dnsNameAttr),
+	}
+	return &gocf.Route53RecordSetAliasTarget{
+		HostedZoneID: gocf.GetAtt(domainInfoResourceName, hostedZoneIDAttr),
+		DNSName:      gocf.GetAtt(domainInfoResourceName, dnsNameAttr),
+	}, nil
+}
+
 // APIGatewayDomainDecorator returns a ServiceDecoratorHookHandler
 // implementation that registers a custom domain for an API Gateway
-// service
+// service. The Route53 record is created in the hosted zone that's
+// named by the domainName's parent domain.
 func APIGatewayDomainDecorator(apiGateway *sparta.API,
 	acmCertARN gocf.Stringable,
 	basePath string,
@@ -41,84 +201,83 @@ func APIGatewayDomainDecorator(apiGateway *sparta.API,
 			return errors.Errorf("Invalid domain name supplied to APIGatewayDomainDecorator: %s",
 				domainName)
 		}
-		// Add the mapping
-		template.Mappings = map[string]*gocf.Mapping{
-			APIGatewayMappingEntry: spartaCF.APIGatewayMapping,
+		aliasTarget, aliasTargetErr := addAPIGatewayDomain(apiGateway,
+			&APIGatewayDomainOptions{
+				DomainName:     domainName,
+				CertificateArn: acmCertARN,
+				BasePath:       basePath,
+			},
+			template,
+			awsSession,
+			logger)
+		if aliasTargetErr != nil {
+			return aliasTargetErr
 		}
-		// Resource names
-		domainInfoResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(),
-			"Domain")
-		basePathMappingResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(), "BasePathMapping")
+		// Use the HostedZoneName to create the record
 		dnsRecordResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(),
 			"CloudFrontDNS")
-
-		// Then add all the resources
-		domainInfo := &gocf.APIGatewayDomainName{
-			DomainName: gocf.String(domainName),
-		}
-		apiGatewayType := ""
-		apiGWEndpointConfiguration := apiGateway.EndpointConfiguration
-		if apiGWEndpointConfiguration != nil && apiGWEndpointConfiguration.Types != nil {
-			typesList := apiGWEndpointConfiguration.Types
-			if len(typesList.Literal) == 1 {
-				apiGatewayType = typesList.Literal[0].Literal
-			} else {
-				return errors.Errorf("Invalid API GW types provided to decorator: %#v",
-					apiGWEndpointConfiguration.Types)
-			}
-		}
-		attrName := ""
-		switch apiGatewayType {
-		case "REGIONAL":
-			{
-				domainInfo.RegionalCertificateArn = acmCertARN.String()
-				domainInfo.EndpointConfiguration = &gocf.APIGatewayDomainNameEndpointConfiguration{
-					Types: gocf.StringList(gocf.String("REGIONAL")),
-				}
-				attrName = "RegionalDomainName"
-			}
-		case "EDGE":
-			{
-				domainInfo.CertificateArn = acmCertARN.String()
-				domainInfo.EndpointConfiguration = &gocf.APIGatewayDomainNameEndpointConfiguration{
-					Types: gocf.StringList(gocf.String("EDGE")),
-				}
-				attrName = "DistributionDomainName"
-			}
-		default:
-			return errors.Errorf("Unsupported API Gateway type: %#v", apiGatewayType)
-		}
-		template.AddResource(domainInfoResourceName, domainInfo)
-
-		basePathMapping := gocf.APIGatewayBasePathMapping{
-			BasePath:   gocf.String(basePath),
-			DomainName: gocf.Ref(domainInfoResourceName).String(),
-			RestAPIID:  gocf.Ref(apiGateway.LogicalResourceName()).String(),
-		}
-		mappingResource := template.AddResource(basePathMappingResourceName, basePathMapping)
-		mappingResource.DependsOn = []string{domainInfoResourceName,
-			apiGateway.LogicalResourceName()}
-
-		// Use the HostedZoneName to create the record
 		domainZone := domainParts[1:]
 		dnsRecordResource := &gocf.Route53RecordSet{
 			HostedZoneName: gocf.String(fmt.Sprintf("%s.", strings.Join(domainZone, "."))),
 			Name:           gocf.String(fmt.Sprintf("%s.", domainName)),
 			Type:           gocf.String("A"),
-			AliasTarget: &gocf.Route53RecordSetAliasTarget{
-				HostedZoneID: gocf.FindInMap(APIGatewayMappingEntry,
-					gocf.Ref("AWS::Region"),
-					gocf.String(spartaCF.HostedZoneID)),
-				DNSName: gocf.GetAtt(domainInfoResourceName, attrName).String(),
-			},
+			AliasTarget:    aliasTarget,
 		}
 		template.AddResource(dnsRecordResourceName, dnsRecordResource)
+		return nil
+	}
+	return sparta.ServiceDecoratorHookFunc(domainDecorator)
+}
+
+// APIGatewayDomainDecoratorWithOptions returns a ServiceDecoratorHookHandler
+// implementation that registers a custom domain for an API Gateway
+// service. A Route53 alias record is created iff the options include a
+// HostedZoneID. Otherwise, create a record for the
+// APIGatewayCustomDomainTarget output value.
+func APIGatewayDomainDecoratorWithOptions(apiGateway *sparta.API,
+	options *APIGatewayDomainOptions) sparta.ServiceDecoratorHookHandler {
+
+	domainDecorator := func(context map[string]interface{},
+		serviceName string,
+		template *gocf.Template,
+		S3Bucket string,
+		S3Key string,
+		buildID string,
+		awsSession *session.Session,
+		noop bool,
+		logger *logrus.Logger) error {
 
-		// Add an output...
-		template.Outputs["APIGatewayCustomDomain"] = &gocf.Output{
-			Description: "Custom API Gateway Domain",
-			Value:       gocf.String(domainName),
+		if options == nil || options.DomainName == "" {
+			return errors.New("APIGatewayDomainDecoratorWithOptions requires a DomainName")
+		}
+		if len(strings.Split(options.DomainName, ".")) < 2 {
+			return errors.Errorf("Invalid domain name supplied to APIGatewayDomainDecoratorWithOptions: %s",
+				options.DomainName)
+		}
+		aliasTarget, aliasTargetErr := addAPIGatewayDomain(apiGateway,
+			options,
+			template,
+			awsSession,
+			logger)
+		if aliasTargetErr != nil {
+			return aliasTargetErr
+		}
+		if options.HostedZoneID == nil {
+			logger.WithFields(logrus.Fields{
+				"DomainName": options.DomainName,
+				"Output":     OutputAPIGatewayCustomDomainTarget,
+			}).Info("No HostedZoneID provided. Create a DNS record for the custom domain alias target.")
+			return nil
 		}
+		dnsRecordResourceName := sparta.CloudFormationResourceName(apiGateway.LogicalResourceName(),
+			"DomainDNS")
+		dnsRecordResource := &gocf.Route53RecordSet{
+			HostedZoneID: options.HostedZoneID.String(),
+			Name:         gocf.String(fmt.Sprintf("%s.", options.DomainName)),
+			Type:         gocf.String("A"),
+			AliasTarget:  aliasTarget,
+		}
+		template.AddResource(dnsRecordResourceName, dnsRecordResource)
 		return nil
 	}
 	return sparta.ServiceDecoratorHookFunc(domainDecorator)
@@ -10,6 +10,7 @@ import (
 	spartaAWSEvents "github.com/mweagle/Sparta/aws/events"
 	spartaTesting "github.com/mweagle/Sparta/testing"
 	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
 )
 
 func TestAPIGatewayCustomDomain(t *testing.T) {
@@ -19,7 +20,7 @@ func TestAPIGatewayCustomDomain(t *testing.T) {
 	}
 	lambdaFuncs := func(api *sparta.API) []*sparta.LambdaAWSInfo {
 		var lambdaFunctions []*sparta.LambdaAWSInfo
-		lambdaFn := sparta.HandleAWSLambda(sparta.LambdaName(helloWorld),
+		lambdaFn := sparta.HandleAWSLambda("helloWorld",
 			helloWorld,
 			sparta.IAMRoleDefinition{})
 		apiGatewayResource, _ := api.NewResource("/hello", lambdaFn)
@@ -70,6 +71,72 @@ func TestAPIGatewayCustomDomain(t *testing.T) {
 		nil)
 }
 
+func TestAPIGatewayDomainDecoratorWithOptions(t *testing.T) {
+	apiGateway := sparta.NewAPIGateway("SpartaDomainOptions", sparta.NewStage("v1"))
+	options := &APIGatewayDomainOptions{
+		DomainName:     "api.spartademo.net",
+		CertificateArn: gocf.String("arn:aws:acm:us-east-1:123412341234:certificate/6486C3FF-A3B7-46B6-83A0-9AE329FEC4E3"),
+		BasePath:       "v1",
+		HostedZoneID:   gocf.String("Z1234567890"),
+	}
+	template := gocf.NewTemplate()
+	decorator := APIGatewayDomainDecoratorWithOptions(apiGateway, options)
+	decorateErr := decorator.DecorateService(map[string]interface{}{},
+		"SpartaDomainOptions",
+		template,
+		"",
+		"",
+		"",
+		nil,
+		true,
+		logrus.New())
+	if decorateErr != nil {
+		t.Fatalf("Failed to decorate service: %s", decorateErr)
+	}
+	resourceTypes := make(map[string]interface{})
+	for _, eachResource := range template.Resources {
+		resourceTypes[eachResource.Properties.CfnResourceType()] = eachResource.Properties
+	}
+	domainName, domainNameOk := resourceTypes["AWS::ApiGateway::DomainName"].(*gocf.APIGatewayDomainName)
+	if !domainNameOk || domainName.CertificateArn == nil || domainName.RegionalCertificateArn != nil {
+		t.Fatalf("Expected edge-optimized DomainName resource: %#v", resourceTypes)
+	}
+	if _, exists := resourceTypes["AWS::ApiGateway::BasePathMapping"]; !exists {
+		t.Fatalf("Expected BasePathMapping resource: %#v", resourceTypes)
+	}
+	recordSet, recordSetOk := resourceTypes["AWS::Route53::RecordSet"].(*gocf.Route53RecordSet)
+	if !recordSetOk || recordSet.HostedZoneID.Literal != "Z1234567890" {
+		t.Fatalf("Expected Route53 alias record: %#v", resourceTypes)
+	}
+	if _, exists := template.Outputs[OutputAPIGatewayCustomDomain]; !exists {
+		t.Fatalf("Expected %s output", OutputAPIGatewayCustomDomain)
+	}
+
+	// No hosted zone, no record
+	options.HostedZoneID = nil
+	template = gocf.NewTemplate()
+	decorateErr = decorator.DecorateService(map[string]interface{}{},
+		"SpartaDomainOptions",
+		template,
+		"",
+		"",
+		"",
+		nil,
+		true,
+		logrus.New())
+	if decorateErr != nil {
+		t.Fatalf("Failed to decorate service: %s", decorateErr)
+	}
+	for _, eachResource := range template.Resources {
+		if eachResource.Properties.CfnResourceType() == "AWS::Route53::RecordSet" {
+			t.Fatalf("Unexpected Route53 record without HostedZoneID")
+		}
+	}
+	if _, exists := template.Outputs[OutputAPIGatewayCustomDomainTarget]; !exists {
+		t.Fatalf("Expected %s output", OutputAPIGatewayCustomDomainTarget)
+	}
+}
+
 func ExampleAPIGatewayDomainDecorator() {
 	helloWorld := func(ctx context.Context,
 		gatewayEvent spartaAWSEvents.APIGatewayRequest) (interface{}, error) {
@@ -77,7 +144,7 @@ func ExampleAPIGatewayDomainDecorator() {
 	}
 	lambdaFuncs := func(api *sparta.API) []*sparta.LambdaAWSInfo {
 		var lambdaFunctions []*sparta.LambdaAWSInfo
-		lambdaFn := sparta.HandleAWSLambda(sparta.LambdaName(helloWorld),
+		lambdaFn := sparta.HandleAWSLambda("helloWorld",
 			helloWorld,
 			sparta.IAMRoleDefinition{})
 		apiGatewayResource, _ := api.NewResource("/hello", lambdaFn)
@@ -17,6 +17,10 @@ const (
 	// that stores the CloudWatch Dashboard URL
 	// @enum OutputKey
 	OutputDashboardURL = "CloudWatchDashboardURL"
+	// OutputFunctionDashboardURL is the keyname used in the CloudFormation
+	// Output that stores the per-function CloudWatch Dashboard URL
+	// @enum OutputKey
+	OutputFunctionDashboardURL = "CloudWatchFunctionDashboardURL"
 )
 
 const (
@@ -25,6 +29,9 @@ const (
 	metricsPerRow     = 3
 	metricWidthUnits  = 6
 	metricHeightUnits = 6
+	// functionDashboardNameSuffix is appended to the service name
+	// to name the per-function dashboard
+	functionDashboardNameSuffix = "-Functions"
 )
 
 // widgetExtents represents the extents of various containers in the generated
@@ -55,12 +62,13 @@ type DashboardTemplateData struct {
 	SpartaGitHash    string
 	TimeSeriesPeriod int
 	Extents          widgetExtents
+	// FunctionMetrics are the metric widgets in each function row
+	FunctionMetrics []DashboardFunctionMetric
 }
 
-// The default dashboard template
-var dashboardTemplate = `
-{
-    "widgets": [
+// dashboardHeaderWidgets are the summary and log widgets at the top of
+// each dashboard
+var dashboardHeaderWidgets = `
     {
         "type": "text",
         "x": 0,
@@ -88,7 +96,12 @@ var dashboardTemplate = `
 * 🔎 [{ "Ref" : "<< $eachLambda.ResourceName >>" }](https://{ "Ref" : "AWS::Region" }.console.aws.amazon.com/cloudwatch/home?region={ "Ref" : "AWS::Region" }#logStream:group=/aws/lambda/{ "Ref" : "<< $eachLambda.ResourceName >>" })\n
 <<end>>"
         }
-    }<<range $index, $eachLambda := .LambdaFunctions>>,
+    }`
+
+// The default dashboard template
+var dashboardTemplate = `
+{
+    "widgets": [` + dashboardHeaderWidgets + `<<range $index, $eachLambda := .LambdaFunctions>>,
     {
       "type": "metric",
       "x": <<widgetX $index >>,
@@ -112,6 +125,46 @@ var dashboardTemplate = `
 }
 `
 
+// functionDashboardTemplate has one row of metric widgets per function
+var functionDashboardTemplate = `
+{
+    "widgets": [` + dashboardHeaderWidgets + `<<range $index, $eachLambda := .LambdaFunctions>><<range $column, $eachMetric := $.FunctionMetrics>>,
+    {
+      "type": "metric",
+      "x": << functionWidgetX $column >>,
+      "y": << functionWidgetY $index >>,
+      "width": << $.Extents.MetricWidthUnits >>,
+      "height": << $.Extents.MetricHeightUnits >>,
+      "properties": {
+        "view": "timeSeries",
+        "stacked": false,
+        "metrics": [<<range $statIndex, $eachStat := $eachMetric.Stats>><<if $statIndex>>,<<end>>
+            [ "AWS/Lambda", "<< $eachMetric.Name >>", "FunctionName", "{ "Ref" : "<< $eachLambda.ResourceName >>" }", { "stat": "<< $eachStat >>" }]<<end>>
+        ],
+        "region": "{ "Ref" : "AWS::Region" }",
+        "period": << $.TimeSeriesPeriod >>,
+        "title": "λ: { "Ref" : "<< $eachLambda.ResourceName >>" } << $eachMetric.Name >>"
+      }
+    }<<end>><<end>>
+  ]
+}
+`
+
+// DashboardFunctionMetric is a metric widget in each function row
+// of the FunctionDashboardDecorator dashboard
+type DashboardFunctionMetric struct {
+	Name  string
+	Stats []string
+}
+
+// functionMetrics are the widgets in each function row
+var functionMetrics = []DashboardFunctionMetric{
+	{Name: "Invocations", Stats: []string{"Sum"}},
+	{Name: "Errors", Stats: []string{"Sum"}},
+	{Name: "Duration", Stats: []string{"Average", "p99"}},
+	{Name: "Throttles", Stats: []string{"Sum"}},
+}
+
 var templateFuncMap = template.FuncMap{
 	// The name "inc" is what the function will be called in the template text.
 	"widgetX": func(lambdaIndex int) int {
@@ -123,12 +176,21 @@ var templateFuncMap = template.FuncMap{
 		// That's the row
 		return headerHeightUnits + (xRow * metricHeightUnits)
 	},
+	"functionWidgetX": func(metricIndex int) int {
+		return metricWidthUnits * metricIndex
+	},
+	"functionWidgetY": func(lambdaIndex int) int {
+		return headerHeightUnits + (lambdaIndex * metricHeightUnits)
+	},
 }
 
-// DashboardDecorator returns a ServiceDecoratorHook function that
-// can be attached the workflow to create a dashboard
-func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
-	timeSeriesPeriod int) sparta.ServiceDecoratorHookFunc {
+// dashboardDecorator returns a ServiceDecoratorHook function that
+// creates a dashboard from the dashboard template text
+func dashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
+	timeSeriesPeriod int,
+	dashboardTemplateText string,
+	dashboardNameSuffix string,
+	outputName string) sparta.ServiceDecoratorHookFunc {
 	return func(context map[string]interface{},
 		serviceName string,
 		cfTemplate *gocf.Template,
@@ -158,12 +220,13 @@ func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
 				MetricHeightUnits: metricHeightUnits,
 				MetricsPerRow:     metricsPerRow,
 			},
+			FunctionMetrics: functionMetrics,
 		}
 
 		dashboardTmpl, dashboardTmplErr := template.New("dashboard").
 			Delims("<<", ">>").
 			Funcs(templateFuncMap).
-			Parse(dashboardTemplate)
+			Parse(dashboardTemplateText)
 		if nil != dashboardTmplErr {
 			return dashboardTmplErr
 		}
@@ -199,12 +262,12 @@ func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
 
 		dashboardResource := gocf.CloudWatchDashboard{}
 		dashboardResource.DashboardBody = templateExpr
-		dashboardResource.DashboardName = gocf.String(serviceName)
-		dashboardName := sparta.CloudFormationResourceName("Dashboard", "Dashboard")
+		dashboardResource.DashboardName = gocf.String(serviceName + dashboardNameSuffix)
+		dashboardName := sparta.CloudFormationResourceName("Dashboard", "Dashboard"+dashboardNameSuffix)
 		cfTemplate.AddResource(dashboardName, &dashboardResource)
 
 		// Add the output
-		cfTemplate.Outputs[OutputDashboardURL] = &gocf.Output{
+		cfTemplate.Outputs[outputName] = &gocf.Output{
 			Description: "CloudWatch Dashboard URL",
 			Value: gocf.Join("",
 				gocf.String("https://"),
@@ -217,3 +280,28 @@ func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
 		return nil
 	}
 }
+
+// DashboardDecorator returns a ServiceDecoratorHook function that
+// can be attached the workflow to create a dashboard
+func DashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
+	timeSeriesPeriod int) sparta.ServiceDecoratorHookFunc {
+	return dashboardDecorator(lambdaAWSInfo,
+		timeSeriesPeriod,
+		dashboardTemplate,
+		"",
+		OutputDashboardURL)
+}
+
+// FunctionDashboardDecorator returns a ServiceDecoratorHook function that
+// creates a dashboard with one row per function. Each row charts the
+// function's invocations, errors, duration and throttles. The dashboard
+// is named <serviceName>-Functions and its URL is published in the
+// OutputFunctionDashboardURL Output.
+func FunctionDashboardDecorator(lambdaAWSInfo []*sparta.LambdaAWSInfo,
+	timeSeriesPeriod int) sparta.ServiceDecoratorHookFunc {
+	return dashboardDecorator(lambdaAWSInfo,
+		timeSeriesPeriod,
+		functionDashboardTemplate,
+		functionDashboardNameSuffix,
+		OutputFunctionDashboardURL)
+}
@@ -0,0 +1,55 @@
+package decorator
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	sparta "github.com/mweagle/Sparta"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func TestFunctionDashboardDecorator(t *testing.T) {
+	var lambdaFunctions []*sparta.LambdaAWSInfo
+	for _, eachName := range []string{"HelloWorld", "GoodbyeWorld"} {
+		lambdaFn, lambdaFnErr := sparta.NewAWSLambda(eachName,
+			func(ctx context.Context) (string, error) {
+				return "Hello World", nil
+			},
+			sparta.IAMRoleDefinition{})
+		if lambdaFnErr != nil {
+			t.Fatalf("Failed to create function: %s", lambdaFnErr)
+		}
+		lambdaFunctions = append(lambdaFunctions, lambdaFn)
+	}
+	template := gocf.NewTemplate()
+	decoratorErr := FunctionDashboardDecorator(lambdaFunctions, 60)(nil,
+		"DashboardService",
+		template,
+		"",
+		"",
+		"",
+		nil,
+		true,
+		logrus.New())
+	if decoratorErr != nil {
+		t.Fatalf("Failed to decorate service: %s", decoratorErr)
+	}
+	if template.Outputs[OutputFunctionDashboardURL] == nil {
+		t.Fatalf("Failed to publish dashboard URL Output")
+	}
+	templateJSON, templateJSONErr := json.Marshal(template)
+	if templateJSONErr != nil {
+		t.Fatalf("Failed to marshal template: %s", templateJSONErr)
+	}
+	// Two header widgets and a row of metrics per function
+	widgetCount := strings.Count(string(templateJSON), `\"type\": \"metric\"`)
+	if widgetCount != len(lambdaFunctions)*len(functionMetrics) {
+		t.Fatalf("Unexpected metric widget count: %d", widgetCount)
+	}
+	if !strings.Contains(string(templateJSON), "DashboardService-Functions") {
+		t.Fatalf("Failed to name dashboard for the service")
+	}
+}
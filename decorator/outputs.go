@@ -100,3 +100,38 @@ func PublishRefOutputDecorator(keyName string, description string) sparta.Templa
 
 	return sparta.TemplateDecoratorHookFunc(attrDecorator)
 }
+
+// PublishExportedOutputDecorator returns a ServiceDecoratorHookFunc that
+// publishes the given value as a stack Output with an Export name so that
+// other stacks can reference it via Fn::ImportValue. Export names
+// must be unique within an account and region.
+func PublishExportedOutputDecorator(keyName string,
+	description string,
+	value interface{},
+	exportName gocf.Stringable) sparta.ServiceDecoratorHookFunc {
+	return func(context map[string]interface{},
+		serviceName string,
+		cfTemplate *gocf.Template,
+		S3Bucket string,
+		S3Key string,
+		buildID string,
+		awsSession *session.Session,
+		noop bool,
+		logger *logrus.Logger) error {
+
+		cfTemplate.Outputs[sanitizedKeyName(keyName)] = &gocf.Output{
+			Description: description,
+			Value:       value,
+			Export: &gocf.OutputExport{
+				Name: exportName.String(),
+			},
+		}
+		return nil
+	}
+}
+
+// ImportValue returns a reference to a value exported by another
+// CloudFormation stack in the same account and region.
+func ImportValue(exportName string) *gocf.StringExpr {
+	return gocf.ImportValue(gocf.String(exportName)).String()
+}
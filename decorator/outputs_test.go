@@ -0,0 +1,58 @@
+package decorator
+
+import (
+	"encoding/json"
+	"testing"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func TestPublishExportedOutputDecorator(t *testing.T) {
+	template := gocf.NewTemplate()
+	exportName := gocf.Join("-", gocf.Ref("AWS::StackName"), gocf.String("TopicArn"))
+	decoratorErr := PublishExportedOutputDecorator("Topic-Arn",
+		"Topic ARN",
+		gocf.Ref("Topic"),
+		exportName)(nil,
+		"ExportService",
+		template,
+		"bucket",
+		"key",
+		"buildID",
+		nil,
+		false,
+		logrus.New())
+	if decoratorErr != nil {
+		t.Fatalf("Failed to decorate template: %s", decoratorErr)
+	}
+	output, outputExists := template.Outputs["TopicArn"]
+	if !outputExists {
+		t.Fatalf("Expected sanitized TopicArn output: %#v", template.Outputs)
+	}
+	if output.Export == nil {
+		t.Fatalf("Expected TopicArn output Export")
+	}
+	actual, actualErr := json.Marshal(output.Export.Name)
+	if actualErr != nil {
+		t.Fatalf("Failed to marshal Export name: %s", actualErr)
+	}
+	expected, expectedErr := json.Marshal(exportName.String())
+	if expectedErr != nil {
+		t.Fatalf("Failed to marshal expected Export name: %s", expectedErr)
+	}
+	if string(actual) != string(expected) {
+		t.Fatalf("Unexpected Export name. Expected: %s, Actual: %s", expected, actual)
+	}
+}
+
+func TestImportValue(t *testing.T) {
+	importJSON, importJSONErr := json.Marshal(ImportValue("SharedTopicArn"))
+	if importJSONErr != nil {
+		t.Fatalf("Failed to marshal ImportValue: %s", importJSONErr)
+	}
+	expected := `{"Fn::ImportValue":"SharedTopicArn"}`
+	if string(importJSON) != expected {
+		t.Fatalf("Unexpected ImportValue. Expected: %s, Actual: %s", expected, importJSON)
+	}
+}
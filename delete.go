@@ -3,16 +3,224 @@
 package sparta
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	spartaAWS "github.com/mweagle/Sparta/aws"
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// statefulResourceTypes are the resource types whose data is
+// lost when the resource is deleted
+var statefulResourceTypes = map[string]bool{
+	"AWS::S3::Bucket":                true,
+	"AWS::DynamoDB::Table":           true,
+	"AWS::RDS::DBInstance":           true,
+	"AWS::RDS::DBCluster":            true,
+	"AWS::SQS::Queue":                true,
+	"AWS::Kinesis::Stream":           true,
+	"AWS::Logs::LogGroup":            true,
+	"AWS::EFS::FileSystem":           true,
+	"AWS::ElastiCache::CacheCluster": true,
+}
+
+// DeleteSummaryResource is a stack resource included in a DeleteSummary
+type DeleteSummaryResource struct {
+	LogicalResourceID string
+	ResourceType      string
+	DeletionPolicy    string
+}
+
+// DeleteSummary describes the stack that will be deleted
+type DeleteSummary struct {
+	// StackName is the name of the stack to delete
+	StackName string
+	// ResourceCounts is the number of stack resources by type
+	ResourceCounts map[string]int
+	// RetainedResources are resources with a DeletionPolicy of Retain
+	// or Snapshot. Their data will survive the delete.
+	RetainedResources []*DeleteSummaryResource
+	// StatefulResources are resources whose data will be deleted
+	StatefulResources []*DeleteSummaryResource
+	// TerminationProtection is true if the stack can't be deleted
+	TerminationProtection bool
+}
+
+// DeleteConfirmation is called with the summary of the stack to delete.
+// The stack is deleted iff it returns true.
+type DeleteConfirmation func(summary *DeleteSummary) (bool, error)
+
+// DeleteOptions are the options for DeleteWithOptions
+type DeleteOptions struct {
+	// Force, if true, deletes the stack without confirmation
+	Force bool
+	// Confirm is required if Force is false
+	Confirm DeleteConfirmation
+}
+
+// isTerminal returns true if the file is a character device, such as an
+// interactive terminal, rather than a pipe or regular file
+func isTerminal(file *os.File) bool {
+	stat, statErr := file.Stat()
+	if statErr != nil {
+		return false
+	}
+	return (stat.Mode() & os.ModeCharDevice) != 0
+}
+
+// NewConsoleDeleteConfirmation returns a DeleteConfirmation that requires
+// the stack name to be entered on the reader before the stack is deleted.
+// If the reader is a file that isn't a terminal (eg, stdin in a CI job),
+// the confirmation fails rather than waiting for input.
+func NewConsoleDeleteConfirmation(reader io.Reader, writer io.Writer) DeleteConfirmation {
+	return func(summary *DeleteSummary) (bool, error) {
+		readerFile, readerFileOk := reader.(*os.File)
+		if readerFileOk && !isTerminal(readerFile) {
+			return false, errors.Errorf("Delete of stack %s requires confirmation from an interactive terminal. "+
+				"Pass --yes to delete without confirmation",
+				summary.StackName)
+		}
+		_, writeErr := fmt.Fprintf(writer,
+			"Enter the stack name (%s) to confirm deletion: ",
+			summary.StackName)
+		if writeErr != nil {
+			return false, writeErr
+		}
+		response, readErr := bufio.NewReader(reader).ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return false, readErr
+		}
+		return strings.TrimSpace(response) == summary.StackName, nil
+	}
+}
+
+// deleteSummary returns the summary of the existing stack
+func deleteSummary(serviceName string,
+	awsSession *session.Session) (*DeleteSummary, error) {
+	awsCloudFormation := cloudformation.New(awsSession)
+	summary := &DeleteSummary{
+		StackName:      serviceName,
+		ResourceCounts: make(map[string]int),
+	}
+	describeOutput, describeErr := awsCloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(serviceName),
+	})
+	if describeErr != nil {
+		return nil, describeErr
+	}
+	if len(describeOutput.Stacks) != 0 &&
+		describeOutput.Stacks[0].EnableTerminationProtection != nil {
+		summary.TerminationProtection = *describeOutput.Stacks[0].EnableTerminationProtection
+	}
+
+	// The DeletionPolicy is only available in the template
+	deletionPolicies := make(map[string]string)
+	templateOutput, templateErr := awsCloudFormation.GetTemplate(&cloudformation.GetTemplateInput{
+		StackName: aws.String(serviceName),
+	})
+	if templateErr != nil {
+		return nil, templateErr
+	}
+	var templateBody struct {
+		Resources map[string]struct {
+			DeletionPolicy string
+		}
+	}
+	// Non-JSON templates don't report DeletionPolicy
+	if templateOutput.TemplateBody != nil &&
+		json.Unmarshal([]byte(*templateOutput.TemplateBody), &templateBody) == nil {
+		for eachName, eachResource := range templateBody.Resources {
+			deletionPolicies[eachName] = eachResource.DeletionPolicy
+		}
+	}
+
+	listErr := awsCloudFormation.ListStackResourcesPages(&cloudformation.ListStackResourcesInput{
+		StackName: aws.String(serviceName),
+	}, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+		for _, eachResource := range page.StackResourceSummaries {
+			resourceType := *eachResource.ResourceType
+			summary.ResourceCounts[resourceType]++
+			resource := &DeleteSummaryResource{
+				LogicalResourceID: *eachResource.LogicalResourceId,
+				ResourceType:      resourceType,
+				DeletionPolicy:    deletionPolicies[*eachResource.LogicalResourceId],
+			}
+			switch resource.DeletionPolicy {
+			case "Retain", "Snapshot":
+				summary.RetainedResources = append(summary.RetainedResources, resource)
+			default:
+				if statefulResourceTypes[resourceType] {
+					summary.StatefulResources = append(summary.StatefulResources, resource)
+				}
+			}
+		}
+		return true
+	})
+	if listErr != nil {
+		return nil, listErr
+	}
+	return summary, nil
+}
+
+// logDeleteSummary logs the summary of the stack to delete
+func logDeleteSummary(summary *DeleteSummary, logger *logrus.Logger) {
+	logSectionHeader("Delete Summary", dividerLength, logger)
+	logger.WithFields(logrus.Fields{
+		"Name":                  summary.StackName,
+		"TerminationProtection": summary.TerminationProtection,
+	}).Info("Stack")
+
+	resourceTypes := make([]string, 0, len(summary.ResourceCounts))
+	for eachType := range summary.ResourceCounts {
+		resourceTypes = append(resourceTypes, eachType)
+	}
+	sort.Strings(resourceTypes)
+	for _, eachType := range resourceTypes {
+		logger.WithFields(logrus.Fields{
+			"Count": summary.ResourceCounts[eachType],
+		}).Info(eachType)
+	}
+	for _, eachResource := range summary.RetainedResources {
+		logger.WithFields(logrus.Fields{
+			"Resource":       eachResource.LogicalResourceID,
+			"Type":           eachResource.ResourceType,
+			"DeletionPolicy": eachResource.DeletionPolicy,
+		}).Info("Resource will be retained")
+	}
+	for _, eachResource := range summary.StatefulResources {
+		logger.WithFields(logrus.Fields{
+			"Resource": eachResource.LogicalResourceID,
+			"Type":     eachResource.ResourceType,
+		}).Warn("Resource data will be deleted")
+	}
+}
+
 // Delete ensures that the provided serviceName is deleted.
 // Failing to delete a non-existent service is considered a success.
 func Delete(serviceName string, logger *logrus.Logger) error {
+	return DeleteWithOptions(serviceName, &DeleteOptions{Force: true}, logger)
+}
+
+// DeleteWithOptions ensures that the provided serviceName is deleted. Unless
+// options.Force is true, a summary of the stack is logged and the
+// options.Confirm function must return true before the stack is deleted.
+// Failing to delete a non-existent service is considered a success.
+func DeleteWithOptions(serviceName string,
+	options *DeleteOptions,
+	logger *logrus.Logger) error {
+	if options == nil || (!options.Force && options.Confirm == nil) {
+		return errors.New("Delete requires either Force or a Confirm function")
+	}
 	session := spartaAWS.NewSession(logger)
 	awsCloudFormation := cloudformation.New(session)
 
@@ -26,7 +234,25 @@ func Delete(serviceName string, logger *logrus.Logger) error {
 	}).Info("Stack existence check")
 
 	if exists {
-
+		if !options.Force {
+			summary, summaryErr := deleteSummary(serviceName, session)
+			if summaryErr != nil {
+				return errors.Wrapf(summaryErr, "Failed to summarize stack %s", serviceName)
+			}
+			logDeleteSummary(summary, logger)
+			if summary.TerminationProtection {
+				return errors.Errorf("Stack %s has termination protection enabled and cannot be deleted",
+					serviceName)
+			}
+			confirmed, confirmErr := options.Confirm(summary)
+			if confirmErr != nil {
+				return confirmErr
+			}
+			if !confirmed {
+				logger.WithField("Name", serviceName).Warn("Delete not confirmed")
+				return errors.Errorf("Delete of stack %s was not confirmed", serviceName)
+			}
+		}
 		params := &cloudformation.DeleteStackInput{
 			StackName: aws.String(serviceName),
 		}
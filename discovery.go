@@ -73,11 +73,13 @@ func initializeDiscovery(logger *logrus.Logger) {
 		if cachedDiscoveryInfo != nil {
 			return cachedDiscoveryInfo, nil
 		}
-		// Initialize the cache
-		cachedDiscoveryInfo = &DiscoveryInfo{}
-
 		// Get the serialized discovery info the environment string
 		discoveryInfo := os.Getenv(envVarDiscoveryInformation)
+		if discoveryInfo == "" {
+			return nil, fmt.Errorf("discovery information is not available. Ensure DisableDiscovery is false for this function")
+		}
+		// Initialize the cache
+		cachedDiscoveryInfo = &DiscoveryInfo{}
 		decoded, decodedErr := base64.StdEncoding.DecodeString(discoveryInfo)
 		logger.WithFields(logrus.Fields{
 			"DecodeData":  string(decoded),
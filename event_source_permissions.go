@@ -0,0 +1,168 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	cfCustomResources "github.com/mweagle/Sparta/aws/cloudformation/resources"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+// eventSourceInvocation is a push based event source that invokes a
+// function in the same template
+type eventSourceInvocation struct {
+	sourceName   string
+	principal    string
+	functionName string
+}
+
+// templateFunctionName returns the logical name of the template's
+// AWS::Lambda::Function referenced by the Ref or Fn::GetAtt value, or
+// the empty string if the value doesn't reference a function
+func templateFunctionName(value interface{}, functionNames map[string]bool) string {
+	valueJSON, valueJSONErr := json.Marshal(value)
+	if valueJSONErr != nil {
+		return ""
+	}
+	var parsedValue map[string]interface{}
+	if json.Unmarshal(valueJSON, &parsedValue) != nil {
+		return ""
+	}
+	refName, _ := parsedValue["Ref"].(string)
+	if attValues, attValuesOk := parsedValue["Fn::GetAtt"].([]interface{}); attValuesOk && len(attValues) != 0 {
+		refName, _ = attValues[0].(string)
+	}
+	if functionNames[refName] {
+		return refName
+	}
+	return ""
+}
+
+// resourceProperties returns the JSON object representation of the
+// resource properties
+func resourceProperties(resource *gocf.Resource) (map[string]interface{}, error) {
+	propertiesJSON, propertiesJSONErr := json.Marshal(resource.Properties)
+	if propertiesJSONErr != nil {
+		return nil, propertiesJSONErr
+	}
+	properties := make(map[string]interface{})
+	unmarshalErr := json.Unmarshal(propertiesJSON, &properties)
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return properties, nil
+}
+
+// validateEventSourcePermissions ensures that every S3 and SNS event
+// source that invokes a function in the template has a matching
+// lambda:InvokeFunction AWS::Lambda::Permission for the source's
+// service principal. Without the permission the stack provisions
+// successfully but the function is never invoked. Sources that
+// target functions outside the template can't be verified and
+// are ignored. Pull based sources such as SQS queues are authorized
+// by the function's execution role rather than a resource policy,
+// and their privileges are added by the EventSourceMapping annotations.
+func validateEventSourcePermissions(template *gocf.Template) error {
+	functionNames := make(map[string]bool)
+	for eachName, eachResource := range template.Resources {
+		if eachResource.Properties != nil &&
+			eachResource.Properties.CfnResourceType() == "AWS::Lambda::Function" {
+			functionNames[eachName] = true
+		}
+	}
+	// Function logical name -> principals with invoke permission
+	invokePermissions := make(map[string]map[string]bool)
+	var invocations []*eventSourceInvocation
+	appendInvocation := func(sourceName string, principal string, target interface{}) {
+		functionName := templateFunctionName(target, functionNames)
+		if functionName != "" {
+			invocations = append(invocations, &eventSourceInvocation{
+				sourceName:   sourceName,
+				principal:    principal,
+				functionName: functionName,
+			})
+		}
+	}
+
+	for eachName, eachResource := range template.Resources {
+		if eachResource.Properties == nil {
+			continue
+		}
+		// Sparta's S3 and SNS subscriptions are custom resources
+		switch typedResource := eachResource.Properties.(type) {
+		case *cfCustomResources.S3LambdaEventSourceResource:
+			appendInvocation(eachName, S3Principal, typedResource.LambdaTargetArn)
+			continue
+		case *cfCustomResources.SNSLambdaEventSourceResource:
+			appendInvocation(eachName, SNSPrincipal, typedResource.LambdaTargetArn)
+			continue
+		}
+		resourceType := eachResource.Properties.CfnResourceType()
+		switch resourceType {
+		case "AWS::Lambda::Permission",
+			"AWS::SNS::Subscription",
+			"AWS::SNS::Topic",
+			"AWS::S3::Bucket":
+		default:
+			continue
+		}
+		properties, propertiesErr := resourceProperties(eachResource)
+		if propertiesErr != nil {
+			return errors.Wrapf(propertiesErr, "Failed to marshal resource %s", eachName)
+		}
+		switch resourceType {
+		case "AWS::Lambda::Permission":
+			action, _ := properties["Action"].(string)
+			principal, _ := properties["Principal"].(string)
+			functionName := templateFunctionName(properties["FunctionName"], functionNames)
+			if functionName == "" || principal == "" ||
+				(action != "lambda:InvokeFunction" && action != "lambda:*") {
+				continue
+			}
+			if invokePermissions[functionName] == nil {
+				invokePermissions[functionName] = make(map[string]bool)
+			}
+			invokePermissions[functionName][principal] = true
+		case "AWS::SNS::Subscription":
+			if protocol, _ := properties["Protocol"].(string); protocol == "lambda" {
+				appendInvocation(eachName, SNSPrincipal, properties["Endpoint"])
+			}
+		case "AWS::SNS::Topic":
+			subscriptions, _ := properties["Subscription"].([]interface{})
+			for _, eachSubscription := range subscriptions {
+				subscription, _ := eachSubscription.(map[string]interface{})
+				if protocol, _ := subscription["Protocol"].(string); protocol == "lambda" {
+					appendInvocation(eachName, SNSPrincipal, subscription["Endpoint"])
+				}
+			}
+		case "AWS::S3::Bucket":
+			notificationConfig, _ := properties["NotificationConfiguration"].(map[string]interface{})
+			lambdaConfigs, _ := notificationConfig["LambdaConfigurations"].([]interface{})
+			for _, eachConfig := range lambdaConfigs {
+				lambdaConfig, _ := eachConfig.(map[string]interface{})
+				appendInvocation(eachName, S3Principal, lambdaConfig["Function"])
+			}
+		}
+	}
+
+	var errorText []string
+	for _, eachInvocation := range invocations {
+		if !invokePermissions[eachInvocation.functionName][eachInvocation.principal] {
+			errorText = append(errorText,
+				fmt.Sprintf("Event source %s invokes function %s without a lambda:InvokeFunction permission for principal %s",
+					eachInvocation.sourceName,
+					eachInvocation.functionName,
+					eachInvocation.principal))
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
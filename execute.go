@@ -2,10 +2,14 @@ package sparta
 
 import (
 	"context"
+	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	awsLambdaEvents "github.com/aws/aws-lambda-go/events"
 	"github.com/pkg/errors"
 )
 
@@ -30,6 +34,88 @@ const (
 
 const functionNameDelimiter = "_"
 
+// LambdaDispatchOptions are the runtime options that control how the
+// handler is invoked in AWS Lambda
+type LambdaDispatchOptions struct {
+	// RecoverPanics, if true, recovers a panic in the handler, logs a
+	// structured error with the recovered value and stack trace, and
+	// returns a *HandlerPanicError
+	RecoverPanics bool
+	// DeadlineMargin, if non-zero, cancels the handler's context this
+	// long before the function times out so that the handler has time to
+	// exit in an orderly fashion
+	DeadlineMargin time.Duration
+}
+
+// HandlerPanicError is the error returned for a recovered handler panic
+type HandlerPanicError struct {
+	// FunctionName is the AWS Lambda function name
+	FunctionName string
+	// RequestID is the AWS request ID
+	RequestID string
+	// Value is the recovered value
+	Value interface{}
+}
+
+// Error satisfies the error interface
+func (panicErr *HandlerPanicError) Error() string {
+	return fmt.Sprintf("Function %s panicked handling request %s: %v",
+		panicErr.FunctionName,
+		panicErr.RequestID,
+		panicErr.Value)
+}
+
+// listHandlersFlag is the argument that causes the lambda binary to
+// print its dispatch handler names and exit
+const listHandlersFlag = "--list-handlers"
+
+// dispatchHandlerNames returns the sorted internal names of the user
+// functions and custom resources that the binary dispatches to
+func dispatchHandlerNames(lambdaAWSInfos []*LambdaAWSInfo) []string {
+	handlerNames := []string{}
+	for _, eachLambdaInfo := range lambdaAWSInfos {
+		handlerNames = append(handlerNames,
+			awsLambdaInternalName(eachLambdaInfo.lambdaFunctionName()))
+		for _, eachCustomResource := range eachLambdaInfo.customResources {
+			handlerNames = append(handlerNames,
+				awsLambdaInternalName(eachCustomResource.userFunctionName))
+		}
+	}
+	sort.Strings(handlerNames)
+	return handlerNames
+}
+
+// dispatchHandlerMismatch returns an error describing the handlers that
+// are only known to one of the build or the lambda binary
+func dispatchHandlerMismatch(expected []string, reported []string) error {
+	reportedSet := make(map[string]bool)
+	for _, eachName := range reported {
+		reportedSet[eachName] = true
+	}
+	expectedSet := make(map[string]bool)
+	var missing []string
+	for _, eachName := range expected {
+		expectedSet[eachName] = true
+		if !reportedSet[eachName] {
+			missing = append(missing, eachName)
+		}
+	}
+	var unexpected []string
+	for _, eachName := range reported {
+		if !expectedSet[eachName] {
+			unexpected = append(unexpected, eachName)
+		}
+	}
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+	return errors.Errorf("Lambda binary dispatch handlers don't match the build. "+
+		"Missing from binary: [%s]. Unknown to build: [%s]. Check the build tags "+
+		"of the files that register the functions.",
+		strings.Join(missing, ", "),
+		strings.Join(unexpected, ", "))
+}
+
 // awsLambdaFunctionName returns the name of the function, which
 // is set in the CloudFormation template that is published
 // into the container as `AWS_LAMBDA_FUNCTION_NAME`. Rather
@@ -107,3 +193,72 @@ func ensureValidSignature(lambdaName string, handlerSymbol interface{}) error {
 	}
 	return nil
 }
+
+// proxyResponseTypes are the return types that are compatible with an
+// AWS_PROXY integration
+var proxyResponseTypes = []reflect.Type{
+	reflect.TypeOf(awsLambdaEvents.APIGatewayProxyResponse{}),
+	reflect.TypeOf(awsLambdaEvents.APIGatewayV2HTTPResponse{}),
+}
+
+// ensureValidProxySignature verifies that a function attached to an
+// AWS_PROXY integration returns a proxy response. Functions that return
+// an interface{} are accepted since the concrete type is only
+// known at execution time.
+func ensureValidProxySignature(lambdaName string, handlerSymbol interface{}) error {
+	handlerType := reflect.TypeOf(handlerSymbol)
+	if handlerType == nil || handlerType.Kind() != reflect.Func {
+		return ensureValidSignature(lambdaName, handlerSymbol)
+	}
+	expectedSignature := "func(context.Context, events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)"
+	if handlerType.NumOut() != 2 {
+		return errors.Errorf("Lambda function (%s) is attached to an API Gateway proxy integration and must return a proxy response. Expected: %s",
+			lambdaName,
+			expectedSignature)
+	}
+	returnType := handlerType.Out(0)
+	if returnType.Kind() == reflect.Ptr {
+		returnType = returnType.Elem()
+	}
+	if returnType.Kind() == reflect.Interface && returnType.NumMethod() == 0 {
+		return nil
+	}
+	for _, eachType := range proxyResponseTypes {
+		if returnType == eachType {
+			return nil
+		}
+	}
+	return errors.Errorf("Lambda function (%s) is attached to an API Gateway proxy integration but returns %s. Expected: %s",
+		lambdaName,
+		handlerType.Out(0),
+		expectedSignature)
+}
+
+// ensureValidWebsocketSignature verifies that a function attached to a
+// WEBSOCKET API AWS_PROXY integration either only returns an error or
+// returns a response that's marshaled to a JSON object, such as a
+// struct with statusCode and body fields
+func ensureValidWebsocketSignature(lambdaName string, handlerSymbol interface{}) error {
+	handlerType := reflect.TypeOf(handlerSymbol)
+	if handlerType == nil || handlerType.Kind() != reflect.Func {
+		return ensureValidSignature(lambdaName, handlerSymbol)
+	}
+	if handlerType.NumOut() < 2 {
+		return nil
+	}
+	returnType := handlerType.Out(0)
+	if returnType.Kind() == reflect.Ptr {
+		returnType = returnType.Elem()
+	}
+	switch returnType.Kind() {
+	case reflect.Struct, reflect.Map:
+		return nil
+	case reflect.Interface:
+		if returnType.NumMethod() == 0 {
+			return nil
+		}
+	}
+	return errors.Errorf("Lambda function (%s) is attached to an API Gateway WEBSOCKET integration but returns %s. Expected a struct response (eg, events.APIGatewayProxyResponse) or only an error",
+		lambdaName,
+		handlerType.Out(0))
+}
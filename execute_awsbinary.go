@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"runtime/debug"
 	"sync"
 
 	awsLambdaGo "github.com/aws/aws-lambda-go/lambda"
@@ -57,9 +58,43 @@ func takesContext(handler reflect.Type) bool {
 	return handlerTakesContext
 }
 
+// dispatchHandler calls the handler. If recoverPanics is true, a panic
+// is logged and returned as a *HandlerPanicError.
+func dispatchHandler(ctx context.Context,
+	handler reflect.Value,
+	args []reflect.Value,
+	recoverPanics bool,
+	logger *logrus.Entry) (response []reflect.Value, panicErr error) {
+	if recoverPanics {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			handlerPanicErr := &HandlerPanicError{
+				FunctionName: awsLambdaContext.FunctionName,
+				Value:        recovered,
+			}
+			lambdaContext, lambdaContextOk := awsLambdaContext.FromContext(ctx)
+			if lambdaContextOk {
+				handlerPanicErr.RequestID = lambdaContext.AwsRequestID
+			}
+			logger.WithFields(logrus.Fields{
+				"function": handlerPanicErr.FunctionName,
+				"panic":    fmt.Sprintf("%v", recovered),
+				"stack":    string(debug.Stack()),
+			}).Error("Recovered handler panic")
+			response = nil
+			panicErr = handlerPanicErr
+		}()
+	}
+	return handler.Call(args), nil
+}
+
 // tappedHandler is the handler that represents this binary's mode
 func tappedHandler(handlerSymbol interface{},
 	interceptors *LambdaEventInterceptors,
+	dispatchOptions *LambdaDispatchOptions,
 	logger *logrus.Logger) interface{} {
 
 	// If there aren't any, make it a bit easier
@@ -67,6 +102,9 @@ func tappedHandler(handlerSymbol interface{},
 	if interceptors == nil {
 		interceptors = &LambdaEventInterceptors{}
 	}
+	if dispatchOptions == nil {
+		dispatchOptions = &LambdaDispatchOptions{}
+	}
 
 	// Tap the call chain to inject the context params...
 	handler := reflect.ValueOf(handlerSymbol)
@@ -89,8 +127,17 @@ func tappedHandler(handlerSymbol interface{},
 	// How to determine if this handler has tracing enabled? That would be a property
 	// of the function template associated with this function.
 
-	// TODO - add Context.Timeout handler to ensure orderly exit
 	return func(ctx context.Context, msg json.RawMessage) (interface{}, error) {
+		// Reserve time before the Lambda deadline s.t. the handler
+		// can exit in an orderly fashion
+		if dispatchOptions.DeadlineMargin > 0 {
+			if deadline, deadlineOk := ctx.Deadline(); deadlineOk {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx,
+					deadline.Add(-dispatchOptions.DeadlineMargin))
+				defer cancel()
+			}
+		}
 
 		awsSession := spartaAWS.NewSession(logger)
 		ctx = applyInterceptors(ctx, msg, interceptors.Begin)
@@ -132,12 +179,16 @@ func tappedHandler(handlerSymbol interface{},
 			args = append(args, event.Elem())
 		}
 		ctx = applyInterceptors(ctx, msg, interceptors.BeforeDispatch)
-		response := handler.Call(args)
+		response, panicErr := dispatchHandler(ctx,
+			handler,
+			args,
+			dispatchOptions.RecoverPanics,
+			logrusEntry)
 		ctx = applyInterceptors(ctx, msg, interceptors.AfterDispatch)
 
 		// If the user function
 		// convert return values into (interface{}, error)
-		var err error
+		err := panicErr
 		if len(response) > 0 {
 			if errVal, ok := response[len(response)-1].Interface().(error); ok {
 				err = errVal
@@ -177,6 +228,7 @@ func Execute(serviceName string,
 
 	// So what if we have workflow hooks in here?
 	var interceptors *LambdaEventInterceptors
+	var dispatchOptions *LambdaDispatchOptions
 
 	/*
 		There are three types of targets:
@@ -202,6 +254,7 @@ func Execute(serviceName string,
 		if requestedLambdaFunctionName == testAWSName {
 			handlerSymbol = eachLambdaInfo.handlerSymbol
 			interceptors = eachLambdaInfo.Interceptors
+			dispatchOptions = eachLambdaInfo.DispatchOptions
 
 		}
 
@@ -260,7 +313,7 @@ func Execute(serviceName string,
 	}
 
 	// Startup our version...
-	tappedHandler := tappedHandler(handlerSymbol, interceptors, logger)
+	tappedHandler := tappedHandler(handlerSymbol, interceptors, dispatchOptions, logger)
 	awsLambdaGo.Start(tappedHandler)
 	return nil
 }
@@ -0,0 +1,155 @@
+package sparta
+
+import (
+	"regexp"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lambdaAliasMaxLength is the maximum length of an alias name
+	lambdaAliasMaxLength = 128
+)
+
+// Alias names can't be numeric, since those are reserved for versions
+var reLambdaAliasName = regexp.MustCompile(`^(?:[a-zA-Z0-9-_]*[a-zA-Z-_][a-zA-Z0-9-_]*)$`)
+
+// FunctionAlias publishes a new version of the function with each
+// provision and points the named alias at that version. The function's
+// permissions and event source mappings target the alias rather than
+// $LATEST.
+//
+// Every provision with a new build ID publishes a version whose
+// CloudFormation resource has a Retain DeletionPolicy, so that a rollback
+// can restore the previous alias target. CloudFormation never deletes the
+// replaced versions. Each retained version keeps a full copy of the code
+// package and counts against the regional Lambda code storage limit (75GB
+// by default). Delete unused versions periodically (eg,
+// `aws lambda delete-function --function-name <name> --qualifier <version>`).
+type FunctionAlias struct {
+	// Name is the alias name (eg, live)
+	Name string
+	// Description is the optional alias description
+	Description string
+}
+
+// validate ensures the alias name is well formed
+func (alias *FunctionAlias) validate() error {
+	if len(alias.Name) <= 0 || len(alias.Name) > lambdaAliasMaxLength {
+		return errors.Errorf("Alias name (%s) must be between 1 and %d characters",
+			alias.Name,
+			lambdaAliasMaxLength)
+	}
+	if !reLambdaAliasName.MatchString(alias.Name) {
+		return errors.Errorf("Alias name (%s) must contain only alphanumeric, - or _ characters and must not be numeric",
+			alias.Name)
+	}
+	return nil
+}
+
+// ProvisionedConcurrency keeps a number of execution environments
+// initialized for the named alias to eliminate cold starts. Provisioned
+// concurrency is billed per execution environment for as long as it's
+// configured, whether or not it's used, so it's only applied to the alias
+// and its backing version rather than to every published version. Each
+// code change publishes a new version whose environments are initialized
+// before the alias is updated, which lengthens the provision.
+type ProvisionedConcurrency struct {
+	// AliasName is the name of the function's Alias
+	AliasName string
+	// ProvisionedConcurrentExecutions is the number of initialized
+	// execution environments
+	ProvisionedConcurrentExecutions int64
+}
+
+// validate ensures that the provisioned concurrency targets
+// the function's alias
+func (concurrency *ProvisionedConcurrency) validate(alias *FunctionAlias) error {
+	if alias == nil {
+		return errors.Errorf("ProvisionedConcurrency for alias %s requires an Alias",
+			concurrency.AliasName)
+	}
+	if concurrency.AliasName != alias.Name {
+		return errors.Errorf("ProvisionedConcurrency alias (%s) doesn't match the function Alias (%s)",
+			concurrency.AliasName,
+			alias.Name)
+	}
+	// The upper bound is the account's unreserved concurrency, which
+	// varies by account and region and is enforced by the service
+	if concurrency.ProvisionedConcurrentExecutions <= 0 {
+		return errors.Errorf("ProvisionedConcurrentExecutions (%d) must be greater than zero",
+			concurrency.ProvisionedConcurrentExecutions)
+	}
+	return nil
+}
+
+// aliasLogicalName returns the logical name of the function's alias
+func (info *LambdaAWSInfo) aliasLogicalName() string {
+	return lambdaAliasLogicalName(info.lambdaFunctionName())
+}
+
+// lambdaAliasLogicalName returns the logical name of the alias for
+// the named function
+func lambdaAliasLogicalName(lambdaFunctionName string) string {
+	return CloudFormationResourceName("LambdaAlias", lambdaFunctionName)
+}
+
+// lambdaTargetArn returns the ARN that event sources invoke. If the function
+// has an Alias, it's the alias ARN so that events are handled by the published
+// version and its provisioned concurrency rather than $LATEST.
+func lambdaTargetArn(lambdaFunctionName string,
+	lambdaLogicalCFResourceName string,
+	template *gocf.Template) *gocf.StringExpr {
+	aliasResourceName := lambdaAliasLogicalName(lambdaFunctionName)
+	if _, exists := template.Resources[aliasResourceName]; exists {
+		return gocf.Ref(aliasResourceName).String()
+	}
+	return gocf.GetAtt(lambdaLogicalCFResourceName, "Arn")
+}
+
+// exportAlias publishes a version of the function and adds the alias
+// that targets it, including the alias' provisioned concurrency
+func (info *LambdaAWSInfo) exportAlias(buildID string,
+	template *gocf.Template) error {
+	alias := info.Options.Alias
+	validateErr := alias.validate()
+	if validateErr != nil {
+		return errors.Errorf("Invalid Alias for %s: %s",
+			info.lambdaFunctionName(),
+			validateErr)
+	}
+	// Each build publishes a new version. Retain the prior versions
+	// s.t. a rollback can restore the previous alias target. The
+	// retained versions are never deleted by CloudFormation and count
+	// against the Lambda code storage limit.
+	versionResourceName := CloudFormationResourceName("LambdaVersion",
+		info.lambdaFunctionName(),
+		buildID)
+	versionEntry := template.AddResource(versionResourceName, &gocf.LambdaVersion{
+		FunctionName: gocf.Ref(info.LogicalResourceName()).String(),
+	})
+	versionEntry.DeletionPolicy = "Retain"
+
+	aliasResource := &gocf.LambdaAlias{
+		FunctionName:    gocf.Ref(info.LogicalResourceName()).String(),
+		FunctionVersion: gocf.GetAtt(versionResourceName, "Version").String(),
+		Name:            gocf.String(alias.Name),
+	}
+	if alias.Description != "" {
+		aliasResource.Description = gocf.String(alias.Description)
+	}
+	if info.Options.ProvisionedConcurrency != nil {
+		concurrencyErr := info.Options.ProvisionedConcurrency.validate(alias)
+		if concurrencyErr != nil {
+			return errors.Errorf("Invalid ProvisionedConcurrency for %s: %s",
+				info.lambdaFunctionName(),
+				concurrencyErr)
+		}
+		aliasResource.ProvisionedConcurrencyConfig = &gocf.LambdaAliasProvisionedConcurrencyConfiguration{
+			ProvisionedConcurrentExecutions: gocf.Integer(info.Options.ProvisionedConcurrency.ProvisionedConcurrentExecutions),
+		}
+	}
+	template.AddResource(info.aliasLogicalName(), aliasResource)
+	return nil
+}
@@ -0,0 +1,195 @@
+package sparta
+
+import (
+	"fmt"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FunctionURLAuthTypeIAM requires IAM authorized requests
+	FunctionURLAuthTypeIAM = "AWS_IAM"
+	// FunctionURLAuthTypeNone allows unauthenticated requests
+	FunctionURLAuthTypeNone = "NONE"
+	// cloudFormationLambdaURLType is the CloudFormation Function URL type
+	cloudFormationLambdaURLType = "AWS::Lambda::Url"
+	// functionURLCorsMaxAge is the maximum CORS MaxAge value (seconds)
+	functionURLCorsMaxAge = 86400
+)
+
+// FunctionURLCors is the cross-origin resource sharing configuration
+// for a Lambda Function URL.
+// Ref: https://docs.aws.amazon.com/lambda/latest/dg/urls-configuration.html#urls-cors
+type FunctionURLCors struct {
+	// AllowCredentials permits cookies and other credentials in requests
+	AllowCredentials bool
+	// AllowHeaders are the headers the browser may include
+	AllowHeaders []string
+	// AllowMethods are the HTTP methods the browser may use
+	AllowMethods []string
+	// AllowOrigins are the origins that may access the function
+	AllowOrigins []string
+	// ExposeHeaders are the response headers exposed to the browser
+	ExposeHeaders []string
+	// MaxAge is the number of seconds the browser may cache the
+	// preflight response
+	MaxAge int64
+}
+
+func (cors *FunctionURLCors) validate() error {
+	if cors.MaxAge < 0 || cors.MaxAge > functionURLCorsMaxAge {
+		return errors.Errorf("FunctionURL CORS MaxAge (%d) must be in the range [0, %d]",
+			cors.MaxAge,
+			functionURLCorsMaxAge)
+	}
+	if cors.AllowCredentials {
+		for _, eachOrigin := range cors.AllowOrigins {
+			if strings.TrimSpace(eachOrigin) == "*" {
+				return errors.Errorf("FunctionURL CORS AllowCredentials cannot be combined with a wildcard origin")
+			}
+		}
+	}
+	return nil
+}
+
+// FunctionURL configures a dedicated HTTPS endpoint for a function.
+// Ref: https://docs.aws.amazon.com/lambda/latest/dg/lambda-urls.html
+type FunctionURL struct {
+	// AuthType is either FunctionURLAuthTypeIAM or FunctionURLAuthTypeNone.
+	// Defaults to FunctionURLAuthTypeIAM.
+	AuthType string
+	// Cors is the optional CORS configuration
+	Cors *FunctionURLCors
+}
+
+func (functionURL *FunctionURL) authType() string {
+	if functionURL.AuthType == "" {
+		return FunctionURLAuthTypeIAM
+	}
+	return functionURL.AuthType
+}
+
+func (functionURL *FunctionURL) validate() error {
+	switch functionURL.authType() {
+	case FunctionURLAuthTypeIAM, FunctionURLAuthTypeNone:
+	default:
+		return errors.Errorf("FunctionURL AuthType must be one of %s or %s, got: %s",
+			FunctionURLAuthTypeIAM,
+			FunctionURLAuthTypeNone,
+			functionURL.AuthType)
+	}
+	if functionURL.Cors != nil {
+		return functionURL.Cors.validate()
+	}
+	return nil
+}
+
+// cloudFormationLambdaURLCors is the AWS::Lambda::Url.Cors property
+type cloudFormationLambdaURLCors struct {
+	AllowCredentials *gocf.BoolExpr       `json:"AllowCredentials,omitempty"`
+	AllowHeaders     *gocf.StringListExpr `json:"AllowHeaders,omitempty"`
+	AllowMethods     *gocf.StringListExpr `json:"AllowMethods,omitempty"`
+	AllowOrigins     *gocf.StringListExpr `json:"AllowOrigins,omitempty"`
+	ExposeHeaders    *gocf.StringListExpr `json:"ExposeHeaders,omitempty"`
+	MaxAge           *gocf.IntegerExpr    `json:"MaxAge,omitempty"`
+}
+
+// cloudFormationLambdaURL is the AWS::Lambda::Url resource, which isn't
+// yet available in go-cloudformation
+type cloudFormationLambdaURL struct {
+	AuthType          *gocf.StringExpr             `json:"AuthType,omitempty"`
+	TargetFunctionArn *gocf.StringExpr             `json:"TargetFunctionArn,omitempty"`
+	Cors              *cloudFormationLambdaURLCors `json:"Cors,omitempty"`
+}
+
+// CfnResourceType returns AWS::Lambda::Url to implement the ResourceProperties interface
+func (lambdaURL cloudFormationLambdaURL) CfnResourceType() string {
+	return cloudFormationLambdaURLType
+}
+
+// CfnResourceAttributes returns the attributes produced by this resource
+func (lambdaURL cloudFormationLambdaURL) CfnResourceAttributes() []string {
+	return []string{"FunctionArn", "FunctionUrl"}
+}
+
+// cloudFormationLambdaURLPermission is the AWS::Lambda::Permission
+// resource including the FunctionUrlAuthType property
+type cloudFormationLambdaURLPermission struct {
+	gocf.LambdaPermission
+	FunctionURLAuthType *gocf.StringExpr `json:"FunctionUrlAuthType,omitempty"`
+}
+
+// stringList returns the StringListExpr for the values, or nil if empty
+func stringList(values []string) *gocf.StringListExpr {
+	if len(values) == 0 {
+		return nil
+	}
+	stringables := make([]gocf.Stringable, len(values))
+	for index, eachValue := range values {
+		stringables[index] = gocf.String(eachValue)
+	}
+	return gocf.StringList(stringables...)
+}
+
+// FunctionURLOutputName returns the stack Output key that stores the
+// function's URL. Use it to find the URL in the StackStatus returned
+// by StatusReport.
+func (info *LambdaAWSInfo) FunctionURLOutputName() string {
+	return CloudFormationResourceName("FunctionURL", info.lambdaFunctionName(), "Output")
+}
+
+// exportFunctionURL adds the function's Function URL, the public
+// invoke permission if needed and the URL Output to the template
+func (info *LambdaAWSInfo) exportFunctionURL(functionAttr *gocf.StringExpr,
+	template *gocf.Template) error {
+	functionURL := info.Options.FunctionURL
+	validateErr := functionURL.validate()
+	if validateErr != nil {
+		return errors.Errorf("Invalid FunctionURL for %s: %s",
+			info.lambdaFunctionName(),
+			validateErr)
+	}
+	urlResource := cloudFormationLambdaURL{
+		AuthType:          gocf.String(functionURL.authType()),
+		TargetFunctionArn: functionAttr,
+	}
+	if functionURL.Cors != nil {
+		urlResource.Cors = &cloudFormationLambdaURLCors{
+			AllowHeaders:  stringList(functionURL.Cors.AllowHeaders),
+			AllowMethods:  stringList(functionURL.Cors.AllowMethods),
+			AllowOrigins:  stringList(functionURL.Cors.AllowOrigins),
+			ExposeHeaders: stringList(functionURL.Cors.ExposeHeaders),
+		}
+		if functionURL.Cors.AllowCredentials {
+			urlResource.Cors.AllowCredentials = gocf.Bool(true)
+		}
+		if functionURL.Cors.MaxAge != 0 {
+			urlResource.Cors.MaxAge = gocf.Integer(functionURL.Cors.MaxAge)
+		}
+	}
+	urlResourceName := CloudFormationResourceName("FunctionURL", info.lambdaFunctionName())
+	template.AddResource(urlResourceName, urlResource)
+
+	// Unauthenticated URLs require a resource policy that allows
+	// anyone to invoke the function via the URL
+	if functionURL.authType() == FunctionURLAuthTypeNone {
+		permission := cloudFormationLambdaURLPermission{
+			LambdaPermission: gocf.LambdaPermission{
+				Action:       gocf.String("lambda:InvokeFunctionUrl"),
+				FunctionName: functionAttr,
+				Principal:    gocf.String("*"),
+			},
+			FunctionURLAuthType: gocf.String(FunctionURLAuthTypeNone),
+		}
+		template.AddResource(CloudFormationResourceName("FunctionURLPermission",
+			info.lambdaFunctionName()),
+			permission)
+	}
+	template.Outputs[info.FunctionURLOutputName()] = &gocf.Output{
+		Description: fmt.Sprintf("%s Function URL", info.lambdaFunctionName()),
+		Value:       gocf.GetAtt(urlResourceName, "FunctionUrl"),
+	}
+	return nil
+}
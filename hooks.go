@@ -2,6 +2,7 @@ package sparta
 
 import (
 	"archive/zip"
+	"context"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	gocf "github.com/mweagle/go-cloudformation"
@@ -311,6 +312,78 @@ type ServiceValidationHookHandler interface {
 		logger *logrus.Logger) error
 }
 
+////////////////////////////////////////////////////////////////////////////////
+// TemplateTransformer
+
+// TemplateTransformHook defines a user function that post-processes
+// the validated template. It returns the template passed to the next
+// transformer, which may be the same template value.
+type TemplateTransformHook func(context map[string]interface{},
+	serviceName string,
+	template *gocf.Template,
+	buildID string,
+	logger *logrus.Logger) (*gocf.Template, error)
+
+// TemplateTransformer is a named step in the ordered template
+// post-processing pipeline. The Name is used to attribute errors.
+type TemplateTransformer struct {
+	Name      string
+	Transform TemplateTransformHook
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// WorkflowStep
+
+// WorkflowStage identifies an insertion point in the provisioning workflow.
+// User WorkflowSteps registered for a stage run before the Sparta step of
+// the same name. Besides the WorkflowHooks.Context values, the shared
+// context map includes:
+//
+//	WorkflowStageVerifyIAM:  -
+//	WorkflowStageVerifyAWS:  -
+//	WorkflowStagePackage:    -
+//	WorkflowStageUpload:     WorkflowContextKeyCodeArchivePath (empty if WorkflowHooks.StreamCodeArchive)
+//	WorkflowStageValidate:   WorkflowContextKeyCodeArchivePath, WorkflowContextKeyCodeArchiveURL
+//	WorkflowStageProvision:  WorkflowContextKeyCodeArchivePath, WorkflowContextKeyCodeArchiveURL
+//
+// With WorkflowHooks.ParallelBuild enabled, the WorkflowStageVerifyAWS and
+// WorkflowStagePackage steps may run concurrently.
+type WorkflowStage string
+
+// WorkflowStepHandler is a user-defined step in the provisioning workflow.
+// Rollback is called for each step whose Invoke function completed if a
+// later step fails.
+type WorkflowStepHandler interface {
+	Invoke(context map[string]interface{},
+		serviceName string,
+		awsSession *session.Session,
+		noop bool,
+		logger *logrus.Logger) error
+	Rollback(context map[string]interface{},
+		serviceName string,
+		awsSession *session.Session,
+		noop bool,
+		logger *logrus.Logger) error
+}
+
+// WorkflowStep is a named WorkflowStepHandler that runs at the Stage
+// insertion point. The Name is used to attribute errors.
+type WorkflowStep struct {
+	Name    string
+	Stage   WorkflowStage
+	Handler WorkflowStepHandler
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BinaryTransform
+
+// BinaryTransformHook defines a user function that modifies the compiled
+// binary in place before it's added to the code archive
+// (eg, compressing it with upx).
+type BinaryTransformHook func(ctx context.Context,
+	binaryPath string,
+	logger *logrus.Logger) error
+
 ////////////////////////////////////////////////////////////////////////////////
 // RollbackHandler
 
@@ -353,3 +426,11 @@ type RollbackHookHandler interface {
 		noop bool,
 		logger *logrus.Logger) error
 }
+
+////////////////////////////////////////////////////////////////////////////////
+// PostProvisionTest
+
+// PostProvisionTest is an integration test that's run against a
+// successfully created or updated stack. The stackOutputs are the
+// stack's Output values keyed by OutputKey.
+type PostProvisionTest func(ctx context.Context, stackOutputs map[string]string) error
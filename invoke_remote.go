@@ -0,0 +1,166 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// InvokeRemoteOptions are the optional InvokeRemoteWithOptions settings
+type InvokeRemoteOptions struct {
+	// DryRun only verifies that the caller can invoke the function
+	DryRun bool
+	// Qualifier is the optional function version or alias to invoke
+	Qualifier string
+}
+
+// remoteFunctionName returns the deployed name of the function. Values
+// that are already ARNs are returned as is, otherwise the name is
+// derived from the same convention used to provision the function.
+func remoteFunctionName(serviceName string, functionName string) string {
+	if strings.HasPrefix(functionName, "arn:aws") {
+		return functionName
+	}
+	return fmt.Sprintf("%s%s%s",
+		serviceName,
+		functionNameDelimiter,
+		awsLambdaInternalName(functionName))
+}
+
+// describeStacksAPI is the CloudFormation subset used to resolve
+// the function ARN from the stack outputs
+type describeStacksAPI interface {
+	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+}
+
+// resolveRemoteFunctionName returns the ARN of the function published in
+// the serviceName stack outputs (eg, by decorator.PublishAllResourceOutputs).
+// If no output references the function, the conventional name is used.
+func resolveRemoteFunctionName(cfAPI describeStacksAPI,
+	serviceName string,
+	functionName string,
+	logger *logrus.Logger) (string, error) {
+
+	physicalName := remoteFunctionName(serviceName, functionName)
+	if physicalName == functionName {
+		return physicalName, nil
+	}
+	describeOutput, describeErr := cfAPI.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(serviceName),
+	})
+	if describeErr != nil {
+		return "", errors.Wrapf(describeErr, "Failed to describe stack %s", serviceName)
+	}
+	arnSuffix := fmt.Sprintf(":function:%s", physicalName)
+	for _, eachStack := range describeOutput.Stacks {
+		for _, eachOutput := range eachStack.Outputs {
+			outputValue := aws.StringValue(eachOutput.OutputValue)
+			if strings.HasPrefix(outputValue, "arn:aws") &&
+				strings.HasSuffix(outputValue, arnSuffix) {
+				logger.WithFields(logrus.Fields{
+					"FunctionName": functionName,
+					"OutputKey":    aws.StringValue(eachOutput.OutputKey),
+					"FunctionArn":  outputValue,
+				}).Debug("Resolved function ARN from stack output")
+				return outputValue, nil
+			}
+		}
+	}
+	return physicalName, nil
+}
+
+// InvokeRemote synchronously invokes the deployed functionName in the
+// serviceName stack with the payload and returns the function's response.
+// The functionName is the name supplied to NewAWSLambda
+// (eg, LambdaName(myHandler)) or the function ARN. The ARN is resolved
+// from the stack outputs if it's published, otherwise the deployed
+// name follows the Sparta naming convention.
+func InvokeRemote(serviceName string,
+	functionName string,
+	payload []byte,
+	logger *logrus.Logger) ([]byte, error) {
+	return InvokeRemoteWithOptions(serviceName,
+		functionName,
+		payload,
+		nil,
+		logger)
+}
+
+// InvokeRemoteWithOptions is the InvokeRemote version that accepts
+// InvokeRemoteOptions. If the function returns an error, both
+// the error response and a non-nil error are returned.
+func InvokeRemoteWithOptions(serviceName string,
+	functionName string,
+	payload []byte,
+	options *InvokeRemoteOptions,
+	logger *logrus.Logger) ([]byte, error) {
+
+	if options == nil {
+		options = &InvokeRemoteOptions{}
+	}
+	awsSession := spartaAWS.NewSession(logger)
+	physicalName, physicalNameErr := resolveRemoteFunctionName(cloudformation.New(awsSession),
+		serviceName,
+		functionName,
+		logger)
+	if physicalNameErr != nil {
+		return nil, physicalNameErr
+	}
+	invokeInput := &lambda.InvokeInput{
+		FunctionName:   aws.String(physicalName),
+		InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+		LogType:        aws.String(lambda.LogTypeTail),
+		Payload:        payload,
+	}
+	if options.DryRun {
+		invokeInput.InvocationType = aws.String(lambda.InvocationTypeDryRun)
+		invokeInput.LogType = nil
+	}
+	if options.Qualifier != "" {
+		invokeInput.Qualifier = aws.String(options.Qualifier)
+	}
+	lambdaSvc := lambda.New(awsSession)
+	invokeRequest, invokeOutput := lambdaSvc.InvokeRequest(invokeInput)
+	invokeErr := invokeRequest.Send()
+	if invokeErr != nil {
+		return nil, errors.Wrapf(invokeErr, "Failed to invoke function %s", physicalName)
+	}
+	logger.WithFields(logrus.Fields{
+		"FunctionName":    physicalName,
+		"RequestID":       invokeRequest.RequestID,
+		"DryRun":          options.DryRun,
+		"StatusCode":      aws.Int64Value(invokeOutput.StatusCode),
+		"ExecutedVersion": aws.StringValue(invokeOutput.ExecutedVersion),
+	}).Info("Invoked remote function")
+
+	if invokeOutput.LogResult != nil {
+		logTail, logTailErr := base64.StdEncoding.DecodeString(*invokeOutput.LogResult)
+		if logTailErr == nil {
+			logger.WithFields(logrus.Fields{
+				"RequestID": invokeRequest.RequestID,
+			}).Debug(string(logTail))
+		}
+	}
+	if invokeOutput.FunctionError != nil {
+		logger.WithFields(logrus.Fields{
+			"FunctionName":  physicalName,
+			"RequestID":     invokeRequest.RequestID,
+			"FunctionError": *invokeOutput.FunctionError,
+			"Response":      string(invokeOutput.Payload),
+		}).Error("Remote function returned an error")
+		return invokeOutput.Payload, errors.Errorf("Function %s returned a %s error (RequestID: %s)",
+			physicalName,
+			*invokeOutput.FunctionError,
+			invokeRequest.RequestID)
+	}
+	return invokeOutput.Payload, nil
+}
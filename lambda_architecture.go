@@ -0,0 +1,152 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"os"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+const (
+	// lambdaProvidedRuntime is the custom runtime used for arm64
+	// functions, since the go1.x runtime only supports x86_64
+	lambdaProvidedRuntime = "provided.al2"
+	// lambdaBootstrapName is the name of the executable that the
+	// provided.al2 runtime invokes
+	lambdaBootstrapName = "bootstrap"
+)
+
+// lambdaArchitectureGOARCH maps the AWS Lambda architecture
+// to the GOARCH value used to compile the binary
+var lambdaArchitectureGOARCH = map[string]string{
+	LambdaArchitectureX8664: "amd64",
+	LambdaArchitectureArm64: "arm64",
+}
+
+// lambdaFunctionEphemeralStorage is the size, in MB, of the
+// function's /tmp directory
+type lambdaFunctionEphemeralStorage struct {
+	Size *gocf.IntegerExpr `json:"Size,omitempty"`
+}
+
+// lambdaFunctionArchitecture is the AWS::Lambda::Function resource
+// together with the Architectures and EphemeralStorage properties,
+// which aren't included in the go-cloudformation schema. It's only
+// used for functions that set one of them.
+type lambdaFunctionArchitecture struct {
+	gocf.LambdaFunction
+	Architectures    *gocf.StringListExpr            `json:"Architectures,omitempty"`
+	EphemeralStorage *lambdaFunctionEphemeralStorage `json:"EphemeralStorage,omitempty"`
+}
+
+// lambdaArchitecture returns the requested architecture or the
+// default LambdaArchitectureX8664 value
+func lambdaArchitecture(workflowHooks *WorkflowHooks) string {
+	if workflowHooks == nil || workflowHooks.Architecture == "" {
+		return LambdaArchitectureX8664
+	}
+	return workflowHooks.Architecture
+}
+
+// lambdaArchitectureGoArch returns the GOARCH value for the
+// supported AWS Lambda architecture
+func lambdaArchitectureGoArch(architecture string) (string, error) {
+	goArch, goArchExists := lambdaArchitectureGOARCH[architecture]
+	if !goArchExists {
+		return "", errors.Errorf("Unsupported AWS Lambda architecture: %s. Must be one of: %s, %s",
+			architecture,
+			LambdaArchitectureX8664,
+			LambdaArchitectureArm64)
+	}
+	return goArch, nil
+}
+
+// verifyCGOGoArch ensures that a CGO build's SPARTA_GOARCH value, which
+// selects the Docker build image architecture, matches the GOARCH
+// of the requested AWS Lambda architecture
+func verifyCGOGoArch(goArch string, useCGO bool) error {
+	envGoArch := os.Getenv("SPARTA_GOARCH")
+	if !useCGO || envGoArch == "" || envGoArch == goArch {
+		return nil
+	}
+	return errors.Errorf("SPARTA_GOARCH=%s doesn't match the AWS Lambda architecture GOARCH=%s. Set WorkflowHooks.Architecture to build a %s binary",
+		envGoArch,
+		goArch,
+		envGoArch)
+}
+
+// lambdaFunctionResource returns the AWS::Lambda::Function properties
+// of the resource and whether the function executes the Sparta binary
+func lambdaFunctionResource(resource *gocf.Resource) (gocf.LambdaFunction, bool) {
+	var lambdaResource gocf.LambdaFunction
+	switch typedResource := resource.Properties.(type) {
+	case gocf.LambdaFunction:
+		lambdaResource = typedResource
+	case *gocf.LambdaFunction:
+		lambdaResource = *typedResource
+	default:
+		return lambdaResource, false
+	}
+	if lambdaResource.Handler == nil ||
+		lambdaResource.Handler.Func != nil ||
+		lambdaResource.Handler.Literal != SpartaBinaryName {
+		return lambdaResource, false
+	}
+	return lambdaResource, true
+}
+
+// applyLambdaArchitecture sets the Architectures property of every
+// function in the template that executes the Sparta binary. The
+// property is only set for the non-default arm64 architecture, and
+// those functions are switched to the provided.al2 runtime, which
+// invokes the binary packaged as lambdaBootstrapName.
+func applyLambdaArchitecture(template *gocf.Template, architecture string) {
+	if architecture == LambdaArchitectureX8664 {
+		return
+	}
+	for _, eachResource := range template.Resources {
+		lambdaResource, lambdaResourceOk := lambdaFunctionResource(eachResource)
+		if !lambdaResourceOk {
+			continue
+		}
+		lambdaResource.Runtime = gocf.String(lambdaProvidedRuntime)
+		lambdaResource.Handler = gocf.String(lambdaBootstrapName)
+		eachResource.Properties = &lambdaFunctionArchitecture{
+			LambdaFunction: lambdaResource,
+			Architectures:  gocf.StringList(gocf.String(architecture)),
+		}
+	}
+}
+
+// applyLambdaEphemeralStorage sets the EphemeralStorage property of the
+// functions that define an EphemeralStorageSize. It must be called after
+// applyLambdaArchitecture, which may have already replaced the function
+// resource.
+func applyLambdaEphemeralStorage(template *gocf.Template,
+	lambdaAWSInfos []*LambdaAWSInfo) {
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.Options == nil || eachLambda.Options.EphemeralStorageSize == 0 {
+			continue
+		}
+		lambdaResource, lambdaResourceExists := template.Resources[eachLambda.LogicalResourceName()]
+		if !lambdaResourceExists {
+			continue
+		}
+		typedResource, typedResourceOk := lambdaResource.Properties.(*lambdaFunctionArchitecture)
+		if !typedResourceOk {
+			lambdaFunction, lambdaFunctionOk := lambdaFunctionResource(lambdaResource)
+			if !lambdaFunctionOk {
+				continue
+			}
+			typedResource = &lambdaFunctionArchitecture{
+				LambdaFunction: lambdaFunction,
+			}
+			lambdaResource.Properties = typedResource
+		}
+		typedResource.EphemeralStorage = &lambdaFunctionEphemeralStorage{
+			Size: gocf.Integer(eachLambda.Options.EphemeralStorageSize),
+		}
+	}
+}
@@ -83,7 +83,7 @@ func (perm BasePermission) export(principal *gocf.StringExpr,
 
 	lambdaPermission := gocf.LambdaPermission{
 		Action:       gocf.String("lambda:InvokeFunction"),
-		FunctionName: gocf.GetAtt(lambdaLogicalCFResourceName, "Arn"),
+		FunctionName: lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template),
 		Principal:    principal,
 	}
 	// If the Arn isn't the wildcard value, then include it.
@@ -150,7 +150,7 @@ func (perm S3Permission) export(serviceName string,
 	S3Key string,
 	logger *logrus.Logger) (string, error) {
 
-	targetLambdaResourceName, err := perm.BasePermission.export(gocf.String("s3.amazonaws.com"),
+	targetLambdaResourceName, err := perm.BasePermission.export(gocf.String(S3Principal),
 		s3SourceArnParts,
 		lambdaFunctionDisplayName,
 		lambdaLogicalCFResourceName,
@@ -192,7 +192,7 @@ func (perm S3Permission) export(serviceName string,
 	}
 	s3Resource.ServiceToken = gocf.GetAtt(configuratorResName, "Arn")
 	s3Resource.BucketArn = sourceArnExpression
-	s3Resource.LambdaTargetArn = gocf.GetAtt(lambdaLogicalCFResourceName, "Arn")
+	s3Resource.LambdaTargetArn = lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template)
 	s3Resource.Events = perm.Events
 	if nil != perm.Filter.Key {
 		s3Resource.Filter = &perm.Filter
@@ -252,6 +252,53 @@ var snsSourceArnParts = []gocf.Stringable{}
 // for more information.
 type SNSPermission struct {
 	BasePermission
+	// Subscription, if non-nil, creates the topic subscription as an
+	// AWS::SNS::Subscription resource rather than with the Sparta
+	// managed custom resource. The subscription is bound to the
+	// BasePermission.SourceArn topic, which may be a literal ARN or
+	// a same template reference.
+	Subscription *SNSSubscription `json:"Subscription,omitempty"`
+	// TopicLogicalName is the optional logical name of an AWS::SNS::Topic
+	// created in the same template (eg, by a decorator). If defined,
+	// BasePermission.SourceArn is ignored.
+	TopicLogicalName string `json:"TopicLogicalName,omitempty"`
+}
+
+// SNSSubscription is the optional configuration for the SNSPermission
+// AWS::SNS::Subscription resource. SNS doesn't support raw message
+// delivery for the lambda protocol, so the function always receives
+// the SNS envelope.
+type SNSSubscription struct {
+	// FilterPolicy is the optional JSON subscription filter policy
+	// that limits the messages delivered to the function. See
+	//		https://docs.aws.amazon.com/sns/latest/dg/sns-subscription-filter-policies.html
+	// for more information.
+	FilterPolicy string `json:"FilterPolicy,omitempty"`
+}
+
+// filterPolicy returns the parsed FilterPolicy, which must be a
+// non-empty JSON object whose values are arrays or nested objects
+func (subscription *SNSSubscription) filterPolicy() (map[string]interface{}, error) {
+	if subscription.FilterPolicy == "" {
+		return nil, nil
+	}
+	var policy map[string]interface{}
+	unmarshalErr := json.Unmarshal([]byte(subscription.FilterPolicy), &policy)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "FilterPolicy must be a JSON object")
+	}
+	if len(policy) == 0 {
+		return nil, errors.New("FilterPolicy must define at least one attribute")
+	}
+	for eachKey, eachValue := range policy {
+		switch eachValue.(type) {
+		case []interface{}, map[string]interface{}:
+		default:
+			return nil, errors.Errorf("FilterPolicy attribute %s value must be an array or object",
+				eachKey)
+		}
+	}
+	return policy, nil
 }
 
 func (perm SNSPermission) export(serviceName string,
@@ -261,6 +308,10 @@ func (perm SNSPermission) export(serviceName string,
 	S3Bucket string,
 	S3Key string,
 	logger *logrus.Logger) (string, error) {
+	if perm.TopicLogicalName != "" {
+		// The topic Ref is its ARN
+		perm.BasePermission.SourceArn = gocf.Ref(perm.TopicLogicalName)
+	}
 	sourceArnExpression := perm.BasePermission.sourceArnExpr(snsSourceArnParts...)
 
 	targetLambdaResourceName, err := perm.BasePermission.export(gocf.String(SNSPrincipal),
@@ -275,6 +326,37 @@ func (perm SNSPermission) export(serviceName string,
 		return "", errors.Wrap(err, "Failed to export SNS permission")
 	}
 
+	if perm.Subscription != nil {
+		if sourceArnExpression == nil || perm.SourceArn == "*" {
+			return "", errors.Errorf("SNS subscription for %s requires a topic SourceArn",
+				lambdaFunctionDisplayName)
+		}
+		filterPolicy, filterPolicyErr := perm.Subscription.filterPolicy()
+		if filterPolicyErr != nil {
+			return "", errors.Wrapf(filterPolicyErr,
+				"Invalid SNS subscription for %s",
+				lambdaFunctionDisplayName)
+		}
+		subscription := &gocf.SNSSubscription{
+			Endpoint: lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template),
+			Protocol: gocf.String("lambda"),
+			TopicArn: sourceArnExpression,
+		}
+		if filterPolicy != nil {
+			subscription.FilterPolicy = filterPolicy
+		}
+		arnLiteral, arnLiteralErr := json.Marshal(sourceArnExpression)
+		if nil != arnLiteralErr {
+			return "", arnLiteralErr
+		}
+		subscriptionResourceName := CloudFormationResourceName("SNSSubscription",
+			lambdaLogicalCFResourceName,
+			string(arnLiteral))
+		cfResource := template.AddResource(subscriptionResourceName, subscription)
+		cfResource.DependsOn = append(cfResource.DependsOn, targetLambdaResourceName)
+		return "", nil
+	}
+
 	// Make sure the custom lambda that manages s3 notifications is provisioned.
 	configuratorResName, err := EnsureCustomResourceHandler(serviceName,
 		cfCustomResources.SNSLambdaEventSource,
@@ -298,7 +380,7 @@ func (perm SNSPermission) export(serviceName string,
 	}
 	customResource := newResource.(*cfCustomResources.SNSLambdaEventSourceResource)
 	customResource.ServiceToken = gocf.GetAtt(configuratorResName, "Arn")
-	customResource.LambdaTargetArn = gocf.GetAtt(lambdaLogicalCFResourceName, "Arn")
+	customResource.LambdaTargetArn = lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template)
 	customResource.SNSTopicArn = sourceArnExpression
 
 	// Name?
@@ -670,7 +752,7 @@ func (perm SESPermission) export(serviceName string,
 		for eachIndex, eachReceiptRule := range perm.ReceiptRules {
 			sesRules[eachIndex] = eachReceiptRule.toResourceRule(
 				serviceName,
-				gocf.GetAtt(lambdaLogicalCFResourceName, "Arn"),
+				lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template),
 				perm.MessageBodyStorage)
 		}
 	}
@@ -839,13 +921,20 @@ func (perm CloudWatchEventsPermission) export(serviceName string,
 			return "", exportErr
 		}
 
+		ruleTarget := gocf.EventsRuleTarget{
+			Arn: lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template),
+			ID:  gocf.String(uniqueRuleName),
+		}
+		if eachRuleDefinition.RuleTarget != nil {
+			if eachRuleDefinition.RuleTarget.Input != "" {
+				ruleTarget.Input = gocf.String(eachRuleDefinition.RuleTarget.Input)
+			}
+			if eachRuleDefinition.RuleTarget.InputPath != "" {
+				ruleTarget.InputPath = gocf.String(eachRuleDefinition.RuleTarget.InputPath)
+			}
+		}
 		cwEventsRuleTargetList := gocf.EventsRuleTargetList{}
-		cwEventsRuleTargetList = append(cwEventsRuleTargetList,
-			gocf.EventsRuleTarget{
-				Arn: gocf.GetAtt(lambdaLogicalCFResourceName, "Arn"),
-				ID:  gocf.String(uniqueRuleName),
-			},
-		)
+		cwEventsRuleTargetList = append(cwEventsRuleTargetList, ruleTarget)
 
 		// Add the rule
 		eventsRule := &gocf.EventsRule{
@@ -906,6 +995,10 @@ func (perm CloudWatchEventsPermission) descriptionInfo() ([]descriptionNode, err
 type EventBridgeRule struct {
 	Description  string
 	EventBusName string
+	// EventBusLogicalName is the optional logical name of an
+	// AWS::Events::EventBus created in the same template (eg, by a
+	// decorator). It's mutually exclusive with EventBusName.
+	EventBusLogicalName string
 	// ArbitraryJSONObject filter for events as documented at
 	// https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-resource-events-rule.html#cfn-events-rule-eventpattern
 	// Rules matches should use the JSON representation (NOT the string form).  Sparta will serialize
@@ -1000,7 +1093,7 @@ func (perm EventBridgePermission) export(serviceName string,
 	eventBridgeRuleTargetList := gocf.EventsRuleTargetList{}
 	eventBridgeRuleTargetList = append(eventBridgeRuleTargetList,
 		gocf.EventsRuleTarget{
-			Arn: gocf.GetAtt(lambdaLogicalCFResourceName, "Arn"),
+			Arn: lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template),
 			ID:  gocf.String(serviceName),
 		},
 	)
@@ -1014,8 +1107,16 @@ func (perm EventBridgePermission) export(serviceName string,
 	eventsRule := &gocf.EventsRule{
 		Targets: &eventBridgeRuleTargetList,
 	}
+	if perm.Rule.EventBusName != "" &&
+		perm.Rule.EventBusLogicalName != "" {
+		return "", fmt.Errorf("rule %s EventBridge specifies both EventBusName and EventBusLogicalName",
+			perm.Rule)
+	}
 	if perm.Rule.EventBusName != "" {
 		eventsRule.EventBusName = marshalString(perm.Rule.EventBusName)
+	} else if perm.Rule.EventBusLogicalName != "" {
+		// The event bus Ref is its name
+		eventsRule.EventBusName = gocf.Ref(perm.Rule.EventBusLogicalName).String()
 	}
 	// Setup the description placeholder...we'll set it in a bit...
 	ruleDescription := ""
@@ -1179,7 +1280,7 @@ func (perm CloudWatchLogsPermission) export(serviceName string,
 	}
 	customResource := newResource.(*cfCustomResources.CloudWatchLogsLambdaEventSourceResource)
 	customResource.ServiceToken = gocf.GetAtt(configurationResourceName, "Arn")
-	customResource.LambdaTargetArn = gocf.GetAtt(lambdaLogicalCFResourceName, "Arn")
+	customResource.LambdaTargetArn = lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template)
 	// Build up the filters...
 	customResource.Filters = make([]*cfCustomResources.CloudWatchLogsLambdaEventSourceFilter, 0)
 	for eachName, eachFilter := range globallyUniqueFilters {
@@ -1302,7 +1403,7 @@ func (perm CodeCommitPermission) export(serviceName string,
 	}
 	customResource := newResource.(*cfCustomResources.CodeCommitLambdaEventSourceResource)
 	customResource.ServiceToken = gocf.GetAtt(configuratorResName, "Arn")
-	customResource.LambdaTargetArn = gocf.GetAtt(lambdaLogicalCFResourceName, "Arn")
+	customResource.LambdaTargetArn = lambdaTargetArn(lambdaFunctionDisplayName, lambdaLogicalCFResourceName, template)
 	customResource.TriggerName = gocf.Ref(lambdaLogicalCFResourceName).String()
 	customResource.RepositoryName = perm.RepositoryName
 	customResource.Events = repoEvents
@@ -0,0 +1,63 @@
+package sparta
+
+import (
+	"testing"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCloudWatchEventsRuleTarget(t *testing.T) {
+	perm := CloudWatchEventsPermission{
+		Rules: map[string]CloudWatchEventsRule{
+			"Rate5Mins": {
+				ScheduleExpression: "rate(5 minutes)",
+				RuleTarget: &CloudWatchEventsRuleTarget{
+					Input: `{"source": "schedule"}`,
+				},
+			},
+			"Detail": {
+				EventPattern: map[string]interface{}{
+					"source": []string{"aws.ec2"},
+				},
+				RuleTarget: &CloudWatchEventsRuleTarget{
+					InputPath: "$.detail",
+				},
+			},
+		},
+	}
+	template := gocf.NewTemplate()
+	_, exportErr := perm.export("CWEventsService",
+		"cwEventsProcessor",
+		"CWEventsProcessorLambda",
+		template,
+		"bucket",
+		"key",
+		logrus.New())
+	if exportErr != nil {
+		t.Fatalf("Failed to export CloudWatch Events rules: %s", exportErr)
+	}
+	inputCount := 0
+	inputPathCount := 0
+	for _, eachResource := range template.Resources {
+		eventsRule, eventsRuleOk := eachResource.Properties.(*gocf.EventsRule)
+		if !eventsRuleOk {
+			continue
+		}
+		if eventsRule.Targets == nil || len(*eventsRule.Targets) != 1 {
+			t.Fatalf("Expected a single rule target")
+		}
+		ruleTarget := (*eventsRule.Targets)[0]
+		if ruleTarget.Input != nil {
+			inputCount++
+		}
+		if ruleTarget.InputPath != nil {
+			inputPathCount++
+		}
+	}
+	if inputCount != 1 || inputPathCount != 1 {
+		t.Fatalf("Expected 1 rule target Input and 1 InputPath, got %d and %d",
+			inputCount,
+			inputPathCount)
+	}
+}
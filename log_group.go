@@ -0,0 +1,67 @@
+package sparta
+
+import (
+	"fmt"
+
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// logRetentionDays are the RetentionInDays values accepted by
+// CloudWatch Logs.
+// Ref: https://docs.aws.amazon.com/AmazonCloudWatchLogs/latest/APIReference/API_PutRetentionPolicy.html
+var logRetentionDays = []int64{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180,
+	365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// validateLogRetentionInDays ensures a non-zero retention is one of the
+// values supported by CloudWatch Logs
+func validateLogRetentionInDays(retentionInDays int64) error {
+	if retentionInDays == 0 {
+		return nil
+	}
+	for _, eachValue := range logRetentionDays {
+		if eachValue == retentionInDays {
+			return nil
+		}
+	}
+	return fmt.Errorf("log retention (%d days) must be one of: %v",
+		retentionInDays,
+		logRetentionDays)
+}
+
+// logGroupLogicalName returns the logical name of the function's
+// CloudWatch Logs log group resource
+func (info *LambdaAWSInfo) logGroupLogicalName() string {
+	return CloudFormationResourceName("LogGroup", info.lambdaFunctionName())
+}
+
+// logRetentionInDays returns the function's log retention, falling back
+// to the service default. Zero means the events never expire.
+func (info *LambdaAWSInfo) logRetentionInDays() int64 {
+	if info.Options != nil && info.Options.LogRetentionInDays != 0 {
+		return info.Options.LogRetentionInDays
+	}
+	return info.serviceLogRetentionInDays
+}
+
+// exportLogGroup adds the function's /aws/lambda/<functionName> log group
+// to the template iff the function defines a log retention or metric
+// filters. Returns the log group logical name, or an empty string if
+// Lambda should implicitly create the log group.
+func (info *LambdaAWSInfo) exportLogGroup(lambdaFunctionName *gocf.StringExpr,
+	template *gocf.Template) string {
+	retentionInDays := info.logRetentionInDays()
+	if retentionInDays == 0 && len(info.MetricFilters) == 0 {
+		return ""
+	}
+	logGroup := &gocf.LogsLogGroup{
+		LogGroupName: gocf.Join("",
+			gocf.String("/aws/lambda/"),
+			lambdaFunctionName),
+	}
+	if retentionInDays != 0 {
+		logGroup.RetentionInDays = gocf.Integer(retentionInDays)
+	}
+	logGroupResourceName := info.logGroupLogicalName()
+	template.AddResource(logGroupResourceName, logGroup)
+	return logGroupResourceName
+}
@@ -0,0 +1,123 @@
+package sparta
+
+import (
+	"fmt"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+)
+
+// metricFilterPatternMaxLength is the maximum length of a
+// CloudWatch Logs filter pattern
+const metricFilterPatternMaxLength = 1024
+
+// MetricFilter is a CloudWatch Logs metric filter that extracts a
+// metric from the function's log events.
+// Ref: https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html
+type MetricFilter struct {
+	// FilterPattern is the CloudWatch Logs filter pattern
+	FilterPattern string
+	// MetricNamespace is the namespace of the published metric
+	MetricNamespace string
+	// MetricName is the name of the published metric
+	MetricName string
+	// MetricValue is the value to publish for each matching event. It may
+	// be a literal (eg, "1") or a reference to a field in the
+	// event (eg, "$.latency"). Defaults to "1".
+	MetricValue string
+}
+
+// logicalName returns the stable resource name for this filter
+func (filter *MetricFilter) logicalName(lambdaFunctionName string) string {
+	return CloudFormationResourceName("LogsMetricFilter",
+		lambdaFunctionName,
+		filter.MetricNamespace,
+		filter.MetricName)
+}
+
+func (filter *MetricFilter) validate() error {
+	if filter.MetricNamespace == "" || filter.MetricName == "" {
+		return errors.New("MetricFilter MetricNamespace and MetricName are required")
+	}
+	return validateMetricFilterPattern(filter.FilterPattern)
+}
+
+// validateMetricFilterPattern performs a best effort client-side check
+// of the filter pattern syntax. CloudWatch Logs performs the
+// authoritative validation at provision time.
+func validateMetricFilterPattern(pattern string) error {
+	if len(pattern) > metricFilterPatternMaxLength {
+		return errors.Errorf("MetricFilter pattern length (%d) exceeds the maximum of %d",
+			len(pattern),
+			metricFilterPatternMaxLength)
+	}
+	if strings.Count(pattern, `"`)%2 != 0 {
+		return errors.Errorf("MetricFilter pattern has unbalanced quotes: %s", pattern)
+	}
+	// Track the nesting of the JSON and space-delimited patterns,
+	// ignoring anything in quotes
+	closers := map[rune]rune{'{': '}', '[': ']', '(': ')'}
+	stack := make([]rune, 0)
+	inQuotes := false
+	for _, eachRune := range pattern {
+		switch {
+		case eachRune == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+			continue
+		case eachRune == '{' || eachRune == '[' || eachRune == '(':
+			stack = append(stack, closers[eachRune])
+		case eachRune == '}' || eachRune == ']' || eachRune == ')':
+			if len(stack) == 0 || stack[len(stack)-1] != eachRune {
+				return errors.Errorf("MetricFilter pattern has unbalanced %q: %s", eachRune, pattern)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) != 0 {
+		return errors.Errorf("MetricFilter pattern is missing %q: %s", stack[len(stack)-1], pattern)
+	}
+	trimmed := strings.TrimSpace(pattern)
+	if strings.HasPrefix(trimmed, "{") && !strings.Contains(trimmed, "$.") {
+		return errors.Errorf("MetricFilter JSON pattern must reference a $. selector: %s", pattern)
+	}
+	return nil
+}
+
+// exportMetricFilters adds the function's metric filter resources to
+// the template. The filters are attached to the log group created
+// by exportLogGroup.
+func (info *LambdaAWSInfo) exportMetricFilters(template *gocf.Template) error {
+	logGroupResourceName := info.logGroupLogicalName()
+	for _, eachFilter := range info.MetricFilters {
+		validateErr := eachFilter.validate()
+		if validateErr != nil {
+			return errors.Wrapf(validateErr, "Invalid MetricFilter for %s", info.lambdaFunctionName())
+		}
+		metricValue := eachFilter.MetricValue
+		if metricValue == "" {
+			metricValue = "1"
+		}
+		filterResourceName := eachFilter.logicalName(info.lambdaFunctionName())
+		_, exists := template.Resources[filterResourceName]
+		if exists {
+			return fmt.Errorf("duplicate MetricFilter for %s: %s/%s",
+				info.lambdaFunctionName(),
+				eachFilter.MetricNamespace,
+				eachFilter.MetricName)
+		}
+		template.AddResource(filterResourceName, &gocf.LogsMetricFilter{
+			FilterPattern: gocf.String(eachFilter.FilterPattern),
+			LogGroupName:  gocf.Ref(logGroupResourceName).String(),
+			MetricTransformations: &gocf.LogsMetricFilterMetricTransformationList{
+				gocf.LogsMetricFilterMetricTransformation{
+					MetricName:      gocf.String(eachFilter.MetricName),
+					MetricNamespace: gocf.String(eachFilter.MetricNamespace),
+					MetricValue:     gocf.String(metricValue),
+				},
+			},
+		})
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	spartaS3 "github.com/mweagle/Sparta/aws/s3"
+	"github.com/mweagle/Sparta/system"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// postProvisionRollbackCapabilities are the capabilities acknowledged
+// when restoring the previous template. The previous template isn't
+// parsed, so acknowledge everything a Sparta template may require.
+var postProvisionRollbackCapabilities = []*string{
+	aws.String(cloudformation.CapabilityCapabilityIam),
+	aws.String(cloudformation.CapabilityCapabilityNamedIam),
+	aws.String(cloudformation.CapabilityCapabilityAutoExpand),
+}
+
+// stackOutputValues returns the stack Outputs keyed by OutputKey
+func stackOutputValues(stack *cloudformation.Stack) map[string]string {
+	outputs := make(map[string]string)
+	if stack == nil {
+		return outputs
+	}
+	for _, eachOutput := range stack.Outputs {
+		outputs[aws.StringValue(eachOutput.OutputKey)] = aws.StringValue(eachOutput.OutputValue)
+	}
+	return outputs
+}
+
+// runPostProvisionTests runs each test in order and returns the
+// first failure
+func runPostProvisionTests(ctx context.Context,
+	tests []PostProvisionTest,
+	stackOutputs map[string]string,
+	logger *logrus.Logger) error {
+	for eachIndex, eachTest := range tests {
+		if eachTest == nil {
+			continue
+		}
+		startTime := time.Now()
+		testErr := eachTest(ctx, stackOutputs)
+		logger.WithFields(logrus.Fields{
+			"Test":     fmt.Sprintf("%d/%d", eachIndex+1, len(tests)),
+			"Duration": time.Since(startTime),
+			"Success":  testErr == nil,
+		}).Info("Post provision test complete")
+		if testErr != nil {
+			return errors.Wrapf(testErr, "Post provision test %d failed", eachIndex+1)
+		}
+	}
+	return nil
+}
+
+// postProvisionRollbackState is the deployed stack state that's
+// restored if a post provision test fails
+type postProvisionRollbackState struct {
+	templateBody string
+	parameters   []*cloudformation.Parameter
+}
+
+// previousStackState returns the original template body and parameters
+// of the deployed stack, or nil if the stack doesn't exist
+func previousStackState(ctx *workflowContext) (*postProvisionRollbackState, error) {
+	exists, existsErr := spartaCF.StackExists(ctx.userdata.serviceName,
+		ctx.context.awsSession,
+		ctx.logger)
+	if existsErr != nil {
+		return nil, existsErr
+	}
+	if !exists {
+		return nil, nil
+	}
+	awsCloudFormation := cloudformation.New(ctx.context.awsSession)
+	describeOutput, describeErr := awsCloudFormation.DescribeStacksWithContext(ctx.callerContext,
+		&cloudformation.DescribeStacksInput{
+			StackName: aws.String(ctx.userdata.serviceName),
+		})
+	if describeErr != nil {
+		return nil, errors.Wrapf(describeErr,
+			"Failed to describe stack %s",
+			ctx.userdata.serviceName)
+	}
+	templateOutput, templateErr := awsCloudFormation.GetTemplateWithContext(ctx.callerContext,
+		&cloudformation.GetTemplateInput{
+			StackName:     aws.String(ctx.userdata.serviceName),
+			TemplateStage: aws.String(cloudformation.TemplateStageOriginal),
+		})
+	if templateErr != nil {
+		return nil, errors.Wrapf(templateErr,
+			"Failed to get template for stack %s",
+			ctx.userdata.serviceName)
+	}
+	state := &postProvisionRollbackState{
+		templateBody: aws.StringValue(templateOutput.TemplateBody),
+	}
+	if len(describeOutput.Stacks) != 0 {
+		state.parameters = describeOutput.Stacks[0].Parameters
+	}
+	return state, nil
+}
+
+// postProvisionRollbackParameters returns the UpdateStack parameters that
+// restore the previous values. Values that weren't changed by the
+// provision, or that are masked NoEcho values, reuse the
+// current stack value.
+func postProvisionRollbackParameters(previousParameters []*cloudformation.Parameter,
+	currentParameters []*cloudformation.Parameter) []*cloudformation.Parameter {
+	currentValues := make(map[string]string)
+	for _, eachParameter := range currentParameters {
+		currentValues[aws.StringValue(eachParameter.ParameterKey)] = aws.StringValue(eachParameter.ParameterValue)
+	}
+	var rollbackParameters []*cloudformation.Parameter
+	for _, eachParameter := range previousParameters {
+		parameterKey := aws.StringValue(eachParameter.ParameterKey)
+		previousValue := aws.StringValue(eachParameter.ParameterValue)
+		currentValue, currentValueExists := currentValues[parameterKey]
+		if currentValueExists &&
+			(currentValue == previousValue || previousValue == "****") {
+			rollbackParameters = append(rollbackParameters, &cloudformation.Parameter{
+				ParameterKey:     aws.String(parameterKey),
+				UsePreviousValue: aws.Bool(true),
+			})
+		} else {
+			rollbackParameters = append(rollbackParameters, &cloudformation.Parameter{
+				ParameterKey:   aws.String(parameterKey),
+				ParameterValue: aws.String(previousValue),
+			})
+		}
+	}
+	return rollbackParameters
+}
+
+// rollbackPostProvision restores the stack to the previousState,
+// or deletes the stack if it was created by this provision. It uses the
+// rollback session s.t. it completes if the caller context is cancelled.
+func rollbackPostProvision(ctx *workflowContext,
+	stack *cloudformation.Stack,
+	previousState *postProvisionRollbackState) error {
+	awsCloudFormation := cloudformation.New(ctx.context.rollbackSession)
+	stackID := aws.StringValue(stack.StackId)
+	var waitOptions *spartaCF.WaitOptions
+	if ctx.userdata.workflowHooks != nil {
+		waitOptions = ctx.userdata.workflowHooks.StackWaitOptions
+	}
+
+	if previousState == nil {
+		ctx.logger.WithFields(logrus.Fields{
+			"StackName": ctx.userdata.serviceName,
+		}).Warn("Deleting stack created by this provision")
+		_, deleteErr := awsCloudFormation.DeleteStack(&cloudformation.DeleteStackInput{
+			StackName: aws.String(stackID),
+		})
+		if deleteErr != nil {
+			return deleteErr
+		}
+	} else {
+		ctx.logger.WithFields(logrus.Fields{
+			"StackName": ctx.userdata.serviceName,
+		}).Warn("Restoring previous stack template")
+
+		templateName := rollbackTemplateName(ctx.userdata.serviceName)
+		templateFile, templateFileErr := system.TemporaryFile(ScratchDirectory, templateName)
+		if templateFileErr != nil {
+			return templateFileErr
+		}
+		_, writeErr := templateFile.WriteString(previousState.templateBody)
+		if writeErr != nil {
+			return writeErr
+		}
+		closeErr := templateFile.Close()
+		if closeErr != nil {
+			return closeErr
+		}
+		ctx.registerFileCleanupFinalizer(templateFile.Name())
+		s3KeyName, s3KeyNameErr := versionAwareS3KeyName(fmt.Sprintf("%s/%s",
+			ctx.userdata.serviceName,
+			templateName),
+			ctx.context.s3BucketVersioningEnabled,
+			ctx.logger)
+		if s3KeyNameErr != nil {
+			return errors.Wrapf(s3KeyNameErr, "Failed to create version aware S3 keyname")
+		}
+		var uploadOptions *spartaS3.UploadOptions
+		if ctx.userdata.workflowHooks != nil {
+			uploadOptions = ctx.userdata.workflowHooks.S3UploadOptions
+		}
+		uploadURL, uploadURLErr := spartaS3.UploadLocalFileToS3WithOptions(templateFile.Name(),
+			ctx.context.rollbackSession,
+			ctx.userdata.s3Bucket,
+			s3KeyName,
+			uploadOptions,
+			ctx.logger)
+		if uploadURLErr != nil {
+			return errors.Wrapf(uploadURLErr, "Failed to upload rollback template to S3")
+		}
+		_, updateErr := awsCloudFormation.UpdateStack(&cloudformation.UpdateStackInput{
+			StackName:    aws.String(stackID),
+			TemplateURL:  aws.String(uploadURL),
+			Parameters:   postProvisionRollbackParameters(previousState.parameters, stack.Parameters),
+			Capabilities: postProvisionRollbackCapabilities,
+			Tags:         stack.Tags,
+		})
+		if updateErr != nil {
+			return updateErr
+		}
+	}
+	_, waitErr := spartaCF.WaitForStackOperationCompleteWithOptions(stackID,
+		"Waiting for post provision rollback to complete",
+		awsCloudFormation,
+		waitOptions,
+		ctx.logger)
+	if waitErr != nil {
+		return waitErr
+	}
+	describeOutput, describeErr := awsCloudFormation.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackID),
+	})
+	if describeErr != nil {
+		return describeErr
+	}
+	if len(describeOutput.Stacks) == 0 {
+		return errors.Errorf("Failed to describe stack %s", stackID)
+	}
+	stackStatus := aws.StringValue(describeOutput.Stacks[0].StackStatus)
+	switch stackStatus {
+	case cloudformation.StackStatusDeleteComplete,
+		cloudformation.StackStatusUpdateComplete:
+		return nil
+	default:
+		return errors.Errorf("Post provision rollback of stack %s failed with status %s",
+			ctx.userdata.serviceName,
+			stackStatus)
+	}
+}
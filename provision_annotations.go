@@ -5,6 +5,7 @@ package sparta
 import (
 	"reflect"
 	"runtime"
+	"time"
 
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	spartaIAM "github.com/mweagle/Sparta/aws/iam"
@@ -41,9 +42,25 @@ type annotationFunc func(lambdaAWSInfos []*LambdaAWSInfo,
 	template *gocf.Template,
 	logger *logrus.Logger) error
 
+// lambdaEnvironmentSize returns the size of the environment. Only literal
+// values can be measured at build time, so values that are
+// CloudFormation intrinsic functions only contribute their key length.
+func lambdaEnvironmentSize(environment map[string]*gocf.StringExpr) int {
+	totalSize := 0
+	for eachKey, eachValue := range environment {
+		totalSize += len(eachKey)
+		if eachValue != nil && eachValue.Func == nil {
+			totalSize += len(eachValue.Literal)
+		}
+	}
+	return totalSize
+}
+
 func annotateBuildInformation(lambdaAWSInfo *LambdaAWSInfo,
 	template *gocf.Template,
 	buildID string,
+	buildTime time.Time,
+	includeEnvironment bool,
 	logger *logrus.Logger) (*gocf.Template, error) {
 
 	// Add the build id s.t. the logger can get stamped...
@@ -54,12 +71,49 @@ func annotateBuildInformation(lambdaAWSInfo *LambdaAWSInfo,
 	if lambdaEnvironment == nil {
 		lambdaAWSInfo.Options.Environment = make(map[string]*gocf.StringExpr)
 	}
+	if !includeEnvironment {
+		return template, nil
+	}
+	buildMetadata := map[string]string{
+		envVarBuildID:   buildID,
+		envVarBuildTime: buildTime.UTC().Format(time.RFC3339),
+	}
+	for eachKey, eachValue := range buildMetadata {
+		_, exists := lambdaAWSInfo.Options.Environment[eachKey]
+		if exists {
+			logger.WithFields(logrus.Fields{
+				"Key":            eachKey,
+				"LambdaFunction": lambdaAWSInfo.lambdaFunctionName(),
+			}).Debug("Preserving user-supplied build metadata environment value")
+			continue
+		}
+		lambdaAWSInfo.Options.Environment[eachKey] = gocf.String(eachValue)
+	}
+	environmentSize := lambdaEnvironmentSize(lambdaAWSInfo.Options.Environment)
+	if environmentSize > lambdaEnvironmentSizeLimit {
+		return nil, errors.Errorf("Lambda function %s environment size (%d bytes) exceeds the %d byte limit after adding build metadata",
+			lambdaAWSInfo.lambdaFunctionName(),
+			environmentSize,
+			lambdaEnvironmentSizeLimit)
+	}
 	return template, nil
 }
 
 func annotateDiscoveryInfo(lambdaAWSInfo *LambdaAWSInfo,
 	template *gocf.Template,
 	logger *logrus.Logger) (*gocf.Template, error) {
+	if lambdaAWSInfo.DisableDiscovery {
+		if len(lambdaAWSInfo.DependsOn) != 0 {
+			logger.WithFields(logrus.Fields{
+				"LambdaFunction": lambdaAWSInfo.lambdaFunctionName(),
+				"DependsOn":      lambdaAWSInfo.DependsOn,
+			}).Warn("Discovery is disabled. DependsOn resources will not be discoverable")
+		}
+		if lambdaAWSInfo.Options != nil && lambdaAWSInfo.Options.Environment != nil {
+			delete(lambdaAWSInfo.Options.Environment, envVarDiscoveryInformation)
+		}
+		return template, nil
+	}
 	depMap := make(map[string]string)
 
 	// Update the metdata with a reference to the output of each
@@ -143,7 +197,7 @@ func annotateEventSourceMappings(lambdaAWSInfos []*LambdaAWSInfo,
 				spartaIAM.PolicyStatement{
 					Action:   eachStatement.Action,
 					Effect:   "Allow",
-					Resource: spartaCF.DynamicValueToStringExpr(eventSourceMapping.EventSourceArn).String(),
+					Resource: spartaCF.DynamicValueToStringExpr(eventSourceMapping.templateEventSourceArn(template)).String(),
 				})
 		}
 
@@ -213,13 +267,183 @@ func annotateEventSourceMappings(lambdaAWSInfos []*LambdaAWSInfo,
 	return nil
 }
 
+// eventSourceMappingLogicalArn returns the ARN expression for a resource
+// defined in the template that is used as an EventSourceMapping source
+func eventSourceMappingLogicalArn(logicalName string,
+	sourceResource *gocf.Resource) (*gocf.StringExpr, error) {
+
+	switch typedResource := sourceResource.Properties.(type) {
+	case *gocf.SQSQueue, gocf.SQSQueue,
+		*gocf.KinesisStream, gocf.KinesisStream,
+		*gocf.AmazonMQBroker, gocf.AmazonMQBroker:
+		return gocf.GetAtt(logicalName, "Arn"), nil
+	case *gocf.MSKCluster, gocf.MSKCluster:
+		// Ref returns the cluster ARN
+		return gocf.Ref(logicalName).String(), nil
+	case *gocf.DynamoDBTable:
+		if typedResource.StreamSpecification == nil {
+			return nil, errors.Errorf("DynamoDB table %s must define a StreamSpecification to be an event source",
+				logicalName)
+		}
+		return gocf.GetAtt(logicalName, "StreamArn"), nil
+	case gocf.DynamoDBTable:
+		if typedResource.StreamSpecification == nil {
+			return nil, errors.Errorf("DynamoDB table %s must define a StreamSpecification to be an event source",
+				logicalName)
+		}
+		return gocf.GetAtt(logicalName, "StreamArn"), nil
+	case *gocf.SNSTopic, gocf.SNSTopic:
+		return nil, errors.Errorf("Resource %s is an SNS topic. Use SNSPermission.TopicLogicalName to subscribe to it",
+			logicalName)
+	case *gocf.EventsEventBus, gocf.EventsEventBus:
+		return nil, errors.Errorf("Resource %s is an EventBridge event bus. Use EventBridgeRule.EventBusLogicalName to subscribe to it",
+			logicalName)
+	}
+	return nil, errors.Errorf("Resource %s (%s) is not a supported EventSourceMapping source",
+		logicalName,
+		sourceResource.Properties.CfnResourceType())
+}
+
+// templateLogicalResource returns the template resource for a logical name
+// referenced by the given lambda function
+func templateLogicalResource(lambdaAWSInfo *LambdaAWSInfo,
+	logicalName string,
+	template *gocf.Template) (*gocf.Resource, error) {
+	sourceResource, sourceResourceExists := template.Resources[logicalName]
+	if !sourceResourceExists {
+		return nil, errors.Errorf("Lambda %s event source references undefined resource: %s",
+			lambdaAWSInfo.lambdaFunctionName(),
+			logicalName)
+	}
+	return sourceResource, nil
+}
+
+// verifyPermissionLogicalNames ensures that any push source permissions
+// that reference a resource in the same template reference the
+// correct resource type
+func verifyPermissionLogicalNames(lambdaAWSInfo *LambdaAWSInfo,
+	template *gocf.Template) error {
+
+	for _, eachPermission := range lambdaAWSInfo.Permissions {
+		logicalName := ""
+		isValidType := func(gocf.ResourceProperties) bool { return false }
+		switch typedPermission := eachPermission.(type) {
+		case SNSPermission:
+			logicalName = typedPermission.TopicLogicalName
+			isValidType = isSNSTopicResource
+		case *SNSPermission:
+			logicalName = typedPermission.TopicLogicalName
+			isValidType = isSNSTopicResource
+		case EventBridgePermission:
+			if typedPermission.Rule != nil {
+				logicalName = typedPermission.Rule.EventBusLogicalName
+			}
+			isValidType = isEventBusResource
+		case *EventBridgePermission:
+			if typedPermission.Rule != nil {
+				logicalName = typedPermission.Rule.EventBusLogicalName
+			}
+			isValidType = isEventBusResource
+		}
+		if logicalName == "" {
+			continue
+		}
+		sourceResource, sourceResourceErr := templateLogicalResource(lambdaAWSInfo,
+			logicalName,
+			template)
+		if sourceResourceErr != nil {
+			return sourceResourceErr
+		}
+		if !isValidType(sourceResource.Properties) {
+			return errors.Errorf("Resource %s (%s) is not a valid source for Lambda %s %T",
+				logicalName,
+				sourceResource.Properties.CfnResourceType(),
+				lambdaAWSInfo.lambdaFunctionName(),
+				eachPermission)
+		}
+	}
+	return nil
+}
+
+func isSNSTopicResource(properties gocf.ResourceProperties) bool {
+	switch properties.(type) {
+	case *gocf.SNSTopic, gocf.SNSTopic:
+		return true
+	}
+	return false
+}
+
+func isEventBusResource(properties gocf.ResourceProperties) bool {
+	switch properties.(type) {
+	case *gocf.EventsEventBus, gocf.EventsEventBus:
+		return true
+	}
+	return false
+}
+
+// annotateEventSourceLogicalNames resolves the ARN for EventSourceMappings
+// that reference a resource defined in the same template and verifies the
+// resources referenced by SNS and EventBridge permissions. This is done
+// once the template is complete since the resource may be provided by
+// a decorator. The resolved ARN is only written to the exported
+// AWS::Lambda::EventSourceMapping resource.
+func annotateEventSourceLogicalNames(lambdaAWSInfos []*LambdaAWSInfo,
+	template *gocf.Template,
+	logger *logrus.Logger) error {
+
+	for _, eachLambda := range lambdaAWSInfos {
+		verifyErr := verifyPermissionLogicalNames(eachLambda, template)
+		if verifyErr != nil {
+			return verifyErr
+		}
+		for _, eachMapping := range eachLambda.EventSourceMappings {
+			if eachMapping.EventSourceLogicalName == "" {
+				continue
+			}
+			logicalName := eachMapping.EventSourceLogicalName
+			sourceResource, sourceResourceErr := templateLogicalResource(eachLambda,
+				logicalName,
+				template)
+			if sourceResourceErr != nil {
+				return sourceResourceErr
+			}
+			sourceArn, sourceArnErr := eventSourceMappingLogicalArn(logicalName, sourceResource)
+			if sourceArnErr != nil {
+				return sourceArnErr
+			}
+			mappingResource, mappingResourceExists := template.Resources[eachMapping.resourceName]
+			if !mappingResourceExists {
+				return errors.Errorf("Failed to find EventSourceMapping resource for: %s", logicalName)
+			}
+			typedMapping, typedMappingOk := mappingResource.Properties.(gocf.LambdaEventSourceMapping)
+			if !typedMappingOk {
+				return errors.Errorf("EventSourceMapping resource is incorrect type: %T",
+					mappingResource.Properties)
+			}
+			typedMapping.EventSourceArn = sourceArn
+			mappingResource.Properties = typedMapping
+			mappingResource.DependsOn = append(mappingResource.DependsOn, logicalName)
+
+			logger.WithFields(logrus.Fields{
+				"LambdaFunction": eachLambda.lambdaFunctionName(),
+				"EventSource":    logicalName,
+				"ResourceType":   sourceResource.Properties.CfnResourceType(),
+			}).Debug("Resolved EventSourceMapping logical name")
+		}
+	}
+	return nil
+}
+
 func annotateMaterializedTemplate(
 	lambdaAWSInfos []*LambdaAWSInfo,
 	template *gocf.Template,
 	logger *logrus.Logger) (*gocf.Template, error) {
 	// Setup the annotation functions
 	annotationFuncs := []annotationFunc{
+		annotateEventSourceLogicalNames,
+		annotateTemplateResourceArns,
 		annotateEventSourceMappings,
+		annotateProvisioningWaves,
 	}
 	for _, eachAnnotationFunc := range annotationFuncs {
 		funcName := runtime.FuncForPC(reflect.ValueOf(eachAnnotationFunc).Pointer()).Name()
@@ -238,3 +462,47 @@ func annotateMaterializedTemplate(
 	}
 	return template, nil
 }
+
+// annotateTemplateResourceArns resolves TemplateResourceArn privilege
+// resources to the scoped ARN of the referenced template resource. The
+// IAM role statements share the placeholder expression, so updating it
+// updates the role policy.
+func annotateTemplateResourceArns(lambdaAWSInfos []*LambdaAWSInfo,
+	template *gocf.Template,
+	logger *logrus.Logger) error {
+	roleDefinitions := make([]*IAMRoleDefinition, 0)
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.RoleDefinition != nil {
+			roleDefinitions = append(roleDefinitions, eachLambda.RoleDefinition)
+		}
+		for _, eachCustomResource := range eachLambda.customResources {
+			if eachCustomResource.roleDefinition != nil {
+				roleDefinitions = append(roleDefinitions, eachCustomResource.roleDefinition)
+			}
+		}
+	}
+	for _, eachRoleDefinition := range roleDefinitions {
+		for _, eachPrivilege := range eachRoleDefinition.Privileges {
+			resourceArn, isResourceArn := eachPrivilege.Resource.(*TemplateResourceArn)
+			if !isResourceArn {
+				continue
+			}
+			resource, resourceExists := template.Resources[resourceArn.LogicalName]
+			if !resourceExists {
+				return errors.Errorf("TemplateResourceArn resource %s not found in template",
+					resourceArn.LogicalName)
+			}
+			scopedArn, scopedArnErr := spartaCF.ScopedArn(resource.Properties.CfnResourceType(),
+				resourceArn.LogicalName)
+			if scopedArnErr != nil {
+				return scopedArnErr
+			}
+			*resourceArn.stringExpr() = *scopedArn
+			logger.WithFields(logrus.Fields{
+				"Resource": resourceArn.LogicalName,
+				"Type":     resource.Properties.CfnResourceType(),
+			}).Debug("Resolved scoped IAM resource ARN")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+func TestLambdaEnvironmentSize(t *testing.T) {
+	environment := map[string]*gocf.StringExpr{
+		"KEY":    gocf.String("value"),
+		"REF":    gocf.Ref("Parameter").String(),
+		"EMPTY":  gocf.String(""),
+		"NILVAL": nil,
+	}
+	// Intrinsic and nil values only contribute their key length
+	expectedSize := len("KEYvalue") + len("REF") + len("EMPTY") + len("NILVAL")
+	if size := lambdaEnvironmentSize(environment); size != expectedSize {
+		t.Fatalf("Expected environment size %d, found: %d", expectedSize, size)
+	}
+}
+
+func TestAnnotateBuildInformation(t *testing.T) {
+	logger, _ := NewLogger("info")
+	buildTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Build metadata is opt-in
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options = nil
+	_, annotateErr := annotateBuildInformation(lambdaFn,
+		gocf.NewTemplate(),
+		"buildID",
+		buildTime,
+		false,
+		logger)
+	if annotateErr != nil {
+		t.Fatalf("Failed to annotate build information: %s", annotateErr)
+	}
+	if lambdaFn.Options == nil || len(lambdaFn.Options.Environment) != 0 {
+		t.Fatalf("Unexpected build metadata environment: %#v", lambdaFn.Options)
+	}
+
+	// User supplied values are preserved
+	lambdaFn.Options.Environment[envVarBuildID] = gocf.String("userBuildID")
+	_, annotateErr = annotateBuildInformation(lambdaFn,
+		gocf.NewTemplate(),
+		"buildID",
+		buildTime,
+		true,
+		logger)
+	if annotateErr != nil {
+		t.Fatalf("Failed to annotate build information: %s", annotateErr)
+	}
+	if lambdaFn.Options.Environment[envVarBuildID].Literal != "userBuildID" {
+		t.Fatalf("Failed to preserve user-supplied %s: %#v",
+			envVarBuildID,
+			lambdaFn.Options.Environment[envVarBuildID])
+	}
+	if lambdaFn.Options.Environment[envVarBuildTime].Literal != "2020-01-02T03:04:05Z" {
+		t.Fatalf("Unexpected %s: %#v",
+			envVarBuildTime,
+			lambdaFn.Options.Environment[envVarBuildTime])
+	}
+
+	// Exceeding the 4KB limit is an error
+	lambdaFn = testLambdaStructData()[0]
+	lambdaFn.Options.Environment = map[string]*gocf.StringExpr{
+		"LARGE": gocf.String(strings.Repeat("x", lambdaEnvironmentSizeLimit-len("LARGE"))),
+	}
+	_, annotateErr = annotateBuildInformation(lambdaFn,
+		gocf.NewTemplate(),
+		"buildID",
+		buildTime,
+		false,
+		logger)
+	if annotateErr != nil {
+		t.Fatalf("Unexpected error without build metadata: %s", annotateErr)
+	}
+	_, annotateErr = annotateBuildInformation(lambdaFn,
+		gocf.NewTemplate(),
+		"buildID",
+		buildTime,
+		true,
+		logger)
+	if annotateErr == nil {
+		t.Fatalf("Failed to reject environment that exceeds the %d byte limit",
+			lambdaEnvironmentSizeLimit)
+	}
+	t.Logf("Expected error: %s", annotateErr)
+}
+
+func TestAnnotateEventSourceLogicalNames(t *testing.T) {
+	logger, _ := NewLogger("info")
+	marshalExpr := func(expr interface{}) string {
+		jsonBytes, _ := json.Marshal(expr)
+		return string(jsonBytes)
+	}
+	streamTable := &gocf.DynamoDBTable{
+		StreamSpecification: &gocf.DynamoDBTableStreamSpecification{
+			StreamViewType: gocf.String("NEW_IMAGE"),
+		},
+	}
+	testCases := []struct {
+		name        string
+		source      gocf.ResourceProperties
+		expectedArn *gocf.StringExpr
+		expectErr   bool
+	}{
+		{"SQS", &gocf.SQSQueue{}, gocf.GetAtt("Source", "Arn"), false},
+		{"Kinesis", gocf.KinesisStream{}, gocf.GetAtt("Source", "Arn"), false},
+		{"DynamoDB", streamTable, gocf.GetAtt("Source", "StreamArn"), false},
+		{"DynamoDBNoStream", &gocf.DynamoDBTable{}, nil, true},
+		{"MSK", &gocf.MSKCluster{}, gocf.Ref("Source").String(), false},
+		{"AmazonMQ", &gocf.AmazonMQBroker{}, gocf.GetAtt("Source", "Arn"), false},
+		{"SNS", &gocf.SNSTopic{}, nil, true},
+		{"EventBridge", &gocf.EventsEventBus{}, nil, true},
+		{"Unsupported", &gocf.S3Bucket{}, nil, true},
+		{"Undefined", nil, nil, true},
+	}
+	for _, eachTestCase := range testCases {
+		t.Run(eachTestCase.name, func(t *testing.T) {
+			template := gocf.NewTemplate()
+			if eachTestCase.source != nil {
+				template.AddResource("Source", eachTestCase.source)
+			}
+			lambdaFn := testLambdaStructData()[0]
+			userArn := "arn:aws:sqs:us-east-1:123456789012:userQueue"
+			mapping := &EventSourceMapping{
+				EventSourceArn:         userArn,
+				EventSourceLogicalName: "Source",
+			}
+			lambdaFn.EventSourceMappings = []*EventSourceMapping{mapping}
+			exportErr := mapping.export("TestService",
+				lambdaFn.lambdaFunctionName(),
+				gocf.GetAtt(lambdaFn.LogicalResourceName(), "Arn"),
+				"",
+				"",
+				template,
+				logger)
+			if exportErr != nil {
+				t.Fatalf("Failed to export EventSourceMapping: %s", exportErr)
+			}
+			annotateErr := annotateEventSourceLogicalNames([]*LambdaAWSInfo{lambdaFn},
+				template,
+				logger)
+			if eachTestCase.expectErr {
+				if annotateErr == nil {
+					t.Fatalf("Expected error for %s source", eachTestCase.name)
+				}
+				return
+			}
+			if annotateErr != nil {
+				t.Fatalf("Failed to annotate logical names: %s", annotateErr)
+			}
+			mappingResource := template.Resources[mapping.resourceName]
+			typedMapping := mappingResource.Properties.(gocf.LambdaEventSourceMapping)
+			if marshalExpr(typedMapping.EventSourceArn) != marshalExpr(eachTestCase.expectedArn) {
+				t.Fatalf("Unexpected EventSourceArn: %s", marshalExpr(typedMapping.EventSourceArn))
+			}
+			if marshalExpr(mapping.templateEventSourceArn(template)) != marshalExpr(eachTestCase.expectedArn) {
+				t.Fatalf("Unexpected template EventSourceArn: %s",
+					marshalExpr(mapping.templateEventSourceArn(template)))
+			}
+			if len(mappingResource.DependsOn) != 1 || mappingResource.DependsOn[0] != "Source" {
+				t.Fatalf("Unexpected DependsOn: %#v", mappingResource.DependsOn)
+			}
+			// The user supplied value is unchanged
+			if mapping.EventSourceArn != userArn {
+				t.Fatalf("EventSourceArn was modified: %#v", mapping.EventSourceArn)
+			}
+		})
+	}
+}
+
+func TestAnnotatePermissionLogicalNames(t *testing.T) {
+	logger, _ := NewLogger("info")
+	testCases := []struct {
+		name       string
+		source     gocf.ResourceProperties
+		permission LambdaPermissionExporter
+		expectErr  bool
+	}{
+		{"SNS",
+			&gocf.SNSTopic{},
+			SNSPermission{TopicLogicalName: "Source"},
+			false},
+		{"SNSPointer",
+			gocf.SNSTopic{},
+			&SNSPermission{TopicLogicalName: "Source"},
+			false},
+		{"SNSIncorrectType",
+			&gocf.SQSQueue{},
+			SNSPermission{TopicLogicalName: "Source"},
+			true},
+		{"SNSUndefined",
+			nil,
+			SNSPermission{TopicLogicalName: "Source"},
+			true},
+		{"EventBridge",
+			&gocf.EventsEventBus{},
+			EventBridgePermission{Rule: &EventBridgeRule{EventBusLogicalName: "Source"}},
+			false},
+		{"EventBridgeIncorrectType",
+			&gocf.SNSTopic{},
+			&EventBridgePermission{Rule: &EventBridgeRule{EventBusLogicalName: "Source"}},
+			true},
+	}
+	for _, eachTestCase := range testCases {
+		t.Run(eachTestCase.name, func(t *testing.T) {
+			template := gocf.NewTemplate()
+			if eachTestCase.source != nil {
+				template.AddResource("Source", eachTestCase.source)
+			}
+			lambdaFn := testLambdaStructData()[0]
+			lambdaFn.Permissions = []LambdaPermissionExporter{eachTestCase.permission}
+			annotateErr := annotateEventSourceLogicalNames([]*LambdaAWSInfo{lambdaFn},
+				template,
+				logger)
+			if (annotateErr != nil) != eachTestCase.expectErr {
+				t.Fatalf("Unexpected annotation result for %s: %v",
+					eachTestCase.name,
+					annotateErr)
+			}
+		})
+	}
+}
+
+func TestEventBridgeBusLogicalName(t *testing.T) {
+	logger, _ := NewLogger("info")
+	template := gocf.NewTemplate()
+	perm := EventBridgePermission{
+		Rule: &EventBridgeRule{
+			EventBusLogicalName: "Bus",
+			ScheduleExpression:  "rate(1 minute)",
+		},
+	}
+	_, exportErr := perm.export("TestService",
+		"lambdaFn",
+		"LambdaFn",
+		template,
+		"",
+		"",
+		logger)
+	if exportErr != nil {
+		t.Fatalf("Failed to export EventBridge permission: %s", exportErr)
+	}
+	foundRule := false
+	for _, eachResource := range template.Resources {
+		eventsRule, eventsRuleOk := eachResource.Properties.(*gocf.EventsRule)
+		if !eventsRuleOk {
+			continue
+		}
+		foundRule = true
+		jsonBytes, _ := json.Marshal(eventsRule.EventBusName)
+		if string(jsonBytes) != `{"Ref":"Bus"}` {
+			t.Fatalf("Unexpected EventBusName: %s", string(jsonBytes))
+		}
+	}
+	if !foundRule {
+		t.Fatalf("Failed to find exported EventsRule")
+	}
+
+	// Name and logical name are mutually exclusive
+	perm.Rule.EventBusName = "busName"
+	_, exportErr = perm.export("TestService",
+		"lambdaFn",
+		"LambdaFn",
+		gocf.NewTemplate(),
+		"",
+		"",
+		logger)
+	if exportErr == nil {
+		t.Fatalf("Expected error for EventBusName and EventBusLogicalName")
+	}
+}
+
+func TestAnnotateTemplateResourceArns(t *testing.T) {
+	logger, _ := NewLogger("info")
+	testCases := []struct {
+		name      string
+		source    gocf.ResourceProperties
+		expected  string
+		expectErr bool
+	}{
+		{"DynamoDB",
+			&gocf.DynamoDBTable{},
+			`{"Fn::GetAtt":["Source","Arn"]}`,
+			false},
+		{"SNS",
+			&gocf.SNSTopic{},
+			`{"Ref":"Source"}`,
+			false},
+		{"SSM",
+			&gocf.SSMParameter{},
+			`{"Fn::Join":["",["arn:",{"Ref":"AWS::Partition"},":ssm:",{"Ref":"AWS::Region"},":",{"Ref":"AWS::AccountId"},":parameter/",{"Ref":"Source"}]]}`,
+			false},
+		{"Unsupported",
+			&gocf.EC2Instance{},
+			"",
+			true},
+		{"Undefined",
+			nil,
+			"",
+			true},
+	}
+	for _, eachTestCase := range testCases {
+		t.Run(eachTestCase.name, func(t *testing.T) {
+			template := gocf.NewTemplate()
+			if eachTestCase.source != nil {
+				template.AddResource("Source", eachTestCase.source)
+			}
+			resourceArn := NewTemplateResourceArn("Source")
+			lambdaFn := testLambdaStructData()[0]
+			lambdaFn.RoleDefinition = &IAMRoleDefinition{
+				Privileges: []IAMRolePrivilege{
+					{
+						Actions:  []string{"sns:Publish"},
+						Resource: resourceArn,
+					},
+				},
+			}
+			// The role statement shares the placeholder expression
+			privilegeExpr := lambdaFn.RoleDefinition.Privileges[0].resourceExpr()
+			annotateErr := annotateTemplateResourceArns([]*LambdaAWSInfo{lambdaFn},
+				template,
+				logger)
+			if eachTestCase.expectErr {
+				if annotateErr == nil {
+					t.Fatalf("Expected error for %s resource", eachTestCase.name)
+				}
+				return
+			}
+			if annotateErr != nil {
+				t.Fatalf("Failed to annotate template resource ARNs: %s", annotateErr)
+			}
+			jsonBytes, _ := json.Marshal(privilegeExpr)
+			if string(jsonBytes) != eachTestCase.expected {
+				t.Fatalf("Unexpected resource ARN. Expected: %s, Found: %s",
+					eachTestCase.expected,
+					string(jsonBytes))
+			}
+		})
+	}
+}
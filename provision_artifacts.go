@@ -0,0 +1,621 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	spartaS3 "github.com/mweagle/Sparta/aws/s3"
+	"github.com/mweagle/Sparta/system"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ArtifactFile is a local artifact and the S3 key it's deployed to
+type ArtifactFile struct {
+	// Path is the artifact path relative to the manifest directory
+	Path string `json:"path"`
+	// S3Key is the content addressed key referenced by the template
+	S3Key string `json:"s3Key"`
+}
+
+// ArtifactManifest describes the deployable artifacts written by
+// BuildArtifacts. DeployArtifacts reads the manifest to provision
+// the service.
+type ArtifactManifest struct {
+	ServiceName string `json:"serviceName"`
+	BuildID     string `json:"buildID"`
+	BuildTags   string `json:"buildTags,omitempty"`
+	// S3Bucket is the artifact bucket referenced by the template
+	S3Bucket string `json:"s3Bucket"`
+	// BinaryName is the name of the binary in the code archive
+	BinaryName string `json:"binaryName"`
+	// CodeArchive is the Lambda code ZIP archive
+	CodeArchive *ArtifactFile `json:"codeArchive,omitempty"`
+	// SiteArchive is the optional S3 site ZIP archive
+	SiteArchive *ArtifactFile `json:"siteArchive,omitempty"`
+	// Template is the CloudFormation template path relative to the
+	// manifest directory
+	Template string `json:"template"`
+	// NestedTemplates are the nested stack templates referenced by the
+	// template if WorkflowHooks.NestedStacks split it
+	NestedTemplates []*ArtifactFile `json:"nestedTemplates,omitempty"`
+	// Capabilities are the CloudFormation capabilities that the template
+	// requires
+	Capabilities []string `json:"capabilities,omitempty"`
+	// OperationTimeoutMinutes is the stack operation timeout for the
+	// template resources
+	OperationTimeoutMinutes int64 `json:"operationTimeoutMinutes,omitempty"`
+	// Created is the time the artifacts were built
+	Created time.Time `json:"created"`
+}
+
+// artifacts returns the archives and nested stack templates that are
+// uploaded before the template is applied. Optional entries are nil.
+func (manifest *ArtifactManifest) artifacts() []*ArtifactFile {
+	artifacts := []*ArtifactFile{manifest.CodeArchive, manifest.SiteArchive}
+	return append(artifacts, manifest.NestedTemplates...)
+}
+
+// DeployOptions are the optional DeployArtifactsWithContext values that
+// differ for the environment the artifacts are deployed to
+type DeployOptions struct {
+	// S3Bucket is the bucket the artifacts are uploaded to. The template
+	// and nested stack template references to the manifest S3Bucket,
+	// including S3 ARNs and URLs, are replaced. Defaults to the manifest
+	// S3Bucket.
+	S3Bucket string
+	// Description is the stack description. Defaults to the built
+	// template description.
+	Description string
+}
+
+// artifactManifestPath returns the manifest path in the artifacts
+// directory
+func artifactManifestPath(artifactsDirectory string, serviceName string) string {
+	return filepath.Join(artifactsDirectory,
+		fmt.Sprintf("%s-artifacts.json", sanitizedName(serviceName)))
+}
+
+// buildingArtifacts returns true if the workflow is building the
+// deployable artifacts. Artifacts aren't uploaded until they're
+// deployed.
+func (ctx *workflowContext) buildingArtifacts() bool {
+	return ctx.userdata.artifactsDirectory != "" && !ctx.context.deployingArtifacts
+}
+
+// moveArtifact moves the local file into the artifacts directory and
+// returns the manifest entry
+func moveArtifact(localPath string, s3Key string, ctx *workflowContext) (*ArtifactFile, error) {
+	mkdirErr := os.MkdirAll(ctx.userdata.artifactsDirectory, os.ModePerm)
+	if nil != mkdirErr {
+		return nil, mkdirErr
+	}
+	artifactName := path.Base(s3Key)
+	if artifactName == "" || artifactName == "." {
+		artifactName = filepath.Base(localPath)
+	}
+	artifactPath := filepath.Join(ctx.userdata.artifactsDirectory, artifactName)
+	// The artifacts directory may be on a different volume, so
+	// copy rather than rename
+	srcFile, srcFileErr := os.Open(localPath)
+	if nil != srcFileErr {
+		return nil, srcFileErr
+	}
+	defer srcFile.Close()
+	destFile, destFileErr := os.Create(artifactPath)
+	if nil != destFileErr {
+		return nil, destFileErr
+	}
+	_, copyErr := io.Copy(destFile, srcFile)
+	if nil != copyErr {
+		destFile.Close()
+		return nil, errors.Wrapf(copyErr, "Failed to copy artifact %s", localPath)
+	}
+	closeErr := destFile.Close()
+	if nil != closeErr {
+		return nil, closeErr
+	}
+	ctx.registerFileCleanupFinalizer(localPath)
+	ctx.logger.WithFields(logrus.Fields{
+		"Path":  relativePath(artifactPath),
+		"S3Key": s3Key,
+	}).Info("Created artifact")
+	return &ArtifactFile{
+		Path:  artifactName,
+		S3Key: s3Key,
+	}, nil
+}
+
+// writeArtifactManifest moves the template into the artifacts directory
+// and writes the manifest that references all the artifacts
+func writeArtifactManifest(templatePath string, ctx *workflowContext) error {
+	manifest := ctx.context.artifactManifest
+	templateArtifact, templateArtifactErr := moveArtifact(templatePath, "", ctx)
+	if nil != templateArtifactErr {
+		return templateArtifactErr
+	}
+	manifest.Template = templateArtifact.Path
+	manifest.S3Bucket = ctx.userdata.s3Bucket
+	manifest.Created = time.Now().UTC()
+	// Record the template values that the deploy requires s.t. the
+	// template doesn't need to be parsed
+	if ctx.context.cfTemplate != nil {
+		manifest.Capabilities = aws.StringValueSlice(spartaCF.StackCapabilities(ctx.context.cfTemplate))
+		sort.Strings(manifest.Capabilities)
+		operationTimeout := maximumStackOperationTimeout(ctx.context.cfTemplate, ctx.logger)
+		manifest.OperationTimeoutMinutes = int64(operationTimeout.Minutes())
+	}
+
+	manifestJSON, manifestJSONErr := json.MarshalIndent(manifest, "", " ")
+	if nil != manifestJSONErr {
+		return manifestJSONErr
+	}
+	manifestPath := artifactManifestPath(ctx.userdata.artifactsDirectory,
+		ctx.userdata.serviceName)
+	writeErr := ioutil.WriteFile(manifestPath, manifestJSON, 0644)
+	if nil != writeErr {
+		return errors.Wrapf(writeErr, "Failed to write artifact manifest")
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Path": relativePath(manifestPath),
+	}).Info("Created artifact manifest")
+	return nil
+}
+
+// readArtifactManifest returns the manifest at manifestPath with
+// the artifact paths resolved relative to the manifest directory
+func readArtifactManifest(manifestPath string) (*ArtifactManifest, error) {
+	manifestJSON, manifestJSONErr := ioutil.ReadFile(manifestPath)
+	if nil != manifestJSONErr {
+		return nil, errors.Wrapf(manifestJSONErr, "Failed to read artifact manifest")
+	}
+	var manifest ArtifactManifest
+	unmarshalErr := json.Unmarshal(manifestJSON, &manifest)
+	if nil != unmarshalErr {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse artifact manifest %s", manifestPath)
+	}
+	if manifest.ServiceName == "" || manifest.Template == "" || manifest.S3Bucket == "" {
+		return nil, errors.Errorf("Artifact manifest %s must include the serviceName, s3Bucket, and template",
+			manifestPath)
+	}
+	manifestDir := filepath.Dir(manifestPath)
+	manifest.Template = filepath.Join(manifestDir, manifest.Template)
+	for _, eachArtifact := range manifest.artifacts() {
+		if eachArtifact != nil {
+			eachArtifact.Path = filepath.Join(manifestDir, eachArtifact.Path)
+		}
+	}
+	return &manifest, nil
+}
+
+// priorArtifactPaths returns the paths of the manifest in artifactsDirectory
+// and the artifacts it references, or nil if there isn't a manifest
+func priorArtifactPaths(artifactsDirectory string, serviceName string) ([]string, error) {
+	manifestPath := artifactManifestPath(artifactsDirectory, serviceName)
+	_, statErr := os.Stat(manifestPath)
+	if os.IsNotExist(statErr) {
+		return nil, nil
+	} else if statErr != nil {
+		return nil, statErr
+	}
+	manifest, manifestErr := readArtifactManifest(manifestPath)
+	if manifestErr != nil {
+		return nil, manifestErr
+	}
+	artifactPaths := []string{manifest.Template}
+	for _, eachArtifact := range manifest.artifacts() {
+		if eachArtifact != nil {
+			artifactPaths = append(artifactPaths, eachArtifact.Path)
+		}
+	}
+	return append(artifactPaths, manifestPath), nil
+}
+
+// overrideTemplateBody returns the JSON or YAML templateBody with the
+// fromBucket references replaced by the toBucket and the optional stack
+// description. JSON templates are encoded as JSON and YAML templates
+// as YAML.
+func overrideTemplateBody(templateBody []byte,
+	fromBucket string,
+	toBucket string,
+	description string) ([]byte, error) {
+	var templateNode yaml.Node
+	unmarshalErr := yaml.Unmarshal(templateBody, &templateNode)
+	if nil != unmarshalErr {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse template")
+	}
+	if len(templateNode.Content) == 0 || templateNode.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("Failed to parse template: template must be a mapping")
+	}
+	if toBucket != "" && toBucket != fromBucket {
+		replaceBucketReferences(&templateNode, fromBucket, toBucket)
+	}
+	if description != "" {
+		rootNode := templateNode.Content[0]
+		descriptionNode := &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Tag:   "!!str",
+			Value: description,
+		}
+		replaced := false
+		for i := 0; i+1 < len(rootNode.Content); i += 2 {
+			if rootNode.Content[i].Value == "Description" {
+				rootNode.Content[i+1] = descriptionNode
+				replaced = true
+			}
+		}
+		if !replaced {
+			rootNode.Content = append([]*yaml.Node{{
+				Kind:  yaml.ScalarNode,
+				Tag:   "!!str",
+				Value: "Description",
+			}, descriptionNode}, rootNode.Content...)
+		}
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(templateBody), []byte("{")) {
+		var template interface{}
+		decodeErr := templateNode.Decode(&template)
+		if nil != decodeErr {
+			return nil, errors.Wrapf(decodeErr, "Failed to decode template")
+		}
+		return json.Marshal(template)
+	}
+	var yamlBuffer bytes.Buffer
+	encoder := yaml.NewEncoder(&yamlBuffer)
+	encoder.SetIndent(2)
+	encodeErr := encoder.Encode(&templateNode)
+	if nil != encodeErr {
+		return nil, errors.Wrapf(encodeErr, "Failed to encode template")
+	}
+	closeErr := encoder.Close()
+	if nil != closeErr {
+		return nil, errors.Wrapf(closeErr, "Failed to encode template")
+	}
+	return yamlBuffer.Bytes(), nil
+}
+
+// replaceBucketReferences replaces the string values that are the
+// fromBucket name, an S3 ARN in the fromBucket, or a fromBucket
+// virtual hosted style URL prefix
+func replaceBucketReferences(node *yaml.Node, fromBucket string, toBucket string) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		fromARN := fmt.Sprintf(":s3:::%s", fromBucket)
+		fromURL := fmt.Sprintf("https://%s.s3.", fromBucket)
+		switch {
+		case node.Value == fromBucket:
+			node.Value = toBucket
+		case strings.HasPrefix(node.Value, "arn:") &&
+			(strings.HasSuffix(node.Value, fromARN) || strings.Contains(node.Value, fromARN+"/")):
+			node.Value = strings.Replace(node.Value, fromARN, fmt.Sprintf(":s3:::%s", toBucket), 1)
+		case strings.HasPrefix(node.Value, fromURL):
+			node.Value = fmt.Sprintf("https://%s.s3.%s", toBucket, strings.TrimPrefix(node.Value, fromURL))
+		}
+	}
+	for _, eachNode := range node.Content {
+		replaceBucketReferences(eachNode, fromBucket, toBucket)
+	}
+}
+
+// deployTemplateCopy copies the template artifact to a scratch file that's
+// uploaded and deleted by the deploy. The template is copied as is unless
+// the toBucket or description override the built values.
+func deployTemplateCopy(templatePath string,
+	fromBucket string,
+	toBucket string,
+	description string) (string, error) {
+	templateBody, templateBodyErr := ioutil.ReadFile(templatePath)
+	if nil != templateBodyErr {
+		return "", errors.Wrapf(templateBodyErr, "Failed to read template")
+	}
+	if (toBucket != "" && toBucket != fromBucket) || description != "" {
+		var overrideErr error
+		templateBody, overrideErr = overrideTemplateBody(templateBody,
+			fromBucket,
+			toBucket,
+			description)
+		if nil != overrideErr {
+			return "", errors.Wrapf(overrideErr, "Failed to override template %s", templatePath)
+		}
+	}
+	templateFile, templateFileErr := system.TemporaryFile(ScratchDirectory,
+		filepath.Base(templatePath))
+	if nil != templateFileErr {
+		return "", templateFileErr
+	}
+	_, writeErr := templateFile.Write(templateBody)
+	if nil != writeErr {
+		templateFile.Close()
+		return "", errors.Wrapf(writeErr, "Failed to copy template")
+	}
+	closeErr := templateFile.Close()
+	if nil != closeErr {
+		return "", closeErr
+	}
+	return templateFile.Name(), nil
+}
+
+// BuildArtifacts compiles and packages the service and creates the
+// CloudFormation template without uploading or provisioning anything.
+// The code archive, optional S3 site archive, template, and a manifest
+// that references them are written to the artifactsDirectory. The
+// archives use content addressed S3 keys in s3Bucket so that the same
+// artifacts can be deployed by DeployArtifacts from a separate job.
+// ServiceDecorators and the other workflow hooks are called with
+// noop=true. The returned value is the manifest path.
+func BuildArtifacts(serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	buildID string,
+	buildTags string,
+	linkerFlags string,
+	artifactsDirectory string,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) (string, error) {
+
+	return BuildArtifactsWithContext(context.Background(),
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		buildID,
+		buildTags,
+		linkerFlags,
+		artifactsDirectory,
+		workflowHooks,
+		logger)
+}
+
+// BuildArtifactsWithContext is BuildArtifacts with a caller supplied
+// context. The context is applied as it is by ProvisionWithContext.
+func BuildArtifactsWithContext(callerContext context.Context,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	buildID string,
+	buildTags string,
+	linkerFlags string,
+	artifactsDirectory string,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) (string, error) {
+
+	if artifactsDirectory == "" {
+		return "", errors.New("BuildArtifacts requires an artifacts directory")
+	}
+	if s3Bucket == "" &&
+		(workflowHooks == nil || workflowHooks.ArtifactBucketOptions == nil) {
+		return "", errors.New("BuildArtifacts requires an S3 bucket. Supply the bucket " +
+			"name or set WorkflowHooks.ArtifactBucketOptions")
+	}
+	provisionErr := provisionWorkflow(callerContext,
+		true,
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		false,
+		buildID,
+		"",
+		buildTags,
+		linkerFlags,
+		nil,
+		artifactsDirectory,
+		nil,
+		workflowHooks,
+		logger)
+	if provisionErr != nil {
+		return "", provisionErr
+	}
+	return artifactManifestPath(artifactsDirectory, serviceName), nil
+}
+
+// DeployArtifacts uploads the artifacts described by the BuildArtifacts
+// manifest and creates or updates the service's stack with the manifest
+// template. The template is uploaded exactly as it was built. The
+// StackTags, S3UploadOptions, StackWaitOptions, ArtifactBucketOptions,
+// MaxConcurrentAWSRequests, and PostProvisionTests workflowHooks values
+// are applied. Archives that already exist in the bucket aren't uploaded
+// again. The stack is created or updated by the same code as Provision.
+func DeployArtifacts(manifestPath string,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+	return DeployArtifactsWithContext(context.Background(),
+		manifestPath,
+		nil,
+		workflowHooks,
+		logger)
+}
+
+// DeployArtifactsWithContext is DeployArtifacts with a caller supplied
+// context, which is applied as it is by ProvisionWithContext, and
+// optional deployOptions to deploy the artifacts to another bucket or
+// with a different stack description.
+func DeployArtifactsWithContext(callerContext context.Context,
+	manifestPath string,
+	deployOptions *DeployOptions,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+
+	manifest, manifestErr := readArtifactManifest(manifestPath)
+	if nil != manifestErr {
+		return manifestErr
+	}
+	s3Bucket := manifest.S3Bucket
+	if deployOptions != nil && deployOptions.S3Bucket != "" {
+		s3Bucket = deployOptions.S3Bucket
+	}
+	startTime := time.Now()
+	awsSession, rollbackSession, awsRequestLimiter := newWorkflowSessions(callerContext,
+		workflowHooks,
+		logger)
+	ctx := &workflowContext{
+		logger:        logger,
+		callerContext: callerContext,
+		userdata: userdata{
+			buildID:       manifest.BuildID,
+			buildTags:     manifest.BuildTags,
+			serviceName:   manifest.ServiceName,
+			s3Bucket:      s3Bucket,
+			workflowHooks: workflowHooks,
+			s3SiteContext: &s3SiteContext{},
+		},
+		context: provisionContext{
+			cfTemplate:           gocf.NewTemplate(),
+			awsSession:           awsSession,
+			rollbackSession:      rollbackSession,
+			workflowHooksContext: make(map[string]interface{}),
+			binaryName:           manifest.BinaryName,
+		},
+		transaction: transaction{
+			startTime: startTime,
+		},
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"ServiceName": manifest.ServiceName,
+		"BuildID":     manifest.BuildID,
+		"Manifest":    manifestPath,
+		"Bucket":      s3Bucket,
+	}).Info("Deploying artifacts")
+
+	// Delete the scratch template copies whether or not the deploy succeeded
+	defer ctx.finalize()
+	deployErr := deployArtifactManifest(manifestPath, deployOptions, true, ctx)
+	if deployErr != nil {
+		showOptionalAWSUsageInfo(deployErr, ctx.logger)
+		ctx.rollback()
+		return errors.Wrapf(deployErr, "Failed to deploy artifacts")
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Duration (s)": fmt.Sprintf("%.f", time.Since(startTime).Seconds()),
+	}).Info("Total elapsed time")
+	awsRequestLimiter.LogSummary(ctx.logger)
+	return nil
+}
+
+// deployArtifactManifest uploads the manifest archives and nested stack
+// templates and applies the manifest template. It's shared by Provision,
+// which deploys the artifacts it builds, and DeployArtifacts. The stack
+// capabilities and operation timeout are the manifest values. If
+// verifyBucket is true, the bucket is created or verified first.
+func deployArtifactManifest(manifestPath string,
+	deployOptions *DeployOptions,
+	verifyBucket bool,
+	ctx *workflowContext) error {
+	manifest, manifestErr := readArtifactManifest(manifestPath)
+	if nil != manifestErr {
+		return manifestErr
+	}
+	ctx.context.artifactManifest = manifest
+	ctx.context.deployingArtifacts = true
+	description := ""
+	if deployOptions != nil {
+		description = deployOptions.Description
+	}
+	if verifyBucket {
+		if ctx.userdata.workflowHooks != nil &&
+			ctx.userdata.workflowHooks.ArtifactBucketOptions != nil {
+			_, ensureErr := spartaS3.EnsureArtifactBucket(ctx.context.awsSession,
+				ctx.userdata.s3Bucket,
+				ctx.userdata.workflowHooks.ArtifactBucketOptions,
+				ctx.logger)
+			if ensureErr != nil {
+				return ensureErr
+			}
+		}
+		regionErr := verifyBucketRegion(ctx)
+		if regionErr != nil {
+			return regionErr
+		}
+	}
+	for _, eachArtifact := range manifest.artifacts() {
+		if eachArtifact == nil {
+			continue
+		}
+		existingURL, existingURLErr := spartaS3.ExistingObjectURL(ctx.context.awsSession,
+			ctx.userdata.s3Bucket,
+			eachArtifact.S3Key,
+			ctx.logger)
+		if existingURLErr != nil {
+			return errors.Wrapf(existingURLErr, "Failed to check for existing artifact")
+		}
+		if existingURL != "" {
+			ctx.logger.WithFields(logrus.Fields{
+				"Key": eachArtifact.S3Key,
+			}).Info("Artifact unchanged. Reusing existing S3 object")
+			if eachArtifact == manifest.CodeArchive {
+				ctx.context.s3CodeZipURL = newS3UploadURL(existingURL)
+			}
+			continue
+		}
+		// Upload directly rather than with uploadLocalFileToS3, which
+		// deletes the local file, s.t. the artifacts can be deployed
+		// to other environments. Nested stack templates reference the
+		// artifact bucket, so they're uploaded as a copy.
+		uploadPath := eachArtifact.Path
+		if eachArtifact != manifest.CodeArchive && eachArtifact != manifest.SiteArchive {
+			copyPath, copyErr := deployTemplateCopy(eachArtifact.Path,
+				manifest.S3Bucket,
+				ctx.userdata.s3Bucket,
+				"")
+			if copyErr != nil {
+				return copyErr
+			}
+			ctx.registerFileCleanupFinalizer(copyPath)
+			uploadPath = copyPath
+		}
+		var uploadOptions *spartaS3.UploadOptions
+		if ctx.userdata.workflowHooks != nil {
+			uploadOptions = ctx.userdata.workflowHooks.S3UploadOptions
+		}
+		uploadLocation, uploadErr := spartaS3.UploadLocalFileToS3WithOptions(uploadPath,
+			ctx.context.awsSession,
+			ctx.userdata.s3Bucket,
+			eachArtifact.S3Key,
+			uploadOptions,
+			ctx.logger)
+		if uploadErr != nil {
+			return errors.Wrapf(uploadErr, "Failed to upload artifact %s", eachArtifact.Path)
+		}
+		ctx.registerRollback(spartaS3.CreateS3RollbackFunc(ctx.context.rollbackSession, uploadLocation))
+		if eachArtifact == manifest.CodeArchive {
+			ctx.context.s3CodeZipURL = newS3UploadURL(uploadLocation)
+		}
+	}
+	templatePath, templateErr := deployTemplateCopy(manifest.Template,
+		manifest.S3Bucket,
+		ctx.userdata.s3Bucket,
+		description)
+	if nil != templateErr {
+		return templateErr
+	}
+	return provisionStackTemplate(templatePath, ctx)
+}
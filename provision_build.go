@@ -5,17 +5,23 @@ package sparta
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -26,6 +32,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sts"
 	humanize "github.com/dustin/go-humanize"
 	spartaAWS "github.com/mweagle/Sparta/aws"
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
@@ -42,7 +49,7 @@ import (
 // CONSTANTS
 ////////////////////////////////////////////////////////////////////////////////
 func spartaTagName(baseKey string) string {
-	return fmt.Sprintf("io:gosparta:%s", baseKey)
+	return fmt.Sprintf("%s%s", stackTagSpartaKeyPrefix, baseKey)
 }
 
 var (
@@ -56,6 +63,45 @@ var (
 	SpartaTagBuildTagsKey = spartaTagName("buildTags")
 )
 
+const (
+	// Stack tag limits
+	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/APIReference/API_Tag.html
+	stackTagsMaxCount       = 50
+	stackTagKeyMaxLength    = 128
+	stackTagValueMaxLength  = 256
+	stackTagReservedPrefix  = "aws:"
+	stackTagSpartaKeyPrefix = "io:gosparta:"
+)
+
+const (
+	// lambdaUncompressedSizeLimit is the maximum size of the unzipped
+	// deployment package. AWS applies the limit to the function code
+	// and its layers together, but only the code archive is measured
+	// since the uncompressed layer sizes aren't available at build time.
+	lambdaUncompressedSizeLimit = 250 * 1024 * 1024
+	// lambdaUncompressedSizeWarningPercent is the percentage of the
+	// unzipped limit that triggers a warning
+	lambdaUncompressedSizeWarningPercent = 90
+	// lambdaUncompressedSizeContributorCount is the number of archive
+	// entries reported when the unzipped size is too large
+	lambdaUncompressedSizeContributorCount = 5
+)
+
+const (
+	// CloudFormation template limits
+	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/cloudformation-limits.html
+	templateResourcesMaxCount  = 500
+	templateOutputsMaxCount    = 200
+	templateParametersMaxCount = 200
+	templateBodyMaxSize        = 1024 * 1024
+	// templateLimitWarningPercent is the percentage of a template limit
+	// that triggers a warning
+	templateLimitWarningPercent = 90
+	// templateLimitResourceTypeCount is the number of resource types
+	// reported when the template is too large
+	templateLimitResourceTypeCount = 5
+)
+
 // finalizerFunction is the type of function pushed onto the cleanup stack
 type finalizerFunction func(logger *logrus.Logger)
 
@@ -156,6 +202,12 @@ type userdata struct {
 	s3SiteContext *s3SiteContext
 	// The user-supplied S3 bucket where service artifacts should be posted.
 	s3Bucket string
+	// Optional directory for the BuildArtifacts output. If non-empty
+	// the workflow stops after the artifacts are written.
+	artifactsDirectory string
+	// Optional report for CreateChangeSet. If non-nil the workflow
+	// creates a change set rather than updating the stack.
+	changeSetReport *ChangeSetReport
 }
 
 // context is data that is mutated during the provisioning workflow
@@ -165,6 +217,9 @@ type provisionContext struct {
 	// AWS Session to be used for all API calls made in the process of provisioning
 	// this service.
 	awsSession *session.Session
+	// AWS Session used by the rollback functions. It isn't bound to the
+	// caller context s.t. rollback completes after the caller cancels.
+	rollbackSession *session.Session
 	// Cached IAM role name map.  Used to support dynamic and static IAM role
 	// names.  Static ARN role names are checked for existence via AWS APIs
 	// prior to CloudFormation provisioning.
@@ -179,6 +234,20 @@ type provisionContext struct {
 	binaryName string
 	// Context to pass between workflow operations
 	workflowHooksContext map[string]interface{}
+	// IAM role logical name to the functions and statement origins
+	// for that role. Used to explain the generated roles.
+	iamRoleExplanations map[string]*iamRoleExplanation
+	// SHA256 digest of the optional SBOM for the binary
+	sbomSHA256 string
+	// SHA256 digest of the packaged binary. Computed for SplitDebugSymbols,
+	// SkipUnchangedCode and BuildArtifacts builds.
+	binarySHA256 string
+	// Names of the ServiceDecorators that ran
+	serviceDecoratorHookNames []string
+	// Manifest of the BuildArtifacts output
+	artifactManifest *ArtifactManifest
+	// Are the built artifacts being deployed?
+	deployingArtifacts bool
 }
 
 // similar to context, transaction scopes values that span the entire
@@ -193,6 +262,13 @@ type transaction struct {
 	finalizerFunctions []finalizerFunction
 	// Timings that measure how long things actually took
 	stepDurations []*workflowStepDuration
+	// Guards the transaction slices in case workflow steps
+	// run concurrently
+	mutex sync.Mutex
+	// Serializes the user hooks and WorkflowSteps, which share the
+	// workflowHooksContext, since the verification and package steps
+	// run concurrently
+	workflowHooksMutex sync.Mutex
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -209,11 +285,15 @@ type workflowContext struct {
 	transaction transaction
 	// Preconfigured logger
 	logger *logrus.Logger
+	// Caller supplied context. The workflow stops if it's cancelled.
+	callerContext context.Context
 }
 
 // recordDuration is a utility function to record how long
 func recordDuration(start time.Time, name string, ctx *workflowContext) {
 	elapsed := time.Since(start)
+	ctx.transaction.mutex.Lock()
+	defer ctx.transaction.mutex.Unlock()
 	ctx.transaction.stepDurations = append(ctx.transaction.stepDurations,
 		&workflowStepDuration{
 			name:     name,
@@ -224,6 +304,8 @@ func recordDuration(start time.Time, name string, ctx *workflowContext) {
 // Register a rollback function in the event that the provisioning
 // function failed.
 func (ctx *workflowContext) registerRollback(userFunction spartaS3.RollbackFunction) {
+	ctx.transaction.mutex.Lock()
+	defer ctx.transaction.mutex.Unlock()
 	if nil == ctx.transaction.rollbackFunctions || len(ctx.transaction.rollbackFunctions) <= 0 {
 		ctx.transaction.rollbackFunctions = make([]spartaS3.RollbackFunction, 0)
 	}
@@ -233,6 +315,8 @@ func (ctx *workflowContext) registerRollback(userFunction spartaS3.RollbackFunct
 // Register a rollback function in the event that the provisioning
 // function failed.
 func (ctx *workflowContext) registerFinalizer(userFunction finalizerFunction) {
+	ctx.transaction.mutex.Lock()
+	defer ctx.transaction.mutex.Unlock()
 	if nil == ctx.transaction.finalizerFunctions || len(ctx.transaction.finalizerFunctions) <= 0 {
 		ctx.transaction.finalizerFunctions = make([]finalizerFunction, 0)
 	}
@@ -289,6 +373,19 @@ func (ctx *workflowContext) rollback() {
 	wg.Wait()
 }
 
+// Run the finalizer functions
+func (ctx *workflowContext) finalize() {
+	if len(ctx.transaction.finalizerFunctions) == 0 {
+		return
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"FinalizerCount": len(ctx.transaction.finalizerFunctions),
+	}).Debug("Invoking finalizer functions")
+	for _, eachFinalizer := range ctx.transaction.finalizerFunctions {
+		eachFinalizer(ctx.logger)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // Private - START
 //
@@ -304,6 +401,83 @@ func logFilesize(message string, filePath string, logger *logrus.Logger) {
 	}
 }
 
+// verifyUncompressedArchiveSize sums the uncompressed size of every entry
+// in the code archive and rejects archives that would exceed the
+// AWS Lambda unzipped deployment package limit. The compressed size
+// can be well under the upload limit while the expanded contents
+// still fail at function creation time. Layers count against the same
+// limit but aren't included in the total.
+// Ref: https://docs.aws.amazon.com/lambda/latest/dg/gettingstarted-limits.html
+func verifyUncompressedArchiveSize(archivePath string, logger *logrus.Logger) error {
+	zipReader, zipReaderErr := zip.OpenReader(archivePath)
+	if zipReaderErr != nil {
+		return errors.Wrapf(zipReaderErr, "Failed to open code archive to verify size")
+	}
+	defer zipReader.Close()
+
+	totalSize := uint64(0)
+	entries := make([]*zip.File, 0, len(zipReader.File))
+	for _, eachFile := range zipReader.File {
+		totalSize += eachFile.UncompressedSize64
+		entries = append(entries, eachFile)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].UncompressedSize64 > entries[j].UncompressedSize64
+	})
+	largestEntries := make([]string, 0)
+	for eachIndex, eachFile := range entries {
+		if eachIndex >= lambdaUncompressedSizeContributorCount {
+			break
+		}
+		largestEntries = append(largestEntries, fmt.Sprintf("%s (%s)",
+			eachFile.Name,
+			humanize.Bytes(eachFile.UncompressedSize64)))
+	}
+	logger.WithFields(logrus.Fields{
+		"UncompressedSize": humanize.Bytes(totalSize),
+		"Limit":            humanize.Bytes(lambdaUncompressedSizeLimit),
+	}).Info("Lambda code archive uncompressed size")
+
+	if totalSize > lambdaUncompressedSizeLimit {
+		return errors.Errorf("Lambda code archive uncompressed size (%s) exceeds the AWS Lambda limit of %s. "+
+			"Largest entries: %s. Consider reducing the archive contents or "+
+			"packaging the service as a container image.",
+			humanize.Bytes(totalSize),
+			humanize.Bytes(lambdaUncompressedSizeLimit),
+			strings.Join(largestEntries, ", "))
+	}
+	warningThreshold := uint64((lambdaUncompressedSizeLimit / 100) * lambdaUncompressedSizeWarningPercent)
+	if totalSize > warningThreshold {
+		logger.WithFields(logrus.Fields{
+			"UncompressedSize": humanize.Bytes(totalSize),
+			"Limit":            humanize.Bytes(lambdaUncompressedSizeLimit),
+			"LargestEntries":   largestEntries,
+		}).Warn("Lambda code archive uncompressed size is approaching the AWS Lambda limit")
+	}
+	return nil
+}
+
+// verifyStreamedBinarySize rejects binaries that exceed the AWS Lambda
+// unzipped deployment package limit. It's used for streamed archives,
+// which can't be inspected before they're uploaded.
+func verifyStreamedBinarySize(binaryPath string, logger *logrus.Logger) error {
+	stat, statErr := os.Stat(binaryPath)
+	if statErr != nil {
+		return errors.Wrapf(statErr, "Failed to verify binary size")
+	}
+	binarySize := uint64(stat.Size())
+	logger.WithFields(logrus.Fields{
+		"Size":  humanize.Bytes(binarySize),
+		"Limit": humanize.Bytes(lambdaUncompressedSizeLimit),
+	}).Info("Lambda binary size")
+	if binarySize > lambdaUncompressedSizeLimit {
+		return errors.Errorf("Lambda binary size (%s) exceeds the AWS Lambda limit of %s",
+			humanize.Bytes(binarySize),
+			humanize.Bytes(lambdaUncompressedSizeLimit))
+	}
+	return nil
+}
+
 // Encapsulate calling the rollback hooks
 func callRollbackHook(ctx *workflowContext, wg *sync.WaitGroup) error {
 	if ctx.userdata.workflowHooks == nil {
@@ -335,7 +509,7 @@ func callRollbackHook(ctx *workflowContext, wg *sync.WaitGroup) error {
 		}(eachRollbackHook,
 			ctx.context.workflowHooksContext,
 			ctx.userdata.serviceName,
-			ctx.context.awsSession,
+			ctx.context.rollbackSession,
 			ctx.userdata.noop,
 			ctx.logger)
 	}
@@ -359,9 +533,7 @@ func callServiceDecoratorHook(ctx *workflowContext) error {
 	// pass that to the S3Site
 	// if it's defined, and then merge it with the normal output map.-
 	for eachIndex, eachServiceHook := range serviceHooks {
-		funcPtr := reflect.ValueOf(eachServiceHook).Pointer()
-		funcForPC := runtime.FuncForPC(funcPtr)
-		hookName := funcForPC.Name()
+		hookName := serviceDecoratorHookName(eachServiceHook)
 		if hookName == "" {
 			hookName = fmt.Sprintf("ServiceHook[%d]", eachIndex)
 		}
@@ -387,6 +559,61 @@ func callServiceDecoratorHook(ctx *workflowContext) error {
 		if len(safeMergeErrs) != 0 {
 			return errors.Errorf("Failed to merge templates: %#v", safeMergeErrs)
 		}
+		ctx.context.serviceDecoratorHookNames = append(ctx.context.serviceDecoratorHookNames,
+			hookName)
+	}
+	return validateRequiredServiceDecorators(ctx.userdata.workflowHooks.RequiredServiceDecorators,
+		ctx.context.serviceDecoratorHookNames)
+}
+
+// reClosureSuffix matches the suffix of anonymous functions
+var reClosureSuffix = regexp.MustCompile(`(\.func\d+)+$`)
+
+// serviceDecoratorHookName returns the function name of a
+// ServiceDecoratorHookFunc or the type name of other hook handlers
+func serviceDecoratorHookName(hook ServiceDecoratorHookHandler) string {
+	hookValue := reflect.ValueOf(hook)
+	if hookValue.Kind() == reflect.Func {
+		funcForPC := runtime.FuncForPC(hookValue.Pointer())
+		if funcForPC == nil {
+			return ""
+		}
+		return funcForPC.Name()
+	}
+	return fmt.Sprintf("%T", hook)
+}
+
+// serviceDecoratorHookMatches returns true if the hook name
+// is the required name. Package paths and closure suffixes
+// (eg, .func1) are ignored.
+func serviceDecoratorHookMatches(hookName string, requiredName string) bool {
+	canonicalName := reClosureSuffix.ReplaceAllString(hookName, "")
+	canonicalName = strings.TrimPrefix(canonicalName, "*")
+	requiredName = strings.TrimPrefix(requiredName, "*")
+	return canonicalName == requiredName ||
+		strings.HasSuffix(canonicalName, "/"+requiredName)
+}
+
+// validateRequiredServiceDecorators ensures that each required
+// ServiceDecorator ran
+func validateRequiredServiceDecorators(requiredNames []string, hookNames []string) error {
+	var missingNames []string
+	for _, eachRequiredName := range requiredNames {
+		found := false
+		for _, eachHookName := range hookNames {
+			if serviceDecoratorHookMatches(eachHookName, eachRequiredName) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missingNames = append(missingNames, eachRequiredName)
+		}
+	}
+	if len(missingNames) != 0 {
+		return errors.Errorf("Required ServiceDecorators did not run: %s. Registered ServiceDecorators: %s",
+			strings.Join(missingNames, ", "),
+			strings.Join(hookNames, ", "))
 	}
 	return nil
 }
@@ -398,6 +625,9 @@ func callArchiveHook(lambdaArchive *zip.Writer,
 	if ctx.userdata.workflowHooks == nil {
 		return nil
 	}
+	ctx.transaction.workflowHooksMutex.Lock()
+	defer ctx.transaction.workflowHooksMutex.Unlock()
+
 	archiveHooks := ctx.userdata.workflowHooks.Archives
 	if ctx.userdata.workflowHooks.Archive != nil {
 		ctx.logger.Warn("DEPRECATED: Single ArchiveHook hook superseded by ArchiveHooks slice")
@@ -428,6 +658,9 @@ func callWorkflowHook(hookPhase string,
 	hooks []WorkflowHookHandler,
 	ctx *workflowContext) error {
 
+	ctx.transaction.workflowHooksMutex.Lock()
+	defer ctx.transaction.workflowHooksMutex.Unlock()
+
 	if hook != nil {
 		ctx.logger.Warn(fmt.Sprintf("DEPRECATED: Single %s hook superseded by %ss slice",
 			hookPhase,
@@ -499,6 +732,128 @@ func callValidationHooks(validationHooks []ServiceValidationHookHandler,
 	return nil
 }
 
+// callTemplateTransformers applies the transformers in order and
+// returns the final template
+func callTemplateTransformers(transformers []*TemplateTransformer,
+	template *gocf.Template,
+	ctx *workflowContext) (*gocf.Template, error) {
+
+	for eachIndex, eachTransformer := range transformers {
+		if eachTransformer == nil {
+			return nil, errors.Errorf("Template transformer %d/%d is nil",
+				eachIndex+1,
+				len(transformers))
+		}
+		if eachTransformer.Transform == nil {
+			return nil, errors.Errorf("Template transformer %s (%d/%d) doesn't define a Transform function",
+				eachTransformer.Name,
+				eachIndex+1,
+				len(transformers))
+		}
+		ctx.logger.WithFields(logrus.Fields{
+			"Name":  eachTransformer.Name,
+			"Index": fmt.Sprintf("%d/%d", eachIndex+1, len(transformers)),
+		}).Info("Calling TemplateTransformer")
+
+		transformedTemplate, transformErr := eachTransformer.Transform(ctx.context.workflowHooksContext,
+			ctx.userdata.serviceName,
+			template,
+			ctx.userdata.buildID,
+			ctx.logger)
+		if transformErr != nil {
+			return nil, errors.Wrapf(transformErr,
+				"Template transformer %s (%d/%d) failed",
+				eachTransformer.Name,
+				eachIndex+1,
+				len(transformers))
+		}
+		if transformedTemplate == nil {
+			return nil, errors.Errorf("Template transformer %s (%d/%d) returned a nil template",
+				eachTransformer.Name,
+				eachIndex+1,
+				len(transformers))
+		}
+		template = transformedTemplate
+	}
+	return template, nil
+}
+
+// validateWorkflowSteps ensures each user WorkflowStep targets a known
+// stage and defines a Handler
+func validateWorkflowSteps(steps []*WorkflowStep) error {
+	errorText := []string{}
+	for eachIndex, eachStep := range steps {
+		if eachStep == nil {
+			errorText = append(errorText,
+				fmt.Sprintf("WorkflowStep %d/%d is nil", eachIndex+1, len(steps)))
+			continue
+		}
+		switch eachStep.Stage {
+		case WorkflowStageVerifyIAM,
+			WorkflowStageVerifyAWS,
+			WorkflowStagePackage,
+			WorkflowStageUpload,
+			WorkflowStageValidate,
+			WorkflowStageProvision:
+		default:
+			errorText = append(errorText,
+				fmt.Sprintf("WorkflowStep %s has unsupported Stage: %s",
+					eachStep.Name,
+					eachStep.Stage))
+		}
+		if eachStep.Handler == nil {
+			errorText = append(errorText,
+				fmt.Sprintf("WorkflowStep %s doesn't define a Handler", eachStep.Name))
+		}
+	}
+	if len(errorText) != 0 {
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// callWorkflowSteps invokes the user WorkflowSteps registered for the
+// stage. The Rollback function of each completed step is registered
+// in case a later step fails.
+func callWorkflowSteps(stage WorkflowStage, ctx *workflowContext) error {
+	if ctx.userdata.workflowHooks == nil {
+		return nil
+	}
+	ctx.transaction.workflowHooksMutex.Lock()
+	defer ctx.transaction.workflowHooksMutex.Unlock()
+
+	for _, eachStep := range ctx.userdata.workflowHooks.Steps {
+		if eachStep.Stage != stage {
+			continue
+		}
+		ctx.logger.WithFields(logrus.Fields{
+			"Name":  eachStep.Name,
+			"Stage": stage,
+		}).Info("Calling WorkflowStep")
+
+		invokeErr := eachStep.Handler.Invoke(ctx.context.workflowHooksContext,
+			ctx.userdata.serviceName,
+			ctx.context.awsSession,
+			ctx.userdata.noop,
+			ctx.logger)
+		if invokeErr != nil {
+			return errors.Wrapf(invokeErr,
+				"WorkflowStep %s (%s) failed",
+				eachStep.Name,
+				stage)
+		}
+		handler := eachStep.Handler
+		ctx.registerRollback(func(logger *logrus.Logger) error {
+			return handler.Rollback(ctx.context.workflowHooksContext,
+				ctx.userdata.serviceName,
+				ctx.context.rollbackSession,
+				ctx.userdata.noop,
+				logger)
+		})
+	}
+	return nil
+}
+
 // versionAwareS3KeyName returns a keyname that provides the correct cache
 // invalidation semantics based on whether the target bucket
 // has versioning enabled
@@ -533,6 +888,15 @@ func versionAwareS3KeyName(s3DefaultKey string, s3VersioningEnabled bool, logger
 // uploaded. If the target bucket does not have versioning enabled,
 // this function will automatically make a new key to ensure uniqueness
 func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowContext) (string, error) {
+	return uploadLocalFileToS3WithMetadata(localPath, s3ObjectKey, nil, ctx)
+}
+
+// uploadLocalFileToS3WithMetadata uploads a local file to S3 together with
+// the user-defined object metadata
+func uploadLocalFileToS3WithMetadata(localPath string,
+	s3ObjectKey string,
+	metadata map[string]string,
+	ctx *workflowContext) (string, error) {
 
 	// If versioning is enabled, use a stable name, otherwise use a name
 	// that's dynamically created. By default assume that the bucket is
@@ -549,7 +913,7 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 	}
 
 	s3URL := ""
-	if ctx.userdata.noop {
+	if ctx.userdata.noop || ctx.buildingArtifacts() {
 
 		// Binary size
 		filesize := int64(0)
@@ -557,29 +921,47 @@ func uploadLocalFileToS3(localPath string, s3ObjectKey string, ctx *workflowCont
 		if statErr == nil {
 			filesize = stat.Size()
 		}
+		// Built artifacts are uploaded when they're deployed
+		uploadMessage := noopMessage("S3 upload")
+		if !ctx.userdata.noop {
+			uploadMessage = "Deferring S3 upload until the artifacts are deployed"
+		}
 		ctx.logger.WithFields(logrus.Fields{
 			"Bucket": ctx.userdata.s3Bucket,
 			"Key":    s3ObjectKey,
 			"File":   filepath.Base(localPath),
 			"Size":   humanize.Bytes(uint64(filesize)),
-		}).Info(noopMessage("S3 upload"))
+		}).Info(uploadMessage)
 		s3URL = fmt.Sprintf("https://%s-s3.amazonaws.com/%s",
 			ctx.userdata.s3Bucket,
 			s3ObjectKey)
 	} else {
 		// Make sure we mark things for cleanup in case there's a problem
 		ctx.registerFileCleanupFinalizer(localPath)
+		uploadOptions := spartaS3.DefaultUploadOptions()
+		if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.S3UploadOptions != nil {
+			// Copy the options s.t. the metadata isn't shared across uploads
+			userOptions := *ctx.userdata.workflowHooks.S3UploadOptions
+			uploadOptions = &userOptions
+		}
+		if len(metadata) != 0 {
+			uploadOptions.Metadata = make(map[string]*string, len(metadata))
+			for eachKey, eachValue := range metadata {
+				uploadOptions.Metadata[eachKey] = aws.String(eachValue)
+			}
+		}
 		// Then upload it
-		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3(localPath,
+		uploadLocation, uploadURLErr := spartaS3.UploadLocalFileToS3WithOptions(localPath,
 			ctx.context.awsSession,
 			ctx.userdata.s3Bucket,
 			s3ObjectKey,
+			uploadOptions,
 			ctx.logger)
 		if nil != uploadURLErr {
 			return "", errors.Wrapf(uploadURLErr, "Failed to upload local file to S3")
 		}
 		s3URL = uploadLocation
-		ctx.registerRollback(spartaS3.CreateS3RollbackFunc(ctx.context.awsSession, uploadLocation))
+		ctx.registerRollback(spartaS3.CreateS3RollbackFunc(ctx.context.rollbackSession, uploadLocation))
 	}
 	return s3URL, nil
 }
@@ -606,10 +988,27 @@ func showOptionalAWSUsageInfo(err error, logger *logrus.Logger) {
 	}
 }
 
+// iamRoleDigest returns the content hash of the generated IAM role. Roles
+// with the same digest have identical trust policies, inline statements,
+// managed policies, and permissions boundaries.
+func iamRoleDigest(iamRole gocf.IAMRole) (string, error) {
+	roleJSON, roleJSONErr := json.Marshal(iamRole)
+	if roleJSONErr != nil {
+		return "", errors.Wrapf(roleJSONErr, "Failed to marshal IAM role for digest")
+	}
+	digest := sha256.Sum256(roleJSON)
+	return hex.EncodeToString(digest[:]), nil
+}
+
 // Verify & cache the IAM rolename to ARN mapping
 func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 	defer recordDuration(time.Now(), "Verifying IAM roles", ctx)
 
+	stepErr := callWorkflowSteps(WorkflowStageVerifyIAM, ctx)
+	if stepErr != nil {
+		return nil, stepErr
+	}
+
 	// The map is either a literal Arn from a pre-existing role name
 	// or a gocf.RefFunc() value.
 	// Don't verify them, just create them...
@@ -617,6 +1016,13 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 	ctx.context.lambdaIAMRoleNameMap = make(map[string]*gocf.StringExpr)
 	iamSvc := iam.New(ctx.context.awsSession)
 
+	// Functions with identical generated roles share a single role
+	// iff the service opted in
+	shareIAMRoles := ctx.userdata.workflowHooks != nil &&
+		ctx.userdata.workflowHooks.ShareIAMRoles
+	sharedRoleNames := make(map[string]string)
+	sharedRoleCount := 0
+
 	// Assemble all the RoleNames and validate the inline IAMRoleDefinitions
 	var allRoleNames []string
 	for _, eachLambdaInfo := range ctx.userdata.lambdaAWSInfos {
@@ -645,15 +1051,38 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 			logicalName := eachLambdaInfo.RoleDefinition.logicalName(ctx.userdata.serviceName, eachLambdaInfo.lambdaFunctionName())
 			_, exists := ctx.context.lambdaIAMRoleNameMap[logicalName]
 			if !exists {
-				// Insert it into the resource creation map and add
-				// the "Ref" entry to the hashmap
-				ctx.context.cfTemplate.AddResource(logicalName,
-					eachLambdaInfo.RoleDefinition.toResource(eachLambdaInfo.EventSourceMappings,
-						eachLambdaInfo.Options,
-						ctx.logger))
-
-				ctx.context.lambdaIAMRoleNameMap[logicalName] = gocf.GetAtt(logicalName, "Arn")
+				roleResource := eachLambdaInfo.RoleDefinition.toResource(eachLambdaInfo.EventSourceMappings,
+					eachLambdaInfo.Options,
+					ctx.logger)
+				// EventSourceMapping policies are added to the role once the
+				// template is materialized, so those roles are never shared
+				roleDigest := ""
+				if shareIAMRoles && len(eachLambdaInfo.EventSourceMappings) == 0 {
+					digest, digestErr := iamRoleDigest(roleResource)
+					if digestErr != nil {
+						return nil, digestErr
+					}
+					roleDigest = digest
+				}
+				sharedLogicalName, isShared := sharedRoleNames[roleDigest]
+				if roleDigest != "" && isShared {
+					eachLambdaInfo.RoleDefinition.cachedLogicalName = sharedLogicalName
+					logicalName = sharedLogicalName
+					sharedRoleCount++
+				} else {
+					// Insert it into the resource creation map and add
+					// the "Ref" entry to the hashmap
+					ctx.context.cfTemplate.AddResource(logicalName, roleResource)
+					ctx.context.lambdaIAMRoleNameMap[logicalName] = gocf.GetAtt(logicalName, "Arn")
+					if roleDigest != "" {
+						sharedRoleNames[roleDigest] = logicalName
+					}
+				}
 			}
+			ctx.recordIAMRoleExplanation(logicalName,
+				eachLambdaInfo.lambdaFunctionName(),
+				eachLambdaInfo.RoleDefinition,
+				eachLambdaInfo.Options)
 		}
 
 		// And the custom resource IAMRoles as well...
@@ -670,6 +1099,10 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 							ctx.logger))
 					ctx.context.lambdaIAMRoleNameMap[customResourceLogicalName] = gocf.GetAtt(customResourceLogicalName, "Arn")
 				}
+				ctx.recordIAMRoleExplanation(customResourceLogicalName,
+					eachCustomResource.userFunctionName,
+					eachCustomResource.roleDefinition,
+					eachCustomResource.options)
 			}
 		}
 	}
@@ -692,6 +1125,12 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 			ctx.context.lambdaIAMRoleNameMap[eachRoleName] = gocf.String(*resp.Role.Arn)
 		}
 	}
+	if shareIAMRoles {
+		ctx.logger.WithFields(logrus.Fields{
+			"CollapsedCount": sharedRoleCount,
+			"SharedCount":    len(sharedRoleNames),
+		}).Info("Shared identical IAM roles")
+	}
 	ctx.logger.WithFields(logrus.Fields{
 		"Count": len(ctx.context.lambdaIAMRoleNameMap),
 	}).Info("IAM roles verified")
@@ -699,10 +1138,71 @@ func verifyIAMRoles(ctx *workflowContext) (workflowStep, error) {
 	return verifyAWSPreconditions, nil
 }
 
+// verifyBucketRegion ensures that the artifact bucket is in the
+// same region as the stack
+func verifyBucketRegion(ctx *workflowContext) error {
+	// Bucket region should match region
+	/*
+		The name of the Amazon S3 bucket where the .zip file that contains your deployment package is stored. This bucket must reside in the same AWS Region that you're creating the Lambda function in. You can specify a bucket from another AWS account as long as the Lambda function and the bucket are in the same region.
+	*/
+	bucketRegion, bucketRegionErr := spartaS3.BucketRegion(ctx.context.awsSession,
+		ctx.userdata.s3Bucket,
+		ctx.logger)
+
+	if bucketRegionErr != nil {
+		return fmt.Errorf("failed to determine region for %s. Error: %s",
+			ctx.userdata.s3Bucket,
+			bucketRegionErr)
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"Bucket": ctx.userdata.s3Bucket,
+		"Region": bucketRegion,
+	}).Info("Checking S3 region")
+	if bucketRegion != *ctx.context.awsSession.Config.Region {
+		return fmt.Errorf("s3 Bucket (%s) region (%s) does not match the stack region (%s). "+
+			"Lambda code and S3 site artifacts must be in the stack's region",
+			ctx.userdata.s3Bucket,
+			bucketRegion,
+			*ctx.context.awsSession.Config.Region)
+	}
+	// Nothing else to do...
+	ctx.logger.WithFields(logrus.Fields{
+		"Region": bucketRegion,
+	}).Debug("Confirmed S3 region match")
+	return nil
+}
+
 // Verify that everything is setup in AWS before we start building things
 func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 	defer recordDuration(time.Now(), "Verifying AWS preconditions", ctx)
 
+	stepErr := callWorkflowSteps(WorkflowStageVerifyAWS, ctx)
+	if stepErr != nil {
+		return nil, stepErr
+	}
+
+	var bucketOptions *spartaS3.ArtifactBucketOptions
+	if ctx.userdata.workflowHooks != nil {
+		bucketOptions = ctx.userdata.workflowHooks.ArtifactBucketOptions
+	}
+	if ctx.userdata.s3Bucket == "" && bucketOptions != nil {
+		stsSvc := sts.New(ctx.context.awsSession)
+		identityResponse, identityResponseErr := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if identityResponseErr != nil {
+			return nil, identityResponseErr
+		}
+		// The concurrent package step passes the bucket to the
+		// user hooks
+		ctx.transaction.workflowHooksMutex.Lock()
+		ctx.userdata.s3Bucket = spartaS3.ArtifactBucketName(ctx.userdata.serviceName,
+			*identityResponse.Account,
+			*ctx.context.awsSession.Config.Region)
+		ctx.transaction.workflowHooksMutex.Unlock()
+		ctx.logger.WithFields(logrus.Fields{
+			"Bucket": ctx.userdata.s3Bucket,
+		}).Info("Using derived S3 artifact bucket")
+	}
+
 	// If this a NOOP, assume that versioning is not enabled
 	if ctx.userdata.noop {
 		ctx.logger.WithFields(logrus.Fields{
@@ -710,7 +1210,22 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 			"Bucket":            ctx.userdata.s3Bucket,
 			"Region":            *ctx.context.awsSession.Config.Region,
 		}).Info(noopMessage("S3 preconditions check"))
-	} else if len(ctx.userdata.lambdaAWSInfos) != 0 {
+	} else if len(ctx.userdata.lambdaAWSInfos) != 0 ||
+		ctx.userdata.s3SiteContext.s3Site != nil {
+		// The S3 site contents are also staged in the artifact bucket
+		if ctx.userdata.s3Bucket == "" {
+			return nil, errors.New("An S3 bucket is required. Supply the --s3Bucket " +
+				"value or set WorkflowHooks.ArtifactBucketOptions to create one")
+		}
+		if bucketOptions != nil {
+			_, ensureErr := spartaS3.EnsureArtifactBucket(ctx.context.awsSession,
+				ctx.userdata.s3Bucket,
+				bucketOptions,
+				ctx.logger)
+			if ensureErr != nil {
+				return nil, ensureErr
+			}
+		}
 		// We only need to check this if we're going to upload a ZIP, which
 		// isn't always true in the case of a Step function...
 		// Bucket versioning
@@ -730,33 +1245,10 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 		if ctx.userdata.codePipelineTrigger != "" && !isEnabled {
 			return nil, fmt.Errorf("s3 Bucket (%s) for CodePipeline trigger doesn't have a versioning policy enabled", ctx.userdata.s3Bucket)
 		}
-		// Bucket region should match region
-		/*
-			The name of the Amazon S3 bucket where the .zip file that contains your deployment package is stored. This bucket must reside in the same AWS Region that you're creating the Lambda function in. You can specify a bucket from another AWS account as long as the Lambda function and the bucket are in the same region.
-		*/
-
-		bucketRegion, bucketRegionErr := spartaS3.BucketRegion(ctx.context.awsSession,
-			ctx.userdata.s3Bucket,
-			ctx.logger)
-
-		if bucketRegionErr != nil {
-			return nil, fmt.Errorf("failed to determine region for %s. Error: %s",
-				ctx.userdata.s3Bucket,
-				bucketRegionErr)
+		regionErr := verifyBucketRegion(ctx)
+		if regionErr != nil {
+			return nil, regionErr
 		}
-		ctx.logger.WithFields(logrus.Fields{
-			"Bucket": ctx.userdata.s3Bucket,
-			"Region": bucketRegion,
-		}).Info("Checking S3 region")
-		if bucketRegion != *ctx.context.awsSession.Config.Region {
-			return nil, fmt.Errorf("region (%s) does not match bucket region (%s)",
-				*ctx.context.awsSession.Config.Region,
-				bucketRegion)
-		}
-		// Nothing else to do...
-		ctx.logger.WithFields(logrus.Fields{
-			"Region": bucketRegion,
-		}).Debug("Confirmed S3 region match")
 	}
 
 	// If there are codePipeline environments defined, warn if they don't include
@@ -804,133 +1296,961 @@ func verifyAWSPreconditions(ctx *workflowContext) (workflowStep, error) {
 	return createPackageStep(), nil
 }
 
-// Build and package the application
-func createPackageStep() workflowStep {
-	return func(ctx *workflowContext) (workflowStep, error) {
-		defer recordDuration(time.Now(), "Creating code bundle", ctx)
-
-		// PreBuild Hook
-		if ctx.userdata.workflowHooks != nil {
-			preBuildErr := callWorkflowHook("PreBuild",
-				ctx.userdata.workflowHooks.PreBuild,
-				ctx.userdata.workflowHooks.PreBuilds,
-				ctx)
-			if nil != preBuildErr {
-				return nil, preBuildErr
-			}
-		}
-		sanitizedServiceName := sanitizedName(ctx.userdata.serviceName)
-		buildErr := system.BuildGoBinary(ctx.userdata.serviceName,
-			ctx.context.binaryName,
-			ctx.userdata.useCGO,
-			ctx.userdata.buildID,
-			ctx.userdata.buildTags,
-			ctx.userdata.linkFlags,
-			ctx.userdata.noop,
-			ctx.logger)
-		if nil != buildErr {
-			return nil, buildErr
+// compileBinary compiles the service with the compiler. The rest of the
+// pipeline expects the binary at the spec OutputPath, so the binary is
+// moved there if the compiler returns a different path.
+func compileBinary(callerContext context.Context,
+	compiler system.Compiler,
+	spec *system.CompileSpec) error {
+	binaryPath, buildErr := compiler.Compile(callerContext, spec)
+	if nil != buildErr {
+		return buildErr
+	}
+	if binaryPath != spec.OutputPath {
+		renameErr := os.Rename(binaryPath, spec.OutputPath)
+		if nil != renameErr {
+			return errors.Wrapf(renameErr,
+				"Failed to move compiled binary %s", binaryPath)
 		}
-		// Cleanup the temporary binary
-		defer func() {
-			errRemove := os.Remove(ctx.context.binaryName)
-			if nil != errRemove {
-				ctx.logger.WithFields(logrus.Fields{
-					"File":  ctx.context.binaryName,
-					"Error": errRemove,
-				}).Warn("Failed to delete binary")
-			}
-		}()
+	}
+	return nil
+}
 
-		// PostBuild Hook
-		if ctx.userdata.workflowHooks != nil {
-			postBuildErr := callWorkflowHook("PostBuild",
-				ctx.userdata.workflowHooks.PostBuild,
-				ctx.userdata.workflowHooks.PostBuilds,
-				ctx)
-			if nil != postBuildErr {
-				return nil, postBuildErr
+// splitBinaryDebugSymbols saves an unstripped copy of the binary and
+// its debug symbols to the scratch directory and strips the binary that
+// is packaged for upload. Neither debug artifact is uploaded. The SHA256
+// digest of the stripped binary is returned.
+func splitBinaryDebugSymbols(binaryPath string,
+	serviceName string,
+	logger *logrus.Logger) (string, error) {
+	workingDir, workingDirErr := os.Getwd()
+	if nil != workingDirErr {
+		return "", workingDirErr
+	}
+	scratchPath := filepath.Join(workingDir, ScratchDirectory)
+	mkdirErr := os.MkdirAll(scratchPath, os.ModePerm)
+	if nil != mkdirErr {
+		return "", mkdirErr
+	}
+	unstrippedPath := filepath.Join(scratchPath, unstrippedBinaryName(serviceName))
+	debugPath := filepath.Join(scratchPath, debugSymbolsName(serviceName))
+
+	copyErr := system.CopyFile(binaryPath, unstrippedPath)
+	if nil != copyErr {
+		return "", errors.Wrapf(copyErr, "Failed to save unstripped binary")
+	}
+	splitErr := system.SplitDebugSymbols(binaryPath, debugPath, logger)
+	if nil != splitErr {
+		return "", splitErr
+	}
+	// The digest identifies the packaged binary that these symbols
+	// belong to, and only depends on the stripped contents
+	strippedDigest, strippedDigestErr := system.FileSHA256(binaryPath)
+	if nil != strippedDigestErr {
+		return "", strippedDigestErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Unstripped":     relativePath(unstrippedPath),
+		"DebugSymbols":   relativePath(debugPath),
+		"StrippedSHA256": strippedDigest,
+	}).Info("Saved debug symbols")
+	return strippedDigest, nil
+}
+
+// transformBinary applies the user BinaryTransformHook to the binary at
+// binaryPath. The transformed binary is saved to the scratch directory
+// together with the digest of the untransformed binary and the
+// transformKey, s.t. subsequent builds of an identical binary with the
+// same transform reuse the prior result rather than running the
+// (typically slow) transform again.
+func transformBinary(callerContext context.Context,
+	transform BinaryTransformHook,
+	transformKey string,
+	binaryPath string,
+	serviceName string,
+	logger *logrus.Logger) error {
+	mkdirErr := os.MkdirAll(ScratchDirectory, os.ModePerm)
+	if nil != mkdirErr {
+		return mkdirErr
+	}
+	cachedPath := filepath.Join(ScratchDirectory, transformedBinaryName(serviceName))
+	cachedDigestPath := filepath.Join(ScratchDirectory, transformedDigestName(serviceName))
+
+	binaryDigest, binaryDigestErr := system.FileSHA256(binaryPath)
+	if nil != binaryDigestErr {
+		return binaryDigestErr
+	}
+	cacheKey := binaryDigest
+	if transformKey != "" {
+		cacheKey = fmt.Sprintf("%s\n%s", binaryDigest, transformKey)
+	}
+	/* #nosec */
+	cachedDigest, cachedDigestErr := ioutil.ReadFile(cachedDigestPath)
+	if cachedDigestErr == nil && string(cachedDigest) == cacheKey {
+		_, cachedStatErr := os.Stat(cachedPath)
+		if cachedStatErr == nil {
+			copyErr := system.CopyFile(cachedPath, binaryPath)
+			if nil != copyErr {
+				return errors.Wrapf(copyErr, "Failed to restore transformed binary")
 			}
+			logger.WithFields(logrus.Fields{
+				"Path":   relativePath(cachedPath),
+				"SHA256": binaryDigest,
+			}).Info("Binary unchanged. Reusing transformed binary")
+			return nil
 		}
-		tmpFile, err := system.TemporaryFile(ScratchDirectory,
-			fmt.Sprintf("%s-code.zip", sanitizedServiceName))
-		if err != nil {
-			return nil, err
-		}
-		// Strip the local directory in case it's in there...
-		ctx.logger.WithFields(logrus.Fields{
-			"TempName": relativePath(tmpFile.Name()),
-		}).Info("Creating code ZIP archive for upload")
-		lambdaArchive := zip.NewWriter(tmpFile)
+	}
+	binaryInfo, binaryInfoErr := os.Stat(binaryPath)
+	if nil != binaryInfoErr {
+		return binaryInfoErr
+	}
+	transformErr := transform(callerContext, binaryPath, logger)
+	if nil != transformErr {
+		return errors.Wrapf(transformErr, "Failed to transform binary %s", binaryPath)
+	}
+	transformedInfo, transformedInfoErr := os.Stat(binaryPath)
+	if nil != transformedInfoErr {
+		return errors.Wrapf(transformedInfoErr,
+			"Failed to stat transformed binary %s",
+			binaryPath)
+	}
+	// Save the result for the next build. The digest is written last
+	// s.t. a partial copy is never reused.
+	_ = os.Remove(cachedDigestPath)
+	copyErr := system.CopyFile(binaryPath, cachedPath)
+	if nil != copyErr {
+		return errors.Wrapf(copyErr, "Failed to save transformed binary")
+	}
+	writeErr := ioutil.WriteFile(cachedDigestPath, []byte(cacheKey), 0644)
+	if nil != writeErr {
+		return errors.Wrapf(writeErr, "Failed to save transformed binary digest")
+	}
+	logger.WithFields(logrus.Fields{
+		"OriginalSize":    humanize.Bytes(uint64(binaryInfo.Size())),
+		"TransformedSize": humanize.Bytes(uint64(transformedInfo.Size())),
+	}).Info("Transformed binary")
+	return nil
+}
 
-		// Archive Hook
-		archiveErr := callArchiveHook(lambdaArchive, ctx)
-		if nil != archiveErr {
-			return nil, archiveErr
-		}
-		// Issue: https://github.com/mweagle/Sparta/issues/103. If the executable
-		// bit isn't set, then AWS Lambda won't be able to fork the binary. This tends
-		// to be set properly on a mac/linux os, but not on others. So pre-emptively
-		// always set the bit.
-		// Ref: https://github.com/mweagle/Sparta/issues/158
-		fileHeaderAnnotator := func(header *zip.FileHeader) (*zip.FileHeader, error) {
-			// Make the binary executable
-			// Ref: https://github.com/aws/aws-lambda-go/blob/master/cmd/build-lambda-zip/main.go#L51
-			header.CreatorVersion = 3 << 8
-			header.ExternalAttrs = 0777 << 16
-			return header, nil
-		}
-
-		// File info for the binary executable
-		readerErr := spartaZip.AnnotateAddToZip(lambdaArchive,
-			ctx.context.binaryName,
-			"",
-			fileHeaderAnnotator,
-			ctx.logger)
-		if nil != readerErr {
-			return nil, readerErr
-		}
-		archiveCloseErr := lambdaArchive.Close()
-		if nil != archiveCloseErr {
-			return nil, archiveCloseErr
-		}
-		tempfileCloseErr := tmpFile.Close()
-		if nil != tempfileCloseErr {
-			return nil, tempfileCloseErr
-		}
-		return createUploadStep(tmpFile.Name()), nil
+// verifyDispatchHandlers runs the lambda binary with the listHandlersFlag
+// and verifies that it dispatches to exactly the functions that
+// the build provisions. The check is skipped if the binary can't be
+// run on the build host.
+func verifyDispatchHandlers(binaryPath string,
+	binaryArch string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	logger *logrus.Logger) error {
+	if runtime.GOOS != "linux" || runtime.GOARCH != binaryArch {
+		logger.WithFields(logrus.Fields{
+			"HostOS":     runtime.GOOS,
+			"HostArch":   runtime.GOARCH,
+			"BinaryArch": binaryArch,
+		}).Debug("Skipping dispatch handler verification for cross-compiled binary")
+		return nil
+	}
+	absBinaryPath, absBinaryPathErr := filepath.Abs(binaryPath)
+	if absBinaryPathErr != nil {
+		return absBinaryPathErr
+	}
+	timeoutContext, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	/* #nosec */
+	cmd := exec.CommandContext(timeoutContext, absBinaryPath, listHandlersFlag)
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if runErr != nil {
+		return errors.Wrapf(runErr, "Failed to list lambda binary handlers: %s",
+			strings.TrimSpace(stderr.String()))
+	}
+	var reportedHandlers []string
+	unmarshalErr := json.Unmarshal(stdout.Bytes(), &reportedHandlers)
+	if unmarshalErr != nil {
+		return errors.Wrapf(unmarshalErr, "Failed to parse lambda binary handlers")
 	}
+	expectedHandlers := dispatchHandlerNames(lambdaAWSInfos)
+	mismatchErr := dispatchHandlerMismatch(expectedHandlers, reportedHandlers)
+	if mismatchErr != nil {
+		return mismatchErr
+	}
+	logger.WithFields(logrus.Fields{
+		"Count": len(reportedHandlers),
+	}).Info("Verified lambda binary dispatch handlers")
+	return nil
 }
 
-// Given the zipped binary in packagePath, upload the primary code bundle
-// and optional S3 site resources iff they're defined.
-func createUploadStep(packagePath string) workflowStep {
-	return func(ctx *workflowContext) (workflowStep, error) {
-		defer recordDuration(time.Now(), "Uploading code", ctx)
+////////////////////////////////////////////////////////////////////////////////
+// Scratch directory artifact names. The artifact writers and
+// cleanOutputDirectory both use these so that the cleaned files
+// match the written files.
 
-		var uploadTasks []*workTask
-		if len(ctx.userdata.lambdaAWSInfos) != 0 {
-			// We always upload the primary binary...
-			uploadBinaryTask := func() workResult {
-				logFilesize("Lambda code archive size", packagePath, ctx.logger)
+func codeArchiveName(serviceName string) string {
+	return fmt.Sprintf("%s-code.zip", sanitizedName(serviceName))
+}
 
-				// Create the S3 key...
-				zipS3URL, zipS3URLErr := uploadLocalFileToS3(packagePath, "", ctx)
-				if nil != zipS3URLErr {
-					return newTaskResult(nil, zipS3URLErr)
-				}
-				ctx.context.s3CodeZipURL = newS3UploadURL(zipS3URL)
-				return newTaskResult(ctx.context.s3CodeZipURL, nil)
-			}
-			uploadTasks = append(uploadTasks, newWorkTask(uploadBinaryTask))
-		} else {
-			ctx.logger.Info("Bypassing S3 upload as no Lambda functions were provided")
-		}
+func siteArchiveName(serviceName string) string {
+	return fmt.Sprintf("%s-S3Site.zip", serviceName)
+}
 
-		// We might need to upload some other things...
-		if nil != ctx.userdata.s3SiteContext.s3Site {
+func templateArtifactName(serviceName string, format string) string {
+	return fmt.Sprintf("%s-cftemplate.%s", sanitizedName(serviceName), format)
+}
+
+func rollbackTemplateName(serviceName string) string {
+	return fmt.Sprintf("%s-rollback-cftemplate.json", sanitizedName(serviceName))
+}
+
+func nestedTemplateName(serviceName string, logicalName string) string {
+	return fmt.Sprintf("%s-%s-cftemplate.json", sanitizedName(serviceName), logicalName)
+}
+
+func unstrippedBinaryName(serviceName string) string {
+	return fmt.Sprintf("%s.unstripped", sanitizedName(serviceName))
+}
+
+func debugSymbolsName(serviceName string) string {
+	return fmt.Sprintf("%s.debug", sanitizedName(serviceName))
+}
+
+func sbomName(serviceName string) string {
+	return fmt.Sprintf("%s.cdx.json", sanitizedName(serviceName))
+}
+
+func transformedBinaryName(serviceName string) string {
+	return fmt.Sprintf("%s.transformed", sanitizedName(serviceName))
+}
+
+func transformedDigestName(serviceName string) string {
+	return fmt.Sprintf("%s.sha256", transformedBinaryName(serviceName))
+}
+
+// generatedArtifactNames returns the names of the files that a build of
+// the service writes to the scratch directory. Nested stack templates
+// are named by their logical name, so they're included by matching
+// the existing files.
+func generatedArtifactNames(serviceName string, codePipelineTrigger string) ([]string, error) {
+	artifactNames := []string{
+		SpartaBinaryName,
+		codeArchiveName(serviceName),
+		siteArchiveName(serviceName),
+		templateArtifactName(serviceName, TemplateFormatJSON),
+		templateArtifactName(serviceName, TemplateFormatYAML),
+		rollbackTemplateName(serviceName),
+		unstrippedBinaryName(serviceName),
+		debugSymbolsName(serviceName),
+		sbomName(serviceName),
+		transformedBinaryName(serviceName),
+		transformedDigestName(serviceName),
+	}
+	if codePipelineTrigger != "" {
+		artifactNames = append(artifactNames, codePipelineTrigger)
+	}
+	nestedTemplatePaths, globErr := filepath.Glob(filepath.Join(ScratchDirectory,
+		nestedTemplateName(serviceName, "*")))
+	if globErr != nil {
+		return nil, globErr
+	}
+	for _, eachPath := range nestedTemplatePaths {
+		artifactNames = append(artifactNames, filepath.Base(eachPath))
+	}
+	return artifactNames, nil
+}
+
+// warnReservedConcurrency logs a warning if the total reserved
+// concurrency of the functions doesn't leave the minimum
+// unreserved concurrency of the accountLimit. It returns the
+// total reserved concurrency.
+func warnReservedConcurrency(lambdaAWSInfos []*LambdaAWSInfo,
+	accountLimit int64,
+	logger *logrus.Logger) int64 {
+	var totalReserved int64
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.Options == nil {
+			continue
+		}
+		reservedConcurrency := eachLambda.Options.reservedConcurrency()
+		if reservedConcurrency != nil {
+			totalReserved += *reservedConcurrency
+		}
+	}
+	if totalReserved > accountLimit-lambdaMinUnreservedConcurrency {
+		logger.WithFields(logrus.Fields{
+			"ReservedConcurrency":     totalReserved,
+			"AccountConcurrencyLimit": accountLimit,
+			"MinimumUnreserved":       lambdaMinUnreservedConcurrency,
+		}).Warn("Reserved concurrency exceeds the available account concurrency")
+	}
+	return totalReserved
+}
+
+// cleanOutputDirectory removes the artifacts of prior builds from the
+// scratch directory so that they can't be reused. If artifactsDirectory
+// is non-empty, the prior artifact manifest and the artifacts it
+// references are also removed.
+func cleanOutputDirectory(serviceName string,
+	codePipelineTrigger string,
+	artifactsDirectory string,
+	logger *logrus.Logger) error {
+	var removed []string
+	artifactNames, artifactNamesErr := generatedArtifactNames(serviceName, codePipelineTrigger)
+	if artifactNamesErr != nil {
+		return artifactNamesErr
+	}
+	var artifactPaths []string
+	for _, eachName := range artifactNames {
+		artifactPaths = append(artifactPaths, filepath.Join(ScratchDirectory, eachName))
+	}
+	if artifactsDirectory != "" {
+		priorPaths, priorPathsErr := priorArtifactPaths(artifactsDirectory, serviceName)
+		if priorPathsErr != nil {
+			return priorPathsErr
+		}
+		artifactPaths = append(artifactPaths, priorPaths...)
+	}
+	for _, artifactPath := range artifactPaths {
+		fileInfo, statErr := os.Stat(artifactPath)
+		if os.IsNotExist(statErr) {
+			continue
+		} else if statErr != nil {
+			return statErr
+		}
+		if fileInfo.IsDir() {
+			continue
+		}
+		removeErr := os.Remove(artifactPath)
+		if removeErr != nil {
+			return errors.Wrapf(removeErr, "Failed to remove prior artifact %s", artifactPath)
+		}
+		removed = append(removed, artifactPath)
+	}
+	logger.WithFields(logrus.Fields{
+		"Directory": ScratchDirectory,
+		"Removed":   removed,
+	}).Info("Cleaned output directory")
+	return nil
+}
+
+// verifyAndPackageStep runs the IAM and AWS precondition checks concurrently
+// with the network independent compile and package step. Both must
+// succeed before the code is uploaded and the template is created.
+func verifyAndPackageStep(ctx *workflowContext) (workflowStep, error) {
+	startTime := time.Now()
+	var verifyDuration time.Duration
+	var packageDuration time.Duration
+	var verifyErr error
+	var packageErr error
+	var uploadStep workflowStep
+
+	// A verification failure cancels the in-flight compile. The user
+	// hooks called by both steps are serialized by the
+	// workflowHooksMutex.
+	callerContext := ctx.callerContext
+	packageContext, cancelPackage := context.WithCancel(callerContext)
+	defer cancelPackage()
+	ctx.callerContext = packageContext
+	defer func() {
+		ctx.callerContext = callerContext
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			verifyDuration = time.Since(startTime)
+		}()
+		// The IAM verification is always followed by the AWS preconditions
+		// check. The package step returned by the preconditions check
+		// is run concurrently below.
+		_, verifyErr = verifyIAMRoles(ctx)
+		if verifyErr == nil {
+			_, verifyErr = verifyAWSPreconditions(ctx)
+		}
+		if verifyErr != nil {
+			cancelPackage()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer func() {
+			packageDuration = time.Since(startTime)
+		}()
+		uploadStep, packageErr = createPackageStep()(ctx)
+	}()
+	wg.Wait()
+
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+	if packageErr != nil {
+		return nil, packageErr
+	}
+	elapsed := time.Since(startTime)
+	ctx.logger.WithFields(logrus.Fields{
+		"Verify (s)":  fmt.Sprintf("%.2f", verifyDuration.Seconds()),
+		"Package (s)": fmt.Sprintf("%.2f", packageDuration.Seconds()),
+		"Elapsed (s)": fmt.Sprintf("%.2f", elapsed.Seconds()),
+		"Saved (s)":   fmt.Sprintf("%.2f", (verifyDuration + packageDuration - elapsed).Seconds()),
+	}).Info("Parallel verification and packaging complete")
+	return uploadStep, nil
+}
+
+// codeArchiveDigest returns the SHA256 digest of the code archive contents.
+// The digest includes the name, mode and uncompressed bytes of each
+// entry, so it's stable across builds of the same binary, regardless
+// of the archive timestamps or compression settings.
+func codeArchiveDigest(archivePath string) (string, error) {
+	archiveReader, archiveReaderErr := zip.OpenReader(archivePath)
+	if nil != archiveReaderErr {
+		return "", errors.Wrapf(archiveReaderErr, "Failed to open code archive")
+	}
+	defer archiveReader.Close()
+
+	entries := make([]*zip.File, len(archiveReader.File))
+	copy(entries, archiveReader.File)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	hash := sha256.New()
+	for _, eachEntry := range entries {
+		entryHash := sha256.New()
+		entryReader, entryReaderErr := eachEntry.Open()
+		if nil != entryReaderErr {
+			return "", errors.Wrapf(entryReaderErr,
+				"Failed to open code archive entry %s",
+				eachEntry.Name)
+		}
+		_, copyErr := io.Copy(entryHash, entryReader)
+		closeErr := entryReader.Close()
+		if nil == copyErr {
+			copyErr = closeErr
+		}
+		if nil != copyErr {
+			return "", errors.Wrapf(copyErr,
+				"Failed to read code archive entry %s",
+				eachEntry.Name)
+		}
+		_, writeErr := fmt.Fprintf(hash, "%s:%d:%x\n",
+			eachEntry.Name,
+			eachEntry.ExternalAttrs,
+			entryHash.Sum(nil))
+		if nil != writeErr {
+			return "", errors.Wrapf(writeErr, "Failed to update hash digest")
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// binarySHA256MetadataKey is the S3 object metadata key that records the
+// SHA256 digest of the binary in the code archive
+const binarySHA256MetadataKey = "binary-sha256"
+
+// existingCodeArchive returns the content addressed S3 key for the code
+// archive and, if an identical archive was previously uploaded, the URL
+// of that S3 object so that the upload can be skipped. An existing
+// object is only reused if its binary-sha256 metadata matches the
+// SHA256 digest of the compiled binary.
+func existingCodeArchive(packagePath string, ctx *workflowContext) (string, string, error) {
+	digest, digestErr := codeArchiveDigest(packagePath)
+	if nil != digestErr {
+		return "", "", digestErr
+	}
+	contentKey := fmt.Sprintf("%s/%s-code-%s.zip",
+		ctx.userdata.serviceName,
+		sanitizedName(ctx.userdata.serviceName),
+		digest)
+	if ctx.userdata.noop || ctx.buildingArtifacts() {
+		return "", contentKey, nil
+	}
+	existingURL, existingURLErr := spartaS3.ExistingObjectURLWithMetadata(ctx.context.awsSession,
+		ctx.userdata.s3Bucket,
+		contentKey,
+		codeArchiveMetadata(ctx),
+		ctx.logger)
+	if nil != existingURLErr {
+		return "", "", errors.Wrapf(existingURLErr, "Failed to check for existing code archive")
+	}
+	if existingURL != "" {
+		ctx.logger.WithFields(logrus.Fields{
+			"Key":          contentKey,
+			"Digest":       digest,
+			"BinarySHA256": ctx.context.binarySHA256,
+		}).Info("Code unchanged. Reusing existing S3 code archive")
+	}
+	return existingURL, contentKey, nil
+}
+
+// codeArchiveMetadata returns the S3 object metadata for the code archive
+func codeArchiveMetadata(ctx *workflowContext) map[string]string {
+	if ctx.context.binarySHA256 == "" {
+		return nil
+	}
+	return map[string]string{
+		binarySHA256MetadataKey: ctx.context.binarySHA256,
+	}
+}
+
+// streamCodeArchive returns true if the code archive should be written
+// directly to S3 rather than to a local file
+func streamCodeArchive(ctx *workflowContext) bool {
+	return ctx.userdata.workflowHooks != nil &&
+		ctx.userdata.workflowHooks.StreamCodeArchive &&
+		!ctx.userdata.noop
+}
+
+// validate ensures the source exists and the destination stays
+// within the archive
+func (archiveFile *ArchiveFile) validate() error {
+	_, statErr := os.Stat(archiveFile.Source)
+	if statErr != nil {
+		return fmt.Errorf("ArchiveFile source (%s) does not exist: %s",
+			archiveFile.Source,
+			statErr)
+	}
+	destination := filepath.ToSlash(archiveFile.Destination)
+	if path.IsAbs(destination) ||
+		strings.HasPrefix(path.Clean(destination), "..") {
+		return fmt.Errorf("ArchiveFile destination (%s) must be relative to the archive root",
+			archiveFile.Destination)
+	}
+	// The root entries can't replace the binary
+	rootNames := []string{path.Clean(destination)}
+	if destination == "" {
+		rootNames = []string{filepath.Base(archiveFile.Source)}
+		sourceInfo, _ := os.Stat(archiveFile.Source)
+		if sourceInfo.IsDir() {
+			rootEntries, rootEntriesErr := ioutil.ReadDir(archiveFile.Source)
+			if rootEntriesErr != nil {
+				return fmt.Errorf("Failed to read ArchiveFile source (%s): %s",
+					archiveFile.Source,
+					rootEntriesErr)
+			}
+			rootNames = []string{}
+			for _, eachEntry := range rootEntries {
+				rootNames = append(rootNames, eachEntry.Name())
+			}
+		}
+	}
+	for _, eachName := range rootNames {
+		if isReservedArchiveName(eachName, SpartaBinaryName) {
+			return fmt.Errorf("ArchiveFile (%s) entry %s collides with the Lambda binary",
+				archiveFile.Source,
+				eachName)
+		}
+	}
+	return nil
+}
+
+// isReservedArchiveName returns true if the archive entry name is the
+// root level binary or bootstrap executable
+func isReservedArchiveName(entryName string, binaryName string) bool {
+	entryName = strings.TrimSuffix(entryName, "/")
+	return entryName == binaryName || entryName == lambdaBootstrapName
+}
+
+// writeCodeArchive writes the code ZIP archive, which includes the
+// binary and any ArchiveHook entries, to the writer
+func writeCodeArchive(writer io.Writer, ctx *workflowContext) error {
+	lambdaArchive := zip.NewWriter(writer)
+
+	var archiveFiles []*ArchiveFile
+	var extraFileAnnotator spartaZip.FileHeaderAnnotator
+	if ctx.userdata.workflowHooks != nil {
+		archiveFiles = ctx.userdata.workflowHooks.ArchiveFiles
+		if ctx.userdata.workflowHooks.ReproducibleArchives {
+			extraFileAnnotator = spartaZip.ReproducibleAnnotator(nil)
+		}
+	}
+	// The archive files can't replace the binary entry
+	binaryEntryName := filepath.Base(ctx.context.binaryName)
+	archiveFileAnnotator := func(header *zip.FileHeader) (*zip.FileHeader, error) {
+		if isReservedArchiveName(header.Name, binaryEntryName) {
+			return nil, errors.Errorf("Archive entry %s collides with the Lambda binary", header.Name)
+		}
+		if extraFileAnnotator != nil {
+			return extraFileAnnotator(header)
+		}
+		return header, nil
+	}
+	for _, eachFile := range archiveFiles {
+		ctx.logger.WithFields(logrus.Fields{
+			"Source":      eachFile.Source,
+			"Destination": eachFile.Destination,
+		}).Info("Adding file to code archive")
+		addErr := spartaZip.AnnotateAddToZipAs(lambdaArchive,
+			eachFile.Source,
+			eachFile.Destination,
+			archiveFileAnnotator,
+			ctx.logger)
+		if nil != addErr {
+			return errors.Wrapf(addErr, "Failed to add %s to code archive", eachFile.Source)
+		}
+	}
+
+	// Archive Hook
+	archiveErr := callArchiveHook(lambdaArchive, ctx)
+	if nil != archiveErr {
+		return archiveErr
+	}
+	architecture := lambdaArchitecture(ctx.userdata.workflowHooks)
+	// Issue: https://github.com/mweagle/Sparta/issues/103. If the executable
+	// bit isn't set, then AWS Lambda won't be able to fork the binary. This tends
+	// to be set properly on a mac/linux os, but not on others. So pre-emptively
+	// always set the bit.
+	// Ref: https://github.com/mweagle/Sparta/issues/158
+	fileHeaderAnnotator := func(header *zip.FileHeader) (*zip.FileHeader, error) {
+		// Make the binary executable
+		// Ref: https://github.com/aws/aws-lambda-go/blob/master/cmd/build-lambda-zip/main.go#L51
+		header.CreatorVersion = 3 << 8
+		header.ExternalAttrs = 0777 << 16
+		// The provided.al2 runtime requires a bootstrap executable
+		if architecture == LambdaArchitectureArm64 {
+			header.Name = lambdaBootstrapName
+		}
+		return header, nil
+	}
+	if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.ReproducibleArchives {
+		fileHeaderAnnotator = spartaZip.ReproducibleAnnotator(fileHeaderAnnotator)
+	}
+
+	// File info for the binary executable
+	readerErr := spartaZip.AnnotateAddToZip(lambdaArchive,
+		ctx.context.binaryName,
+		"",
+		fileHeaderAnnotator,
+		ctx.logger)
+	if nil != readerErr {
+		return readerErr
+	}
+	return lambdaArchive.Close()
+}
+
+// uploadStreamedCodeArchive writes the code archive through a pipe into
+// a multipart S3 upload so that the archive isn't buffered to disk
+func uploadStreamedCodeArchive(ctx *workflowContext) (string, error) {
+	defaultS3KeyName := fmt.Sprintf("%s/%s-code.zip",
+		ctx.userdata.serviceName,
+		sanitizedName(ctx.userdata.serviceName))
+	s3KeyName, s3KeyNameErr := versionAwareS3KeyName(defaultS3KeyName,
+		ctx.context.s3BucketVersioningEnabled,
+		ctx.logger)
+	if nil != s3KeyNameErr {
+		return "", errors.Wrapf(s3KeyNameErr, "Failed to create version aware S3 keyname")
+	}
+	var uploadOptions *spartaS3.UploadOptions
+	if ctx.userdata.workflowHooks != nil {
+		uploadOptions = ctx.userdata.workflowHooks.S3UploadOptions
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		// Closing the writer with a nil error signals EOF to the
+		// uploader, while an error aborts the upload
+		pipeWriter.CloseWithError(writeCodeArchive(pipeWriter, ctx))
+	}()
+	uploadLocation, uploadErr := spartaS3.UploadReaderToS3WithOptions(pipeReader,
+		ctx.context.awsSession,
+		ctx.userdata.s3Bucket,
+		s3KeyName,
+		uploadOptions,
+		ctx.logger)
+	// Unblock the archive writer if the upload failed before
+	// the archive was consumed
+	pipeReader.CloseWithError(uploadErr)
+	if nil != uploadErr {
+		return "", errors.Wrapf(uploadErr, "Failed to stream code archive to S3")
+	}
+	ctx.registerRollback(spartaS3.CreateS3RollbackFunc(ctx.context.rollbackSession, uploadLocation))
+	return uploadLocation, nil
+}
+
+// Build and package the application
+func createPackageStep() workflowStep {
+	return func(ctx *workflowContext) (workflowStep, error) {
+		defer recordDuration(time.Now(), "Creating code bundle", ctx)
+
+		stepErr := callWorkflowSteps(WorkflowStagePackage, ctx)
+		if stepErr != nil {
+			return nil, stepErr
+		}
+
+		// PreBuild Hook
+		if ctx.userdata.workflowHooks != nil {
+			preBuildErr := callWorkflowHook("PreBuild",
+				ctx.userdata.workflowHooks.PreBuild,
+				ctx.userdata.workflowHooks.PreBuilds,
+				ctx)
+			if nil != preBuildErr {
+				return nil, preBuildErr
+			}
+		}
+		splitDebugSymbols := ctx.userdata.workflowHooks != nil &&
+			ctx.userdata.workflowHooks.SplitDebugSymbols
+		var buildEnvironment map[string]string
+		if ctx.userdata.workflowHooks != nil {
+			buildEnvironment = ctx.userdata.workflowHooks.BuildEnvironment
+		}
+		architecture := lambdaArchitecture(ctx.userdata.workflowHooks)
+		goArch, goArchErr := lambdaArchitectureGoArch(architecture)
+		if nil != goArchErr {
+			return nil, goArchErr
+		}
+		goArchErr = verifyCGOGoArch(goArch, ctx.userdata.useCGO)
+		if nil != goArchErr {
+			return nil, goArchErr
+		}
+		compiler := system.NewGoToolchainCompiler()
+		if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.Compiler != nil {
+			compiler = ctx.userdata.workflowHooks.Compiler
+		}
+		buildErr := compileBinary(ctx.callerContext,
+			compiler,
+			&system.CompileSpec{
+				ServiceName: ctx.userdata.serviceName,
+				OutputPath:  ctx.context.binaryName,
+				UseCGO:      ctx.userdata.useCGO,
+				BuildID:     ctx.userdata.buildID,
+				BuildTags:   ctx.userdata.buildTags,
+				LinkFlags:   ctx.userdata.linkFlags,
+				Noop:        ctx.userdata.noop && !ctx.buildingArtifacts(),
+				Options: &system.BuildOptions{
+					KeepDebugSymbols: splitDebugSymbols,
+					Environment:      buildEnvironment,
+					GOARCH:           goArch,
+				},
+				Logger: ctx.logger,
+			})
+		if nil != buildErr {
+			return nil, buildErr
+		}
+		// Cleanup the temporary binary
+		defer func() {
+			errRemove := os.Remove(ctx.context.binaryName)
+			if nil != errRemove {
+				ctx.logger.WithFields(logrus.Fields{
+					"File":  ctx.context.binaryName,
+					"Error": errRemove,
+				}).Warn("Failed to delete binary")
+			}
+		}()
+		if splitDebugSymbols {
+			// The stripped binary digest is the binary content hash s.t.
+			// it doesn't depend on the debug symbols
+			strippedDigest, splitErr := splitBinaryDebugSymbols(ctx.context.binaryName,
+				ctx.userdata.serviceName,
+				ctx.logger)
+			if nil != splitErr {
+				return nil, splitErr
+			}
+			ctx.context.binarySHA256 = strippedDigest
+		}
+		// Make sure the binary will run in AWS Lambda. The noop build
+		// isn't necessarily a linux executable.
+		if ctx.userdata.noop && !ctx.buildingArtifacts() {
+			ctx.logger.Info(noopMessage("Lambda binary verification"))
+		} else {
+			verifyErr := system.VerifyLambdaBinary(ctx.context.binaryName,
+				goArch,
+				ctx.logger)
+			if nil != verifyErr {
+				return nil, verifyErr
+			}
+			if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.VerifyDispatchHandlers {
+				verifyErr = verifyDispatchHandlers(ctx.context.binaryName,
+					goArch,
+					ctx.userdata.lambdaAWSInfos,
+					ctx.logger)
+				if nil != verifyErr {
+					return nil, verifyErr
+				}
+			}
+		}
+		if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.GenerateSBOM {
+			mkdirErr := os.MkdirAll(ScratchDirectory, os.ModePerm)
+			if nil != mkdirErr {
+				return nil, mkdirErr
+			}
+			sbomPath := filepath.Join(ScratchDirectory,
+				sbomName(ctx.userdata.serviceName))
+			sbomDigest, sbomErr := system.WriteSBOM(ctx.context.binaryName,
+				sbomPath,
+				ctx.logger)
+			if nil != sbomErr {
+				return nil, sbomErr
+			}
+			ctx.context.sbomSHA256 = sbomDigest
+			ctx.logger.WithFields(logrus.Fields{
+				"Path":   relativePath(sbomPath),
+				"SHA256": sbomDigest,
+			}).Info("Created SBOM")
+		}
+
+		// PostBuild Hook
+		if ctx.userdata.workflowHooks != nil {
+			postBuildErr := callWorkflowHook("PostBuild",
+				ctx.userdata.workflowHooks.PostBuild,
+				ctx.userdata.workflowHooks.PostBuilds,
+				ctx)
+			if nil != postBuildErr {
+				return nil, postBuildErr
+			}
+		}
+		// Binary transform
+		if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.BinaryTransform != nil {
+			if ctx.userdata.noop && !ctx.buildingArtifacts() {
+				ctx.logger.Info(noopMessage("Binary transform"))
+			} else {
+				transformErr := transformBinary(ctx.callerContext,
+					ctx.userdata.workflowHooks.BinaryTransform,
+					ctx.userdata.workflowHooks.BinaryTransformKey,
+					ctx.context.binaryName,
+					ctx.userdata.serviceName,
+					ctx.logger)
+				if nil != transformErr {
+					return nil, transformErr
+				}
+				// The packaged binary changed
+				ctx.context.binarySHA256 = ""
+			}
+		}
+		// The binary digest identifies unchanged code
+		if ctx.context.binarySHA256 == "" &&
+			(ctx.buildingArtifacts() ||
+				(ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.SkipUnchangedCode)) {
+			binaryDigest, binaryDigestErr := system.FileSHA256(ctx.context.binaryName)
+			if nil != binaryDigestErr {
+				return nil, errors.Wrapf(binaryDigestErr, "Failed to compute binary SHA256 digest")
+			}
+			ctx.context.binarySHA256 = binaryDigest
+		}
+		// Streamed archives are written directly to S3 by the upload step
+		if streamCodeArchive(ctx) {
+			// The archive can't be reopened, so the binary is
+			// the only entry included in the size check
+			sizeErr := verifyStreamedBinarySize(ctx.context.binaryName, ctx.logger)
+			if nil != sizeErr {
+				return nil, sizeErr
+			}
+			return createUploadStep(""), nil
+		}
+		archivePath, archiveErr := createCodeArchive(codeArchiveName(ctx.userdata.serviceName),
+			ctx)
+		if nil != archiveErr {
+			return nil, archiveErr
+		}
+		sizeErr := verifyUncompressedArchiveSize(archivePath, ctx.logger)
+		if nil != sizeErr {
+			return nil, sizeErr
+		}
+		return createUploadStep(archivePath), nil
+	}
+}
+
+// createCodeArchive writes the code archive to the scratch directory and
+// returns its path. A partially written archive is deleted if there's an
+// error or the workflow is cancelled.
+func createCodeArchive(archiveName string, ctx *workflowContext) (string, error) {
+	tmpFile, err := system.TemporaryFile(ScratchDirectory, archiveName)
+	if err != nil {
+		return "", err
+	}
+	// Strip the local directory in case it's in there...
+	ctx.logger.WithFields(logrus.Fields{
+		"TempName": relativePath(tmpFile.Name()),
+	}).Info("Creating code ZIP archive for upload")
+	archiveErr := writeCodeArchive(tmpFile, ctx)
+	closeErr := tmpFile.Close()
+	if archiveErr == nil {
+		archiveErr = closeErr
+	}
+	if archiveErr == nil {
+		archiveErr = ctx.callerContext.Err()
+	}
+	if archiveErr != nil {
+		removeErr := os.Remove(tmpFile.Name())
+		if removeErr != nil {
+			ctx.logger.WithFields(logrus.Fields{
+				"Path":  tmpFile.Name(),
+				"Error": removeErr,
+			}).Warn("Failed to delete partial code archive")
+		}
+		return "", archiveErr
+	}
+	return tmpFile.Name(), nil
+}
+
+// Given the zipped binary in packagePath, upload the primary code bundle
+// and optional S3 site resources iff they're defined.
+func createUploadStep(packagePath string) workflowStep {
+	return func(ctx *workflowContext) (workflowStep, error) {
+		defer recordDuration(time.Now(), "Uploading code", ctx)
+
+		ctx.context.workflowHooksContext[WorkflowContextKeyCodeArchivePath] = packagePath
+		stepErr := callWorkflowSteps(WorkflowStageUpload, ctx)
+		if stepErr != nil {
+			return nil, stepErr
+		}
+
+		var uploadTasks []*workTask
+		if len(ctx.userdata.lambdaAWSInfos) != 0 {
+			// We always upload the primary binary...
+			uploadBinaryTask := func() workResult {
+				if packagePath == "" {
+					zipS3URL, zipS3URLErr := uploadStreamedCodeArchive(ctx)
+					if nil != zipS3URLErr {
+						return newTaskResult(nil, zipS3URLErr)
+					}
+					ctx.context.s3CodeZipURL = newS3UploadURL(zipS3URL)
+					return newTaskResult(ctx.context.s3CodeZipURL, nil)
+				}
+				logFilesize("Lambda code archive size", packagePath, ctx.logger)
+
+				// Create the S3 key...
+				// Artifacts are deployed later, so the key must be
+				// known before the upload
+				codeS3Key := ""
+				if ctx.buildingArtifacts() ||
+					(ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.SkipUnchangedCode) {
+					existingURL, contentKey, existingErr := existingCodeArchive(packagePath, ctx)
+					if nil != existingErr {
+						return newTaskResult(nil, existingErr)
+					}
+					if existingURL != "" {
+						ctx.registerFileCleanupFinalizer(packagePath)
+						ctx.context.s3CodeZipURL = newS3UploadURL(existingURL)
+						return newTaskResult(ctx.context.s3CodeZipURL, nil)
+					}
+					codeS3Key = contentKey
+				}
+				zipS3URL, zipS3URLErr := uploadLocalFileToS3WithMetadata(packagePath,
+					codeS3Key,
+					codeArchiveMetadata(ctx),
+					ctx)
+				if nil != zipS3URLErr {
+					return newTaskResult(nil, zipS3URLErr)
+				}
+				ctx.context.s3CodeZipURL = newS3UploadURL(zipS3URL)
+				if ctx.buildingArtifacts() {
+					artifact, artifactErr := moveArtifact(packagePath,
+						ctx.context.s3CodeZipURL.keyName(),
+						ctx)
+					if nil != artifactErr {
+						return newTaskResult(nil, artifactErr)
+					}
+					ctx.context.artifactManifest.CodeArchive = artifact
+				}
+				return newTaskResult(ctx.context.s3CodeZipURL, nil)
+			}
+			uploadTasks = append(uploadTasks, newWorkTask(uploadBinaryTask))
+		} else {
+			ctx.logger.Info("Bypassing S3 upload as no Lambda functions were provided")
+		}
+
+		// We might need to upload some other things...
+		if nil != ctx.userdata.s3SiteContext.s3Site {
 			uploadSiteTask := func() workResult {
-				tempName := fmt.Sprintf("%s-S3Site.zip", ctx.userdata.serviceName)
+				tempName := siteArchiveName(ctx.userdata.serviceName)
 				tmpFile, err := system.TemporaryFile(ScratchDirectory, tempName)
 				if err != nil {
 					return newTaskResult(nil,
@@ -957,7 +2277,16 @@ func createUploadStep(packagePath string) workflowStep {
 					"SourcePath": absResourcePath,
 				}).Info("Creating S3Site archive")
 
-				err = spartaZip.AddToZip(zipArchive, absResourcePath, absResourcePath, ctx.logger)
+				var siteAnnotator spartaZip.FileHeaderAnnotator
+				if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.ReproducibleArchives {
+					siteAnnotator = spartaZip.ReproducibleAnnotator(nil)
+				}
+				err = spartaZip.AnnotateAddToZipWithExcludes(zipArchive,
+					absResourcePath,
+					absResourcePath,
+					siteAnnotator,
+					ctx.userdata.s3SiteContext.s3Site.Excludes,
+					ctx.logger)
 				if nil != err {
 					return newTaskResult(nil, err)
 				}
@@ -965,324 +2294,1249 @@ func createUploadStep(packagePath string) workflowStep {
 				if errClose != nil {
 					return newTaskResult(nil, errClose)
 				}
+				errClose = tmpFile.Close()
+				if errClose != nil {
+					return newTaskResult(nil, errClose)
+				}
+
+				// Upload it & save the key
+				siteS3Key := ""
+				if ctx.buildingArtifacts() {
+					siteDigest, siteDigestErr := codeArchiveDigest(tmpFile.Name())
+					if nil != siteDigestErr {
+						return newTaskResult(nil, siteDigestErr)
+					}
+					siteS3Key = fmt.Sprintf("%s/%s-site-%s.zip",
+						ctx.userdata.serviceName,
+						sanitizedName(ctx.userdata.serviceName),
+						siteDigest)
+				}
+				s3SiteLambdaZipURL, s3SiteLambdaZipURLErr := uploadLocalFileToS3(tmpFile.Name(), siteS3Key, ctx)
+				if s3SiteLambdaZipURLErr != nil {
+					return newTaskResult(nil,
+						errors.Wrapf(s3SiteLambdaZipURLErr, "Failed to upload local file to S3"))
+				}
+				ctx.userdata.s3SiteContext.s3UploadURL = newS3UploadURL(s3SiteLambdaZipURL)
+				if ctx.buildingArtifacts() {
+					artifact, artifactErr := moveArtifact(tmpFile.Name(),
+						siteS3Key,
+						ctx)
+					if nil != artifactErr {
+						return newTaskResult(nil, artifactErr)
+					}
+					ctx.context.artifactManifest.SiteArchive = artifact
+				}
+				return newTaskResult(ctx.userdata.s3SiteContext.s3UploadURL, nil)
+			}
+			uploadTasks = append(uploadTasks, newWorkTask(uploadSiteTask))
+
+		}
+
+		// Run it and figure out what happened
+		p := newWorkerPool(uploadTasks, len(uploadTasks))
+		_, uploadErrors := p.Run()
+
+		if len(uploadErrors) > 0 {
+			return nil, errors.Errorf("Encountered multiple errors during upload: %#v", uploadErrors)
+		}
+		return validateSpartaPostconditions(), nil
+	}
+}
+
+// maximumStackOperationTimeout returns the timeout
+// value to use for a stack operation based on the type
+// of resources that it provisions. In general the timeout
+// is short with an exception made for CloudFront
+// distributions
+func maximumStackOperationTimeout(template *gocf.Template, logger *logrus.Logger) time.Duration {
+	stackOperationTimeout := 20 * time.Minute
+	// If there is a CloudFront distributon in there then
+	// let's give that a bit more time to settle down...In general
+	// the initial CloudFront distribution takes ~30 minutes
+	for _, eachResource := range template.Resources {
+		if eachResource.Properties.CfnResourceType() == "AWS::CloudFront::Distribution" {
+			stackOperationTimeout = 60 * time.Minute
+			break
+		}
+	}
+	logger.WithField("OperationTimeout", stackOperationTimeout).Debug("Computed operation timeout value")
+	return stackOperationTimeout
+}
+
+// createCodePipelineTriggerPackage handles marshaling the template, zipping
+// the config files in the package, and the
+func createCodePipelineTriggerPackage(cfTemplateJSON []byte, ctx *workflowContext) (string, error) {
+	tmpFile, err := system.TemporaryFile(ScratchDirectory, ctx.userdata.codePipelineTrigger)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to create temporary file for CodePipeline")
+	}
+
+	ctx.logger.WithFields(logrus.Fields{
+		"PipelineName": tmpFile.Name(),
+	}).Info("Creating pipeline archive")
+
+	templateArchive := zip.NewWriter(tmpFile)
+	ctx.logger.WithFields(logrus.Fields{
+		"Path": tmpFile.Name(),
+	}).Info("Creating CodePipeline archive")
+
+	// File info for the binary executable
+	zipEntryName := "cloudformation.json"
+	bytesWriter, bytesWriterErr := templateArchive.Create(zipEntryName)
+	if bytesWriterErr != nil {
+		return "", bytesWriterErr
+	}
+
+	bytesReader := bytes.NewReader(cfTemplateJSON)
+	written, writtenErr := io.Copy(bytesWriter, bytesReader)
+	if nil != writtenErr {
+		return "", writtenErr
+	}
+	ctx.logger.WithFields(logrus.Fields{
+		"WrittenBytes": written,
+		"ZipName":      zipEntryName,
+	}).Debug("Archiving file")
+
+	// If there is a codePipelineEnvironments defined, then we'll need to get all the
+	// maps, marshal them to JSON, then add the JSON to the ZIP archive.
+	if nil != codePipelineEnvironments {
+		for eachEnvironment, eachMap := range codePipelineEnvironments {
+			codePipelineParameters := map[string]interface{}{
+				"Parameters": eachMap,
+			}
+			environmentJSON, environmentJSONErr := json.Marshal(codePipelineParameters)
+			if nil != environmentJSONErr {
+				ctx.logger.Error("Failed to Marshal CodePipeline environment: " + eachEnvironment)
+				return "", environmentJSONErr
+			}
+			var envVarName = fmt.Sprintf("%s.json", eachEnvironment)
+
+			// File info for the binary executable
+			binaryWriter, binaryWriterErr := templateArchive.Create(envVarName)
+			if binaryWriterErr != nil {
+				return "", binaryWriterErr
+			}
+			_, writeErr := binaryWriter.Write(environmentJSON)
+			if writeErr != nil {
+				return "", writeErr
+			}
+		}
+	}
+	archiveCloseErr := templateArchive.Close()
+	if nil != archiveCloseErr {
+		return "", archiveCloseErr
+	}
+	tempfileCloseErr := tmpFile.Close()
+	if nil != tempfileCloseErr {
+		return "", tempfileCloseErr
+	}
+	// Leave it here...
+	ctx.logger.WithFields(logrus.Fields{
+		"File": filepath.Base(tmpFile.Name()),
+	}).Info("Created CodePipeline archive")
+	// The key is the name + the pipeline name
+	return tmpFile.Name(), nil
+}
+
+// inPlaceResourceSetChanges returns the logical resource names that are
+// only defined by either the template or the provisioned stack
+func inPlaceResourceSetChanges(template *gocf.Template,
+	stackResources []*cloudformation.StackResource) []string {
+	provisioned := make(map[string]bool, len(stackResources))
+	for _, eachResource := range stackResources {
+		provisioned[aws.StringValue(eachResource.LogicalResourceId)] = true
+	}
+	changes := []string{}
+	for eachName := range template.Resources {
+		if !provisioned[eachName] {
+			changes = append(changes, fmt.Sprintf("Add %s", eachName))
+		}
+		delete(provisioned, eachName)
+	}
+	for eachName := range provisioned {
+		changes = append(changes, fmt.Sprintf("Remove %s", eachName))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// inPlaceUpdateCodeRequests returns the UpdateFunctionCode requests for
+// the Lambda function changes, together with the descriptions of any
+// changes that can't be applied in-place. The function physical IDs
+// are resolved from the provisioned stack resources.
+func inPlaceUpdateCodeRequests(changes []*cloudformation.Change,
+	stackResources []*cloudformation.StackResource,
+	s3Bucket string,
+	codeZipURL *s3UploadURL) ([]*lambda.UpdateFunctionCodeInput, []string) {
+
+	physicalIDs := make(map[string]*string, len(stackResources))
+	for _, eachResource := range stackResources {
+		physicalIDs[aws.StringValue(eachResource.LogicalResourceId)] = eachResource.PhysicalResourceId
+	}
+	updateCodeRequests := []*lambda.UpdateFunctionCodeInput{}
+	invalidInPlaceRequests := []string{}
+	for _, eachChange := range changes {
+		resourceChange := eachChange.ResourceChange
+		if resourceChange == nil {
+			continue
+		}
+		logicalID := aws.StringValue(resourceChange.LogicalResourceId)
+		physicalID, physicalIDExists := physicalIDs[logicalID]
+		if aws.StringValue(resourceChange.Action) == cloudformation.ChangeActionModify &&
+			aws.StringValue(resourceChange.ResourceType) == "AWS::Lambda::Function" &&
+			physicalIDExists {
+			updateCodeRequest := &lambda.UpdateFunctionCodeInput{
+				FunctionName: physicalID,
+				S3Bucket:     aws.String(s3Bucket),
+				S3Key:        aws.String(codeZipURL.keyName()),
+			}
+			if codeZipURL.version != "" {
+				updateCodeRequest.S3ObjectVersion = aws.String(codeZipURL.version)
+			}
+			updateCodeRequests = append(updateCodeRequests, updateCodeRequest)
+		} else {
+			invalidInPlaceRequests = append(invalidInPlaceRequests,
+				fmt.Sprintf("%s for %s (ResourceType: %s)",
+					aws.StringValue(resourceChange.Action),
+					logicalID,
+					aws.StringValue(resourceChange.ResourceType)))
+		}
+	}
+	return updateCodeRequests, invalidInPlaceRequests
+}
+
+// If the only detected changes to a stack are Lambda code updates,
+// then use the Lambda API to update the code directly rather than
+// waiting for CloudFormation. The boolean result is false if the
+// stack doesn't exist or the template changes can't be applied
+// in-place, in which case the caller should perform a regular
+// CloudFormation operation.
+func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*cloudformation.Stack, bool, error) {
+	awsCloudFormation := cloudformation.New(ctx.context.awsSession)
+
+	// The stack must exist and define the same set of resources
+	stackExists, stackExistsErr := spartaCF.StackExists(ctx.userdata.serviceName,
+		ctx.context.awsSession,
+		ctx.logger)
+	if nil != stackExistsErr {
+		return nil, false, stackExistsErr
+	}
+	if !stackExists {
+		ctx.logger.WithFields(logrus.Fields{
+			"StackName": ctx.userdata.serviceName,
+		}).Info("Stack doesn't exist. Provisioning with CloudFormation rather than in-place updates")
+		return nil, false, nil
+	}
+	if ctx.context.s3CodeZipURL == nil {
+		ctx.logger.Info("Code archive wasn't uploaded. Updating with CloudFormation rather than in-place updates")
+		return nil, false, nil
+	}
+	describeResourcesOutput, describeResourcesErr := awsCloudFormation.DescribeStackResources(&cloudformation.DescribeStackResourcesInput{
+		StackName: aws.String(ctx.userdata.serviceName),
+	})
+	if nil != describeResourcesErr {
+		return nil, false, describeResourcesErr
+	}
+	stackResources := describeResourcesOutput.StackResources
+	resourceSetChanges := inPlaceResourceSetChanges(ctx.context.cfTemplate, stackResources)
+	if len(resourceSetChanges) != 0 {
+		ctx.logger.WithFields(logrus.Fields{
+			"Changes": resourceSetChanges,
+		}).Info("Stack resources changed. Updating with CloudFormation rather than in-place updates")
+		return nil, false, nil
+	}
+
+	// Get the updates...
+	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sInPlaceChangeSet", ctx.userdata.serviceName))
+	changes, changesErr := spartaCF.CreateStackChangeSet(changeSetRequestName,
+		ctx.userdata.serviceName,
+		ctx.context.cfTemplate,
+		templateURL,
+		nil,
+		awsCloudFormation,
+		ctx.logger)
+	if nil != changesErr {
+		return nil, false, changesErr
+	}
+	// CreateStackChangeSet deletes the change set if there aren't
+	// any changes
+	var updateCodeRequests []*lambda.UpdateFunctionCodeInput
+	if nil != changes {
+		invalidInPlaceRequests := []string{}
+		updateCodeRequests, invalidInPlaceRequests = inPlaceUpdateCodeRequests(changes.Changes,
+			stackResources,
+			ctx.userdata.s3Bucket,
+			ctx.context.s3CodeZipURL)
+		if len(invalidInPlaceRequests) != 0 {
+			ctx.logger.WithFields(logrus.Fields{
+				"Changes": invalidInPlaceRequests,
+			}).Info("Unsupported in-place operations detected. Updating with CloudFormation rather than in-place updates")
+			_, deleteChangeSetErr := spartaCF.DeleteChangeSet(ctx.userdata.serviceName,
+				changeSetRequestName,
+				awsCloudFormation)
+			if nil != deleteChangeSetErr {
+				return nil, false, deleteChangeSetErr
+			}
+			return nil, false, nil
+		}
+	}
+
+	ctx.logger.WithFields(logrus.Fields{
+		"FunctionCount": len(updateCodeRequests),
+	}).Info("Updating Lambda function code")
+	ctx.logger.WithFields(logrus.Fields{
+		"Updates": updateCodeRequests,
+	}).Debug("Update requests")
+
+	updateTaskMaker := func(lambdaSvc *lambda.Lambda, request *lambda.UpdateFunctionCodeInput) taskFunc {
+		return func() workResult {
+			_, updateResultErr := lambdaSvc.UpdateFunctionCode(request)
+			return newTaskResult("", updateResultErr)
+		}
+	}
+	inPlaceUpdateTasks := make([]*workTask,
+		len(updateCodeRequests))
+	awsLambda := lambda.New(ctx.context.awsSession)
+	for eachIndex, eachUpdateCodeRequest := range updateCodeRequests {
+		updateTask := updateTaskMaker(awsLambda, eachUpdateCodeRequest)
+		inPlaceUpdateTasks[eachIndex] = newWorkTask(updateTask)
+	}
+
+	// Add the request to delete the change set...
+	// TODO: add some retry logic in here to handle failures.
+	if nil != changes {
+		deleteChangeSetTask := func() workResult {
+			_, deleteChangeSetResultErr := spartaCF.DeleteChangeSet(ctx.userdata.serviceName,
+				changeSetRequestName,
+				awsCloudFormation)
+			return newTaskResult("", deleteChangeSetResultErr)
+		}
+		inPlaceUpdateTasks = append(inPlaceUpdateTasks, newWorkTask(deleteChangeSetTask))
+	}
+	if len(inPlaceUpdateTasks) != 0 {
+		p := newWorkerPool(inPlaceUpdateTasks, len(inPlaceUpdateTasks))
+		_, asyncErrors := p.Run()
+		if len(asyncErrors) != 0 {
+			return nil, false, fmt.Errorf("failed to update function code: %v", asyncErrors)
+		}
+	}
+	// Describe the stack so that we can satisfy the contract with the
+	// normal path using CloudFormation
+	describeStacksInput := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(ctx.userdata.serviceName),
+	}
+	describeStackOutput, describeStackOutputErr := awsCloudFormation.DescribeStacks(describeStacksInput)
+	if nil != describeStackOutputErr {
+		return nil, false, describeStackOutputErr
+	}
+	return describeStackOutput.Stacks[0], true, nil
+}
+
+// applyCloudFormationOperation is responsible for taking the current template
+// and applying that operation to the stack. It's where the in-place
+// branch is applied, because at this point all the template
+// mutations have been accumulated
+// cancelStackUpdateAPI is the CloudFormation API used to cancel an
+// in progress stack update
+type cancelStackUpdateAPI interface {
+	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+	CancelUpdateStack(*cloudformation.CancelUpdateStackInput) (*cloudformation.CancelUpdateStackOutput, error)
+}
+
+// cancelStackUpdate cancels the stackName update if it's in progress, s.t.
+// CloudFormation rolls back to the previous stack state. It's called if the
+// caller context is cancelled while waiting for the stack operation, which
+// otherwise only stops the waiter. Stack creation can't be cancelled and
+// continues.
+func cancelStackUpdate(cfSvc cancelStackUpdateAPI,
+	stackName string,
+	logger *logrus.Logger) error {
+	describeOutput, describeErr := cfSvc.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if describeErr != nil {
+		return errors.Wrapf(describeErr, "Failed to describe stack %s", stackName)
+	}
+	if len(describeOutput.Stacks) == 0 {
+		return nil
+	}
+	stackStatus := aws.StringValue(describeOutput.Stacks[0].StackStatus)
+	switch stackStatus {
+	case cloudformation.StackStatusUpdateInProgress:
+		_, cancelErr := cfSvc.CancelUpdateStack(&cloudformation.CancelUpdateStackInput{
+			StackName: aws.String(stackName),
+		})
+		if cancelErr != nil {
+			return errors.Wrapf(cancelErr, "Failed to cancel stack %s update", stackName)
+		}
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+		}).Warn("Cancelled stack update. CloudFormation will roll back the stack")
+	case cloudformation.StackStatusCreateInProgress:
+		logger.WithFields(logrus.Fields{
+			"StackName": stackName,
+		}).Warn("Stack creation can't be cancelled and will continue")
+	}
+	return nil
+}
+
+func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
+	// Generate the CF template...
+	format, formatErr := templateFormat(ctx.userdata.workflowHooks)
+	if formatErr != nil {
+		return nil, formatErr
+	}
+	cfTemplate, err := json.Marshal(ctx.context.cfTemplate)
+	if err != nil {
+		ctx.logger.Error("Failed to Marshal CloudFormation template: ", err.Error())
+		return nil, err
+	}
+	var nestedTemplates []*nestedStackTemplate
+	if nestedStacksEnabled(ctx.userdata.workflowHooks) {
+		cfTemplate, nestedTemplates, err = splitNestedStacks(cfTemplate,
+			format,
+			ctx.userdata.serviceName,
+			ctx.userdata.s3Bucket,
+			ctx.logger)
+		if err != nil {
+			return nil, err
+		}
+		if len(nestedTemplates) != 0 && ctx.userdata.inPlace {
+			return nil, errors.Errorf("In-place updates aren't supported for templates split into %d nested stacks",
+				len(nestedTemplates))
+		}
+	}
+	if format == TemplateFormatYAML {
+		cfTemplate, err = yamlTemplate(cfTemplate)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-				// Upload it & save the key
-				s3SiteLambdaZipURL, s3SiteLambdaZipURLErr := uploadLocalFileToS3(tmpFile.Name(), "", ctx)
-				if s3SiteLambdaZipURLErr != nil {
-					return newTaskResult(nil,
-						errors.Wrapf(s3SiteLambdaZipURLErr, "Failed to upload local file to S3"))
-				}
-				ctx.userdata.s3SiteContext.s3UploadURL = newS3UploadURL(s3SiteLambdaZipURL)
-				return newTaskResult(ctx.userdata.s3SiteContext.s3UploadURL, nil)
+	// Consistent naming of template
+	templateName := templateArtifactName(ctx.userdata.serviceName, format)
+	templateFile, templateFileErr := system.TemporaryFile(ScratchDirectory, templateName)
+	if nil != templateFileErr {
+		return nil, templateFileErr
+	}
+	_, writeErr := templateFile.Write(cfTemplate)
+	if nil != writeErr {
+		return nil, writeErr
+	}
+	errClose := templateFile.Close()
+	if errClose != nil {
+		return nil, errClose
+	}
+	// Log the template if needed
+	if nil != ctx.context.templateWriter || ctx.logger.Level <= logrus.DebugLevel {
+		templateBody := string(cfTemplate)
+		formatted := []byte(templateBody)
+		if format == TemplateFormatJSON {
+			var formattedErr error
+			formatted, formattedErr = json.MarshalIndent(templateBody, "", " ")
+			if nil != formattedErr {
+				return nil, formattedErr
+			}
+		}
+		ctx.logger.WithFields(logrus.Fields{
+			"Body": string(formatted),
+		}).Debug("CloudFormation template body")
+		if nil != ctx.context.templateWriter {
+			_, writeErr := io.WriteString(ctx.context.templateWriter,
+				string(formatted))
+			if writeErr != nil {
+				return nil, errors.Wrapf(writeErr, "Failed to write template")
 			}
-			uploadTasks = append(uploadTasks, newWorkTask(uploadSiteTask))
-
 		}
+	}
 
-		// Run it and figure out what happened
-		p := newWorkerPool(uploadTasks, len(uploadTasks))
-		_, uploadErrors := p.Run()
+	// The nested stacks must be available before the parent
+	// template is provisioned
+	nestedErr := publishNestedStackTemplates(nestedTemplates, ctx)
+	if nestedErr != nil {
+		return nil, nestedErr
+	}
 
-		if len(uploadErrors) > 0 {
-			return nil, errors.Errorf("Encountered multiple errors during upload: %#v", uploadErrors)
+	// If this isn't a codePipelineTrigger, then do that
+	if ctx.buildingArtifacts() {
+		manifestErr := writeArtifactManifest(templateFile.Name(), ctx)
+		if nil != manifestErr {
+			return nil, manifestErr
+		}
+		// Provision deploys the artifacts it builds
+		if !ctx.userdata.noop {
+			deployErr := deployArtifactManifest(artifactManifestPath(ctx.userdata.artifactsDirectory,
+				ctx.userdata.serviceName),
+				nil,
+				false,
+				ctx)
+			if nil != deployErr {
+				return nil, deployErr
+			}
+		}
+	} else if ctx.userdata.codePipelineTrigger == "" {
+		if ctx.userdata.noop {
+			ctx.logger.WithFields(logrus.Fields{
+				"Bucket":       ctx.userdata.s3Bucket,
+				"TemplateName": templateName,
+			}).Info(noopMessage("Stack creation"))
+		} else {
+			provisionErr := provisionStackTemplate(templateFile.Name(), ctx)
+			if nil != provisionErr {
+				return nil, provisionErr
+			}
+		}
+	} else {
+		ctx.logger.Info("Creating pipeline package")
+
+		ctx.registerFileCleanupFinalizer(templateFile.Name())
+		_, urlErr := createCodePipelineTriggerPackage(cfTemplate, ctx)
+		if nil != urlErr {
+			return nil, urlErr
 		}
-		return validateSpartaPostconditions(), nil
 	}
+	return nil, nil
 }
 
-// maximumStackOperationTimeout returns the timeout
-// value to use for a stack operation based on the type
-// of resources that it provisions. In general the timeout
-// is short with an exception made for CloudFront
-// distributions
-func maximumStackOperationTimeout(template *gocf.Template, logger *logrus.Logger) time.Duration {
-	stackOperationTimeout := 20 * time.Minute
-	// If there is a CloudFront distributon in there then
-	// let's give that a bit more time to settle down...In general
-	// the initial CloudFront distribution takes ~30 minutes
-	for _, eachResource := range template.Resources {
-		if eachResource.Properties.CfnResourceType() == "AWS::CloudFront::Distribution" {
-			stackOperationTimeout = 60 * time.Minute
-			break
+// stackOperationSettings returns the capabilities and operation timeout
+// for the stack operation. Deployed artifacts use the values that were
+// recorded in the manifest when the template was built.
+func stackOperationSettings(ctx *workflowContext) ([]*string, time.Duration) {
+	if ctx.context.deployingArtifacts {
+		operationTimeout := time.Duration(ctx.context.artifactManifest.OperationTimeoutMinutes) * time.Minute
+		if operationTimeout <= 0 {
+			operationTimeout = maximumStackOperationTimeout(gocf.NewTemplate(), ctx.logger)
 		}
+		return aws.StringSlice(ctx.context.artifactManifest.Capabilities), operationTimeout
 	}
-	logger.WithField("OperationTimeout", stackOperationTimeout).Debug("Computed operation timeout value")
-	return stackOperationTimeout
+	return spartaCF.StackCapabilities(ctx.context.cfTemplate),
+		maximumStackOperationTimeout(ctx.context.cfTemplate, ctx.logger)
 }
 
-// createCodePipelineTriggerPackage handles marshaling the template, zipping
-// the config files in the package, and the
-func createCodePipelineTriggerPackage(cfTemplateJSON []byte, ctx *workflowContext) (string, error) {
-	tmpFile, err := system.TemporaryFile(ScratchDirectory, ctx.userdata.codePipelineTrigger)
-	if err != nil {
-		return "", errors.Wrapf(err, "Failed to create temporary file for CodePipeline")
+// provisionStackTemplate uploads the template at templatePath and creates
+// or updates the service's stack. It's shared by Provision and
+// DeployArtifacts. The stack capabilities and operation timeout are
+// the stackOperationSettings values.
+func provisionStackTemplate(templatePath string, ctx *workflowContext) error {
+	spartaTags := map[string]string{
+		SpartaTagBuildIDKey: ctx.userdata.buildID,
 	}
+	if len(ctx.userdata.buildTags) != 0 {
+		spartaTags[SpartaTagBuildTagsKey] = ctx.userdata.buildTags
+	}
+	var userTags map[string]string
+	if ctx.userdata.workflowHooks != nil {
+		userTags = ctx.userdata.workflowHooks.StackTags
+	}
+	stackTags := mergeStackTags(spartaTags, userTags, ctx.logger)
 
-	ctx.logger.WithFields(logrus.Fields{
-		"PipelineName": tmpFile.Name(),
-	}).Info("Creating pipeline archive")
-
-	templateArchive := zip.NewWriter(tmpFile)
-	ctx.logger.WithFields(logrus.Fields{
-		"Path": tmpFile.Name(),
-	}).Info("Creating CodePipeline archive")
+	uploadURL, uploadURLErr := uploadLocalFileToS3(templatePath, "", ctx)
+	if nil != uploadURLErr {
+		return uploadURLErr
+	}
+	if ctx.userdata.changeSetReport != nil {
+		return createChangeSet(ctx, uploadURL, stackTags)
+	}
 
-	// File info for the binary executable
-	zipEntryName := "cloudformation.json"
-	bytesWriter, bytesWriterErr := templateArchive.Create(zipEntryName)
-	if bytesWriterErr != nil {
-		return "", bytesWriterErr
+	// If the post provision tests may need to rollback, save the
+	// template that's currently deployed
+	var postProvisionTests []PostProvisionTest
+	rollbackTests := false
+	if ctx.userdata.workflowHooks != nil {
+		postProvisionTests = ctx.userdata.workflowHooks.PostProvisionTests
+		rollbackTests = ctx.userdata.workflowHooks.RollbackOnPostProvisionTestFailure
+	}
+	var previousState *postProvisionRollbackState
+	if len(postProvisionTests) != 0 && rollbackTests && !ctx.userdata.inPlace {
+		stackState, stackStateErr := previousStackState(ctx)
+		if stackStateErr != nil {
+			return stackStateErr
+		}
+		previousState = stackState
 	}
 
-	bytesReader := bytes.NewReader(cfTemplateJSON)
-	written, writtenErr := io.Copy(bytesWriter, bytesReader)
-	if nil != writtenErr {
-		return "", writtenErr
+	// If we're supposed to be inplace, then go ahead and try that
+	var stack *cloudformation.Stack
+	var stackErr error
+	inPlaceUpdated := false
+	if ctx.userdata.inPlace {
+		stack, inPlaceUpdated, stackErr = applyInPlaceFunctionUpdates(ctx, uploadURL)
+	}
+	if nil == stackErr && !inPlaceUpdated {
+		capabilities, operationTimeout := stackOperationSettings(ctx)
+		var waitOptions *spartaCF.WaitOptions
+		if ctx.userdata.workflowHooks != nil {
+			waitOptions = ctx.userdata.workflowHooks.StackWaitOptions
+		}
+		// Regular update, go ahead with the CloudFormation changes
+		stack, stackErr = spartaCF.ConvergeStackStateWithCapabilities(ctx.userdata.serviceName,
+			capabilities,
+			uploadURL,
+			stackTags,
+			ctx.transaction.startTime,
+			operationTimeout,
+			ctx.context.awsSession,
+			"▬",
+			dividerLength,
+			waitOptions,
+			ctx.logger)
+		if nil != stackErr && ctx.callerContext.Err() != nil {
+			cancelErr := cancelStackUpdate(cloudformation.New(ctx.context.rollbackSession),
+				ctx.userdata.serviceName,
+				ctx.logger)
+			if cancelErr != nil {
+				ctx.logger.WithFields(logrus.Fields{
+					"Error": cancelErr,
+				}).Warn("Failed to cancel stack update")
+			}
+		}
+	}
+	if nil != stackErr {
+		return stackErr
 	}
 	ctx.logger.WithFields(logrus.Fields{
-		"WrittenBytes": written,
-		"ZipName":      zipEntryName,
-	}).Debug("Archiving file")
+		"StackName":    *stack.StackName,
+		"StackId":      *stack.StackId,
+		"CreationTime": *stack.CreationTime,
+	}).Info("Stack provisioned")
 
-	// If there is a codePipelineEnvironments defined, then we'll need to get all the
-	// maps, marshal them to JSON, then add the JSON to the ZIP archive.
-	if nil != codePipelineEnvironments {
-		for eachEnvironment, eachMap := range codePipelineEnvironments {
-			codePipelineParameters := map[string]interface{}{
-				"Parameters": eachMap,
-			}
-			environmentJSON, environmentJSONErr := json.Marshal(codePipelineParameters)
-			if nil != environmentJSONErr {
-				ctx.logger.Error("Failed to Marshal CodePipeline environment: " + eachEnvironment)
-				return "", environmentJSONErr
+	if len(postProvisionTests) != 0 {
+		testErr := runPostProvisionTests(ctx.callerContext,
+			postProvisionTests,
+			stackOutputValues(stack),
+			ctx.logger)
+		if testErr != nil {
+			if !rollbackTests {
+				ctx.logger.WithFields(logrus.Fields{
+					"StackName": *stack.StackName,
+				}).Warn("Post provision test failed. Stack left in place.")
+				return testErr
 			}
-			var envVarName = fmt.Sprintf("%s.json", eachEnvironment)
-
-			// File info for the binary executable
-			binaryWriter, binaryWriterErr := templateArchive.Create(envVarName)
-			if binaryWriterErr != nil {
-				return "", binaryWriterErr
+			// In place updates bypass CloudFormation, so there's
+			// no template to restore
+			if ctx.userdata.inPlace {
+				ctx.logger.Warn("Post provision rollback isn't supported for in-place updates")
+				return testErr
 			}
-			_, writeErr := binaryWriter.Write(environmentJSON)
-			if writeErr != nil {
-				return "", writeErr
+			rollbackErr := rollbackPostProvision(ctx, stack, previousState)
+			if rollbackErr != nil {
+				return errors.Wrapf(rollbackErr,
+					"Failed to rollback after error: %s",
+					testErr)
 			}
+			return testErr
 		}
 	}
-	archiveCloseErr := templateArchive.Close()
-	if nil != archiveCloseErr {
-		return "", archiveCloseErr
+	if ctx.userdata.workflowHooks != nil &&
+		ctx.userdata.workflowHooks.StackPolicy != "" {
+		policyErr := applyStackPolicy(stack,
+			ctx.userdata.workflowHooks.StackPolicy,
+			ctx.context.awsSession,
+			ctx.logger)
+		if policyErr != nil {
+			return policyErr
+		}
 	}
-	tempfileCloseErr := tmpFile.Close()
-	if nil != tempfileCloseErr {
-		return "", tempfileCloseErr
+	if ctx.userdata.workflowHooks != nil &&
+		ctx.userdata.workflowHooks.TerminationProtection {
+		protectErr := enableTerminationProtection(stack,
+			ctx.context.awsSession,
+			ctx.logger)
+		if protectErr != nil {
+			return protectErr
+		}
 	}
-	// Leave it here...
-	ctx.logger.WithFields(logrus.Fields{
-		"File": filepath.Base(tmpFile.Name()),
-	}).Info("Created CodePipeline archive")
-	// The key is the name + the pipeline name
-	return tmpFile.Name(), nil
+	return nil
 }
 
-// If the only detected changes to a stack are Lambda code updates,
-// then update use the LAmbda API to update the code directly
-// rather than waiting for CloudFormation
-func applyInPlaceFunctionUpdates(ctx *workflowContext, templateURL string) (*cloudformation.Stack, error) {
-	// Get the updates...
-	awsCloudFormation := cloudformation.New(ctx.context.awsSession)
-	changeSetRequestName := CloudFormationResourceName(fmt.Sprintf("%sInPlaceChangeSet", ctx.userdata.serviceName))
-	changes, changesErr := spartaCF.CreateStackChangeSet(changeSetRequestName,
-		ctx.userdata.serviceName,
-		ctx.context.cfTemplate,
-		templateURL,
-		nil,
-		awsCloudFormation,
-		ctx.logger)
-	if nil != changesErr {
-		return nil, changesErr
+// validateResourceNameLengths ensures that the physical names derived from
+// the service and function names fit within the AWS length limits. The
+// function name is the stack name, a delimiter and the sanitized
+// function name.
+func validateResourceNameLengths(serviceName string, lambdaAWSInfos []*LambdaAWSInfo) error {
+	var errorText []string
+	if len(serviceName) > stackNameMaxLength {
+		errorText = append(errorText,
+			fmt.Sprintf("Service name %s (%d characters) exceeds the CloudFormation stack name limit of %d",
+				serviceName,
+				len(serviceName),
+				stackNameMaxLength))
+	}
+	validateFunctionName := func(userFunctionName string) {
+		physicalName := fmt.Sprintf("%s%s%s",
+			serviceName,
+			functionNameDelimiter,
+			awsLambdaInternalName(userFunctionName))
+		if len(physicalName) > lambdaFunctionNameMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda function name %s (%d characters) exceeds the limit of %d. Shorten the service name or the function name by %d characters",
+					physicalName,
+					len(physicalName),
+					lambdaFunctionNameMaxLength,
+					len(physicalName)-lambdaFunctionNameMaxLength))
+		}
+		logGroupName := fmt.Sprintf("/aws/lambda/%s", physicalName)
+		if len(logGroupName) > logGroupNameMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("Log group name %s exceeds the limit of %d",
+					logGroupName,
+					logGroupNameMaxLength))
+		}
 	}
-	if nil == changes || len(changes.Changes) <= 0 {
-		return nil, fmt.Errorf("no changes detected")
+	for _, eachLambda := range lambdaAWSInfos {
+		validateFunctionName(eachLambda.lambdaFunctionName())
+		for _, eachCustomResource := range eachLambda.customResources {
+			validateFunctionName(eachCustomResource.userFunctionName)
+		}
+		// Generated role names are truncated by CloudFormation, so
+		// only user supplied names need to be checked
+		if len(eachLambda.RoleName) > iamRoleNameMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("IAM role name %s (%d characters) exceeds the limit of %d",
+					eachLambda.RoleName,
+					len(eachLambda.RoleName),
+					iamRoleNameMaxLength))
+		}
 	}
-	updateCodeRequests := []*lambda.UpdateFunctionCodeInput{}
-	invalidInPlaceRequests := []string{}
-	for _, eachChange := range changes.Changes {
-		resourceChange := eachChange.ResourceChange
-		if *resourceChange.Action == "Modify" && *resourceChange.ResourceType == "AWS::Lambda::Function" {
-			updateCodeRequest := &lambda.UpdateFunctionCodeInput{
-				FunctionName: resourceChange.PhysicalResourceId,
-				S3Bucket:     aws.String(ctx.userdata.s3Bucket),
-				S3Key:        aws.String(ctx.context.s3CodeZipURL.keyName()),
+	if len(errorText) != 0 {
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// applyPermissionsBoundary sets the permissions boundary of every IAM role
+// in the template that doesn't already define one
+func applyPermissionsBoundary(template *gocf.Template,
+	permissionsBoundary gocf.Stringable,
+	logger *logrus.Logger) {
+	for eachName, eachResource := range template.Resources {
+		applied := false
+		switch typedRole := eachResource.Properties.(type) {
+		case gocf.IAMRole:
+			if typedRole.PermissionsBoundary == nil {
+				typedRole.PermissionsBoundary = permissionsBoundary.String()
+				eachResource.Properties = typedRole
+				applied = true
 			}
-			if ctx.context.s3CodeZipURL != nil && ctx.context.s3CodeZipURL.version != "" {
-				updateCodeRequest.S3ObjectVersion = aws.String(ctx.context.s3CodeZipURL.version)
+		case *gocf.IAMRole:
+			if typedRole.PermissionsBoundary == nil {
+				typedRole.PermissionsBoundary = permissionsBoundary.String()
+				applied = true
+			}
+		}
+		if applied {
+			logger.WithFields(logrus.Fields{
+				"Role": eachName,
+			}).Debug("Applied IAM permissions boundary")
+		}
+	}
+}
+
+// applyRetainPolicy sets the DeletionPolicy of the template resources
+// whose logical name or resource type is in the retainResources list
+// to Retain. Entries that don't match any resource are logged.
+func applyRetainPolicy(template *gocf.Template,
+	retainResources []string,
+	logger *logrus.Logger) {
+	matched := make(map[string]bool, len(retainResources))
+	for eachName, eachResource := range template.Resources {
+		for _, eachRetain := range retainResources {
+			if eachRetain != eachName &&
+				(eachResource.Properties == nil ||
+					eachRetain != eachResource.Properties.CfnResourceType()) {
+				continue
+			}
+			matched[eachRetain] = true
+			if eachResource.DeletionPolicy != "Retain" {
+				eachResource.DeletionPolicy = "Retain"
+				logger.WithFields(logrus.Fields{
+					"Resource": eachName,
+				}).Debug("Applied Retain DeletionPolicy")
 			}
-			updateCodeRequests = append(updateCodeRequests, updateCodeRequest)
-		} else {
-			invalidInPlaceRequests = append(invalidInPlaceRequests,
-				fmt.Sprintf("%s for %s (ResourceType: %s)",
-					*resourceChange.Action,
-					*resourceChange.LogicalResourceId,
-					*resourceChange.ResourceType))
 		}
 	}
-	if len(invalidInPlaceRequests) != 0 {
-		return nil, fmt.Errorf("unsupported in-place operations detected:\n\t%s", strings.Join(invalidInPlaceRequests, ",\n\t"))
+	for _, eachRetain := range retainResources {
+		if !matched[eachRetain] {
+			logger.WithFields(logrus.Fields{
+				"Entry": eachRetain,
+			}).Warn("RetainResources entry doesn't match a template resource name or type")
+		}
 	}
+}
 
-	ctx.logger.WithFields(logrus.Fields{
-		"FunctionCount": len(updateCodeRequests),
-	}).Info("Updating Lambda function code")
-	ctx.logger.WithFields(logrus.Fields{
-		"Updates": updateCodeRequests,
-	}).Debug("Update requests")
+// validateStackPolicy ensures that the stack policy is a JSON
+// document with at least one Statement
+func validateStackPolicy(stackPolicy string) error {
+	if stackPolicy == "" {
+		return nil
+	}
+	var policy struct {
+		Statement []map[string]interface{}
+	}
+	unmarshalErr := json.Unmarshal([]byte(stackPolicy), &policy)
+	if unmarshalErr != nil {
+		return errors.Wrapf(unmarshalErr, "Stack policy is not a valid JSON policy document")
+	}
+	if len(policy.Statement) == 0 {
+		return errors.New("Stack policy must define at least one Statement")
+	}
+	return nil
+}
 
-	updateTaskMaker := func(lambdaSvc *lambda.Lambda, request *lambda.UpdateFunctionCodeInput) taskFunc {
-		return func() workResult {
-			_, updateResultErr := lambdaSvc.UpdateFunctionCode(request)
-			return newTaskResult("", updateResultErr)
+// applyStackPolicy sets the stack policy of the provisioned stack
+func applyStackPolicy(stack *cloudformation.Stack,
+	stackPolicy string,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	cfSvc := cloudformation.New(awsSession)
+	_, setPolicyErr := cfSvc.SetStackPolicy(&cloudformation.SetStackPolicyInput{
+		StackName:       stack.StackId,
+		StackPolicyBody: aws.String(stackPolicy),
+	})
+	if setPolicyErr != nil {
+		return errors.Wrapf(setPolicyErr,
+			"Failed to set stack policy for stack %s",
+			aws.StringValue(stack.StackName))
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName": aws.StringValue(stack.StackName),
+	}).Info("Applied stack policy")
+	return nil
+}
+
+// enableTerminationProtection enables termination protection for the
+// provisioned stack, if it's not already enabled
+func enableTerminationProtection(stack *cloudformation.Stack,
+	awsSession *session.Session,
+	logger *logrus.Logger) error {
+	if aws.BoolValue(stack.EnableTerminationProtection) {
+		return nil
+	}
+	cfSvc := cloudformation.New(awsSession)
+	_, updateErr := cfSvc.UpdateTerminationProtection(&cloudformation.UpdateTerminationProtectionInput{
+		EnableTerminationProtection: aws.Bool(true),
+		StackName:                   stack.StackId,
+	})
+	if updateErr != nil {
+		return errors.Wrapf(updateErr,
+			"Failed to enable termination protection for stack %s",
+			aws.StringValue(stack.StackName))
+	}
+	logger.WithFields(logrus.Fields{
+		"StackName": aws.StringValue(stack.StackName),
+	}).Info("Enabled stack termination protection")
+	return nil
+}
+
+// isSpartaManagedStackTag returns true if the key is one of the
+// tags that Sparta applies to every stack
+func isSpartaManagedStackTag(key string) bool {
+	return key == SpartaTagBuildIDKey || key == SpartaTagBuildTagsKey
+}
+
+// mergeStackTags returns the user-defined stack tags merged with the
+// Sparta-managed tags. The Sparta-managed values take precedence, and
+// a warning is logged for each user-defined value that's replaced.
+func mergeStackTags(spartaTags map[string]string,
+	userTags map[string]string,
+	logger *logrus.Logger) map[string]string {
+	stackTags := make(map[string]string, len(spartaTags)+len(userTags))
+	for eachKey, eachValue := range userTags {
+		stackTags[eachKey] = eachValue
+	}
+	for eachKey, eachValue := range spartaTags {
+		if userValue, exists := stackTags[eachKey]; exists && userValue != eachValue {
+			logger.WithFields(logrus.Fields{
+				"Key":       eachKey,
+				"UserValue": userValue,
+				"Value":     eachValue,
+			}).Warn("Ignoring user-defined stack tag that collides with a Sparta-managed tag")
+		}
+		stackTags[eachKey] = eachValue
+	}
+	return stackTags
+}
+
+// validateStackTags ensures that the user-defined stack tags satisfy
+// the CloudFormation tag limits once merged with the Sparta-managed tags.
+// User values for the Sparta-managed tags are replaced when the tags
+// are merged.
+func validateStackTags(userTags map[string]string) error {
+	var errorText []string
+	// Account for the Sparta managed tags
+	userTagCount := 0
+	for eachKey := range userTags {
+		if !isSpartaManagedStackTag(eachKey) {
+			userTagCount++
+		}
+	}
+	if userTagCount+2 > stackTagsMaxCount {
+		errorText = append(errorText,
+			fmt.Sprintf("Too many stack tags (%d). A maximum of %d user-defined tags are supported",
+				userTagCount,
+				stackTagsMaxCount-2))
+	}
+	for eachKey, eachValue := range userTags {
+		if isSpartaManagedStackTag(eachKey) {
+			continue
+		}
+		if len(eachKey) <= 0 || len(eachKey) > stackTagKeyMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("Stack tag key (%s) must be between 1 and %d characters",
+					eachKey,
+					stackTagKeyMaxLength))
+		}
+		if len(eachValue) > stackTagValueMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("Stack tag value for key %s must be at most %d characters",
+					eachKey,
+					stackTagValueMaxLength))
+		}
+		lowerKey := strings.ToLower(eachKey)
+		if strings.HasPrefix(lowerKey, stackTagReservedPrefix) {
+			errorText = append(errorText,
+				fmt.Sprintf("Stack tag key (%s) must not use the reserved prefix: %s",
+					eachKey,
+					stackTagReservedPrefix))
+		}
+		if strings.HasPrefix(lowerKey, stackTagSpartaKeyPrefix) {
+			errorText = append(errorText,
+				fmt.Sprintf("Stack tag key (%s) must not use the Sparta prefix: %s",
+					eachKey,
+					stackTagSpartaKeyPrefix))
 		}
 	}
-	inPlaceUpdateTasks := make([]*workTask,
-		len(updateCodeRequests))
-	awsLambda := lambda.New(ctx.context.awsSession)
-	for eachIndex, eachUpdateCodeRequest := range updateCodeRequests {
-		updateTask := updateTaskMaker(awsLambda, eachUpdateCodeRequest)
-		inPlaceUpdateTasks[eachIndex] = newWorkTask(updateTask)
+	if len(errorText) != 0 {
+		return errors.New(strings.Join(errorText, "\n"))
 	}
+	return nil
+}
 
-	// Add the request to delete the change set...
-	// TODO: add some retry logic in here to handle failures.
-	deleteChangeSetTask := func() workResult {
-		_, deleteChangeSetResultErr := spartaCF.DeleteChangeSet(ctx.userdata.serviceName,
-			changeSetRequestName,
-			awsCloudFormation)
-		return newTaskResult("", deleteChangeSetResultErr)
-	}
-	inPlaceUpdateTasks = append(inPlaceUpdateTasks, newWorkTask(deleteChangeSetTask))
-	p := newWorkerPool(inPlaceUpdateTasks, len(inPlaceUpdateTasks))
-	_, asyncErrors := p.Run()
-	if len(asyncErrors) != 0 {
-		return nil, fmt.Errorf("failed to update function code: %v", asyncErrors)
+// validateFunctionTags ensures that the merged function tags satisfy
+// the AWS tag limits
+func validateFunctionTags(functionName string, tags map[string]string) error {
+	return validateResourceTags(fmt.Sprintf("Function %s", functionName), tags)
+}
+
+// validateAPIGatewayTags ensures that the merged API Gateway tags satisfy
+// the AWS tag limits
+func validateAPIGatewayTags(apiName string, tags map[string]string) error {
+	return validateResourceTags(fmt.Sprintf("API Gateway %s", apiName), tags)
+}
+
+// validateResourceTags ensures that the tags applied to the
+// resourceDescription resource satisfy the AWS tag limits
+func validateResourceTags(resourceDescription string, tags map[string]string) error {
+	var errorText []string
+	if len(tags) > stackTagsMaxCount {
+		errorText = append(errorText,
+			fmt.Sprintf("%s has too many tags (%d). A maximum of %d tags are supported",
+				resourceDescription,
+				len(tags),
+				stackTagsMaxCount))
 	}
-	// Describe the stack so that we can satisfy the contract with the
-	// normal path using CloudFormation
-	describeStacksInput := &cloudformation.DescribeStacksInput{
-		StackName: aws.String(ctx.userdata.serviceName),
+	for eachKey, eachValue := range tags {
+		if len(eachKey) <= 0 || len(eachKey) > stackTagKeyMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("%s tag key (%s) must be between 1 and %d characters",
+					resourceDescription,
+					eachKey,
+					stackTagKeyMaxLength))
+		}
+		if len(eachValue) > stackTagValueMaxLength {
+			errorText = append(errorText,
+				fmt.Sprintf("%s tag value for key %s must be at most %d characters",
+					resourceDescription,
+					eachKey,
+					stackTagValueMaxLength))
+		}
+		if strings.HasPrefix(strings.ToLower(eachKey), stackTagReservedPrefix) {
+			errorText = append(errorText,
+				fmt.Sprintf("%s tag key (%s) must not use the reserved prefix: %s",
+					resourceDescription,
+					eachKey,
+					stackTagReservedPrefix))
+		}
 	}
-	describeStackOutput, describeStackOutputErr := awsCloudFormation.DescribeStacks(describeStacksInput)
-	if nil != describeStackOutputErr {
-		return nil, describeStackOutputErr
+	if len(errorText) != 0 {
+		return errors.New(strings.Join(errorText, "\n"))
 	}
-	return describeStackOutput.Stacks[0], nil
+	return nil
 }
 
-// applyCloudFormationOperation is responsible for taking the current template
-// and applying that operation to the stack. It's where the in-place
-// branch is applied, because at this point all the template
-// mutations have been accumulated
-func applyCloudFormationOperation(ctx *workflowContext) (workflowStep, error) {
-	stackTags := map[string]string{
-		SpartaTagBuildIDKey: ctx.userdata.buildID,
-	}
-	if len(ctx.userdata.buildTags) != 0 {
-		stackTags[SpartaTagBuildTagsKey] = ctx.userdata.buildTags
+// validateOutputExports ensures that the Export names for the
+// template Outputs are unique. CloudFormation requires export names to be
+// unique across the account and region, so this is a necessary but
+// not sufficient check.
+func validateOutputExports(template *gocf.Template) error {
+	exportNames := make(map[string]string)
+	for eachOutputName, eachOutput := range template.Outputs {
+		if eachOutput == nil || eachOutput.Export == nil {
+			continue
+		}
+		exportName, exportNameErr := json.Marshal(eachOutput.Export.Name)
+		if exportNameErr != nil {
+			return errors.Wrapf(exportNameErr, "Failed to marshal export name for Output: %s", eachOutputName)
+		}
+		existingOutputName, exists := exportNames[string(exportName)]
+		if exists {
+			return errors.Errorf("Outputs %s and %s declare the same Export name: %s",
+				existingOutputName,
+				eachOutputName,
+				string(exportName))
+		}
+		exportNames[string(exportName)] = eachOutputName
 	}
+	return nil
+}
 
-	// Generate the CF template...
-	cfTemplate, err := json.Marshal(ctx.context.cfTemplate)
-	if err != nil {
-		ctx.logger.Error("Failed to Marshal CloudFormation template: ", err.Error())
-		return nil, err
+// validateTemplateLimits ensures that the template is within the
+// CloudFormation resource, output, parameter, and template body size
+// limits. A warning is logged for values that are near a limit. The
+// error for a template that exceeds a limit includes the resource
+// types with the most resources. The body size is that of the template
+// in the uploaded format.
+func validateTemplateLimits(template *gocf.Template, format string, logger *logrus.Logger) error {
+	templateJSON, templateJSONErr := json.Marshal(template)
+	if templateJSONErr != nil {
+		return errors.Wrapf(templateJSONErr, "Failed to marshal template")
 	}
+	return validateTemplateBodyLimits(templateJSON, format, logger)
+}
 
-	// Consistent naming of template
-	sanitizedServiceName := sanitizedName(ctx.userdata.serviceName)
-	templateName := fmt.Sprintf("%s-cftemplate.json", sanitizedServiceName)
-	templateFile, templateFileErr := system.TemporaryFile(ScratchDirectory, templateName)
-	if nil != templateFileErr {
-		return nil, templateFileErr
+// validateTemplateBodyLimits is the validateTemplateLimits check of the
+// marshaled JSON template that's uploaded in the given format
+func validateTemplateBodyLimits(templateJSON []byte, format string, logger *logrus.Logger) error {
+	templateBody := templateJSON
+	if format == TemplateFormatYAML {
+		yamlBody, yamlBodyErr := yamlTemplate(templateJSON)
+		if yamlBodyErr != nil {
+			return yamlBodyErr
+		}
+		templateBody = yamlBody
 	}
-	_, writeErr := templateFile.Write(cfTemplate)
-	if nil != writeErr {
-		return nil, writeErr
+	var template struct {
+		Resources map[string]struct {
+			Type string
+		}
+		Outputs    map[string]json.RawMessage
+		Parameters map[string]json.RawMessage
 	}
-	errClose := templateFile.Close()
-	if errClose != nil {
-		return nil, errClose
+	unmarshalErr := json.Unmarshal(templateJSON, &template)
+	if unmarshalErr != nil {
+		return errors.Wrapf(unmarshalErr, "Failed to parse template")
 	}
-	// Log the template if needed
-	if nil != ctx.context.templateWriter || ctx.logger.Level <= logrus.DebugLevel {
-		templateBody := string(cfTemplate)
-		formatted, formattedErr := json.MarshalIndent(templateBody, "", " ")
-		if nil != formattedErr {
-			return nil, formattedErr
+	limits := []struct {
+		name  string
+		count int
+		max   int
+	}{
+		{"Resources", len(template.Resources), templateResourcesMaxCount},
+		{"Outputs", len(template.Outputs), templateOutputsMaxCount},
+		{"Parameters", len(template.Parameters), templateParametersMaxCount},
+		{"Template body bytes", len(templateBody), templateBodyMaxSize},
+	}
+	var errorText []string
+	for _, eachLimit := range limits {
+		if eachLimit.count > eachLimit.max {
+			errorText = append(errorText,
+				fmt.Sprintf("%s: %d exceeds the CloudFormation limit of %d",
+					eachLimit.name,
+					eachLimit.count,
+					eachLimit.max))
+		} else if eachLimit.count*100 >= eachLimit.max*templateLimitWarningPercent {
+			logger.WithFields(logrus.Fields{
+				"Limit": eachLimit.name,
+				"Count": eachLimit.count,
+				"Max":   eachLimit.max,
+			}).Warn("Template is approaching a CloudFormation limit")
 		}
-		ctx.logger.WithFields(logrus.Fields{
-			"Body": string(formatted),
-		}).Debug("CloudFormation template body")
-		if nil != ctx.context.templateWriter {
-			_, writeErr := io.WriteString(ctx.context.templateWriter,
-				string(formatted))
-			if writeErr != nil {
-				return nil, errors.Wrapf(writeErr, "Failed to write template")
-			}
+	}
+	if len(errorText) == 0 {
+		return nil
+	}
+	typeCounts := make(map[string]int)
+	for _, eachResource := range template.Resources {
+		if eachResource.Type != "" {
+			typeCounts[eachResource.Type]++
+		}
+	}
+	resourceTypes := make([]string, 0, len(typeCounts))
+	for eachType := range typeCounts {
+		resourceTypes = append(resourceTypes, eachType)
+	}
+	sort.Slice(resourceTypes, func(i, j int) bool {
+		if typeCounts[resourceTypes[i]] != typeCounts[resourceTypes[j]] {
+			return typeCounts[resourceTypes[i]] > typeCounts[resourceTypes[j]]
 		}
+		return resourceTypes[i] < resourceTypes[j]
+	})
+	if len(resourceTypes) > templateLimitResourceTypeCount {
+		resourceTypes = resourceTypes[0:templateLimitResourceTypeCount]
 	}
+	for _, eachType := range resourceTypes {
+		errorText = append(errorText,
+			fmt.Sprintf("\t%s: %d resources", eachType, typeCounts[eachType]))
+	}
+	return errors.Errorf("Template exceeds the CloudFormation limits. Split the service into multiple stacks or enable WorkflowHooks.NestedStacks:\n%s",
+		strings.Join(errorText, "\n"))
+}
 
-	// If this isn't a codePipelineTrigger, then do that
-	if ctx.userdata.codePipelineTrigger == "" {
-		if ctx.userdata.noop {
-			ctx.logger.WithFields(logrus.Fields{
-				"Bucket":       ctx.userdata.s3Bucket,
-				"TemplateName": templateName,
-			}).Info(noopMessage("Stack creation"))
-		} else {
-			// Dump the template to a file, then upload it...
-			uploadURL, uploadURLErr := uploadLocalFileToS3(templateFile.Name(), "", ctx)
-			if nil != uploadURLErr {
-				return nil, uploadURLErr
+// discoveryReferences returns the logical names referenced by the Ref and
+// Fn::GetAtt expressions in the JSON value
+func discoveryReferences(value interface{}) []string {
+	var references []string
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for eachKey, eachValue := range typedValue {
+			switch eachKey {
+			case "Ref":
+				if refName, refNameOk := eachValue.(string); refNameOk {
+					references = append(references, refName)
+				}
+			case "Fn::GetAtt":
+				if attValues, attValuesOk := eachValue.([]interface{}); attValuesOk && len(attValues) != 0 {
+					if attName, attNameOk := attValues[0].(string); attNameOk {
+						references = append(references, attName)
+					}
+				}
+			default:
+				references = append(references, discoveryReferences(eachValue)...)
 			}
+		}
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			references = append(references, discoveryReferences(eachValue)...)
+		}
+	}
+	return references
+}
 
-			// If we're supposed to be inplace, then go ahead and try that
-			var stack *cloudformation.Stack
-			var stackErr error
-			if ctx.userdata.inPlace {
-				stack, stackErr = applyInPlaceFunctionUpdates(ctx, uploadURL)
-			} else {
-				operationTimeout := maximumStackOperationTimeout(ctx.context.cfTemplate, ctx.logger)
-				// Regular update, go ahead with the CloudFormation changes
-				stack, stackErr = spartaCF.ConvergeStackState(ctx.userdata.serviceName,
-					ctx.context.cfTemplate,
-					uploadURL,
-					stackTags,
-					ctx.transaction.startTime,
-					operationTimeout,
-					ctx.context.awsSession,
-					"▬",
-					dividerLength,
-					ctx.logger)
+// validateDiscoveryReferences ensures that every logical name referenced
+// by the discovery information of each function exists in the final
+// template. Decorators that rename or remove a resource after the
+// discovery information is generated would otherwise produce
+// Discover() failures at runtime.
+func validateDiscoveryReferences(template *gocf.Template,
+	lambdaAWSInfos []*LambdaAWSInfo) error {
+	// Function logical name -> missing logical names
+	missingReferences := make(map[string]map[string]bool)
+	appendError := func(functionName string, logicalName string) {
+		if missingReferences[functionName] == nil {
+			missingReferences[functionName] = make(map[string]bool)
+		}
+		missingReferences[functionName][logicalName] = true
+	}
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.DisableDiscovery {
+			continue
+		}
+		for _, eachDependency := range eachLambda.DependsOn {
+			if _, exists := template.Resources[eachDependency]; !exists {
+				appendError(eachLambda.LogicalResourceName(), eachDependency)
+			}
+		}
+	}
+	for eachResourceID, eachResourceDef := range template.Resources {
+		typedResource, typedResourceOk := eachResourceDef.Properties.(*gocf.LambdaFunction)
+		if !typedResourceOk || typedResource.Environment == nil {
+			continue
+		}
+		vars, varsOk := typedResource.Environment.Variables.(map[string]interface{})
+		if !varsOk || vars[envVarDiscoveryInformation] == nil {
+			continue
+		}
+		discoveryJSON, discoveryJSONErr := json.Marshal(vars[envVarDiscoveryInformation])
+		if discoveryJSONErr != nil {
+			return errors.Wrapf(discoveryJSONErr,
+				"Failed to marshal discovery information for resource %s",
+				eachResourceID)
+		}
+		var discoveryValue interface{}
+		unmarshalErr := json.Unmarshal(discoveryJSON, &discoveryValue)
+		if unmarshalErr != nil {
+			return errors.Wrapf(unmarshalErr,
+				"Failed to unmarshal discovery information for resource %s",
+				eachResourceID)
+		}
+		for _, eachReference := range discoveryReferences(discoveryValue) {
+			// Pseudo parameters and template parameters aren't resources
+			if strings.HasPrefix(eachReference, "AWS::") {
+				continue
 			}
-			if nil != stackErr {
-				return nil, stackErr
+			if _, exists := template.Parameters[eachReference]; exists {
+				continue
+			}
+			if _, exists := template.Resources[eachReference]; !exists {
+				appendError(eachResourceID, eachReference)
 			}
-			ctx.logger.WithFields(logrus.Fields{
-				"StackName":    *stack.StackName,
-				"StackId":      *stack.StackId,
-				"CreationTime": *stack.CreationTime,
-			}).Info("Stack provisioned")
 		}
-	} else {
-		ctx.logger.Info("Creating pipeline package")
+	}
+	var errorText []string
+	for eachFunctionName, eachMissing := range missingReferences {
+		for eachLogicalName := range eachMissing {
+			errorText = append(errorText,
+				fmt.Sprintf("Discovery information for function %s references a resource that doesn't exist: %s",
+					eachFunctionName,
+					eachLogicalName))
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
 
-		ctx.registerFileCleanupFinalizer(templateFile.Name())
-		_, urlErr := createCodePipelineTriggerPackage(cfTemplate, ctx)
-		if nil != urlErr {
-			return nil, urlErr
+// insertTemplateParameters declares the CloudFormation parameters that
+// are referenced by the function options
+func insertTemplateParameters(lambdaAWSInfos []*LambdaAWSInfo,
+	template *gocf.Template) error {
+
+	if template.Parameters == nil {
+		template.Parameters = make(map[string]*gocf.Parameter)
+	}
+	defaultValues := make(map[string]int64)
+	insertParameter := func(param *LambdaIntegerParameter,
+		description string,
+		minValue int64,
+		maxValue int64) error {
+		if param == nil {
+			return nil
+		}
+		existingDefault, exists := defaultValues[param.Name]
+		if exists && existingDefault != param.Default {
+			return errors.Errorf("Parameter %s is declared with conflicting default values: %d, %d",
+				param.Name,
+				existingDefault,
+				param.Default)
 		}
+		defaultValues[param.Name] = param.Default
+		template.Parameters[param.Name] = param.templateParameter(description,
+			minValue,
+			maxValue)
+		return nil
 	}
-	return nil, nil
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.Options == nil {
+			continue
+		}
+		memoryErr := insertParameter(eachLambda.Options.MemorySizeParameter,
+			"Lambda function MemorySize (MB)",
+			lambdaMinMemorySize,
+			lambdaMaxMemorySize)
+		if memoryErr != nil {
+			return memoryErr
+		}
+		timeoutErr := insertParameter(eachLambda.Options.TimeoutParameter,
+			"Lambda function Timeout (seconds)",
+			lambdaMinTimeout,
+			lambdaMaxTimeout)
+		if timeoutErr != nil {
+			return timeoutErr
+		}
+	}
+	return nil
 }
 
 func verifyLambdaPreconditions(lambdaAWSInfo *LambdaAWSInfo, logger *logrus.Logger) error {
@@ -1292,15 +3546,35 @@ func verifyLambdaPreconditions(lambdaAWSInfo *LambdaAWSInfo, logger *logrus.Logg
 
 func validateSpartaPostconditions() workflowStep {
 	return func(ctx *workflowContext) (workflowStep, error) {
+		if ctx.context.s3CodeZipURL != nil {
+			ctx.context.workflowHooksContext[WorkflowContextKeyCodeArchiveURL] = ctx.context.s3CodeZipURL.location
+		}
+		stepErr := callWorkflowSteps(WorkflowStageValidate, ctx)
+		if stepErr != nil {
+			return nil, stepErr
+		}
+
 		validateErrs := make([]error, 0)
 
 		requiredEnvVars := []string{envVarDiscoveryInformation,
 			envVarLogLevel}
 
+		// Functions that opted out of discovery only require the log level
+		discoveryDisabled := make(map[string]bool)
+		for _, eachLambda := range ctx.userdata.lambdaAWSInfos {
+			if eachLambda.DisableDiscovery {
+				discoveryDisabled[eachLambda.LogicalResourceName()] = true
+			}
+		}
+
 		// Verify that all Lambda functions have discovery information
 		for eachResourceID, eachResourceDef := range ctx.context.cfTemplate.Resources {
 			switch typedResource := eachResourceDef.Properties.(type) {
 			case *gocf.LambdaFunction:
+				requiredEnvVars := requiredEnvVars
+				if discoveryDisabled[eachResourceID] {
+					requiredEnvVars = []string{envVarLogLevel}
+				}
 				if typedResource.Environment == nil {
 					validateErrs = append(validateErrs,
 						errors.Errorf("Lambda function %s does not include environment info", eachResourceID))
@@ -1325,6 +3599,11 @@ func validateSpartaPostconditions() workflowStep {
 				}
 			}
 		}
+		discoveryErr := validateDiscoveryReferences(ctx.context.cfTemplate,
+			ctx.userdata.lambdaAWSInfos)
+		if discoveryErr != nil {
+			validateErrs = append(validateErrs, discoveryErr)
+		}
 		if len(validateErrs) != 0 {
 			return nil, errors.Errorf("Problems validating template contents: %v", validateErrs)
 		}
@@ -1341,6 +3620,11 @@ func ensureCloudFormationStack() workflowStep {
 		}
 		defer recordDuration(time.Now(), msg, ctx)
 
+		stepErr := callWorkflowSteps(WorkflowStageProvision, ctx)
+		if stepErr != nil {
+			return nil, stepErr
+		}
+
 		// PreMarshall Hook
 		if ctx.userdata.workflowHooks != nil {
 			preMarshallErr := callWorkflowHook("PreMarshall",
@@ -1364,6 +3648,11 @@ func ensureCloudFormationStack() workflowStep {
 				}
 			}
 		}
+		parametersErr := insertTemplateParameters(ctx.userdata.lambdaAWSInfos,
+			ctx.context.cfTemplate)
+		if parametersErr != nil {
+			return nil, parametersErr
+		}
 		for _, eachEntry := range ctx.userdata.lambdaAWSInfos {
 			verifyErr := verifyLambdaPreconditions(eachEntry, ctx.logger)
 			if verifyErr != nil {
@@ -1383,6 +3672,13 @@ func ensureCloudFormationStack() workflowStep {
 			if nil != err {
 				return nil, err
 			}
+			cfResource, exists := ctx.context.cfTemplate.Resources[eachEntry.LogicalResourceName()]
+			if exists && ctx.context.sbomSHA256 != "" {
+				safeMetadataInsert(cfResource, "sbomSHA256", ctx.context.sbomSHA256)
+			}
+			if exists && ctx.context.binarySHA256 != "" {
+				safeMetadataInsert(cfResource, "binarySHA256", ctx.context.binarySHA256)
+			}
 		}
 		// If there's an API gateway definition, include the resources that provision it. Since this export will likely
 		// generate outputs that the s3 site needs, we'll use a temporary outputs accumulator, pass that to the S3Site
@@ -1429,6 +3725,9 @@ func ensureCloudFormationStack() workflowStep {
 			_, annotateErr = annotateBuildInformation(eachEntry,
 				ctx.context.cfTemplate,
 				ctx.userdata.buildID,
+				ctx.transaction.startTime,
+				ctx.userdata.workflowHooks != nil &&
+					ctx.userdata.workflowHooks.BuildMetadataEnvironment,
 				ctx.logger)
 			if annotateErr != nil {
 				return nil, annotateErr
@@ -1487,6 +3786,55 @@ func ensureCloudFormationStack() workflowStep {
 				"Failed to perform final template annotations")
 		}
 
+		if ctx.userdata.workflowHooks != nil &&
+			ctx.userdata.workflowHooks.PermissionsBoundary != nil {
+			applyPermissionsBoundary(ctx.context.cfTemplate,
+				ctx.userdata.workflowHooks.PermissionsBoundary,
+				ctx.logger)
+		}
+		if ctx.userdata.workflowHooks != nil &&
+			len(ctx.userdata.workflowHooks.RetainResources) != 0 {
+			applyRetainPolicy(ctx.context.cfTemplate,
+				ctx.userdata.workflowHooks.RetainResources,
+				ctx.logger)
+		}
+		if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.ExplainIAM {
+			explainIAMRoles(ctx)
+		}
+
+		exportErr := validateOutputExports(ctx.context.cfTemplate)
+		if exportErr != nil {
+			return nil, exportErr
+		}
+		// Nested stack templates are checked after they're split
+		if !nestedStacksEnabled(ctx.userdata.workflowHooks) {
+			format, formatErr := templateFormat(ctx.userdata.workflowHooks)
+			if formatErr != nil {
+				return nil, formatErr
+			}
+			limitsErr := validateTemplateLimits(ctx.context.cfTemplate, format, ctx.logger)
+			if limitsErr != nil {
+				return nil, limitsErr
+			}
+		}
+		permissionsErr := validateEventSourcePermissions(ctx.context.cfTemplate)
+		if permissionsErr != nil {
+			return nil, errors.Wrapf(permissionsErr,
+				"Event source permissions are missing")
+		}
+
+		// Can the caller actually provision this?
+		if !ctx.userdata.noop &&
+			ctx.userdata.workflowHooks != nil &&
+			ctx.userdata.workflowHooks.SimulateIAMPermissions {
+			simulateErr := simulateProvisioningPermissions(ctx.context.awsSession,
+				ctx.context.cfTemplate,
+				ctx.logger)
+			if simulateErr != nil {
+				return nil, simulateErr
+			}
+		}
+
 		// validations?
 		if ctx.userdata.workflowHooks != nil {
 			validationErr := callValidationHooks(ctx.userdata.workflowHooks.Validators,
@@ -1495,7 +3843,18 @@ func ensureCloudFormationStack() workflowStep {
 			if validationErr != nil {
 				return nil, validationErr
 			}
+			transformedTemplate, transformErr := callTemplateTransformers(ctx.userdata.workflowHooks.TemplateTransformers,
+				ctx.context.cfTemplate,
+				ctx)
+			if transformErr != nil {
+				return nil, transformErr
+			}
+			ctx.context.cfTemplate = transformedTemplate
 		}
+		applyLambdaArchitecture(ctx.context.cfTemplate,
+			lambdaArchitecture(ctx.userdata.workflowHooks))
+		applyLambdaEphemeralStorage(ctx.context.cfTemplate,
+			ctx.userdata.lambdaAWSInfos)
 
 		// Do the operation!
 		return applyCloudFormationOperation(ctx)
@@ -1507,7 +3866,7 @@ func ensureCloudFormationStack() workflowStep {
 // identify and is used to determine create vs update operations.  The compilation options/flags are:
 //
 // 	TAGS:         -tags lambdabinary
-// 	ENVIRONMENT:  GOOS=linux GOARCH=amd64
+// 	ENVIRONMENT:  GOOS=linux GOARCH=amd64 (or arm64, see WorkflowHooks.Architecture)
 //
 // The compiled binary is packaged with a NodeJS proxy shim to manage AWS Lambda setup & invocation per
 // http://docs.aws.amazon.com/lambda/latest/dg/authoring-function-in-nodejs.html
@@ -1516,6 +3875,9 @@ func ensureCloudFormationStack() workflowStep {
 // template (http://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/Welcome.html)
 // which creates or updates the service state.
 //
+// If buildID is empty, it's the short git SHA of the working directory with
+// a "-dirty" suffix for uncommitted changes, or a UTC timestamp outside of
+// a git repository.
 func Provision(noop bool,
 	serviceName string,
 	serviceDescription string,
@@ -1533,14 +3895,246 @@ func Provision(noop bool,
 	workflowHooks *WorkflowHooks,
 	logger *logrus.Logger) error {
 
+	return ProvisionWithContext(context.Background(),
+		noop,
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		inPlaceUpdates,
+		buildID,
+		codePipelineTrigger,
+		buildTags,
+		linkerFlags,
+		templateWriter,
+		workflowHooks,
+		logger)
+}
+
+// ProvisionWithContext is Provision with a caller supplied context, for
+// instance to enforce a CI deadline. The context is applied to the `go build`
+// command, the BinaryTransform hook, the AWS API requests, and the
+// PostProvisionTests. If it's cancelled, the current workflow step fails,
+// rollback functions are called, and the intermediate artifacts (including
+// partially written code archives) are deleted.
+func ProvisionWithContext(callerContext context.Context,
+	noop bool,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	inPlaceUpdates bool,
+	buildID string,
+	codePipelineTrigger string,
+	buildTags string,
+	linkerFlags string,
+	templateWriter io.Writer,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+
+	// Provision builds the artifacts as BuildArtifacts does and deploys
+	// them as DeployArtifacts does. NOOP and CodePipeline provisions
+	// don't deploy the artifacts, and streamed code archives aren't
+	// written locally.
+	artifactsDirectory := ""
+	if !noop &&
+		codePipelineTrigger == "" &&
+		(workflowHooks == nil || !workflowHooks.StreamCodeArchive) {
+		mkdirErr := os.MkdirAll(ScratchDirectory, os.ModePerm)
+		if nil != mkdirErr {
+			return mkdirErr
+		}
+		tempDir, tempDirErr := ioutil.TempDir(ScratchDirectory,
+			fmt.Sprintf("%s-artifacts", sanitizedName(serviceName)))
+		if nil != tempDirErr {
+			return errors.Wrapf(tempDirErr, "Failed to create artifacts directory")
+		}
+		defer os.RemoveAll(tempDir)
+		artifactsDirectory = tempDir
+	}
+	return provisionWorkflow(callerContext,
+		noop,
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		inPlaceUpdates,
+		buildID,
+		codePipelineTrigger,
+		buildTags,
+		linkerFlags,
+		templateWriter,
+		artifactsDirectory,
+		nil,
+		workflowHooks,
+		logger)
+}
+
+// newWorkflowSessions returns the AWS session for the workflow's API calls,
+// which is bound to the callerContext, and the session for the rollback
+// functions, which isn't. Both sessions share the request limiter.
+func newWorkflowSessions(callerContext context.Context,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) (*session.Session, *session.Session, *spartaAWS.RequestLimiter) {
+	maxConcurrentAWSRequests := 0
+	if workflowHooks != nil {
+		maxConcurrentAWSRequests = workflowHooks.MaxConcurrentAWSRequests
+	}
+	awsRequestLimiter := spartaAWS.NewRequestLimiter(maxConcurrentAWSRequests)
+	awsSession := spartaAWS.NewSession(logger)
+	awsRequestLimiter.Attach(awsSession)
+	spartaAWS.AttachContext(awsSession, callerContext)
+	rollbackSession := spartaAWS.NewSession(logger)
+	awsRequestLimiter.Attach(rollbackSession)
+	return awsSession, rollbackSession, awsRequestLimiter
+}
+
+// provisionWorkflow is the Provision implementation. If artifactsDirectory
+// is non-empty, the workflow writes the deployable artifacts and their
+// manifest to artifactsDirectory after the template is created. Unless
+// it's a NOOP build, the artifacts are then deployed with the same
+// workflowContext.
+// If changeSetReport is non-nil, the workflow creates a change set for
+// the uploaded template rather than updating the stack.
+func provisionWorkflow(callerContext context.Context,
+	noop bool,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	inPlaceUpdates bool,
+	buildID string,
+	codePipelineTrigger string,
+	buildTags string,
+	linkerFlags string,
+	templateWriter io.Writer,
+	artifactsDirectory string,
+	changeSetReport *ChangeSetReport,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) error {
+
 	err := validateSpartaPreconditions(lambdaAWSInfos, logger)
 	if nil != err {
 		return errors.Wrapf(err, "Failed to validate preconditions")
 	}
+	// Programmatic callers may not supply a buildID
+	buildID, err = provisionBuildID(buildID, logger)
+	if nil != err {
+		return errors.Wrapf(err, "Failed to determine buildID")
+	}
+	if workflowHooks != nil && workflowHooks.AccountConcurrencyLimit > 0 {
+		warnReservedConcurrency(lambdaAWSInfos,
+			workflowHooks.AccountConcurrencyLimit,
+			logger)
+	}
+	if len(serviceDescription) > templateDescriptionMaxLength {
+		return errors.Errorf("Service description (%d bytes) exceeds the CloudFormation limit of %d bytes",
+			len(serviceDescription),
+			templateDescriptionMaxLength)
+	}
+	err = validateResourceNameLengths(serviceName, lambdaAWSInfos)
+	if nil != err {
+		return errors.Wrapf(err, "Failed to validate resource names")
+	}
+	apiV2, isAPIV2 := api.(*APIV2)
+	if isAPIV2 && apiV2 != nil {
+		signatureErrs := apiV2.validateProxySignatures()
+		if len(signatureErrs) != 0 {
+			return errors.Errorf("Failed to validate API Gateway proxy functions:\n%s",
+				strings.Join(signatureErrs, "\n"))
+		}
+	}
+	if workflowHooks != nil {
+		err = validateStackTags(workflowHooks.StackTags)
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate stack tags")
+		}
+		err = validatePermissionsBoundary(workflowHooks.PermissionsBoundary)
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate service permissions boundary")
+		}
+		err = validateStackPolicy(workflowHooks.StackPolicy)
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate stack policy")
+		}
+		err = validateWorkflowSteps(workflowHooks.Steps)
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate workflow steps")
+		}
+		err = validateLogRetentionInDays(workflowHooks.LogRetentionInDays)
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate service log retention")
+		}
+		for _, eachFile := range workflowHooks.ArchiveFiles {
+			if eachFile == nil {
+				return errors.New("Failed to validate archive files: nil ArchiveFile")
+			}
+			err = eachFile.validate()
+			if nil != err {
+				return errors.Wrapf(err, "Failed to validate archive files")
+			}
+		}
+		if workflowHooks.StreamCodeArchive &&
+			(workflowHooks.SkipUnchangedCode || artifactsDirectory != "") {
+			return errors.New("StreamCodeArchive can't be combined with SkipUnchangedCode or BuildArtifacts, " +
+				"which require a local code archive")
+		}
+	}
+	for _, eachLambda := range lambdaAWSInfos {
+		// Each function gets its own copy of the stack tags s.t. neither
+		// the caller's StackTags nor tags from a prior build are shared
+		eachLambda.serviceTags = make(map[string]string)
+		if workflowHooks != nil {
+			for eachKey, eachValue := range workflowHooks.StackTags {
+				eachLambda.serviceTags[eachKey] = eachValue
+			}
+			eachLambda.serviceLogRetentionInDays = workflowHooks.LogRetentionInDays
+		}
+		err = validateFunctionTags(eachLambda.lambdaFunctionName(),
+			eachLambda.functionTags())
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate function tags")
+		}
+		if eachLambda.warmupConfig != nil {
+			err = eachLambda.warmupConfig.validateFunctions(lambdaAWSInfos)
+			if nil != err {
+				return errors.Wrapf(err, "Failed to validate warmup configuration")
+			}
+		}
+	}
+	if restAPI, restAPIOk := api.(*API); restAPIOk && restAPI != nil {
+		restAPI.serviceTags = make(map[string]string)
+		if workflowHooks != nil {
+			for eachKey, eachValue := range workflowHooks.StackTags {
+				restAPI.serviceTags[eachKey] = eachValue
+			}
+		}
+		err = validateAPIGatewayTags(restAPI.name, restAPI.apiTags())
+		if nil != err {
+			return errors.Wrapf(err, "Failed to validate API Gateway tags")
+		}
+	}
 	startTime := time.Now()
 
+	awsSession, rollbackSession, awsRequestLimiter := newWorkflowSessions(callerContext,
+		workflowHooks,
+		logger)
+
 	ctx := &workflowContext{
-		logger: logger,
+		logger:        logger,
+		callerContext: callerContext,
 		userdata: userdata{
 			noop:               noop,
 			useCGO:             useCGO,
@@ -1558,12 +4152,16 @@ func Provision(noop bool,
 			},
 			codePipelineTrigger: codePipelineTrigger,
 			workflowHooks:       workflowHooks,
+			artifactsDirectory:  artifactsDirectory,
+			changeSetReport:     changeSetReport,
 		},
 		context: provisionContext{
 			cfTemplate:                gocf.NewTemplate(),
 			s3BucketVersioningEnabled: false,
-			awsSession:                spartaAWS.NewSession(logger),
+			awsSession:                awsSession,
+			rollbackSession:           rollbackSession,
 			workflowHooksContext:      make(map[string]interface{}),
+			iamRoleExplanations:       make(map[string]*iamRoleExplanation),
 			templateWriter:            templateWriter,
 			binaryName:                SpartaBinaryName,
 		},
@@ -1572,6 +4170,14 @@ func Provision(noop bool,
 		},
 	}
 	ctx.context.cfTemplate.Description = serviceDescription
+	if artifactsDirectory != "" {
+		ctx.context.artifactManifest = &ArtifactManifest{
+			ServiceName: serviceName,
+			BuildID:     buildID,
+			BuildTags:   buildTags,
+			BinaryName:  ctx.context.binaryName,
+		}
+	}
 
 	// Update the context iff it exists
 	if nil != workflowHooks && nil != workflowHooks.Context {
@@ -1596,9 +4202,31 @@ func Provision(noop bool,
 		ctx.logger.Warn("No lambda functions provided to Sparta.Provision()")
 	}
 
+	if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.CleanOutputDir {
+		cleanErr := cleanOutputDirectory(ctx.userdata.serviceName,
+			ctx.userdata.codePipelineTrigger,
+			ctx.userdata.artifactsDirectory,
+			ctx.logger)
+		if cleanErr != nil {
+			return cleanErr
+		}
+	}
+
 	// Start the workflow
-	for step := verifyIAMRoles; step != nil; {
-		next, err := step(ctx)
+	var step workflowStep = verifyIAMRoles
+	if ctx.userdata.workflowHooks != nil && ctx.userdata.workflowHooks.ParallelBuild {
+		step = verifyAndPackageStep
+	}
+	// When we're done, execute any finalizers. These cleanup the
+	// intermediate artifacts whether or not the workflow succeeded.
+	defer ctx.finalize()
+	for step != nil {
+		// Don't start another step if the caller cancelled the workflow
+		err = callerContext.Err()
+		var next workflowStep
+		if err == nil {
+			next, err = step(ctx)
+		}
 		if err != nil {
 			showOptionalAWSUsageInfo(err, ctx.logger)
 
@@ -1621,6 +4249,7 @@ func Provision(noop bool,
 			ctx.logger.WithFields(logrus.Fields{
 				"Duration (s)": fmt.Sprintf("%.f", elapsed.Seconds()),
 			}).Info("Total elapsed time")
+			awsRequestLimiter.LogSummary(ctx.logger)
 			curTime := time.Now()
 			ctx.logger.WithFields(logrus.Fields{
 				"Time (UTC)":   curTime.UTC().Format(time.RFC3339),
@@ -1631,14 +4260,5 @@ func Provision(noop bool,
 			step = next
 		}
 	}
-	// When we're done, execute any finalizers
-	if nil != ctx.transaction.finalizerFunctions {
-		ctx.logger.WithFields(logrus.Fields{
-			"FinalizerCount": len(ctx.transaction.finalizerFunctions),
-		}).Debug("Invoking finalizer functions")
-		for _, eachFinalizer := range ctx.transaction.finalizerFunctions {
-			eachFinalizer(ctx.logger)
-		}
-	}
 	return nil
 }
@@ -0,0 +1,226 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// changeSetNameMaxLength is the maximum length of a change set name
+const changeSetNameMaxLength = 128
+
+var reInvalidChangeSetChars = regexp.MustCompile("[^a-zA-Z0-9-]+")
+
+// ChangeSetResourceChange is a resource change in a CloudFormation
+// change set
+type ChangeSetResourceChange struct {
+	// Action is one of Add, Modify, Remove, Import or Dynamic
+	Action            string
+	LogicalResourceID string
+	ResourceType      string
+	// Replacement is True, False or Conditional for Modify actions
+	Replacement string `json:",omitempty"`
+}
+
+// ChangeSetReport describes the change set created by CreateChangeSet
+type ChangeSetReport struct {
+	ServiceName string
+	// ChangeSetName is empty if there aren't any changes, in which
+	// case the change set isn't retained
+	ChangeSetName string
+	ChangeSetID   string
+	StackID       string
+	Changes       []*ChangeSetResourceChange
+}
+
+// changeSetName returns the name of the change set for the build
+func changeSetName(buildID string) string {
+	name := fmt.Sprintf("%s-%s",
+		ProperName,
+		reInvalidChangeSetChars.ReplaceAllString(buildID, "-"))
+	if len(name) > changeSetNameMaxLength {
+		name = name[:changeSetNameMaxLength]
+	}
+	return name
+}
+
+// newChangeSetResourceChanges returns the resource changes in the
+// change set description
+func newChangeSetResourceChanges(changes []*cloudformation.Change) []*ChangeSetResourceChange {
+	resourceChanges := make([]*ChangeSetResourceChange, 0, len(changes))
+	for _, eachChange := range changes {
+		if eachChange.ResourceChange == nil {
+			continue
+		}
+		resourceChanges = append(resourceChanges, &ChangeSetResourceChange{
+			Action:            aws.StringValue(eachChange.ResourceChange.Action),
+			LogicalResourceID: aws.StringValue(eachChange.ResourceChange.LogicalResourceId),
+			ResourceType:      aws.StringValue(eachChange.ResourceChange.ResourceType),
+			Replacement:       aws.StringValue(eachChange.ResourceChange.Replacement),
+		})
+	}
+	return resourceChanges
+}
+
+// createChangeSet creates the change set for the uploaded template and
+// populates the workflow's change set report. The change set is retained
+// so that it can be executed or deleted after it's reviewed.
+func createChangeSet(ctx *workflowContext,
+	templateURL string,
+	stackTags map[string]string) error {
+
+	stackExists, stackExistsErr := spartaCF.StackExists(ctx.userdata.serviceName,
+		ctx.context.awsSession,
+		ctx.logger)
+	if nil != stackExistsErr {
+		return stackExistsErr
+	}
+	if !stackExists {
+		return errors.Errorf("CreateChangeSet requires an existing stack: %s",
+			ctx.userdata.serviceName)
+	}
+	awsTags := make([]*cloudformation.Tag, 0, len(stackTags))
+	for eachKey, eachValue := range stackTags {
+		awsTags = append(awsTags, &cloudformation.Tag{
+			Key:   aws.String(eachKey),
+			Value: aws.String(eachValue),
+		})
+	}
+	name := changeSetName(ctx.userdata.buildID)
+	changes, changesErr := spartaCF.CreateStackChangeSet(name,
+		ctx.userdata.serviceName,
+		ctx.context.cfTemplate,
+		templateURL,
+		awsTags,
+		cloudformation.New(ctx.context.awsSession),
+		ctx.logger)
+	if nil != changesErr {
+		return errors.Wrapf(changesErr, "Failed to create change set %s", name)
+	}
+	report := ctx.userdata.changeSetReport
+	report.ServiceName = ctx.userdata.serviceName
+	report.Changes = []*ChangeSetResourceChange{}
+	// CreateStackChangeSet deletes the change set if there
+	// aren't any changes
+	if nil != changes {
+		report.ChangeSetName = name
+		report.ChangeSetID = aws.StringValue(changes.ChangeSetId)
+		report.StackID = aws.StringValue(changes.StackId)
+		report.Changes = newChangeSetResourceChanges(changes.Changes)
+	}
+	return nil
+}
+
+// logChangeSetReport logs the change set resource changes
+func logChangeSetReport(report *ChangeSetReport, logger *logrus.Logger) {
+	logSectionHeader("Change Set", dividerLength, logger)
+	if report.ChangeSetName == "" {
+		logger.WithField("StackName", report.ServiceName).Info("No changes detected")
+		return
+	}
+	logger.WithField("Id", report.ChangeSetID).Info("ChangeSetId")
+	logger.WithField("Name", report.ChangeSetName).Info("ChangeSetName")
+	logger.Info()
+	logSectionHeader("Changes", dividerLength, logger)
+	for _, eachChange := range report.Changes {
+		statement := logger.WithFields(logrus.Fields{
+			"Action": eachChange.Action,
+			"Type":   eachChange.ResourceType,
+		})
+		if eachChange.Replacement != "" {
+			statement = statement.WithField("Replacement", eachChange.Replacement)
+		}
+		statement.Info(eachChange.LogicalResourceID)
+	}
+	logger.Info()
+}
+
+// CreateChangeSet compiles, packages, and uploads the service artifacts,
+// then creates a CloudFormation change set for the existing stack. The
+// change set is named with the buildID and isn't executed, so that it
+// can be reviewed before it's executed or deleted with the
+// CloudFormation console or CLI. If logReport is true, the
+// changes are logged.
+func CreateChangeSet(serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	buildID string,
+	buildTags string,
+	linkerFlags string,
+	logReport bool,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) (*ChangeSetReport, error) {
+
+	return CreateChangeSetWithContext(context.Background(),
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		buildID,
+		buildTags,
+		linkerFlags,
+		logReport,
+		workflowHooks,
+		logger)
+}
+
+// CreateChangeSetWithContext is CreateChangeSet with a caller supplied
+// context. The context is applied as it is by ProvisionWithContext.
+func CreateChangeSetWithContext(callerContext context.Context,
+	serviceName string,
+	serviceDescription string,
+	lambdaAWSInfos []*LambdaAWSInfo,
+	api APIGateway,
+	site *S3Site,
+	s3Bucket string,
+	useCGO bool,
+	buildID string,
+	buildTags string,
+	linkerFlags string,
+	logReport bool,
+	workflowHooks *WorkflowHooks,
+	logger *logrus.Logger) (*ChangeSetReport, error) {
+
+	report := &ChangeSetReport{}
+	provisionErr := provisionWorkflow(callerContext,
+		false,
+		serviceName,
+		serviceDescription,
+		lambdaAWSInfos,
+		api,
+		site,
+		s3Bucket,
+		useCGO,
+		false,
+		buildID,
+		"",
+		buildTags,
+		linkerFlags,
+		nil,
+		"",
+		report,
+		workflowHooks,
+		logger)
+	if provisionErr != nil {
+		return nil, provisionErr
+	}
+	if logReport {
+		logChangeSetReport(report, logger)
+	}
+	return report, nil
+}
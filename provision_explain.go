@@ -0,0 +1,109 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+// iamRoleExplanation is the set of functions that use a Sparta-generated
+// IAM role together with the origin of each statement
+type iamRoleExplanation struct {
+	functionNames []string
+	provenance    []*iamStatementProvenance
+}
+
+// recordIAMRoleExplanation saves the statement origins for the given role
+func (ctx *workflowContext) recordIAMRoleExplanation(roleLogicalName string,
+	functionName string,
+	roleDefinition *IAMRoleDefinition,
+	options *LambdaFunctionOptions) {
+
+	explanation, exists := ctx.context.iamRoleExplanations[roleLogicalName]
+	if !exists {
+		explanation = &iamRoleExplanation{
+			provenance: roleDefinition.statementProvenance(options),
+		}
+		ctx.context.iamRoleExplanations[roleLogicalName] = explanation
+	}
+	explanation.functionNames = append(explanation.functionNames, functionName)
+}
+
+// explainIAMRoles logs the origin of each statement in the
+// Sparta-generated IAM roles. Statements that are added once the
+// template is materialized (eg, EventSourceMapping policies) are
+// discovered from the role's named inline policies.
+func explainIAMRoles(ctx *workflowContext) {
+	roleNames := make([]string, 0, len(ctx.context.iamRoleExplanations))
+	for eachRoleName := range ctx.context.iamRoleExplanations {
+		roleNames = append(roleNames, eachRoleName)
+	}
+	sort.Strings(roleNames)
+
+	ctx.logger.Info("IAM Role Explanation")
+	for _, eachRoleName := range roleNames {
+		explanation := ctx.context.iamRoleExplanations[eachRoleName]
+		ctx.logger.WithFields(logrus.Fields{
+			"Functions": strings.Join(explanation.functionNames, ", "),
+		}).Info(eachRoleName)
+
+		for _, eachEntry := range explanation.provenance {
+			logExplainedStatement(eachEntry.source,
+				eachEntry.statement.Action,
+				eachEntry.statement.Resource,
+				ctx.logger)
+		}
+		// Any policies added after the role was created?
+		cfResource, cfResourceExists := ctx.context.cfTemplate.Resources[eachRoleName]
+		if !cfResourceExists {
+			continue
+		}
+		iamRole, iamRoleOk := cfResource.Properties.(gocf.IAMRole)
+		if !iamRoleOk {
+			continue
+		}
+		if iamRole.ManagedPolicyArns != nil {
+			logExplainedStatement("ManagedPolicyArns",
+				nil,
+				iamRole.ManagedPolicyArns,
+				ctx.logger)
+		}
+		if iamRole.Policies == nil {
+			continue
+		}
+		for _, eachPolicy := range *iamRole.Policies {
+			if eachPolicy.PolicyName == nil || eachPolicy.PolicyName.Literal == "LambdaPolicy" {
+				continue
+			}
+			logExplainedStatement(eachPolicy.PolicyName.Literal,
+				nil,
+				eachPolicy.PolicyDocument,
+				ctx.logger)
+		}
+	}
+}
+
+func logExplainedStatement(source string,
+	actions []string,
+	resource interface{},
+	logger *logrus.Logger) {
+	resourceJSON, resourceJSONErr := json.Marshal(resource)
+	if resourceJSONErr != nil {
+		resourceJSON = []byte("?")
+	}
+	fields := logrus.Fields{
+		"Source": source,
+	}
+	if len(actions) != 0 {
+		fields["Actions"] = strings.Join(actions, ", ")
+		fields["Resource"] = string(resourceJSON)
+	} else {
+		fields["PolicyDocument"] = string(resourceJSON)
+	}
+	logger.WithFields(fields).Info("    Statement")
+}
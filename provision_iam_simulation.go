@@ -0,0 +1,169 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// provisioningActions are the actions required to provision any
+// Sparta service, independent of the resources in the template
+var provisioningActions = []string{
+	"cloudformation:CreateStack",
+	"cloudformation:UpdateStack",
+	"cloudformation:CreateChangeSet",
+	"cloudformation:ExecuteChangeSet",
+	"cloudformation:DescribeStacks",
+	"cloudformation:DescribeStackEvents",
+	"s3:PutObject",
+}
+
+// resourceTypeProvisioningActions is the set of actions required to
+// provision the given CloudFormation resource type. Resource types that
+// are not in this map are not included in the simulation.
+var resourceTypeProvisioningActions = map[string][]string{
+	"AWS::IAM::Role": {"iam:CreateRole",
+		"iam:GetRole",
+		"iam:PutRolePolicy",
+		"iam:PassRole"},
+	"AWS::Lambda::Function": {"lambda:CreateFunction",
+		"lambda:GetFunction",
+		"lambda:UpdateFunctionCode",
+		"lambda:UpdateFunctionConfiguration"},
+	"AWS::Lambda::Permission":          {"lambda:AddPermission"},
+	"AWS::Lambda::EventSourceMapping":  {"lambda:CreateEventSourceMapping"},
+	"AWS::Lambda::Version":             {"lambda:PublishVersion"},
+	"AWS::Lambda::Alias":               {"lambda:CreateAlias"},
+	"AWS::Logs::LogGroup":              {"logs:CreateLogGroup"},
+	"AWS::ApiGateway::RestApi":         {"apigateway:POST"},
+	"AWS::S3::Bucket":                  {"s3:CreateBucket"},
+	"AWS::SNS::Topic":                  {"sns:CreateTopic"},
+	"AWS::SQS::Queue":                  {"sqs:CreateQueue"},
+	"AWS::DynamoDB::Table":             {"dynamodb:CreateTable"},
+	"AWS::Kinesis::Stream":             {"kinesis:CreateStream"},
+	"AWS::Events::Rule":                {"events:PutRule", "events:PutTargets"},
+	"AWS::StepFunctions::StateMachine": {"states:CreateStateMachine"},
+	"AWS::CloudWatch::Alarm":           {"cloudwatch:PutMetricAlarm"},
+}
+
+// Assumed role sessions must be simulated against the role
+var reAssumedRoleArn = regexp.MustCompile(`^arn:([^:]+):sts::(\d+):assumed-role/([^/]+)/.+$`)
+
+// requiredProvisioningActions returns the sorted, unique set of actions
+// needed to provision the given template
+func requiredProvisioningActions(template *gocf.Template, logger *logrus.Logger) []string {
+	actionSet := make(map[string]bool)
+	for _, eachAction := range provisioningActions {
+		actionSet[eachAction] = true
+	}
+	for eachLogicalName, eachResource := range template.Resources {
+		resourceType := eachResource.Properties.CfnResourceType()
+		actions, actionsExist := resourceTypeProvisioningActions[resourceType]
+		if !actionsExist {
+			logger.WithFields(logrus.Fields{
+				"Resource": eachLogicalName,
+				"Type":     resourceType,
+			}).Debug("Resource type not included in IAM simulation")
+			continue
+		}
+		for _, eachAction := range actions {
+			actionSet[eachAction] = true
+		}
+	}
+	actions := make([]string, 0, len(actionSet))
+	for eachAction := range actionSet {
+		actions = append(actions, eachAction)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// simulationPrincipalArn returns the IAM principal ARN for the current
+// caller. STS assumed role ARNs are converted to the IAM role ARN. The
+// assumed role ARN doesn't include the role's Path, so the role ARN is
+// read from IAM rather than derived from the session ARN.
+func simulationPrincipalArn(stsSvc stsiface.STSAPI, iamSvc iamiface.IAMAPI) (string, error) {
+	identityResponse, identityResponseErr := stsSvc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if identityResponseErr != nil {
+		return "", identityResponseErr
+	}
+	callerArn := *identityResponse.Arn
+	matches := reAssumedRoleArn.FindStringSubmatch(callerArn)
+	if len(matches) != 4 {
+		return callerArn, nil
+	}
+	roleResponse, roleResponseErr := iamSvc.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(matches[3]),
+	})
+	if roleResponseErr != nil {
+		return "", errors.Wrapf(roleResponseErr,
+			"Failed to get IAM role %s for assumed role %s",
+			matches[3],
+			callerArn)
+	}
+	return aws.StringValue(roleResponse.Role.Arn), nil
+}
+
+// simulateProvisioningPermissions uses iam:SimulatePrincipalPolicy to
+// verify that the current caller is allowed to perform the actions
+// required to provision the template. Any denied actions are reported
+// before the stack operation starts.
+func simulateProvisioningPermissions(awsSession *session.Session,
+	template *gocf.Template,
+	logger *logrus.Logger) error {
+
+	iamSvc := iam.New(awsSession)
+	principalArn, principalArnErr := simulationPrincipalArn(sts.New(awsSession), iamSvc)
+	if principalArnErr != nil {
+		return errors.Wrapf(principalArnErr, "Failed to determine caller identity for IAM simulation")
+	}
+	actions := requiredProvisioningActions(template, logger)
+	logger.WithFields(logrus.Fields{
+		"Principal":   principalArn,
+		"ActionCount": len(actions),
+	}).Info("Simulating IAM provisioning permissions")
+
+	deniedActions := make([]string, 0)
+	params := &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principalArn),
+		ActionNames:     aws.StringSlice(actions),
+	}
+	simulateErr := iamSvc.SimulatePrincipalPolicyPages(params,
+		func(page *iam.SimulatePolicyResponse, lastPage bool) bool {
+			for _, eachResult := range page.EvaluationResults {
+				if *eachResult.EvalDecision != iam.PolicyEvaluationDecisionTypeAllowed {
+					deniedActions = append(deniedActions, *eachResult.EvalActionName)
+					logger.WithFields(logrus.Fields{
+						"Action":   *eachResult.EvalActionName,
+						"Decision": *eachResult.EvalDecision,
+					}).Error("IAM simulation denied action")
+				}
+			}
+			return true
+		})
+	if simulateErr != nil {
+		return errors.Wrapf(simulateErr,
+			"Failed to simulate IAM permissions for %s. The caller may need iam:SimulatePrincipalPolicy",
+			principalArn)
+	}
+	if len(deniedActions) != 0 {
+		return errors.Errorf("Principal %s is not allowed to perform %d required action(s): %s",
+			principalArn,
+			len(deniedActions),
+			strings.Join(deniedActions, ", "))
+	}
+	logger.WithField("Principal", principalArn).Info("IAM simulation succeeded")
+	return nil
+}
@@ -0,0 +1,758 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	spartaS3 "github.com/mweagle/Sparta/aws/s3"
+	"github.com/mweagle/Sparta/system"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// nestedStackResourceType is the CloudFormation nested stack type
+	nestedStackResourceType = "AWS::CloudFormation::Stack"
+	// nestedStackNamePrefix is the logical name prefix of the nested
+	// stack resources
+	nestedStackNamePrefix = "NestedStack"
+	// nestedStackResourcesMaxCount is the resource budget of a nested
+	// stack. The headroom is left for the cross stack parameters and
+	// outputs.
+	nestedStackResourcesMaxCount = templateResourcesMaxCount * templateLimitWarningPercent / 100
+	// nestedStackBodyMaxSize is the template body budget of a nested stack
+	nestedStackBodyMaxSize = templateBodyMaxSize * templateLimitWarningPercent / 100
+)
+
+var (
+	// nestedStackSubVariable matches the ${Name} and ${Name.Attribute}
+	// Fn::Sub variables. ${!Literal} values are escaped text.
+	nestedStackSubVariable = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+	// nestedStackNonAlphanumeric matches the characters that aren't
+	// allowed in a logical name
+	nestedStackNonAlphanumeric = regexp.MustCompile("[^A-Za-z0-9]+")
+	// nestedStackPseudoParameters are the pseudo parameters whose value
+	// in a nested stack is that of the nested stack. The parent values
+	// are passed as parameters s.t. the function names and discovery
+	// information are unchanged.
+	nestedStackPseudoParameters = map[string]bool{
+		"AWS::StackName": true,
+		"AWS::StackId":   true,
+	}
+)
+
+// nestedStackTemplate is a nested stack template created by
+// splitNestedStacks
+type nestedStackTemplate struct {
+	// logicalName is the AWS::CloudFormation::Stack resource name
+	logicalName string
+	// s3Key is the content addressed S3 key of the template
+	s3Key string
+	// body is the JSON template
+	body []byte
+}
+
+// nestedStackReferenceResolver returns the variable name and the
+// value that replaces a reference to the name and optional attribute.
+// If the boolean return value is false, the reference is unchanged.
+type nestedStackReferenceResolver func(name string, attr string) (string, interface{}, bool)
+
+// nestedStacksEnabled returns true if the WorkflowHooks opt in to
+// splitting oversized templates
+func nestedStacksEnabled(workflowHooks *WorkflowHooks) bool {
+	return workflowHooks != nil && workflowHooks.NestedStacks
+}
+
+// nestedStackVariableName returns the nested stack parameter and output
+// name that passes the reference across stacks
+func nestedStackVariableName(name string, attr string) string {
+	if attr == "" && !nestedStackPseudoParameters[name] {
+		attr = "Ref"
+	}
+	return nestedStackNonAlphanumeric.ReplaceAllString(name+attr, "")
+}
+
+// nestedStackReference returns the Ref or Fn::GetAtt expression
+func nestedStackReference(name string, attr string) interface{} {
+	if attr == "" {
+		return map[string]interface{}{"Ref": name}
+	}
+	return map[string]interface{}{"Fn::GetAtt": []interface{}{name, attr}}
+}
+
+// nestedStackGetAttParts returns the name and attribute of the
+// Fn::GetAtt value, which is either a list or a dotted string
+func nestedStackGetAttParts(value interface{}) (string, string) {
+	switch typedValue := value.(type) {
+	case []interface{}:
+		if len(typedValue) == 2 {
+			name, nameOk := typedValue[0].(string)
+			attr, attrOk := typedValue[1].(string)
+			if nameOk && attrOk {
+				return name, attr
+			}
+		}
+	case string:
+		parts := strings.SplitN(typedValue, ".", 2)
+		if len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+	}
+	return "", ""
+}
+
+// rewriteNestedStackSub rewrites the Fn::Sub variables that the
+// resolver replaces. Replaced variables are added to the Fn::Sub
+// variable map.
+func rewriteNestedStackSub(value interface{}, resolve nestedStackReferenceResolver) interface{} {
+	var subText string
+	subVariables := make(map[string]interface{})
+	switch typedValue := value.(type) {
+	case string:
+		subText = typedValue
+	case []interface{}:
+		if len(typedValue) != 2 {
+			return value
+		}
+		text, textOk := typedValue[0].(string)
+		variables, variablesOk := typedValue[1].(map[string]interface{})
+		if !textOk || !variablesOk {
+			return value
+		}
+		subText = text
+		for eachKey, eachValue := range variables {
+			subVariables[eachKey] = rewriteNestedStackReferences(eachValue, resolve)
+		}
+	default:
+		return value
+	}
+	userVariables := make(map[string]bool)
+	for eachKey := range subVariables {
+		userVariables[eachKey] = true
+	}
+	rewrittenText := nestedStackSubVariable.ReplaceAllStringFunc(subText, func(match string) string {
+		variable := strings.TrimSpace(match[2 : len(match)-1])
+		parts := strings.SplitN(variable, ".", 2)
+		if userVariables[parts[0]] {
+			return match
+		}
+		attr := ""
+		if len(parts) == 2 {
+			attr = parts[1]
+		}
+		variableName, variableValue, ok := resolve(parts[0], attr)
+		if !ok {
+			return match
+		}
+		subVariables[variableName] = variableValue
+		return fmt.Sprintf("${%s}", variableName)
+	})
+	if len(subVariables) == 0 {
+		return rewrittenText
+	}
+	return []interface{}{rewrittenText, subVariables}
+}
+
+// rewriteNestedStackReferences replaces the Ref, Fn::GetAtt and Fn::Sub
+// references in the value that the resolver replaces. The resolver
+// is called for every reference, so it's also used to find them.
+func rewriteNestedStackReferences(value interface{}, resolve nestedStackReferenceResolver) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if len(typedValue) == 1 {
+			if refName, ok := typedValue["Ref"].(string); ok {
+				_, replacement, replaced := resolve(refName, "")
+				if replaced {
+					return replacement
+				}
+				return typedValue
+			}
+			if getAtt, exists := typedValue["Fn::GetAtt"]; exists {
+				name, attr := nestedStackGetAttParts(getAtt)
+				if name != "" {
+					_, replacement, replaced := resolve(name, attr)
+					if replaced {
+						return replacement
+					}
+				}
+				return typedValue
+			}
+			if sub, exists := typedValue["Fn::Sub"]; exists {
+				typedValue["Fn::Sub"] = rewriteNestedStackSub(sub, resolve)
+				return typedValue
+			}
+		}
+		for eachKey, eachValue := range typedValue {
+			typedValue[eachKey] = rewriteNestedStackReferences(eachValue, resolve)
+		}
+	case []interface{}:
+		for eachIndex, eachValue := range typedValue {
+			typedValue[eachIndex] = rewriteNestedStackReferences(eachValue, resolve)
+		}
+	}
+	return value
+}
+
+// nestedStackContainsKey returns true if the key is used anywhere in
+// the value
+func nestedStackContainsKey(value interface{}, key string) bool {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for eachKey, eachValue := range typedValue {
+			if eachKey == key || nestedStackContainsKey(eachValue, key) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			if nestedStackContainsKey(eachValue, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nestedStackDependsOn returns the DependsOn resource names, which
+// are either a string or a list
+func nestedStackDependsOn(resource map[string]interface{}) []string {
+	var dependsOn []string
+	switch typedValue := resource["DependsOn"].(type) {
+	case string:
+		dependsOn = append(dependsOn, typedValue)
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			if eachName, ok := eachValue.(string); ok {
+				dependsOn = append(dependsOn, eachName)
+			}
+		}
+	}
+	return dependsOn
+}
+
+// setNestedStackDependsOn replaces the resource's DependsOn values with
+// the sorted, unique names
+func setNestedStackDependsOn(resource map[string]interface{}, dependsOn map[string]bool) {
+	delete(resource, "DependsOn")
+	if len(dependsOn) == 0 {
+		return
+	}
+	names := make([]string, 0, len(dependsOn))
+	for eachName := range dependsOn {
+		names = append(names, eachName)
+	}
+	sort.Strings(names)
+	values := make([]interface{}, len(names))
+	for eachIndex, eachName := range names {
+		values[eachIndex] = eachName
+	}
+	resource["DependsOn"] = values
+}
+
+// nestedStackAcyclic returns true if the stack dependency graph is
+// acyclic. Resources in a nested stack are represented by the stack,
+// so moving resources can create a cycle between the parent and a
+// nested stack.
+func nestedStackAcyclic(dependencies map[string][]string, stackNames map[string]string) bool {
+	node := func(name string) string {
+		if stackName, exists := stackNames[name]; exists {
+			return stackName
+		}
+		return name
+	}
+	edges := make(map[string]map[string]bool)
+	for eachName, eachDependencies := range dependencies {
+		from := node(eachName)
+		for _, eachDependency := range eachDependencies {
+			to := node(eachDependency)
+			if from == to {
+				continue
+			}
+			if edges[from] == nil {
+				edges[from] = make(map[string]bool)
+			}
+			edges[from][to] = true
+		}
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		switch state[name] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+		state[name] = visiting
+		for eachTarget := range edges[name] {
+			if !visit(eachTarget) {
+				return false
+			}
+		}
+		state[name] = visited
+		return true
+	}
+	for eachName := range edges {
+		if !visit(eachName) {
+			return false
+		}
+	}
+	return true
+}
+
+// nestedStackTemplateURL returns the regional S3 URL of the template
+func nestedStackTemplateURL(s3Bucket string, s3Key string) interface{} {
+	return map[string]interface{}{
+		"Fn::Join": []interface{}{"",
+			[]interface{}{
+				fmt.Sprintf("https://%s.s3.", s3Bucket),
+				map[string]interface{}{"Ref": "AWS::Region"},
+				".",
+				map[string]interface{}{"Ref": "AWS::URLSuffix"},
+				fmt.Sprintf("/%s", s3Key),
+			},
+		},
+	}
+}
+
+// splitNestedStacks moves groups of resources from a template that
+// exceeds the CloudFormation limits into AWS::CloudFormation::Stack
+// resources. Each group is a Lambda function and the resources that
+// only reference that function (eg, permissions, event source
+// mappings, log groups, versions and aliases). References across
+// stacks are passed as nested stack parameters and outputs. Resources
+// with a Condition or an Fn::If value, and groups that would create a
+// circular dependency, remain in the parent template. The template is
+// returned unchanged if it's within the limits.
+func splitNestedStacks(templateJSON []byte,
+	format string,
+	serviceName string,
+	s3Bucket string,
+	logger *logrus.Logger) ([]byte, []*nestedStackTemplate, error) {
+
+	limitsErr := validateTemplateBodyLimits(templateJSON, format, logger)
+	if limitsErr == nil {
+		return templateJSON, nil, nil
+	}
+	var templateMap map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(templateJSON))
+	// Preserve the integer formatting
+	decoder.UseNumber()
+	decodeErr := decoder.Decode(&templateMap)
+	if decodeErr != nil {
+		return nil, nil, errors.Wrapf(decodeErr, "Failed to parse template")
+	}
+	if _, exists := templateMap["Transform"]; exists {
+		return nil, nil, errors.Wrapf(limitsErr,
+			"Templates with a Transform can't be split into nested stacks")
+	}
+	resources, _ := templateMap["Resources"].(map[string]interface{})
+	parameters, _ := templateMap["Parameters"].(map[string]interface{})
+	resourceNames := make([]string, 0, len(resources))
+	typedResources := make(map[string]map[string]interface{})
+	for eachName, eachResource := range resources {
+		typedResource, ok := eachResource.(map[string]interface{})
+		if !ok {
+			return nil, nil, errors.Errorf("Resource %s is not an object", eachName)
+		}
+		typedResources[eachName] = typedResource
+		resourceNames = append(resourceNames, eachName)
+	}
+	sort.Strings(resourceNames)
+
+	// The resources that each resource depends on
+	dependencies := make(map[string][]string)
+	for _, eachName := range resourceNames {
+		resource := typedResources[eachName]
+		referenced := make(map[string]bool)
+		rewriteNestedStackReferences(resource, func(name string, attr string) (string, interface{}, bool) {
+			if _, exists := typedResources[name]; exists {
+				referenced[name] = true
+			}
+			return "", nil, false
+		})
+		for _, eachDependsOn := range nestedStackDependsOn(resource) {
+			referenced[eachDependsOn] = true
+		}
+		for eachReference := range referenced {
+			dependencies[eachName] = append(dependencies[eachName], eachReference)
+		}
+		sort.Strings(dependencies[eachName])
+	}
+	movable := func(name string) bool {
+		resource := typedResources[name]
+		_, hasCondition := resource["Condition"]
+		return !hasCondition && !nestedStackContainsKey(resource, "Fn::If")
+	}
+
+	// Group the functions and the resources that only reference them
+	owners := make(map[string]string)
+	var seeds []string
+	for _, eachName := range resourceNames {
+		if typedResources[eachName]["Type"] == "AWS::Lambda::Function" && movable(eachName) {
+			owners[eachName] = eachName
+			seeds = append(seeds, eachName)
+		}
+	}
+	for assigned := true; assigned; {
+		assigned = false
+		for _, eachName := range resourceNames {
+			if _, exists := owners[eachName]; exists || !movable(eachName) {
+				continue
+			}
+			resourceOwners := make(map[string]bool)
+			for _, eachDependency := range dependencies[eachName] {
+				if owner, exists := owners[eachDependency]; exists {
+					resourceOwners[owner] = true
+				}
+			}
+			if len(resourceOwners) == 1 {
+				for eachOwner := range resourceOwners {
+					owners[eachName] = eachOwner
+				}
+				assigned = true
+			}
+		}
+	}
+	groups := make(map[string][]string)
+	for _, eachName := range resourceNames {
+		if owner, exists := owners[eachName]; exists {
+			groups[owner] = append(groups[owner], eachName)
+		}
+	}
+
+	// Pack the groups into nested stacks
+	type nestedStack struct {
+		logicalName string
+		resources   []string
+		size        int
+	}
+	var nestedStacks []*nestedStack
+	stackNames := make(map[string]string)
+	newStack := func() *nestedStack {
+		return &nestedStack{
+			logicalName: fmt.Sprintf("%s%d", nestedStackNamePrefix, len(nestedStacks)+1),
+		}
+	}
+	tryAdd := func(stack *nestedStack, group []string, groupSize int) bool {
+		if len(stack.resources) != 0 &&
+			(len(stack.resources)+len(group) > nestedStackResourcesMaxCount ||
+				stack.size+groupSize > nestedStackBodyMaxSize) {
+			return false
+		}
+		for _, eachName := range group {
+			stackNames[eachName] = stack.logicalName
+		}
+		if !nestedStackAcyclic(dependencies, stackNames) {
+			for _, eachName := range group {
+				delete(stackNames, eachName)
+			}
+			return false
+		}
+		stack.resources = append(stack.resources, group...)
+		stack.size += groupSize
+		return true
+	}
+	for _, eachSeed := range seeds {
+		group := groups[eachSeed]
+		groupSize := 0
+		for _, eachName := range group {
+			resourceJSON, resourceJSONErr := json.Marshal(typedResources[eachName])
+			if resourceJSONErr != nil {
+				return nil, nil, errors.Wrapf(resourceJSONErr, "Failed to marshal resource %s", eachName)
+			}
+			groupSize += len(eachName) + len(resourceJSON)
+		}
+		if len(nestedStacks) != 0 && tryAdd(nestedStacks[len(nestedStacks)-1], group, groupSize) {
+			continue
+		}
+		stack := newStack()
+		if tryAdd(stack, group, groupSize) {
+			nestedStacks = append(nestedStacks, stack)
+			continue
+		}
+		logger.WithFields(logrus.Fields{
+			"LambdaFunction": eachSeed,
+		}).Debug("Function resources remain in the parent stack to avoid a circular dependency")
+	}
+	if len(nestedStacks) == 0 {
+		return nil, nil, errors.Wrapf(limitsErr,
+			"Failed to find resources that can be moved to a nested stack")
+	}
+	for _, eachStack := range nestedStacks {
+		if _, exists := typedResources[eachStack.logicalName]; exists {
+			return nil, nil, errors.Errorf("Nested stack name %s conflicts with an existing resource",
+				eachStack.logicalName)
+		}
+	}
+
+	// Wire up the references across stacks
+	variableReferences := make(map[string]string)
+	registerVariable := func(variableName string, name string, attr string) error {
+		reference := fmt.Sprintf("%s.%s", name, attr)
+		existing, exists := variableReferences[variableName]
+		if exists && existing != reference {
+			return errors.Errorf("Nested stack variable %s is used for both %s and %s",
+				variableName,
+				existing,
+				reference)
+		}
+		if _, isParameter := parameters[variableName]; isParameter {
+			return errors.Errorf("Nested stack variable %s for %s conflicts with a template parameter",
+				variableName,
+				reference)
+		}
+		variableReferences[variableName] = reference
+		return nil
+	}
+	stackOutputs := make(map[string]map[string]interface{})
+	// exportValue returns the parent stack value of the reference
+	exportValue := func(name string, attr string, variableName string) interface{} {
+		stackName, moved := stackNames[name]
+		if !moved {
+			return nestedStackReference(name, attr)
+		}
+		if stackOutputs[stackName] == nil {
+			stackOutputs[stackName] = make(map[string]interface{})
+		}
+		stackOutputs[stackName][variableName] = map[string]interface{}{
+			"Value": nestedStackReference(name, attr),
+		}
+		return nestedStackReference(stackName, fmt.Sprintf("Outputs.%s", variableName))
+	}
+	var wiringErr error
+	childTemplates := make(map[string]map[string]interface{})
+	stackResources := make(map[string]map[string]interface{})
+	for _, eachStack := range nestedStacks {
+		childParameters := make(map[string]interface{})
+		stackParameters := make(map[string]interface{})
+		stackDependsOn := make(map[string]bool)
+		resolve := func(name string, attr string) (string, interface{}, bool) {
+			if stackNames[name] == eachStack.logicalName {
+				return "", nil, false
+			}
+			if parameter, isParameter := parameters[name]; isParameter {
+				childParameters[name] = parameter
+				var value interface{} = map[string]interface{}{"Ref": name}
+				// Nested stack parameter values are strings
+				if typedParameter, ok := parameter.(map[string]interface{}); ok {
+					parameterType, _ := typedParameter["Type"].(string)
+					if parameterType == "CommaDelimitedList" || strings.HasPrefix(parameterType, "List<") {
+						value = map[string]interface{}{
+							"Fn::Join": []interface{}{",", value},
+						}
+					}
+				}
+				stackParameters[name] = value
+				return "", nil, false
+			}
+			_, isResource := typedResources[name]
+			if !isResource && !nestedStackPseudoParameters[name] {
+				return "", nil, false
+			}
+			variableName := nestedStackVariableName(name, attr)
+			registerErr := registerVariable(variableName, name, attr)
+			if registerErr != nil && wiringErr == nil {
+				wiringErr = registerErr
+			}
+			childParameters[variableName] = map[string]interface{}{
+				"Type": "String",
+			}
+			stackParameters[variableName] = exportValue(name, attr, variableName)
+			return variableName, map[string]interface{}{"Ref": variableName}, true
+		}
+		childResources := make(map[string]interface{})
+		for _, eachName := range eachStack.resources {
+			resource := typedResources[eachName]
+			rewriteNestedStackReferences(resource, resolve)
+			localDependsOn := make(map[string]bool)
+			for _, eachDependsOn := range nestedStackDependsOn(resource) {
+				stackName, moved := stackNames[eachDependsOn]
+				switch {
+				case stackName == eachStack.logicalName:
+					localDependsOn[eachDependsOn] = true
+				case moved:
+					stackDependsOn[stackName] = true
+				default:
+					stackDependsOn[eachDependsOn] = true
+				}
+			}
+			setNestedStackDependsOn(resource, localDependsOn)
+			childResources[eachName] = resource
+			delete(resources, eachName)
+		}
+		childTemplate := map[string]interface{}{
+			"AWSTemplateFormatVersion": "2010-09-09",
+			"Description": fmt.Sprintf("%s %s (%d resources)",
+				serviceName,
+				eachStack.logicalName,
+				len(eachStack.resources)),
+			"Resources": childResources,
+		}
+		if mappings, exists := templateMap["Mappings"]; exists {
+			childTemplate["Mappings"] = mappings
+		}
+		if len(childParameters) != 0 {
+			childTemplate["Parameters"] = childParameters
+		}
+		childTemplates[eachStack.logicalName] = childTemplate
+		stackProperties := make(map[string]interface{})
+		if len(stackParameters) != 0 {
+			stackProperties["Parameters"] = stackParameters
+		}
+		stackResource := map[string]interface{}{
+			"Type":       nestedStackResourceType,
+			"Properties": stackProperties,
+		}
+		delete(stackDependsOn, eachStack.logicalName)
+		setNestedStackDependsOn(stackResource, stackDependsOn)
+		stackResources[eachStack.logicalName] = stackResource
+	}
+
+	// Parent references to the moved resources use the nested
+	// stack outputs
+	resolveParent := func(name string, attr string) (string, interface{}, bool) {
+		if _, moved := stackNames[name]; !moved {
+			return "", nil, false
+		}
+		variableName := nestedStackVariableName(name, attr)
+		registerErr := registerVariable(variableName, name, attr)
+		if registerErr != nil && wiringErr == nil {
+			wiringErr = registerErr
+		}
+		return variableName, exportValue(name, attr, variableName), true
+	}
+	for eachName, eachResource := range resources {
+		typedResource := typedResources[eachName]
+		rewriteNestedStackReferences(eachResource, resolveParent)
+		dependsOn := make(map[string]bool)
+		for _, eachDependsOn := range nestedStackDependsOn(typedResource) {
+			if stackName, moved := stackNames[eachDependsOn]; moved {
+				dependsOn[stackName] = true
+			} else {
+				dependsOn[eachDependsOn] = true
+			}
+		}
+		setNestedStackDependsOn(typedResource, dependsOn)
+	}
+	if outputs, exists := templateMap["Outputs"]; exists {
+		rewriteNestedStackReferences(outputs, resolveParent)
+	}
+	if wiringErr != nil {
+		return nil, nil, wiringErr
+	}
+
+	// Marshal the nested stacks. The content addressed keys are used
+	// by the parent TemplateURL values.
+	var nestedTemplates []*nestedStackTemplate
+	for _, eachStack := range nestedStacks {
+		childTemplate := childTemplates[eachStack.logicalName]
+		if outputs, exists := stackOutputs[eachStack.logicalName]; exists {
+			childTemplate["Outputs"] = outputs
+		}
+		childJSON, childJSONErr := json.Marshal(childTemplate)
+		if childJSONErr != nil {
+			return nil, nil, errors.Wrapf(childJSONErr, "Failed to marshal nested stack %s", eachStack.logicalName)
+		}
+		// Nested stack templates are always uploaded as JSON
+		childLimitsErr := validateTemplateBodyLimits(childJSON, TemplateFormatJSON, logger)
+		if childLimitsErr != nil {
+			return nil, nil, errors.Wrapf(childLimitsErr, "Nested stack %s", eachStack.logicalName)
+		}
+		digest := sha256.Sum256(childJSON)
+		s3Key := fmt.Sprintf("%s/%s-%s-%s.json",
+			serviceName,
+			sanitizedName(serviceName),
+			eachStack.logicalName,
+			hex.EncodeToString(digest[:]))
+		stackResource := stackResources[eachStack.logicalName]
+		stackProperties := stackResource["Properties"].(map[string]interface{})
+		stackProperties["TemplateURL"] = nestedStackTemplateURL(s3Bucket, s3Key)
+		resources[eachStack.logicalName] = stackResource
+		nestedTemplates = append(nestedTemplates, &nestedStackTemplate{
+			logicalName: eachStack.logicalName,
+			s3Key:       s3Key,
+			body:        childJSON,
+		})
+		logger.WithFields(logrus.Fields{
+			"NestedStack": eachStack.logicalName,
+			"Resources":   len(eachStack.resources),
+			"Outputs":     len(stackOutputs[eachStack.logicalName]),
+		}).Info("Moved resources to nested stack")
+	}
+	parentJSON, parentJSONErr := json.Marshal(templateMap)
+	if parentJSONErr != nil {
+		return nil, nil, errors.Wrapf(parentJSONErr, "Failed to marshal parent template")
+	}
+	parentLimitsErr := validateTemplateBodyLimits(parentJSON, format, logger)
+	if parentLimitsErr != nil {
+		return nil, nil, errors.Wrapf(parentLimitsErr, "Parent template with %d nested stacks",
+			len(nestedStacks))
+	}
+	return parentJSON, nestedTemplates, nil
+}
+
+// publishNestedStackTemplates writes the nested stack templates to the
+// scratch directory and either adds them to the artifact manifest
+// or uploads them to their content addressed S3 keys
+func publishNestedStackTemplates(nestedTemplates []*nestedStackTemplate, ctx *workflowContext) error {
+	for _, eachTemplate := range nestedTemplates {
+		templateName := nestedTemplateName(ctx.userdata.serviceName,
+			eachTemplate.logicalName)
+		templateFile, templateFileErr := system.TemporaryFile(ScratchDirectory, templateName)
+		if nil != templateFileErr {
+			return templateFileErr
+		}
+		templateFile.Close()
+		writeErr := ioutil.WriteFile(templateFile.Name(), eachTemplate.body, 0644)
+		if nil != writeErr {
+			return errors.Wrapf(writeErr, "Failed to write nested stack template")
+		}
+		if ctx.buildingArtifacts() {
+			artifact, artifactErr := moveArtifact(templateFile.Name(), eachTemplate.s3Key, ctx)
+			if nil != artifactErr {
+				return artifactErr
+			}
+			ctx.context.artifactManifest.NestedTemplates = append(ctx.context.artifactManifest.NestedTemplates,
+				artifact)
+			continue
+		}
+		// Templates that are referenced by a deployed stack must not be
+		// deleted by a rollback, so existing objects are reused
+		if !ctx.userdata.noop {
+			existingURL, existingURLErr := spartaS3.ExistingObjectURL(ctx.context.awsSession,
+				ctx.userdata.s3Bucket,
+				eachTemplate.s3Key,
+				ctx.logger)
+			if existingURLErr != nil {
+				return errors.Wrapf(existingURLErr, "Failed to check for existing nested stack template")
+			}
+			if existingURL != "" {
+				ctx.registerFileCleanupFinalizer(templateFile.Name())
+				ctx.logger.WithFields(logrus.Fields{
+					"Key": eachTemplate.s3Key,
+				}).Info("Nested stack template unchanged. Reusing existing S3 object")
+				continue
+			}
+		}
+		_, uploadErr := uploadLocalFileToS3(templateFile.Name(), eachTemplate.s3Key, ctx)
+		if nil != uploadErr {
+			return uploadErr
+		}
+	}
+	return nil
+}
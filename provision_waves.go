@@ -0,0 +1,240 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// provisioningWaveMembers returns the map of wave number to the sorted
+// logical resource names in that wave. Functions with a zero
+// ProvisioningWave are not staged.
+func provisioningWaveMembers(lambdaAWSInfos []*LambdaAWSInfo,
+	template *gocf.Template) (map[int][]string, error) {
+	waves := make(map[int][]string)
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.ProvisioningWave == 0 {
+			if len(eachLambda.ProvisioningWaveResources) != 0 {
+				return nil, errors.Errorf("Lambda %s defines ProvisioningWaveResources without a ProvisioningWave",
+					eachLambda.lambdaFunctionName())
+			}
+			continue
+		}
+		if eachLambda.ProvisioningWave < 0 {
+			return nil, errors.Errorf("Lambda %s ProvisioningWave (%d) must not be negative",
+				eachLambda.lambdaFunctionName(),
+				eachLambda.ProvisioningWave)
+		}
+		members := []string{eachLambda.LogicalResourceName()}
+		for _, eachCustomResource := range eachLambda.customResources {
+			members = append(members, eachCustomResource.logicalName())
+		}
+		for _, eachResourceName := range eachLambda.ProvisioningWaveResources {
+			_, exists := template.Resources[eachResourceName]
+			if !exists {
+				return nil, errors.Errorf("Lambda %s ProvisioningWaveResources entry %s is not a template resource",
+					eachLambda.lambdaFunctionName(),
+					eachResourceName)
+			}
+			members = append(members, eachResourceName)
+		}
+		waves[eachLambda.ProvisioningWave] = append(waves[eachLambda.ProvisioningWave], members...)
+	}
+	for eachWave, eachMembers := range waves {
+		sort.Strings(eachMembers)
+		waves[eachWave] = eachMembers
+	}
+	return waves, nil
+}
+
+// collectResourceReferences appends the logical names referenced by
+// Ref and Fn::GetAtt in the JSON value
+func collectResourceReferences(value interface{}, references map[string]bool) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		for eachKey, eachValue := range typedValue {
+			switch eachKey {
+			case "Ref":
+				refName, refNameOk := eachValue.(string)
+				if refNameOk && !strings.HasPrefix(refName, "AWS::") {
+					references[refName] = true
+				}
+			case "Fn::GetAtt":
+				attValues, attValuesOk := eachValue.([]interface{})
+				if attValuesOk && len(attValues) != 0 {
+					attName, attNameOk := attValues[0].(string)
+					if attNameOk {
+						references[attName] = true
+					}
+				}
+			}
+			collectResourceReferences(eachValue, references)
+		}
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			collectResourceReferences(eachValue, references)
+		}
+	}
+}
+
+// resourceDependencyGraph returns the explicit (DependsOn) and implicit
+// (Ref, Fn::GetAtt) dependencies between template resources
+func resourceDependencyGraph(template *gocf.Template) (map[string][]string, error) {
+	graph := make(map[string][]string, len(template.Resources))
+	for eachName, eachResource := range template.Resources {
+		references := make(map[string]bool)
+		for _, eachDependency := range eachResource.DependsOn {
+			references[eachDependency] = true
+		}
+		propertiesJSON, propertiesJSONErr := json.Marshal(eachResource.Properties)
+		if propertiesJSONErr != nil {
+			return nil, errors.Wrapf(propertiesJSONErr,
+				"Failed to marshal resource %s", eachName)
+		}
+		var properties interface{}
+		unmarshalErr := json.Unmarshal(propertiesJSON, &properties)
+		if unmarshalErr != nil {
+			return nil, errors.Wrapf(unmarshalErr,
+				"Failed to unmarshal resource %s", eachName)
+		}
+		collectResourceReferences(properties, references)
+		for eachReference := range references {
+			_, exists := template.Resources[eachReference]
+			if exists && eachReference != eachName {
+				graph[eachName] = append(graph[eachName], eachReference)
+			}
+		}
+		sort.Strings(graph[eachName])
+	}
+	return graph, nil
+}
+
+// dependencyCycle returns the first cycle found in the graph, or nil
+func dependencyCycle(graph map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(graph))
+	var path []string
+	var visit func(node string) []string
+	visit = func(node string) []string {
+		state[node] = visiting
+		path = append(path, node)
+		for _, eachDependency := range graph[node] {
+			switch state[eachDependency] {
+			case visiting:
+				for i, eachPathNode := range path {
+					if eachPathNode == eachDependency {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, eachDependency)
+					}
+				}
+			case unvisited:
+				cycle := visit(eachDependency)
+				if cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+	nodes := make([]string, 0, len(graph))
+	for eachNode := range graph {
+		nodes = append(nodes, eachNode)
+	}
+	sort.Strings(nodes)
+	for _, eachNode := range nodes {
+		if state[eachNode] == unvisited {
+			cycle := visit(eachNode)
+			if cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// annotateProvisioningWaves adds DependsOn edges so that every resource
+// in provisioning wave N depends on the resources in the preceding wave.
+// It's an error if a resource in an earlier wave depends on a resource
+// in a later wave.
+func annotateProvisioningWaves(lambdaAWSInfos []*LambdaAWSInfo,
+	template *gocf.Template,
+	logger *logrus.Logger) error {
+	waves, wavesErr := provisioningWaveMembers(lambdaAWSInfos, template)
+	if wavesErr != nil {
+		return wavesErr
+	}
+	if len(waves) == 0 {
+		return nil
+	}
+	waveNumbers := make([]int, 0, len(waves))
+	for eachWave := range waves {
+		waveNumbers = append(waveNumbers, eachWave)
+	}
+	sort.Ints(waveNumbers)
+
+	resourceWave := make(map[string]int)
+	for _, eachWave := range waveNumbers {
+		for _, eachMember := range waves[eachWave] {
+			existingWave, exists := resourceWave[eachMember]
+			if exists && existingWave != eachWave {
+				return errors.Errorf("Resource %s is in both provisioning wave %d and %d",
+					eachMember,
+					existingWave,
+					eachWave)
+			}
+			resourceWave[eachMember] = eachWave
+		}
+	}
+
+	for i := 1; i < len(waveNumbers); i++ {
+		previousMembers := waves[waveNumbers[i-1]]
+		for _, eachMember := range waves[waveNumbers[i]] {
+			resource, exists := template.Resources[eachMember]
+			if !exists {
+				continue
+			}
+			existingDependencies := make(map[string]bool, len(resource.DependsOn))
+			for _, eachDependency := range resource.DependsOn {
+				existingDependencies[eachDependency] = true
+			}
+			for _, eachPrevious := range previousMembers {
+				if !existingDependencies[eachPrevious] {
+					resource.DependsOn = append(resource.DependsOn, eachPrevious)
+				}
+			}
+		}
+		logger.WithFields(logrus.Fields{
+			"Wave":      waveNumbers[i],
+			"DependsOn": previousMembers,
+			"Resources": waves[waveNumbers[i]],
+		}).Debug("Provisioning wave dependencies")
+	}
+
+	// Any cycles are the result of a resource in an earlier wave
+	// that depends on a resource in a later one
+	graph, graphErr := resourceDependencyGraph(template)
+	if graphErr != nil {
+		return graphErr
+	}
+	cycle := dependencyCycle(graph)
+	if cycle != nil {
+		return errors.Errorf("Provisioning waves create a dependency cycle: %s",
+			strings.Join(cycle, " -> "))
+	}
+	logger.WithFields(logrus.Fields{
+		"Waves": waveNumbers,
+	}).Info("Provisioning waves")
+	return nil
+}
@@ -142,7 +142,7 @@ func visitResolvedEventSourceMapping(visitor resolvedResourceVisitor,
 	// find the referred resource and supply it to the visitor
 	for _, eachLambda := range lambdaAWSInfos {
 		for eachIndex, eachEventSource := range eachLambda.EventSourceMappings {
-			resourceRef, resourceRefErr := resolveResourceRef(eachEventSource.EventSourceArn)
+			resourceRef, resourceRefErr := resolveResourceRef(eachEventSource.templateEventSourceArn(template))
 			if resourceRefErr != nil {
 				return errors.Wrapf(resourceRefErr,
 					"Failed to resolve EventSourceArn: %#v", eachEventSource)
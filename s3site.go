@@ -26,6 +26,33 @@ type S3Site struct {
 	// values will be scoped to a `userdata` key in the MANIFEST.json
 	// object
 	UserManifestData map[string]interface{}
+	// RequiredOutputs are the template Output keys (eg, the API Gateway
+	// URL) that the site expects in its MANIFEST.json data. Provisioning
+	// fails if any of them isn't defined.
+	RequiredOutputs []string
+	// Excludes are gitignore-style patterns (eg, ".DS_Store", "node_modules/",
+	// "*.map") evaluated against each path relative to the resources
+	// directory. Matching files and directories aren't deployed.
+	Excludes []string
+	// CloudFront optionally provisions a CloudFront distribution in front
+	// of the site bucket. If nil, the bucket is a public S3 website.
+	CloudFront *S3SiteCloudFront
+}
+
+// S3SiteCloudFront provisions a CloudFront distribution whose origin is the
+// S3Site bucket. The bucket isn't public and is only readable by the
+// distribution's Origin Access Identity. Viewers are redirected to HTTPS.
+// Since the origin is the bucket's REST endpoint rather than the website
+// endpoint, only the root index document is resolved.
+type S3SiteCloudFront struct {
+	// CertificateArn is the ACM certificate (in us-east-1) for the
+	// Aliases. If nil, the default *.cloudfront.net certificate is used.
+	CertificateArn gocf.Stringable
+	// Aliases are the alternate domain names served by the distribution.
+	// Defaults to the S3Site BucketName if CertificateArn is set.
+	Aliases []string
+	// PriceClass is the optional distribution price class (eg, "PriceClass_100")
+	PriceClass string
 }
 
 // CloudFormationS3ResourceName returns the stable CloudformationResource name that
@@ -33,3 +60,9 @@ type S3Site struct {
 func (s3Site *S3Site) CloudFormationS3ResourceName() string {
 	return stableCloudformationResourceName("S3Site")
 }
+
+// CloudFormationDistributionResourceName returns the stable CloudformationResource
+// name of the optional CloudFront distribution
+func (s3Site *S3Site) CloudFormationDistributionResourceName() string {
+	return stableCloudformationResourceName("S3SiteDistribution")
+}
@@ -3,6 +3,9 @@
 package sparta
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	cfCustomResources "github.com/mweagle/Sparta/aws/cloudformation/resources"
@@ -17,6 +20,12 @@ const (
 	// that stores the S3 backed static site provisioned with this Sparta application
 	// @enum OutputKey
 	OutputS3SiteURL = "S3SiteURL"
+
+	// OutputS3SiteCloudFrontDomainName is the keyname used in the CloudFormation
+	// Output that stores the domain name of the optional S3Site CloudFront
+	// distribution
+	// @enum OutputKey
+	OutputS3SiteCloudFrontDomainName = "S3SiteCloudFrontDomainName"
 )
 
 // Create the resource, which will be part of the stack definition and use a CustomResource
@@ -62,15 +71,15 @@ func (s3Site *S3Site) export(serviceName string,
 
 	//////////////////////////////////////////////////////////////////////////////
 	// 1 - Create the S3 bucket.  The "BucketName" property is empty s.t.
-	// AWS will assign a unique one.
-
-	s3WebsiteConfig := &gocf.S3BucketWebsiteConfiguration{
-		ErrorDocument: gocf.String(aws.StringValue(s3Site.WebsiteConfiguration.ErrorDocument.Key)),
-		IndexDocument: gocf.String(aws.StringValue(s3Site.WebsiteConfiguration.IndexDocument.Suffix)),
-	}
-	s3Bucket := &gocf.S3Bucket{
-		AccessControl:        gocf.String("PublicRead"),
-		WebsiteConfiguration: s3WebsiteConfig,
+	// AWS will assign a unique one. If there's a CloudFront distribution, the
+	// bucket isn't public and doesn't need a website configuration.
+	s3Bucket := &gocf.S3Bucket{}
+	if s3Site.CloudFront == nil {
+		s3Bucket.AccessControl = gocf.String("PublicRead")
+		s3Bucket.WebsiteConfiguration = &gocf.S3BucketWebsiteConfiguration{
+			ErrorDocument: gocf.String(aws.StringValue(s3Site.WebsiteConfiguration.ErrorDocument.Key)),
+			IndexDocument: gocf.String(aws.StringValue(s3Site.WebsiteConfiguration.IndexDocument.Suffix)),
+		}
 	}
 	if s3Site.BucketName != nil {
 		s3Bucket.BucketName = s3Site.BucketName
@@ -79,9 +88,11 @@ func (s3Site *S3Site) export(serviceName string,
 	cfResource := template.AddResource(s3BucketResourceName, s3Bucket)
 	cfResource.DeletionPolicy = "Delete"
 
-	template.Outputs[OutputS3SiteURL] = &gocf.Output{
-		Description: "S3 Website URL",
-		Value:       gocf.GetAtt(s3BucketResourceName, "WebsiteURL"),
+	if s3Site.CloudFront == nil {
+		template.Outputs[OutputS3SiteURL] = &gocf.Output{
+			Description: "S3 Website URL",
+			Value:       gocf.GetAtt(s3BucketResourceName, "WebsiteURL"),
+		}
 	}
 
 	// Represents the S3 ARN that is provisioned
@@ -97,21 +108,33 @@ func (s3Site *S3Site) export(serviceName string,
 	// 2 - Add a bucket policy to enable anonymous access, as the PublicRead
 	// canned ACL doesn't seem to do what is implied.
 	// TODO - determine if this is needed or if PublicRead is being misued
+	// If there's a CloudFront distribution, only its Origin Access Identity
+	// can read the bucket.
+	readStatement := ArbitraryJSONObject{
+		"Sid":    "PublicReadGetObject",
+		"Effect": "Allow",
+		"Principal": ArbitraryJSONObject{
+			"AWS": "*",
+		},
+		"Action":   "s3:GetObject",
+		"Resource": s3SiteBucketAllKeysResourceValue,
+	}
+	if s3Site.CloudFront != nil {
+		identityResourceName, identityErr := s3Site.exportCloudFrontDistribution(s3BucketResourceName,
+			template)
+		if identityErr != nil {
+			return identityErr
+		}
+		readStatement["Sid"] = "CloudFrontReadGetObject"
+		readStatement["Principal"] = ArbitraryJSONObject{
+			"CanonicalUser": gocf.GetAtt(identityResourceName, "S3CanonicalUserId"),
+		}
+	}
 	s3SiteBucketPolicy := &gocf.S3BucketPolicy{
 		Bucket: gocf.Ref(s3BucketResourceName).String(),
 		PolicyDocument: ArbitraryJSONObject{
-			"Version": "2012-10-17",
-			"Statement": []ArbitraryJSONObject{
-				{
-					"Sid":    "PublicReadGetObject",
-					"Effect": "Allow",
-					"Principal": ArbitraryJSONObject{
-						"AWS": "*",
-					},
-					"Action":   "s3:GetObject",
-					"Resource": s3SiteBucketAllKeysResourceValue,
-				},
-			},
+			"Version":   "2012-10-17",
+			"Statement": []ArbitraryJSONObject{readStatement},
 		},
 	}
 	s3BucketPolicyResourceName := stableCloudformationResourceName("S3SiteBucketPolicy")
@@ -231,8 +254,12 @@ func (s3Site *S3Site) export(serviceName string,
 	zipResource.DestBucket = gocf.Ref(s3BucketResourceName).String()
 
 	// Build the manifest data with any output info...
+	manifestOutputs, manifestOutputsErr := s3Site.manifestOutputs(apiGatewayOutputs, template)
+	if manifestOutputsErr != nil {
+		return manifestOutputsErr
+	}
 	manifestData := make(map[string]interface{})
-	for eachKey, eachOutput := range apiGatewayOutputs {
+	for eachKey, eachOutput := range manifestOutputs {
 		manifestData[eachKey] = map[string]interface{}{
 			"Description": eachOutput.Description,
 			"Value":       eachOutput.Value,
@@ -251,6 +278,140 @@ func (s3Site *S3Site) export(serviceName string,
 	return nil
 }
 
+// exportCloudFrontDistribution adds the CloudFront distribution and the Origin
+// Access Identity that reads the site bucket. It returns the Origin Access
+// Identity resource name so that the bucket policy can grant it access.
+func (s3Site *S3Site) exportCloudFrontDistribution(s3BucketResourceName string,
+	template *gocf.Template) (string, error) {
+
+	var aliases *gocf.StringListExpr
+	if len(s3Site.CloudFront.Aliases) != 0 {
+		aliases = gocf.StringList()
+		for _, eachAlias := range s3Site.CloudFront.Aliases {
+			aliases.Literal = append(aliases.Literal, gocf.String(eachAlias))
+		}
+	}
+	viewerCert := &gocf.CloudFrontDistributionViewerCertificate{
+		CloudFrontDefaultCertificate: gocf.Bool(true),
+	}
+	if s3Site.CloudFront.CertificateArn != nil {
+		if aliases == nil && s3Site.BucketName != nil {
+			aliases = gocf.StringList(s3Site.BucketName)
+		}
+		if aliases == nil {
+			return "", errors.Errorf("S3Site CloudFront CertificateArn requires either Aliases or a BucketName")
+		}
+		viewerCert = &gocf.CloudFrontDistributionViewerCertificate{
+			AcmCertificateArn:      s3Site.CloudFront.CertificateArn.String(),
+			SslSupportMethod:       gocf.String("sni-only"),
+			MinimumProtocolVersion: gocf.String("TLSv1.2_2018"),
+		}
+	}
+
+	identityResourceName := stableCloudformationResourceName("S3SiteOriginAccessIdentity")
+	identity := &gocf.CloudFrontCloudFrontOriginAccessIdentity{
+		CloudFrontOriginAccessIdentityConfig: &gocf.CloudFrontCloudFrontOriginAccessIdentityCloudFrontOriginAccessIdentityConfig{
+			Comment: gocf.Join("",
+				gocf.String("S3 site access identity for "),
+				gocf.Ref(s3BucketResourceName)),
+		},
+	}
+	template.AddResource(identityResourceName, identity)
+
+	// Missing keys are a 403 from the REST endpoint, so both
+	// errors are served with the ErrorDocument
+	errorPagePath := gocf.String("/" +
+		aws.StringValue(s3Site.WebsiteConfiguration.ErrorDocument.Key))
+	errorResponses := gocf.CloudFrontDistributionCustomErrorResponseList{}
+	for _, eachErrorCode := range []int64{403, 404} {
+		errorResponses = append(errorResponses,
+			gocf.CloudFrontDistributionCustomErrorResponse{
+				ErrorCode:        gocf.Integer(eachErrorCode),
+				ResponseCode:     gocf.Integer(404),
+				ResponsePagePath: errorPagePath,
+			})
+	}
+	distroConfig := &gocf.CloudFrontDistributionDistributionConfig{
+		Aliases:              aliases,
+		CustomErrorResponses: &errorResponses,
+		DefaultRootObject:    gocf.String(aws.StringValue(s3Site.WebsiteConfiguration.IndexDocument.Suffix)),
+		Enabled:              gocf.Bool(true),
+		Origins: &gocf.CloudFrontDistributionOriginList{
+			gocf.CloudFrontDistributionOrigin{
+				DomainName: gocf.GetAtt(s3BucketResourceName, "RegionalDomainName"),
+				ID:         gocf.String("S3SiteOrigin"),
+				S3OriginConfig: &gocf.CloudFrontDistributionS3OriginConfig{
+					OriginAccessIdentity: gocf.Join("",
+						gocf.String("origin-access-identity/cloudfront/"),
+						gocf.Ref(identityResourceName)),
+				},
+			},
+		},
+		DefaultCacheBehavior: &gocf.CloudFrontDistributionDefaultCacheBehavior{
+			Compress: gocf.Bool(true),
+			ForwardedValues: &gocf.CloudFrontDistributionForwardedValues{
+				QueryString: gocf.Bool(false),
+			},
+			TargetOriginID:       gocf.String("S3SiteOrigin"),
+			ViewerProtocolPolicy: gocf.String("redirect-to-https"),
+		},
+		ViewerCertificate: viewerCert,
+	}
+	if s3Site.CloudFront.PriceClass != "" {
+		distroConfig.PriceClass = gocf.String(s3Site.CloudFront.PriceClass)
+	}
+	distroResourceName := s3Site.CloudFormationDistributionResourceName()
+	template.AddResource(distroResourceName, &gocf.CloudFrontDistribution{
+		DistributionConfig: distroConfig,
+	})
+	template.Outputs[OutputS3SiteCloudFrontDomainName] = &gocf.Output{
+		Description: "S3 Site CloudFront distribution domain name",
+		Value:       gocf.GetAtt(distroResourceName, "DomainName"),
+	}
+	return identityResourceName, nil
+}
+
+// manifestOutputs returns the outputs to include in the site MANIFEST.json.
+// Every RequiredOutputs key must be defined by either the API Gateway
+// or the service template so that the site doesn't have a dangling
+// reference after it's deployed.
+func (s3Site *S3Site) manifestOutputs(apiGatewayOutputs map[string]*gocf.Output,
+	template *gocf.Template) (map[string]*gocf.Output, error) {
+	outputs := make(map[string]*gocf.Output, len(apiGatewayOutputs))
+	for eachKey, eachOutput := range apiGatewayOutputs {
+		outputs[eachKey] = eachOutput
+	}
+	var missingOutputs []string
+	for _, eachRequired := range s3Site.RequiredOutputs {
+		_, exists := outputs[eachRequired]
+		if exists {
+			continue
+		}
+		templateOutput, templateOutputExists := template.Outputs[eachRequired]
+		if templateOutputExists {
+			outputs[eachRequired] = templateOutput
+			continue
+		}
+		missingOutputs = append(missingOutputs, eachRequired)
+	}
+	if len(missingOutputs) != 0 {
+		availableOutputs := make([]string, 0, len(outputs)+len(template.Outputs))
+		for eachKey := range outputs {
+			availableOutputs = append(availableOutputs, eachKey)
+		}
+		for eachKey := range template.Outputs {
+			if _, exists := outputs[eachKey]; !exists {
+				availableOutputs = append(availableOutputs, eachKey)
+			}
+		}
+		sort.Strings(availableOutputs)
+		return nil, errors.Errorf("S3 site requires output(s) that are not defined: %s. Available outputs: %s",
+			strings.Join(missingOutputs, ", "),
+			strings.Join(availableOutputs, ", "))
+	}
+	return outputs, nil
+}
+
 // NewS3Site returns a new S3Site pointer initialized with the
 // static resources at the supplied path.  If resources is a directory,
 // the contents will be recursively archived and used to populate
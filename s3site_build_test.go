@@ -0,0 +1,122 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"strings"
+	"testing"
+
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+)
+
+func TestS3SiteCloudFront(t *testing.T) {
+	exportSite := func(site *S3Site) (*gocf.Template, error) {
+		template := gocf.NewTemplate()
+		exportErr := site.export("TestS3SiteCloudFront",
+			"bootstrap",
+			"testBucket",
+			"testKey.zip",
+			"testResources.zip",
+			nil,
+			nil,
+			template,
+			logrus.New())
+		return template, exportErr
+	}
+	s3Site, _ := NewS3Site("./site")
+	template, templateErr := exportSite(s3Site)
+	if templateErr != nil {
+		t.Fatalf("Failed to export S3 site: %s", templateErr)
+	}
+	if _, exists := template.Resources[s3Site.CloudFormationDistributionResourceName()]; exists {
+		t.Fatalf("Unexpected CloudFront distribution for plain S3 site")
+	}
+	if _, exists := template.Outputs[OutputS3SiteURL]; !exists {
+		t.Fatalf("Missing S3 site URL output")
+	}
+
+	s3Site.CloudFront = &S3SiteCloudFront{
+		CertificateArn: gocf.String("arn:aws:acm:us-east-1:123412341234:certificate/test"),
+	}
+	_, templateErr = exportSite(s3Site)
+	if templateErr == nil {
+		t.Fatalf("Expected error for CloudFront certificate without an alias")
+	}
+	s3Site.BucketName = gocf.String("site.example.com")
+	template, templateErr = exportSite(s3Site)
+	if templateErr != nil {
+		t.Fatalf("Failed to export S3 site with CloudFront: %s", templateErr)
+	}
+	distroResource, exists := template.Resources[s3Site.CloudFormationDistributionResourceName()]
+	if !exists {
+		t.Fatalf("Missing CloudFront distribution")
+	}
+	distroConfig := distroResource.Properties.(*gocf.CloudFrontDistribution).DistributionConfig
+	if distroConfig.ViewerCertificate.AcmCertificateArn == nil ||
+		distroConfig.Aliases == nil {
+		t.Fatalf("Expected CloudFront distribution certificate and alias")
+	}
+	if _, exists := template.Outputs[OutputS3SiteCloudFrontDomainName]; !exists {
+		t.Fatalf("Missing CloudFront domain name output")
+	}
+	if _, exists := template.Outputs[OutputS3SiteURL]; exists {
+		t.Fatalf("Unexpected S3 site URL output for CloudFront site")
+	}
+	bucket := template.Resources[s3Site.CloudFormationS3ResourceName()].Properties.(*gocf.S3Bucket)
+	if bucket.AccessControl != nil {
+		t.Fatalf("Expected private bucket for CloudFront site")
+	}
+}
+
+func TestS3SiteRequiredOutputs(t *testing.T) {
+	apiGatewayOutputs := map[string]*gocf.Output{
+		OutputAPIGatewayURL: {
+			Description: "API Gateway URL",
+			Value:       gocf.String("https://api.example.com"),
+		},
+	}
+	template := gocf.NewTemplate()
+	template.Outputs["TemplateOutput"] = &gocf.Output{
+		Description: "Template output",
+		Value:       gocf.String("value"),
+	}
+	s3Site, _ := NewS3Site("./site")
+
+	// API Gateway and template outputs both satisfy the requirement
+	s3Site.RequiredOutputs = []string{OutputAPIGatewayURL, "TemplateOutput"}
+	outputs, outputsErr := s3Site.manifestOutputs(apiGatewayOutputs, template)
+	if outputsErr != nil {
+		t.Fatalf("Failed to resolve required outputs: %s", outputsErr)
+	}
+	for _, eachRequired := range s3Site.RequiredOutputs {
+		if _, exists := outputs[eachRequired]; !exists {
+			t.Fatalf("Missing manifest output: %s", eachRequired)
+		}
+	}
+
+	// A missing output is reported by name
+	s3Site.RequiredOutputs = []string{OutputAPIGatewayURL, "MissingOutput"}
+	_, outputsErr = s3Site.manifestOutputs(apiGatewayOutputs, template)
+	if outputsErr == nil {
+		t.Fatalf("Expected error for missing required output")
+	}
+	if !strings.Contains(outputsErr.Error(), "MissingOutput") {
+		t.Fatalf("Error doesn't include the missing output name: %s", outputsErr)
+	}
+
+	// The export reports the missing output
+	exportTemplate := gocf.NewTemplate()
+	exportErr := s3Site.export("TestS3SiteRequiredOutputs",
+		"bootstrap",
+		"testBucket",
+		"testKey.zip",
+		"testResources.zip",
+		apiGatewayOutputs,
+		nil,
+		exportTemplate,
+		logrus.New())
+	if exportErr == nil {
+		t.Fatalf("Expected export error for missing required output")
+	}
+}
@@ -11,11 +11,14 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	spartaIAM "github.com/mweagle/Sparta/aws/iam"
+	spartaS3 "github.com/mweagle/Sparta/aws/s3"
+	"github.com/mweagle/Sparta/system"
 	gocc "github.com/mweagle/go-cloudcondenser"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/pkg/errors"
@@ -34,6 +37,10 @@ func customResourceProvider(resourceType string) gocf.ResourceProperties {
 		{
 			return &cloudFormationLambdaCustomResource{}
 		}
+	case cloudFormationLambdaURLType:
+		{
+			return &cloudFormationLambdaURL{}
+		}
 	default:
 		return nil
 	}
@@ -167,9 +174,21 @@ var CommonIAMStatements = struct {
 // RE for sanitizing names
 var reSanitize = regexp.MustCompile(`\W+`)
 
+// RE for AWS service principals
+var reServicePrincipal = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)*\.amazonaws\.com(\.cn)?$`)
+
+// RE for IAM managed policy ARNs
+var reIAMPolicyArn = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(aws|\d{12}):policy/.+$`)
+
 // Wildcard ARN for any AWS resource
 var wildcardArn = gocf.String("*")
 
+// defaultAssumeRolePrincipals are the service principals that may
+// assume the Sparta-generated IAM roles
+var defaultAssumeRolePrincipals = []string{LambdaPrincipal,
+	EC2Principal,
+	APIGatewayPrincipal}
+
 // AssumePolicyDocument defines common a IAM::Role PolicyDocument
 // used as part of IAM::Role resource definitions
 var AssumePolicyDocument = ArbitraryJSONObject{
@@ -178,9 +197,7 @@ var AssumePolicyDocument = ArbitraryJSONObject{
 		{
 			"Effect": "Allow",
 			"Principal": ArbitraryJSONObject{
-				"Service": []string{LambdaPrincipal,
-					EC2Principal,
-					APIGatewayPrincipal},
+				"Service": defaultAssumeRolePrincipals,
 			},
 			"Action": []string{"sts:AssumeRole"},
 		},
@@ -222,27 +239,120 @@ type LambdaFunctionOptions struct {
 	MemorySize int64
 	// Timeout (seconds)
 	Timeout int64
-	// VPC Settings
+	// VPC Settings. At least one subnet and one security group are required.
+	// Sparta-generated roles include the CommonIAMStatements.VPC
+	// network interface privileges.
 	VpcConfig *gocf.LambdaFunctionVPCConfig
 	// Environment Variables
 	Environment map[string]*gocf.StringExpr
 	// KMS Key Arn used to encrypt environment variables
 	KmsKeyArn string
-	// The maximum of concurrent executions you want reserved for the function
+	// KmsKey is the customer managed KMS key used to encrypt the
+	// environment variables, including the Sparta-managed values. Unlike
+	// KmsKeyArn, it may reference a key defined in the same template
+	// (eg, gocf.GetAtt(keyName, "Arn")). It takes precedence over KmsKeyArn.
+	// If the function uses a RoleDefinition, the role is granted
+	// kms:Decrypt on the key.
+	KmsKey gocf.Stringable
+	// The maximum of concurrent executions you want reserved for the function.
+	// Deprecated: prefer ReservedConcurrency, which can reserve zero
+	// executions. Non-zero values are used iff ReservedConcurrency is nil.
 	ReservedConcurrentExecutions int64
+	// ReservedConcurrency is the optional number of concurrent executions
+	// reserved for the function. A nil value doesn't reserve concurrency,
+	// while zero throttles all invocations.
+	ReservedConcurrency *int64
 	// DeadLetterConfigArn is how Lambda handles events that it can't process.If
 	// you don't specify a Dead Letter Queue (DLQ) configuration, Lambda
 	// discards events after the maximum number of retries. For more information,
-	// see Dead Letter Queues in the AWS Lambda Developer Guide.
+	// see Dead Letter Queues in the AWS Lambda Developer Guide. The value
+	// is either a literal SNS topic or SQS queue ARN or a reference
+	// (eg, gocf.GetAtt(queueName, "Arn")). If the function uses a
+	// RoleDefinition, the role is granted permission to publish
+	// to the target.
 	DeadLetterConfigArn gocf.Stringable
 	// Tags to associate with the Lambda function
 	Tags map[string]string
-	// Tracing options for XRay
+	// LogRetentionInDays is the number of days to retain the function's
+	// log events. Zero uses WorkflowHooks.LogRetentionInDays, which
+	// defaults to never expiring the events. If non-zero, the
+	// /aws/lambda/<functionName> log group is created by the stack, so
+	// it must not already exist. Delete any log group that Lambda
+	// implicitly created before provisioning the setting.
+	LogRetentionInDays int64
+	// EphemeralStorageSize is the size, in MB, of the function's /tmp
+	// directory. Zero uses the 512MB default and omits the property.
+	// Non-zero values must be between 512 and 10240.
+	EphemeralStorageSize int64
+	// Tracing options for XRay. The Mode is either Active or PassThrough.
+	// Sparta-generated roles always include the X-Ray statements
+	// in CommonIAMStatements.Core, so no additional
+	// privileges are required.
 	TracingConfig *gocf.LambdaFunctionTracingConfig
+	// Optional CloudFormation parameter that supplies the MemorySize
+	// at provision time. If defined, MemorySize is ignored.
+	MemorySizeParameter *LambdaIntegerParameter
+	// Optional CloudFormation parameter that supplies the Timeout
+	// at provision time. If defined, Timeout is ignored.
+	TimeoutParameter *LambdaIntegerParameter
+	// Optional AWS AppConfig extension configuration
+	AppConfig *AppConfigOptions
+	// Optional Function URL configuration
+	FunctionURL *FunctionURL
+	// Optional alias that targets the version published by each provision
+	Alias *FunctionAlias
+	// Optional provisioned concurrency for the Alias. Requires an Alias.
+	ProvisionedConcurrency *ProvisionedConcurrency
 	// Additional params
 	SpartaOptions *SpartaOptions
 }
 
+// LambdaIntegerParameter is a CloudFormation Number parameter whose
+// value is supplied when the stack is provisioned. It allows a single
+// build artifact to be deployed with environment specific values.
+type LambdaIntegerParameter struct {
+	// Name is the CloudFormation parameter name
+	Name string
+	// Default is the value used if no parameter value is supplied
+	Default int64
+}
+
+// templateParameter returns the CloudFormation parameter declaration. The
+// min and max values are enforced by CloudFormation against the value
+// supplied at provisioning time, not just the default.
+func (param *LambdaIntegerParameter) templateParameter(description string,
+	minValue int64,
+	maxValue int64) *gocf.Parameter {
+	return &gocf.Parameter{
+		Type:        "Number",
+		Default:     fmt.Sprintf("%d", param.Default),
+		MinValue:    gocf.Integer(minValue),
+		MaxValue:    gocf.Integer(maxValue),
+		Description: description,
+	}
+}
+
+// NewLambdaIntegerParameter returns a LambdaIntegerParameter that can be
+// assigned to the MemorySizeParameter or TimeoutParameter option
+func NewLambdaIntegerParameter(name string, defaultValue int64) *LambdaIntegerParameter {
+	return &LambdaIntegerParameter{
+		Name:    name,
+		Default: defaultValue,
+	}
+}
+
+// kmsKey returns the key used to encrypt the environment variables, or
+// nil if the default key is used
+func (options *LambdaFunctionOptions) kmsKey() *gocf.StringExpr {
+	if options.KmsKey != nil {
+		return options.KmsKey.String()
+	}
+	if options.KmsKeyArn != "" {
+		return gocf.String(options.KmsKeyArn)
+	}
+	return nil
+}
+
 func defaultLambdaFunctionOptions() *LambdaFunctionOptions {
 	return &LambdaFunctionOptions{Description: "",
 		MemorySize:                   128,
@@ -255,6 +365,18 @@ func defaultLambdaFunctionOptions() *LambdaFunctionOptions {
 	}
 }
 
+// reservedConcurrency returns the reserved concurrency or nil if
+// the function doesn't reserve concurrency
+func (options *LambdaFunctionOptions) reservedConcurrency() *int64 {
+	if options.ReservedConcurrency != nil {
+		return options.ReservedConcurrency
+	}
+	if options.ReservedConcurrentExecutions != 0 {
+		return &options.ReservedConcurrentExecutions
+	}
+	return nil
+}
+
 // SpartaOptions allow the passing in of additional options during the creation of a Lambda Function
 type SpartaOptions struct {
 	// User supplied function name to use for
@@ -264,6 +386,19 @@ type SpartaOptions struct {
 	Name string
 }
 
+// ArchiveFile is a local file or directory that's added to the code
+// archive together with the binary. At runtime the files are available
+// relative to the LAMBDA_TASK_ROOT directory.
+type ArchiveFile struct {
+	// Source is the local file or directory path. Directories are
+	// added recursively.
+	Source string
+	// Destination is the relative, slash separated path in the archive.
+	// Defaults to the Source file name or, for a directory,
+	// the archive root.
+	Destination string
+}
+
 // WorkflowHooks is a structure that allows callers to customize the Sparta provisioning
 // pipeline to add contents the Lambda archive or perform other workflow operations.
 // TODO: remove single-valued fields
@@ -286,6 +421,11 @@ type WorkflowHooks struct {
 	// AWS Lambda code package and before the ZIP writer is closed.  Define this hook
 	// to add additional resource files to your Lambda package
 	Archives []ArchiveHookHandler
+	// ArchiveFiles are local files and directories added to the code
+	// archive before the Archives hooks run. The uncompressed contents
+	// count against the AWS Lambda deployment package limit and
+	// larger archives increase function cold start times.
+	ArchiveFiles []*ArchiveFile
 	// PreMarshall is called before Sparta marshalls the application contents to a CloudFormation template
 	PreMarshall WorkflowHook
 	// PreMarshalls are called before Sparta marshalls the application contents into a CloudFormation
@@ -306,10 +446,222 @@ type WorkflowHooks struct {
 	// copy of the materialized template.
 	Validators []ServiceValidationHookHandler
 
+	// TemplateTransformers are applied in order to the template after
+	// the Validators run. Each transformer receives the template returned
+	// by the previous transformer, and the final template is provisioned.
+	TemplateTransformers []*TemplateTransformer
+
+	// LogRetentionInDays is the default number of days to retain the log
+	// events of functions that don't define
+	// LambdaFunctionOptions.LogRetentionInDays
+	LogRetentionInDays int64
+
+	// Steps are user-defined workflow steps that run at the insertion
+	// point identified by each Stage. Steps for the same Stage run in order.
+	Steps []*WorkflowStep
+
 	// Rollback is called if there is an error performing the requested operation
 	Rollback RollbackHook
 	// Rollbacks are called if there is an error performing the requested operation
 	Rollbacks []RollbackHookHandler
+
+	// BuildMetadataEnvironment, if true, publishes the SPARTA_BUILD_ID
+	// and SPARTA_BUILD_TIME values into each function's environment.
+	// User-supplied values for either key are not overwritten.
+	BuildMetadataEnvironment bool
+
+	// StackTags are additional user-defined tags (eg, CostCenter, Owner)
+	// applied to the CloudFormation stack together with the Sparta-managed
+	// tags. CloudFormation propagates stack tags to the supported stack
+	// resources. The Sparta-managed tags take precedence over user values
+	// for the same keys.
+	StackTags map[string]string
+
+	// PermissionsBoundary is the ARN of the managed policy (or a reference
+	// to it) that's set as the permissions boundary of every IAM role in
+	// the template, including the custom resource roles that Sparta
+	// creates. Roles that define their own boundary are unchanged.
+	PermissionsBoundary gocf.Stringable
+
+	// ShareIAMRoles, if true, provisions a single IAM role for functions
+	// whose generated roles are identical, rather than one role per
+	// function. Functions with EventSourceMappings always have their
+	// own role.
+	ShareIAMRoles bool
+
+	// TerminationProtection, if true, enables CloudFormation termination
+	// protection for the stack after it's successfully provisioned. It
+	// must be disabled in the AWS Console or CLI before the stack can be
+	// deleted. Sparta doesn't disable termination protection if this
+	// value is later set to false.
+	TerminationProtection bool
+
+	// RetainResources are the logical resource names (eg, a decorator's
+	// DynamoDB table) or resource types (eg, AWS::Logs::LogGroup) whose
+	// DeletionPolicy is set to Retain. Retained resources aren't deleted
+	// when they're removed from the template or when the stack is deleted.
+	RetainResources []string
+
+	// StackPolicy is the optional JSON stack policy document that's applied
+	// to the stack after it's provisioned. The policy isn't part of the
+	// template. It persists across stack updates until it's replaced.
+	// See https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/protect-stack-resources.html
+	StackPolicy string
+
+	// S3UploadOptions are the optional multipart upload settings used to
+	// upload artifacts. If nil, spartaS3.DefaultUploadOptions() is used.
+	S3UploadOptions *spartaS3.UploadOptions
+
+	// StackWaitOptions are the optional polling settings used to wait for
+	// the CloudFormation stack operation to complete. Increase these values
+	// for stacks with resources that take a long time to provision.
+	StackWaitOptions *spartaCF.WaitOptions
+
+	// ExplainIAM, if true, logs the origin of every statement in
+	// each Sparta-generated IAM role
+	ExplainIAM bool
+
+	// SimulateIAMPermissions, if true, uses iam:SimulatePrincipalPolicy to
+	// verify that the caller can provision the template's resources
+	// before the stack operation starts
+	SimulateIAMPermissions bool
+
+	// SplitDebugSymbols, if true, builds the binary with debug symbols,
+	// saves an unstripped copy and a separate .debug file to the
+	// scratch directory, and packages only the stripped binary
+	SplitDebugSymbols bool
+
+	// ParallelBuild, if true, compiles and packages the code while the IAM
+	// roles and AWS preconditions are verified. Workflow hooks
+	// must be safe to call concurrently with the verification.
+	ParallelBuild bool
+
+	// Compiler is the optional toolchain used to compile the service
+	// binary (eg, TinyGo or a remote builder). Defaults to the
+	// installed Go toolchain.
+	Compiler system.Compiler
+
+	// Architecture is the AWS Lambda instruction set architecture of the
+	// functions that execute the Sparta binary. It is either
+	// LambdaArchitectureX8664 (default) or LambdaArchitectureArm64.
+	// CGO builds must target the architecture of the build host.
+	Architecture string
+
+	// TemplateFormat is the format of the provisioned CloudFormation
+	// template and the template written to the templateWriter. It is
+	// either TemplateFormatJSON (default) or TemplateFormatYAML.
+	TemplateFormat string
+
+	// ReproducibleArchives, if true, pins the modification time of the
+	// code and S3 site archive entries s.t. identical inputs produce
+	// byte-identical archives. Entries added by Archive hooks
+	// must use a fixed modification time as well.
+	ReproducibleArchives bool
+
+	// AccountConcurrencyLimit is the optional regional concurrency limit
+	// of the account. If non-zero, a warning is logged if the functions'
+	// reserved concurrency doesn't leave the minimum unreserved
+	// concurrency that AWS Lambda requires. Functions
+	// in other services aren't included.
+	AccountConcurrencyLimit int64
+
+	// BinaryTransform is the optional function that modifies the compiled
+	// binary before it's archived (eg, upx --best). The transformed
+	// binary is cached in the scratch directory and reused while the
+	// compiled binary is unchanged. The BuildID is stamped into the
+	// binary, so it must also be unchanged. Transforms are skipped
+	// for noop builds.
+	BinaryTransform BinaryTransformHook
+
+	// BinaryTransformKey is the optional identifier of the BinaryTransform
+	// configuration (eg, the upx version and flags). It's part of the
+	// transformed binary cache key, so changing it invalidates the
+	// cached binary even if the compiled binary is unchanged.
+	BinaryTransformKey string
+
+	// SkipUnchangedCode, if true, uploads the code archive to a content
+	// addressed S3 key and skips the upload if an identical archive
+	// already exists, so that configuration-only changes don't
+	// update the function code. The BuildID is stamped into the binary,
+	// so it must also be unchanged. The SHA256 digest of the binary is
+	// stored in the archive's binary-sha256 S3 object metadata, which must
+	// match for the existing archive to be reused, and is recorded in
+	// each function's binarySHA256 resource metadata.
+	SkipUnchangedCode bool
+
+	// StreamCodeArchive, if true, writes the code archive directly to a
+	// multipart S3 upload rather than to a local file. It can't be combined
+	// with SkipUnchangedCode or BuildArtifacts, and only the binary
+	// is included in the uncompressed size check.
+	StreamCodeArchive bool
+
+	// GenerateSBOM, if true, writes a CycloneDX software bill of materials
+	// for the compiled binary to the scratch directory and records
+	// its SHA256 digest in each function's resource metadata
+	GenerateSBOM bool
+
+	// VerifyDispatchHandlers, if true, verifies that the compiled binary
+	// dispatches to exactly the functions being provisioned. The binary
+	// is run on the build host with the --list-handlers argument, so
+	// the package init functions and any code in main that runs before
+	// sparta.Main are executed. The check is skipped for binaries that
+	// can't run on the build host.
+	VerifyDispatchHandlers bool
+
+	// CleanOutputDir, if true, removes the artifacts created by prior
+	// builds of this service from the scratch directory before the
+	// build starts. This includes the rollback and nested stack
+	// templates. For BuildArtifacts, the prior artifact manifest and
+	// the artifacts it references are also removed. Other files in
+	// the directories are not modified.
+	CleanOutputDir bool
+
+	// ArtifactBucketOptions, if non-nil, creates the S3 artifact bucket if
+	// it doesn't exist. If the bucket name isn't supplied, it's
+	// derived from the service name, account and region.
+	ArtifactBucketOptions *spartaS3.ArtifactBucketOptions
+
+	// RequiredServiceDecorators are the names of the ServiceDecorators
+	// that must run during the build. The name is either the decorator
+	// function name (eg, decorator.CodeDeployServiceUpdateDecorator) or the
+	// decorator's type name (eg, *decorator.LogAggregatorDecorator).
+	// The build fails if any required decorator isn't registered.
+	RequiredServiceDecorators []string
+
+	// PostProvisionTests are run in order with the stack Outputs after
+	// the stack is successfully created or updated. The provision
+	// fails if any test fails. Tests are skipped for noop builds.
+	PostProvisionTests []PostProvisionTest
+
+	// RollbackOnPostProvisionTestFailure, if true, restores the stack's
+	// previous template and parameter values (or deletes a newly
+	// created stack) if a PostProvisionTest fails. Otherwise the stack
+	// is left as is so that the failure can be investigated.
+	RollbackOnPostProvisionTestFailure bool
+
+	// BuildEnvironment are module related environment variables
+	// (GOFLAGS, GOPROXY, GOSUMDB, GONOSUMCHECK, GONOSUMDB, GONOPROXY,
+	// GOPRIVATE, GOINSECURE) that override the ambient environment of
+	// the compile subprocess. For example, air-gapped builds can use
+	// GOFLAGS=-mod=vendor and GOPROXY=off. If GOPROXY is off, the build
+	// fails fast if the module dependencies aren't available locally.
+	BuildEnvironment map[string]string
+
+	// MaxConcurrentAWSRequests caps the number of concurrent AWS API
+	// requests made during the build to limit the impact on the
+	// account-wide API rate limits. Values <= 0 are unlimited.
+	// The number of requests per service is logged with the build summary.
+	MaxConcurrentAWSRequests int
+
+	// NestedStacks, if true, splits a template that exceeds the
+	// CloudFormation resource or template body limits into
+	// AWS::CloudFormation::Stack resources. Each function and the
+	// resources that only reference it are moved to a nested stack
+	// whose template is uploaded to the S3 bucket. CloudFormation
+	// replaces resources that move between stacks, which fails for
+	// resources with fixed names such as the functions, so
+	// existing stacks may need to be deleted before they're split.
+	NestedStacks bool
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -339,10 +691,38 @@ type IAMRolePrivilege struct {
 	Condition interface{} `json:",omitempty"`
 }
 
+// TemplateResourceArn is an IAMRolePrivilege Resource value that refers to
+// a resource in the same template. It's resolved to the resource's scoped
+// ARN when the template is materialized so that the privilege targets the
+// exact resource rather than a wildcard.
+type TemplateResourceArn struct {
+	// LogicalName is the logical name of the template resource
+	LogicalName string
+	// Placeholder expression updated in place during materialization
+	expr *gocf.StringExpr
+}
+
+// NewTemplateResourceArn returns a TemplateResourceArn for the given
+// template resource logical name
+func NewTemplateResourceArn(logicalName string) *TemplateResourceArn {
+	return &TemplateResourceArn{
+		LogicalName: logicalName,
+	}
+}
+
+func (resourceArn *TemplateResourceArn) stringExpr() *gocf.StringExpr {
+	if resourceArn.expr == nil {
+		resourceArn.expr = gocf.String(fmt.Sprintf("unresolved:%s", resourceArn.LogicalName))
+	}
+	return resourceArn.expr
+}
+
 func (rolePrivilege *IAMRolePrivilege) resourceExpr() *gocf.StringExpr {
 	switch typedPrivilege := rolePrivilege.Resource.(type) {
 	case string:
 		return gocf.String(typedPrivilege)
+	case *TemplateResourceArn:
+		return typedPrivilege.stringExpr()
 	case gocf.RefFunc:
 		return typedPrivilege.String()
 	default:
@@ -357,27 +737,102 @@ func (rolePrivilege *IAMRolePrivilege) resourceExpr() *gocf.StringExpr {
 type IAMRoleDefinition struct {
 	// Slice of IAMRolePrivilege entries
 	Privileges []IAMRolePrivilege
+	// TrustedPrincipals are additional service principals (eg,
+	// EdgeLambdaPrincipal) that may assume the role. They're merged
+	// with the default principals in AssumePolicyDocument.
+	TrustedPrincipals []string
+	// ManagedPolicyArns are existing managed policies (eg,
+	// arn:aws:iam::aws:policy/service-role/AWSLambdaVPCAccessExecutionRole)
+	// attached to the role in addition to the inline Privileges
+	ManagedPolicyArns []gocf.Stringable
+	// PermissionsBoundary is the ARN of the managed policy that sets the
+	// maximum permissions for the role. It may be a literal ARN or a
+	// reference (eg, gocf.Ref to a Parameter). If nil, the service-wide
+	// WorkflowHooks.PermissionsBoundary is used.
+	PermissionsBoundary gocf.Stringable
 	// Cached logical resource name
 	cachedLogicalName string
 }
 
-func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*EventSourceMapping,
-	options *LambdaFunctionOptions,
-	logger *logrus.Logger) gocf.IAMRole {
+// iamStatementProvenance records why a statement is included in
+// an IAM role
+type iamStatementProvenance struct {
+	source    string
+	statement spartaIAM.PolicyStatement
+}
 
-	statements := CommonIAMStatements.Core
-	for _, eachPrivilege := range roleDefinition.Privileges {
-		policyStatement := spartaIAM.PolicyStatement{
-			Effect:   "Allow",
-			Action:   eachPrivilege.Actions,
-			Resource: eachPrivilege.resourceExpr(),
+// statementProvenance returns the set of statements, and their origin,
+// that are included in the IAMRole inline policy
+func (roleDefinition *IAMRoleDefinition) statementProvenance(options *LambdaFunctionOptions) []*iamStatementProvenance {
+	provenance := make([]*iamStatementProvenance, 0)
+	appendStatements := func(source string, statements ...spartaIAM.PolicyStatement) {
+		for _, eachStatement := range statements {
+			provenance = append(provenance, &iamStatementProvenance{
+				source:    source,
+				statement: eachStatement,
+			})
 		}
-		statements = append(statements, policyStatement)
 	}
-
+	appendStatements("Sparta core (CloudWatch Logs, CloudFormation discovery, X-Ray)",
+		CommonIAMStatements.Core...)
+	for _, eachPrivilege := range roleDefinition.Privileges {
+		appendStatements("User declared privilege",
+			spartaIAM.PolicyStatement{
+				Effect:   "Allow",
+				Action:   eachPrivilege.Actions,
+				Resource: eachPrivilege.resourceExpr(),
+			})
+	}
 	// Add VPC permissions iff needed
 	if options != nil && options.VpcConfig != nil {
-		statements = append(statements, CommonIAMStatements.VPC...)
+		appendStatements("VpcConfig", CommonIAMStatements.VPC...)
+	}
+	if options != nil && options.AppConfig != nil {
+		appendStatements("AppConfig", options.AppConfig.iamStatement())
+	}
+	if options != nil && options.DeadLetterConfigArn != nil {
+		appendStatements("DeadLetterConfigArn",
+			deadLetterIAMStatement(options.DeadLetterConfigArn))
+	}
+	if options != nil && options.kmsKey() != nil {
+		appendStatements("KmsKey",
+			spartaIAM.PolicyStatement{
+				Effect:   "Allow",
+				Action:   []string{"kms:Decrypt"},
+				Resource: options.kmsKey(),
+			})
+	}
+	return provenance
+}
+
+// deadLetterIAMStatement returns the statement that allows the function
+// to send failed events to the dead letter target. References can't be
+// resolved when the role is created, so both the SNS and SQS
+// actions are allowed for targets that aren't literal ARNs.
+func deadLetterIAMStatement(targetArn gocf.Stringable) spartaIAM.PolicyStatement {
+	targetExpr := targetArn.String()
+	actions := []string{"sns:Publish", "sqs:SendMessage"}
+	if targetExpr.Func == nil {
+		if strings.Contains(targetExpr.Literal, ":sns:") {
+			actions = []string{"sns:Publish"}
+		} else if strings.Contains(targetExpr.Literal, ":sqs:") {
+			actions = []string{"sqs:SendMessage"}
+		}
+	}
+	return spartaIAM.PolicyStatement{
+		Effect:   "Allow",
+		Action:   actions,
+		Resource: targetExpr,
+	}
+}
+
+func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*EventSourceMapping,
+	options *LambdaFunctionOptions,
+	logger *logrus.Logger) gocf.IAMRole {
+
+	statements := make([]spartaIAM.PolicyStatement, 0)
+	for _, eachEntry := range roleDefinition.statementProvenance(options) {
+		statements = append(statements, eachEntry.statement)
 	}
 	// In the past Sparta used to attach EventSourceMapping policies here.
 	// However, moving everything to dynamic references means that we can't
@@ -396,10 +851,86 @@ func (roleDefinition *IAMRoleDefinition) toResource(eventSourceMappings []*Event
 		},
 		PolicyName: gocf.String("LambdaPolicy"),
 	})
-	return gocf.IAMRole{
-		AssumeRolePolicyDocument: AssumePolicyDocument,
+	iamRole := gocf.IAMRole{
+		AssumeRolePolicyDocument: roleDefinition.assumeRolePolicyDocument(),
 		Policies:                 &iamPolicies,
 	}
+	if len(roleDefinition.ManagedPolicyArns) != 0 {
+		iamRole.ManagedPolicyArns = gocf.StringList(roleDefinition.ManagedPolicyArns...)
+	}
+	if roleDefinition.PermissionsBoundary != nil {
+		iamRole.PermissionsBoundary = roleDefinition.PermissionsBoundary.String()
+	}
+	return iamRole
+}
+
+// assumeRolePolicyDocument returns the AssumePolicyDocument that
+// includes the TrustedPrincipals
+func (roleDefinition *IAMRoleDefinition) assumeRolePolicyDocument() ArbitraryJSONObject {
+	if len(roleDefinition.TrustedPrincipals) == 0 {
+		return AssumePolicyDocument
+	}
+	principals := make([]string, 0, len(defaultAssumeRolePrincipals)+len(roleDefinition.TrustedPrincipals))
+	principals = append(principals, defaultAssumeRolePrincipals...)
+	for _, eachPrincipal := range roleDefinition.TrustedPrincipals {
+		exists := false
+		for _, eachExisting := range principals {
+			if eachExisting == eachPrincipal {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			principals = append(principals, eachPrincipal)
+		}
+	}
+	return ArbitraryJSONObject{
+		"Version": "2012-10-17",
+		"Statement": []ArbitraryJSONObject{
+			{
+				"Effect": "Allow",
+				"Principal": ArbitraryJSONObject{
+					"Service": principals,
+				},
+				"Action": []string{"sts:AssumeRole"},
+			},
+		},
+	}
+}
+
+// validatePermissionsBoundary ensures that a literal permissions boundary
+// is an IAM managed policy ARN. References are resolved by CloudFormation.
+func validatePermissionsBoundary(permissionsBoundary gocf.Stringable) error {
+	if permissionsBoundary == nil {
+		return nil
+	}
+	boundaryExpr := permissionsBoundary.String()
+	if boundaryExpr == nil || boundaryExpr.Func != nil {
+		return nil
+	}
+	if !reIAMPolicyArn.MatchString(boundaryExpr.Literal) {
+		return errors.Errorf("PermissionsBoundary %s is not an IAM managed policy ARN (eg, arn:aws:iam::123412341234:policy/Boundary)",
+			boundaryExpr.Literal)
+	}
+	return nil
+}
+
+// validate ensures the TrustedPrincipals are service principals and
+// the ManagedPolicyArns and PermissionsBoundary are valid
+func (roleDefinition *IAMRoleDefinition) validate() error {
+	for _, eachPrincipal := range roleDefinition.TrustedPrincipals {
+		if !reServicePrincipal.MatchString(eachPrincipal) {
+			return errors.Errorf("IAMRoleDefinition TrustedPrincipal %s is not an AWS service principal (eg, %s)",
+				eachPrincipal,
+				EdgeLambdaPrincipal)
+		}
+	}
+	for index, eachPolicyArn := range roleDefinition.ManagedPolicyArns {
+		if eachPolicyArn == nil {
+			return errors.Errorf("IAMRoleDefinition ManagedPolicyArns[%d] must not be nil", index)
+		}
+	}
+	return validatePermissionsBoundary(roleDefinition.PermissionsBoundary)
 }
 
 // Returns the stable logical name for this IAMRoleDefinition, which depends on the serviceName
@@ -434,6 +965,41 @@ type EventSourceMapping struct {
 	MaximumRecordAgeInSeconds      int64
 	MaximumRetryAttempts           int64
 	ParallelizationFactor          int64
+	// EventSourceLogicalName is the optional logical name of a resource
+	// created in the same template (eg, by a decorator) that is the source
+	// of events. If defined, EventSourceArn is ignored and the ARN is
+	// resolved after the template is materialized. Supported resource types
+	// are SQS queues, Kinesis streams, DynamoDB tables with a stream, MSK
+	// clusters, and Amazon MQ brokers. SNS topics and EventBridge event
+	// buses are push sources and are referenced by SNSPermission.TopicLogicalName
+	// and EventBridgeRule.EventBusLogicalName respectively.
+	EventSourceLogicalName string
+	// The logical name of the exported AWS::Lambda::EventSourceMapping
+	resourceName string
+}
+
+// eventSourceArn returns the ARN expression for this mapping
+func (mapping *EventSourceMapping) eventSourceArn() interface{} {
+	if mapping.EventSourceLogicalName != "" {
+		return gocf.GetAtt(mapping.EventSourceLogicalName, "Arn")
+	}
+	return mapping.EventSourceArn
+}
+
+// templateEventSourceArn returns the ARN expression from the exported
+// AWS::Lambda::EventSourceMapping resource, which includes the resolved
+// EventSourceLogicalName ARN
+func (mapping *EventSourceMapping) templateEventSourceArn(template *gocf.Template) interface{} {
+	if mapping.EventSourceLogicalName != "" {
+		mappingResource, mappingResourceExists := template.Resources[mapping.resourceName]
+		if mappingResourceExists {
+			typedMapping, typedMappingOk := mappingResource.Properties.(gocf.LambdaEventSourceMapping)
+			if typedMappingOk && typedMapping.EventSourceArn != nil {
+				return typedMapping.EventSourceArn
+			}
+		}
+	}
+	return mapping.eventSourceArn()
 }
 
 func (mapping *EventSourceMapping) export(serviceName string,
@@ -444,7 +1010,7 @@ func (mapping *EventSourceMapping) export(serviceName string,
 	template *gocf.Template,
 	logger *logrus.Logger) error {
 
-	dynamicArn := spartaCF.DynamicValueToStringExpr(mapping.EventSourceArn)
+	dynamicArn := spartaCF.DynamicValueToStringExpr(mapping.eventSourceArn())
 	eventSourceMappingResource := gocf.LambdaEventSourceMapping{
 		StartingPosition:               marshalString(mapping.StartingPosition),
 		EventSourceArn:                 dynamicArn.String(),
@@ -467,6 +1033,7 @@ func (mapping *EventSourceMapping) export(serviceName string,
 		targetLambdaArn.Literal,
 		fmt.Sprintf("%d", mapping.BatchSize),
 		mapping.StartingPosition,
+		mapping.EventSourceLogicalName,
 	}
 	hash := sha1.New()
 	for _, eachHashPart := range hashParts {
@@ -478,6 +1045,7 @@ func (mapping *EventSourceMapping) export(serviceName string,
 	}
 	resourceName := fmt.Sprintf("LambdaES%s", hex.EncodeToString(hash.Sum(nil)))
 	template.AddResource(resourceName, eventSourceMappingResource)
+	mapping.resourceName = resourceName
 	return nil
 }
 
@@ -715,21 +1283,97 @@ type LambdaAWSInfo struct {
 	// defined by a TemplateDecorator, that this lambda depends on
 	DependsOn []string
 
-	// Lambda Layers
+	// Lambda Layers. Values may be literal ARNs or references (eg, gocf.Ref)
+	// to layers provisioned in the same template. A function supports at
+	// most 5 layers, including the AppConfig extension layer.
 	// Ref: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/aws-resource-lambda-function.html#cfn-lambda-function-layers
 	Layers []gocf.Stringable
 
+	// ProvisioningWave is the optional provisioning stage for this function.
+	// Resources in wave N DependsOn all resources in the preceding wave,
+	// so that, for example, a custom resource can seed a database before
+	// the functions that use it are created. Zero means the function
+	// is not staged.
+	ProvisioningWave int
+	// ProvisioningWaveResources are additional template resource logical
+	// names, typically created by a decorator, that belong to this
+	// function's ProvisioningWave
+	ProvisioningWaveResources []string
+
+	// MetricFilters are CloudWatch Logs metric filters applied to this
+	// function's log group. If defined, the log group is created by the
+	// stack, so it must not already exist.
+	MetricFilters []*MetricFilter
+
+	// DisableDiscovery, if true, excludes the discovery information from
+	// this function's environment. Functions that disable discovery
+	// cannot call sparta.Discover().
+	DisableDiscovery bool
+
+	// Tags are cost allocation tags (eg, team or feature) applied to this
+	// function. They are merged with the service-level
+	// WorkflowHooks.StackTags and LambdaFunctionOptions.Tags, and take
+	// precedence over both.
+	Tags map[string]string
+
 	// Slice of customResourceInfo pointers for any associated CloudFormation
 	// CustomResources associated with this lambda
 	customResources []*customResourceInfo
 	// Cached lambda name s.t. we only compute it once
 	cachedLambdaFunctionName string
+	// Service-level tags that are inherited by the function
+	serviceTags map[string]string
+	// Service-level log retention used if the function doesn't define one
+	serviceLogRetentionInDays int64
+	// Warmup configuration if this is the warmup dispatcher
+	warmupConfig *WarmupConfig
 
 	// deprecation notices
 	deprecationNotices []string
 
 	// interceptors
 	Interceptors *LambdaEventInterceptors
+
+	// DispatchOptions are the runtime options that control how the
+	// handler is invoked
+	DispatchOptions *LambdaDispatchOptions
+}
+
+// functionTags returns a new map with the merged service, options and
+// function tags. Function tags take precedence over the options tags,
+// which take precedence over the service tags.
+func (info *LambdaAWSInfo) functionTags() map[string]string {
+	tags := make(map[string]string)
+	for eachKey, eachValue := range info.serviceTags {
+		tags[eachKey] = eachValue
+	}
+	if info.Options != nil {
+		for eachKey, eachValue := range info.Options.Tags {
+			tags[eachKey] = eachValue
+		}
+	}
+	for eachKey, eachValue := range info.Tags {
+		tags[eachKey] = eachValue
+	}
+	return tags
+}
+
+// templateTagList returns the tags as a TagList sorted by key s.t.
+// the template is stable
+func templateTagList(tags map[string]string) *gocf.TagList {
+	tagKeys := make([]string, 0, len(tags))
+	for eachKey := range tags {
+		tagKeys = append(tagKeys, eachKey)
+	}
+	sort.Strings(tagKeys)
+	tagList := gocf.TagList{}
+	for _, eachKey := range tagKeys {
+		tagList = append(tagList, gocf.Tag{
+			Key:   gocf.String(eachKey),
+			Value: gocf.String(tags[eachKey]),
+		})
+	}
+	return &tagList
 }
 
 // lambdaFunctionName returns the internal
@@ -848,7 +1492,7 @@ func (info *LambdaAWSInfo) Description(targetNodeName string) ([]*DescriptionTri
 
 	// Finally, event sources...
 	for index, eachEventSourceMapping := range info.EventSourceMappings {
-		dynamicArn := spartaCF.DynamicValueToStringExpr(eachEventSourceMapping.EventSourceArn)
+		dynamicArn := spartaCF.DynamicValueToStringExpr(eachEventSourceMapping.eventSourceArn())
 		jsonBytes, jsonBytesErr := json.Marshal(dynamicArn)
 		if jsonBytesErr != nil {
 			jsonBytes = []byte(fmt.Sprintf("%s-EventSourceMapping[%d]",
@@ -1033,16 +1677,27 @@ func (info *LambdaAWSInfo) export(serviceName string,
 		Timeout:     gocf.Integer(info.Options.Timeout),
 		VPCConfig:   info.Options.VpcConfig,
 	}
+	if nil != info.Options.MemorySizeParameter {
+		lambdaResource.MemorySize = gocf.Ref(info.Options.MemorySizeParameter.Name).Integer()
+	}
+	if nil != info.Options.TimeoutParameter {
+		lambdaResource.Timeout = gocf.Ref(info.Options.TimeoutParameter.Name).Integer()
+	}
 	// Layers?
-	if nil != info.Layers {
-		lambdaResource.Layers = gocf.StringList(info.Layers...)
+	layers := append([]gocf.Stringable{}, info.Layers...)
+	if nil != info.Options.AppConfig {
+		layers = append(layers, info.Options.AppConfig.LayerArn)
+	}
+	if len(layers) != 0 {
+		lambdaResource.Layers = gocf.StringList(layers...)
 	}
 
 	if S3Version != "" {
 		lambdaResource.Code.S3ObjectVersion = gocf.String(S3Version)
 	}
-	if info.Options.ReservedConcurrentExecutions != 0 {
-		lambdaResource.ReservedConcurrentExecutions = gocf.Integer(info.Options.ReservedConcurrentExecutions)
+	reservedConcurrency := info.Options.reservedConcurrency()
+	if reservedConcurrency != nil {
+		lambdaResource.ReservedConcurrentExecutions = gocf.Integer(*reservedConcurrency)
 	}
 	if info.Options.DeadLetterConfigArn != nil {
 		lambdaResource.DeadLetterConfig = &gocf.LambdaFunctionDeadLetterConfig{
@@ -1052,18 +1707,12 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	if nil != info.Options.TracingConfig {
 		lambdaResource.TracingConfig = info.Options.TracingConfig
 	}
-	if info.Options.KmsKeyArn != "" {
-		lambdaResource.KmsKeyArn = gocf.String(info.Options.KmsKeyArn)
+	if info.Options.kmsKey() != nil {
+		lambdaResource.KmsKeyArn = info.Options.kmsKey()
 	}
-	if nil != info.Options.Tags {
-		tagList := gocf.TagList{}
-		for eachKey, eachValue := range info.Options.Tags {
-			tagList = append(tagList, gocf.Tag{
-				Key:   gocf.String(eachKey),
-				Value: gocf.String(eachValue),
-			})
-		}
-		lambdaResource.Tags = &tagList
+	functionTags := info.functionTags()
+	if len(functionTags) != 0 {
+		lambdaResource.Tags = templateTagList(functionTags)
 	}
 
 	// DISPATCH INFORMATION
@@ -1075,6 +1724,9 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	}
 	info.Options.Environment[envVarLogLevel] =
 		gocf.String(logger.Level.String())
+	if nil != info.Options.AppConfig {
+		info.Options.AppConfig.environment(info.Options.Environment)
+	}
 
 	lambdaResource.Environment = &gocf.LambdaFunctionEnvironment{
 		Variables: info.Options.Environment,
@@ -1087,6 +1739,19 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	lambdaFunctionName := awsLambdaFunctionName(info.lambdaFunctionName())
 	lambdaResource.FunctionName = lambdaFunctionName.String()
 
+	// Log retention or derived metrics? Make sure the log group exists
+	// before the function so that Lambda doesn't implicitly create it.
+	logGroupResourceName := info.exportLogGroup(lambdaFunctionName.String(), template)
+	if logGroupResourceName != "" {
+		dependsOn = append(dependsOn, logGroupResourceName)
+	}
+	if len(info.MetricFilters) != 0 {
+		metricFiltersErr := info.exportMetricFilters(template)
+		if nil != metricFiltersErr {
+			return metricFiltersErr
+		}
+	}
+
 	cfResource := template.AddResource(info.LogicalResourceName(), lambdaResource)
 	cfResource.DependsOn = append(cfResource.DependsOn, dependsOn...)
 	safeMetadataInsert(cfResource, "golangFunc", info.lambdaFunctionName())
@@ -1094,6 +1759,18 @@ func (info *LambdaAWSInfo) export(serviceName string,
 	// Create the lambda Ref in case we need a permission or event mapping
 	functionAttr := gocf.GetAtt(info.LogicalResourceName(), "Arn")
 
+	// Alias and provisioned concurrency. This is exported before the
+	// permissions and event source mappings s.t. they target the alias.
+	if nil != info.Options.Alias {
+		aliasErr := info.exportAlias(buildID, template)
+		if nil != aliasErr {
+			return aliasErr
+		}
+	}
+	eventTargetAttr := lambdaTargetArn(info.lambdaFunctionName(),
+		info.LogicalResourceName(),
+		template)
+
 	// Permissions
 	for _, eachPermission := range info.Permissions {
 		_, err := eachPermission.export(serviceName,
@@ -1108,11 +1785,19 @@ func (info *LambdaAWSInfo) export(serviceName string,
 		}
 	}
 
+	// Function URL
+	if nil != info.Options.FunctionURL {
+		functionURLErr := info.exportFunctionURL(functionAttr, template)
+		if nil != functionURLErr {
+			return functionURLErr
+		}
+	}
+
 	// Event Source Mappings
 	for _, eachEventSourceMapping := range info.EventSourceMappings {
 		mappingErr := eachEventSourceMapping.export(serviceName,
 			info.lambdaFunctionName(),
-			functionAttr,
+			eventTargetAttr,
 			S3Bucket,
 			S3Key,
 			template,
@@ -1221,6 +1906,36 @@ func validateSpartaPreconditions(lambdaAWSInfos []*LambdaAWSInfo,
 		logger.WithFields(logrus.Fields{
 			"CollisionMap": collisionMemo,
 		}).Debug("Lambda collision map")
+
+		// 3 - check for invalid function options
+		for _, eachLambda := range lambdaAWSInfos {
+			errorText = append(errorText, validateLambdaFunctionOptions(eachLambda)...)
+		}
+		// 4 - check the layer limit
+		for _, eachLambda := range lambdaAWSInfos {
+			layerCount := len(eachLambda.Layers)
+			if eachLambda.Options != nil && eachLambda.Options.AppConfig != nil {
+				layerCount++
+			}
+			if layerCount > lambdaMaxLayers {
+				errorText = append(errorText,
+					fmt.Sprintf("Lambda %s has %d layers, which exceeds the limit of %d",
+						eachLambda.lambdaFunctionName(),
+						layerCount,
+						lambdaMaxLayers))
+			}
+		}
+		// 5 - check the IAM role trust policies
+		for _, eachLambda := range lambdaAWSInfos {
+			if eachLambda.RoleDefinition == nil {
+				continue
+			}
+			roleErr := eachLambda.RoleDefinition.validate()
+			if roleErr != nil {
+				errorText = append(errorText,
+					fmt.Sprintf("Lambda %s %s", eachLambda.lambdaFunctionName(), roleErr))
+			}
+		}
 	}
 	if len(errorText) != 0 {
 		return errors.New(strings.Join(errorText[:], "\n"))
@@ -1229,6 +1944,157 @@ func validateSpartaPreconditions(lambdaAWSInfos []*LambdaAWSInfo,
 	return nil
 }
 
+// validateLambdaFunctionOptions returns the list of problems with the
+// user supplied LambdaFunctionOptions
+func validateLambdaFunctionOptions(lambdaAWSInfo *LambdaAWSInfo) []string {
+	var errorText []string
+	if lambdaAWSInfo.Options == nil {
+		return errorText
+	}
+	validateParameter := func(optionName string,
+		param *LambdaIntegerParameter,
+		minValue int64,
+		maxValue int64) {
+		if param == nil {
+			return
+		}
+		if param.Name == "" {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s parameter name must not be empty",
+					lambdaAWSInfo.lambdaFunctionName(),
+					optionName))
+		}
+		if param.Default < minValue || param.Default > maxValue {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s parameter default (%d) must be in the range [%d, %d]",
+					lambdaAWSInfo.lambdaFunctionName(),
+					optionName,
+					param.Default,
+					minValue,
+					maxValue))
+		}
+	}
+	// Sort the keys s.t. the errors are stable
+	reservedKeys := make([]string, 0)
+	for eachKey := range lambdaAWSInfo.Options.Environment {
+		if lambdaReservedEnvironmentVariables[eachKey] {
+			reservedKeys = append(reservedKeys, eachKey)
+		}
+	}
+	sort.Strings(reservedKeys)
+	for _, eachKey := range reservedKeys {
+		errorText = append(errorText,
+			fmt.Sprintf("Lambda %s Environment variable %s is reserved by the Lambda runtime",
+				lambdaAWSInfo.lambdaFunctionName(),
+				eachKey))
+	}
+	if lambdaAWSInfo.Options.ReservedConcurrency != nil {
+		if lambdaAWSInfo.Options.ReservedConcurrentExecutions != 0 {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s must not define both ReservedConcurrency and ReservedConcurrentExecutions",
+					lambdaAWSInfo.lambdaFunctionName()))
+		}
+		if *lambdaAWSInfo.Options.ReservedConcurrency < 0 {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s ReservedConcurrency (%d) must not be negative",
+					lambdaAWSInfo.lambdaFunctionName(),
+					*lambdaAWSInfo.Options.ReservedConcurrency))
+		}
+	}
+	if lambdaAWSInfo.Options.EphemeralStorageSize != 0 &&
+		(lambdaAWSInfo.Options.EphemeralStorageSize < lambdaMinEphemeralStorageSize ||
+			lambdaAWSInfo.Options.EphemeralStorageSize > lambdaMaxEphemeralStorageSize) {
+		errorText = append(errorText,
+			fmt.Sprintf("Lambda %s EphemeralStorageSize (%d MB) must be between %d and %d MB",
+				lambdaAWSInfo.lambdaFunctionName(),
+				lambdaAWSInfo.Options.EphemeralStorageSize,
+				lambdaMinEphemeralStorageSize,
+				lambdaMaxEphemeralStorageSize))
+	}
+	retentionErr := validateLogRetentionInDays(lambdaAWSInfo.Options.LogRetentionInDays)
+	if retentionErr != nil {
+		errorText = append(errorText,
+			fmt.Sprintf("Lambda %s %s",
+				lambdaAWSInfo.lambdaFunctionName(),
+				retentionErr))
+	}
+	if lambdaAWSInfo.Options.VpcConfig != nil {
+		// Lists supplied by an intrinsic function (eg, a parameter Ref)
+		// can't be validated until the stack is provisioned
+		emptyList := func(listExpr *gocf.StringListExpr) bool {
+			return listExpr == nil || (listExpr.Func == nil && len(listExpr.Literal) == 0)
+		}
+		if emptyList(lambdaAWSInfo.Options.VpcConfig.SubnetIDs) {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s VpcConfig must include at least one subnet",
+					lambdaAWSInfo.lambdaFunctionName()))
+		}
+		if emptyList(lambdaAWSInfo.Options.VpcConfig.SecurityGroupIDs) {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s VpcConfig must include at least one security group",
+					lambdaAWSInfo.lambdaFunctionName()))
+		}
+	}
+	if lambdaAWSInfo.Options.TracingConfig != nil &&
+		lambdaAWSInfo.Options.TracingConfig.Mode != nil &&
+		lambdaAWSInfo.Options.TracingConfig.Mode.Func == nil {
+		tracingMode := lambdaAWSInfo.Options.TracingConfig.Mode.Literal
+		if tracingMode != lambdaTracingModeActive && tracingMode != lambdaTracingModePassThrough {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s TracingConfig Mode (%s) must be either %s or %s",
+					lambdaAWSInfo.lambdaFunctionName(),
+					tracingMode,
+					lambdaTracingModeActive,
+					lambdaTracingModePassThrough))
+		}
+	}
+	validateParameter("MemorySize",
+		lambdaAWSInfo.Options.MemorySizeParameter,
+		lambdaMinMemorySize,
+		lambdaMaxMemorySize)
+	validateParameter("Timeout",
+		lambdaAWSInfo.Options.TimeoutParameter,
+		lambdaMinTimeout,
+		lambdaMaxTimeout)
+	if lambdaAWSInfo.Options.AppConfig != nil {
+		appConfigErr := lambdaAWSInfo.Options.AppConfig.validate()
+		if appConfigErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s",
+					lambdaAWSInfo.lambdaFunctionName(),
+					appConfigErr))
+		}
+	}
+	if lambdaAWSInfo.Options.Alias != nil {
+		aliasErr := lambdaAWSInfo.Options.Alias.validate()
+		if aliasErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s",
+					lambdaAWSInfo.lambdaFunctionName(),
+					aliasErr))
+		}
+	}
+	if lambdaAWSInfo.Options.ProvisionedConcurrency != nil {
+		concurrencyErr := lambdaAWSInfo.Options.ProvisionedConcurrency.validate(lambdaAWSInfo.Options.Alias)
+		if concurrencyErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s",
+					lambdaAWSInfo.lambdaFunctionName(),
+					concurrencyErr))
+		}
+	}
+	if lambdaAWSInfo.Options.FunctionURL != nil {
+		functionURLErr := lambdaAWSInfo.Options.FunctionURL.validate()
+		if functionURLErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Lambda %s %s",
+					lambdaAWSInfo.lambdaFunctionName(),
+					functionURLErr))
+		}
+	}
+	return errorText
+}
+
 // Sanitize the provided input by replacing illegal characters with underscores
 func sanitizedName(input string) string {
 	return reSanitize.ReplaceAllString(input, "_")
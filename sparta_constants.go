@@ -25,6 +25,43 @@ const (
 	LambdaBinaryTag = "lambdabinary"
 )
 
+const (
+	// TemplateFormatJSON is the default JSON CloudFormation template format
+	TemplateFormatJSON = "json"
+	// TemplateFormatYAML is the YAML CloudFormation template format
+	TemplateFormatYAML = "yaml"
+)
+
+const (
+	// LambdaArchitectureX8664 is the default x86_64 AWS Lambda architecture
+	LambdaArchitectureX8664 = "x86_64"
+	// LambdaArchitectureArm64 is the Graviton2 arm64 AWS Lambda architecture.
+	// Functions with this architecture use the provided.al2 runtime.
+	LambdaArchitectureArm64 = "arm64"
+)
+
+const (
+	// WorkflowStageVerifyIAM precedes the IAM role verification
+	WorkflowStageVerifyIAM WorkflowStage = "VerifyIAM"
+	// WorkflowStageVerifyAWS precedes the S3 bucket and AWS account checks
+	WorkflowStageVerifyAWS WorkflowStage = "VerifyAWS"
+	// WorkflowStagePackage precedes compiling and archiving the binary
+	WorkflowStagePackage WorkflowStage = "Package"
+	// WorkflowStageUpload precedes uploading the code archive to S3
+	WorkflowStageUpload WorkflowStage = "Upload"
+	// WorkflowStageValidate precedes the template postcondition checks
+	WorkflowStageValidate WorkflowStage = "Validate"
+	// WorkflowStageProvision precedes marshalling and provisioning the template
+	WorkflowStageProvision WorkflowStage = "Provision"
+)
+
+const (
+	// WorkflowContextKeyCodeArchivePath is the local path of the code archive
+	WorkflowContextKeyCodeArchivePath = "sparta.codeArchivePath"
+	// WorkflowContextKeyCodeArchiveURL is the S3 URL of the uploaded code archive
+	WorkflowContextKeyCodeArchiveURL = "sparta.codeArchiveURL"
+)
+
 var (
 	// SpartaBinaryName is binary name that exposes the Go lambda function
 	SpartaBinaryName = fmt.Sprintf("%s.lambda.amd64", ProperName)
@@ -47,6 +84,57 @@ const (
 	// envVarDiscoveryInformation is the name of the discovery information
 	// published into the environment
 	envVarDiscoveryInformation = "SPARTA_DISCOVERY_INFO"
+	// envVarBuildID is the optional buildID published into
+	// the environment
+	envVarBuildID = "SPARTA_BUILD_ID"
+	// envVarBuildTime is the optional RFC3339 build time published
+	// into the environment
+	envVarBuildTime = "SPARTA_BUILD_TIME"
+	// lambdaEnvironmentSizeLimit is the maximum total size of all
+	// environment variables for a single function
+	lambdaEnvironmentSizeLimit = 4 * 1024
+)
+
+const (
+	// lambdaMinMemorySize is the minimum function MemorySize (MB)
+	lambdaMinMemorySize = 128
+	// lambdaMaxMemorySize is the maximum function MemorySize (MB)
+	lambdaMaxMemorySize = 10240
+	// lambdaMinTimeout is the minimum function Timeout (seconds)
+	lambdaMinTimeout = 1
+	// lambdaMaxTimeout is the maximum function Timeout (seconds)
+	lambdaMaxTimeout = 900
+	// lambdaMaxLayers is the maximum number of layers per function
+	lambdaMaxLayers = 5
+	// lambdaMinEphemeralStorageSize is the minimum function
+	// EphemeralStorageSize (MB)
+	lambdaMinEphemeralStorageSize = 512
+	// lambdaMaxEphemeralStorageSize is the maximum function
+	// EphemeralStorageSize (MB)
+	lambdaMaxEphemeralStorageSize = 10240
+	// lambdaTracingModeActive samples and traces incoming requests
+	lambdaTracingModeActive = "Active"
+	// lambdaTracingModePassThrough only traces requests that
+	// include a sampled trace header
+	lambdaTracingModePassThrough = "PassThrough"
+	// lambdaMinUnreservedConcurrency is the account concurrency that
+	// can't be reserved by functions
+	lambdaMinUnreservedConcurrency = 100
+)
+
+const (
+	// templateDescriptionMaxLength is the maximum CloudFormation template
+	// description length
+	templateDescriptionMaxLength = 1024
+	// stackNameMaxLength is the maximum CloudFormation stack name length
+	stackNameMaxLength = 128
+	// lambdaFunctionNameMaxLength is the maximum function name length
+	lambdaFunctionNameMaxLength = 64
+	// iamRoleNameMaxLength is the maximum IAM role name length
+	iamRoleNameMaxLength = 64
+	// logGroupNameMaxLength is the maximum CloudWatch Logs log group
+	// name length
+	logGroupNameMaxLength = 512
 )
 
 var (
@@ -54,6 +142,30 @@ var (
 	headerDivider = strings.Repeat("═", dividerLength)
 )
 
+// lambdaReservedEnvironmentVariables are the environment variable names
+// set by the Lambda runtime that functions can't override.
+// Ref: https://docs.aws.amazon.com/lambda/latest/dg/configuration-envvars.html#configuration-envvars-runtime
+var lambdaReservedEnvironmentVariables = map[string]bool{
+	"_HANDLER":                        true,
+	"_X_AMZN_TRACE_ID":                true,
+	"AWS_ACCESS_KEY":                  true,
+	"AWS_ACCESS_KEY_ID":               true,
+	"AWS_DEFAULT_REGION":              true,
+	"AWS_EXECUTION_ENV":               true,
+	"AWS_LAMBDA_FUNCTION_MEMORY_SIZE": true,
+	"AWS_LAMBDA_FUNCTION_NAME":        true,
+	"AWS_LAMBDA_FUNCTION_VERSION":     true,
+	"AWS_LAMBDA_INITIALIZATION_TYPE":  true,
+	"AWS_LAMBDA_LOG_GROUP_NAME":       true,
+	"AWS_LAMBDA_LOG_STREAM_NAME":      true,
+	"AWS_LAMBDA_RUNTIME_API":          true,
+	"AWS_REGION":                      true,
+	"AWS_SECRET_ACCESS_KEY":           true,
+	"AWS_SESSION_TOKEN":               true,
+	"LAMBDA_RUNTIME_DIR":              true,
+	"LAMBDA_TASK_ROOT":                true,
+}
+
 // AWS Principal ARNs from http://docs.aws.amazon.com/general/latest/gr/aws-arns-and-namespaces.html
 // See also
 // http://docs.aws.amazon.com/general/latest/gr/rande.html
@@ -68,10 +180,16 @@ const (
 	// @enum AWSPrincipal
 	SNSPrincipal = "sns.amazonaws.com"
 	// @enum AWSPrincipal
+	S3Principal = "s3.amazonaws.com"
+	// @enum AWSPrincipal
 	EC2Principal = "ec2.amazonaws.com"
 	// @enum AWSPrincipal
 	LambdaPrincipal = "lambda.amazonaws.com"
 	// @enum AWSPrincipal
+	EdgeLambdaPrincipal = "edgelambda.amazonaws.com"
+	// @enum AWSPrincipal
+	SchedulerPrincipal = "scheduler.amazonaws.com"
+	// @enum AWSPrincipal
 	ElasticLoadBalancingPrincipal = "elasticloadbalancing.amazonaws.com"
 	// @enum KinesisFirehosePrincipal
 	KinesisFirehosePrincipal = "firehose.amazonaws.com"
@@ -2,9 +2,6 @@ package sparta
 
 import (
 	"bytes"
-	cryptoRand "crypto/rand"
-	"crypto/sha1"
-	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"os"
@@ -89,54 +86,68 @@ var CommandLineOptions = struct {
 // Provision options
 // Ref: http://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html
 type optionsProvisionStruct struct {
-	S3Bucket        string `validate:"required"`
+	S3Bucket        string `validate:"-"` // required unless the bucket is created
 	BuildID         string `validate:"-"` // non-whitespace
 	PipelineTrigger string `validate:"-"`
 	InPlace         bool   `validate:"-"`
+	Description     string `validate:"-"`
 }
 
 var optionsProvision optionsProvisionStruct
 
+// gitBuildID returns the short SHA of the current git HEAD, with a
+// "-dirty" suffix if the working tree has uncommitted changes. An empty
+// string is returned if the working directory isn't a git repository.
+func gitBuildID() string {
+	gitOutput := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		cmdErr := cmd.Run()
+		return strings.TrimSpace(stdout.String()), cmdErr
+	}
+	sha, shaErr := gitOutput("rev-parse", "--short", "HEAD")
+	if shaErr != nil || sha == "" {
+		return ""
+	}
+	changes, changesErr := gitOutput("status", "--porcelain")
+	if changesErr == nil && changes != "" {
+		sha = fmt.Sprintf("%s-dirty", sha)
+	}
+	return sha
+}
+
+// provisionBuildID returns the userSuppliedValue if it's non-empty.
+// Otherwise the buildID is the git SHA and dirty-tree marker, falling back
+// to a UTC timestamp if the working directory isn't a git repository.
 func provisionBuildID(userSuppliedValue string, logger *logrus.Logger) (string, error) {
 	buildID := userSuppliedValue
 	if buildID == "" {
 		// That's cool, let's see if we can find a git SHA
-		cmd := exec.Command("git",
-			"rev-parse",
-			"HEAD")
-		var stdout bytes.Buffer
-		var stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-		cmdErr := cmd.Run()
-		if cmdErr == nil {
-			// Great, let's use the SHA
-			buildID = strings.TrimSpace(string(stdout.String()))
-			if buildID != "" {
-				logger.WithField("SHA", buildID).
-					WithField("Command", "git rev-parse HEAD").
-					Info("Using `git` SHA for StampedBuildID")
-			}
+		buildID = gitBuildID()
+		if buildID != "" {
+			logger.WithField("SHA", buildID).
+				WithField("Command", "git rev-parse --short HEAD").
+				Info("Using `git` SHA for StampedBuildID")
 		}
-		// Ignore any errors and make up a random one
+		// Not a git repo, so use the current time
 		if buildID == "" {
-			// No problem, let's use an arbitrary SHA
-			hash := sha1.New()
-			randomBytes := make([]byte, 256)
-			_, err := cryptoRand.Read(randomBytes)
-			if err != nil {
-				return "", err
-			}
-			_, err = hash.Write(randomBytes)
-			if err != nil {
-				return "", err
-			}
-			buildID = hex.EncodeToString(hash.Sum(nil))
+			buildID = time.Now().UTC().Format("20060102T150405Z")
+			logger.WithField("BuildID", buildID).
+				Info("Using timestamp for StampedBuildID")
 		}
 	}
 	return buildID, nil
 }
 
+/*============================================================================*/
+// Delete options
+type optionsDeleteStruct struct {
+	Yes bool `validate:"-"`
+}
+
+var optionsDelete optionsDeleteStruct
+
 /*============================================================================*/
 // Describe options
 type optionsDescribeStruct struct {
@@ -166,7 +177,11 @@ var optionsProfile optionsProfileStruct
 /*============================================================================*/
 // Status options
 type optionsStatusStruct struct {
-	Redact bool `validate:"-"`
+	Redact         bool     `validate:"-"`
+	RedactPatterns []string `validate:"-"`
+	Terraform      bool     `validate:"-"`
+	Events         int      `validate:"min=0"`
+	Drift          bool     `validate:"-"`
 }
 
 var optionsStatus optionsStatusStruct
@@ -255,7 +270,12 @@ func init() {
 		"inplace",
 		"c",
 		false,
-		"If the provision operation results in *only* function updates, bypass CloudFormation")
+		"If the provision operation results in *only* function updates, bypass CloudFormation. Other changes are applied with CloudFormation")
+	CommandLineOptions.Provision.Flags().StringVarP(&optionsProvision.Description,
+		"description",
+		"d",
+		"",
+		"Optional stack description that overrides the service description (eg: \"MyService [prod]\")")
 
 	// Delete
 	CommandLineOptions.Delete = &cobra.Command{
@@ -264,6 +284,11 @@ func init() {
 		Long:         `Ensure service is successfully deleted`,
 		SilenceUsage: true,
 	}
+	CommandLineOptions.Delete.Flags().BoolVarP(&optionsDelete.Yes,
+		"yes",
+		"y",
+		false,
+		"Delete the service without a summary and confirmation prompt")
 
 	// Execute
 	CommandLineOptions.Execute = &cobra.Command{
@@ -333,6 +358,22 @@ func init() {
 		"r",
 		false,
 		"Redact AWS Account ID from report")
+	CommandLineOptions.Status.Flags().StringArrayVarP(&optionsStatus.RedactPatterns, "redactPattern",
+		"",
+		nil,
+		"Additional regular expression to redact from the report when --redact is set. May be repeated")
+	CommandLineOptions.Status.Flags().BoolVarP(&optionsStatus.Terraform, "terraform",
+		"",
+		false,
+		"Write `terraform import` commands for the stack resources to stdout")
+	CommandLineOptions.Status.Flags().IntVarP(&optionsStatus.Events, "events",
+		"e",
+		0,
+		"Number of the most recent failed resource events to report")
+	CommandLineOptions.Status.Flags().BoolVarP(&optionsStatus.Drift, "drift",
+		"",
+		false,
+		"Run stack drift detection and report the modified and deleted resources")
 }
 
 // CommandLineOptionsHook allows embedding applications the ability
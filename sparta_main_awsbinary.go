@@ -6,6 +6,7 @@ package sparta
 // in the Lambda context
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -51,6 +52,12 @@ func MainEx(serviceName string,
 	workflowHooks *WorkflowHooks,
 	useCGO bool) error {
 
+	// Report the dispatch handlers s.t. the build can verify
+	// that they match the functions it provisions
+	if len(os.Args) == 2 && os.Args[1] == listHandlersFlag {
+		return json.NewEncoder(os.Stdout).Encode(dispatchHandlerNames(lambdaAWSInfos))
+	}
+
 	// It's possible the user attached a custom command to the
 	// root command. If there is no command, then just run the
 	// Execute command...
@@ -162,9 +162,14 @@ func MainEx(serviceName string,
 			}
 			// Save the BuildID
 			StampedBuildID = buildID
+			// The environment specific description takes precedence
+			stackDescription := serviceDescription
+			if optionsProvision.Description != "" {
+				stackDescription = optionsProvision.Description
+			}
 			return Provision(OptionsGlobal.Noop,
 				serviceName,
-				serviceDescription,
+				stackDescription,
 				lambdaAWSInfos,
 				api,
 				site,
@@ -185,7 +190,12 @@ func MainEx(serviceName string,
 	//////////////////////////////////////////////////////////////////////////////
 	// Delete
 	CommandLineOptions.Delete.RunE = func(cmd *cobra.Command, args []string) error {
-		return Delete(serviceName, OptionsGlobal.Logger)
+		return DeleteWithOptions(serviceName,
+			&DeleteOptions{
+				Force:   optionsDelete.Yes,
+				Confirm: NewConsoleDeleteConfirmation(os.Stdin, os.Stdout),
+			},
+			OptionsGlobal.Logger)
 	}
 
 	CommandLineOptions.Root.AddCommand(CommandLineOptions.Delete)
@@ -296,9 +306,18 @@ func MainEx(serviceName string,
 			if nil != validateErr {
 				return validateErr
 			}
-			return Status(serviceName,
+			statusErr := StatusWithDrift(serviceName,
 				serviceDescription,
 				optionsStatus.Redact,
+				optionsStatus.RedactPatterns,
+				optionsStatus.Events,
+				optionsStatus.Drift,
+				OptionsGlobal.Logger)
+			if statusErr != nil || !optionsStatus.Terraform {
+				return statusErr
+			}
+			return TerraformImports(serviceName,
+				os.Stdout,
 				OptionsGlobal.Logger)
 		}
 	}
@@ -4,12 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	awsLambdaEvents "github.com/aws/aws-lambda-go/events"
 	spartaCFResources "github.com/mweagle/Sparta/aws/cloudformation/resources"
+	spartaIAM "github.com/mweagle/Sparta/aws/iam"
 	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 )
 
 type StructHandler1 struct {
@@ -159,6 +164,40 @@ func TestInvalidFunctionSignature(t *testing.T) {
 		assertError("Failed to reject invalid lambda function signature"))
 }
 
+func TestProxyFunctionSignature(t *testing.T) {
+	validHandlers := []interface{}{
+		func(ctx context.Context, req awsLambdaEvents.APIGatewayProxyRequest) (awsLambdaEvents.APIGatewayProxyResponse, error) {
+			return awsLambdaEvents.APIGatewayProxyResponse{}, nil
+		},
+		func(ctx context.Context) (*awsLambdaEvents.APIGatewayProxyResponse, error) {
+			return nil, nil
+		},
+		func(ctx context.Context) (interface{}, error) {
+			return nil, nil
+		},
+	}
+	for _, eachHandler := range validHandlers {
+		signatureErr := ensureValidProxySignature("ValidProxy", eachHandler)
+		if signatureErr != nil {
+			t.Fatalf("Failed to accept valid proxy signature: %s", signatureErr)
+		}
+	}
+	invalidHandlers := []interface{}{
+		func(ctx context.Context) (string, error) {
+			return "", nil
+		},
+		func(ctx context.Context) error {
+			return nil
+		},
+	}
+	for _, eachHandler := range invalidHandlers {
+		signatureErr := ensureValidProxySignature("InvalidProxy", eachHandler)
+		if signatureErr == nil {
+			t.Fatalf("Failed to reject invalid proxy signature: %T", eachHandler)
+		}
+	}
+}
+
 func TestNOP(t *testing.T) {
 	template := gocf.NewTemplate()
 	s3Resources := gocf.S3Bucket{
@@ -270,5 +309,638 @@ func TestProvisionID(t *testing.T) {
 			t.Fatalf("Failed to roundTrip buildID. User: %s, Computed: %s", eachTestValue, buildID)
 		}
 	}
+	// The derived value is the git SHA with an optional dirty marker
+	gitID := gitBuildID()
+	if gitID != "" && !regexp.MustCompile(`^[0-9a-f]{4,40}(-dirty)?$`).MatchString(gitID) {
+		t.Fatalf("Unexpected git buildID: %s", gitID)
+	}
+}
+
+func TestMetricFilterPattern(t *testing.T) {
+	validPatterns := []string{
+		"ERROR",
+		`"Order placed"`,
+		"{ $.level = \"error\" }",
+		"{ ($.latency > 100) && ($.status = 200) }",
+		"[ip, user, timestamp, request, status_code = 5*, bytes]",
+	}
+	for _, eachPattern := range validPatterns {
+		validateErr := validateMetricFilterPattern(eachPattern)
+		if validateErr != nil {
+			t.Fatalf("Failed to accept valid pattern %s: %s", eachPattern, validateErr)
+		}
+	}
+	invalidPatterns := []string{
+		`"Order placed`,
+		"{ $.level = \"error\" ",
+		"[ip, user",
+		"{ ($.latency > 100 }",
+		"{ level = error }",
+	}
+	for _, eachPattern := range invalidPatterns {
+		validateErr := validateMetricFilterPattern(eachPattern)
+		if validateErr == nil {
+			t.Fatalf("Failed to reject invalid pattern: %s", eachPattern)
+		}
+	}
+}
+
+func TestFunctionURLCors(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options = &LambdaFunctionOptions{
+		FunctionURL: &FunctionURL{
+			AuthType: FunctionURLAuthTypeNone,
+			Cors: &FunctionURLCors{
+				AllowOrigins: []string{"https://example.com"},
+				AllowMethods: []string{"GET", "POST"},
+				MaxAge:       300,
+			},
+		},
+	}
+	roleNameMap := map[string]*gocf.StringExpr{
+		lambdaTestExecuteARN: gocf.String(lambdaTestExecuteARN),
+	}
+	logger, _ := NewLogger("info")
+	template := gocf.NewTemplate()
+	exportErr := lambdaFn.export("TestFunctionURLCors",
+		"testBucket",
+		"testKey",
+		"",
+		"buildID",
+		roleNameMap,
+		template,
+		map[string]interface{}{},
+		logger)
+	if exportErr != nil {
+		t.Fatalf("Failed to export lambda: %s", exportErr)
+	}
+	var urlResourceJSON []byte
+	for _, eachResource := range template.Resources {
+		if eachResource.Properties.CfnResourceType() == cloudFormationLambdaURLType {
+			urlResourceJSON, _ = json.Marshal(eachResource.Properties)
+		}
+	}
+	if urlResourceJSON == nil {
+		t.Fatalf("Failed to find %s resource", cloudFormationLambdaURLType)
+	}
+	expectedJSON := []string{
+		`"AuthType":"NONE"`,
+		`"AllowMethods":["GET","POST"]`,
+		`"AllowOrigins":["https://example.com"]`,
+		`"MaxAge":300`,
+	}
+	for _, eachExpected := range expectedJSON {
+		if !strings.Contains(string(urlResourceJSON), eachExpected) {
+			t.Fatalf("Expected %s in %s resource: %s",
+				eachExpected,
+				cloudFormationLambdaURLType,
+				string(urlResourceJSON))
+		}
+	}
+	if strings.Contains(string(urlResourceJSON), "AllowCredentials") {
+		t.Fatalf("Unexpected AllowCredentials: %s", string(urlResourceJSON))
+	}
+
+	lambdaFn.Options.FunctionURL.Cors.AllowCredentials = true
+	lambdaFn.Options.FunctionURL.Cors.AllowOrigins = []string{"*"}
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 {
+		t.Fatalf("Failed to reject credentials with a wildcard origin: %v", errorText)
+	}
+}
 
+func TestFunctionURLOutput(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options.FunctionURL = &FunctionURL{
+		AuthType: FunctionURLAuthTypeIAM,
+	}
+	template := gocf.NewTemplate()
+	exportErr := lambdaFn.exportFunctionURL(gocf.GetAtt("MyFunction", "Arn"), template)
+	if exportErr != nil {
+		t.Fatalf("Failed to export FunctionURL: %s", exportErr)
+	}
+	if _, exists := template.Outputs[lambdaFn.FunctionURLOutputName()]; !exists {
+		t.Fatalf("Failed to find FunctionURL Output: %s", lambdaFn.FunctionURLOutputName())
+	}
+	for _, eachResource := range template.Resources {
+		if eachResource.Properties.CfnResourceType() == "AWS::Lambda::Permission" {
+			t.Fatalf("Unexpected public invoke permission for AWS_IAM FunctionURL")
+		}
+	}
+}
+
+func TestDispatchHandlerMismatch(t *testing.T) {
+	expected := dispatchHandlerNames(testLambdaStructData())
+	if err := dispatchHandlerMismatch(expected, expected); err != nil {
+		t.Fatalf("Failed to accept matching handlers: %s", err)
+	}
+	if err := dispatchHandlerMismatch(expected, expected[1:]); err == nil {
+		t.Fatalf("Failed to reject missing handler")
+	}
+	if err := dispatchHandlerMismatch(expected, append(expected, "unknown")); err == nil {
+		t.Fatalf("Failed to reject unknown handler")
+	}
+}
+
+func TestWarmupConfig(t *testing.T) {
+	for _, eachExpression := range []string{"rate(1 minute)",
+		"rate(5 minutes)",
+		"cron(0/5 8-17 ? * MON-FRI *)"} {
+		if err := validateScheduleExpression(eachExpression); err != nil {
+			t.Fatalf("Failed to accept schedule %s: %s", eachExpression, err)
+		}
+	}
+	for _, eachExpression := range []string{"rate(1 minutes)",
+		"rate(5 minute)",
+		"cron(0/5 8-17 * * MON-FRI *)",
+		"every 5 minutes"} {
+		if err := validateScheduleExpression(eachExpression); err == nil {
+			t.Fatalf("Failed to reject schedule %s", eachExpression)
+		}
+	}
+	lambdaFn, _ := NewAWSLambda("WarmFunction",
+		func(ctx context.Context) (string, error) {
+			if IsWarmupEvent(ctx) {
+				return "", nil
+			}
+			return "Hello World", nil
+		},
+		IAMRoleDefinition{})
+	dispatcher, dispatcherErr := NewWarmupDispatcher(&WarmupConfig{
+		Schedules: map[string]*WarmupSchedule{
+			"BusinessHours": {
+				ScheduleExpression: "cron(0/5 8-17 ? * MON-FRI *)",
+				FunctionNames:      []string{"WarmFunction", "MissingFunction"},
+			},
+		},
+	})
+	if dispatcherErr != nil {
+		t.Fatalf("Failed to create warmup dispatcher: %s", dispatcherErr)
+	}
+	validateErr := dispatcher.warmupConfig.validateFunctions([]*LambdaAWSInfo{lambdaFn, dispatcher})
+	if validateErr == nil || !strings.Contains(validateErr.Error(), "MissingFunction") {
+		t.Fatalf("Failed to reject undefined warmup function: %v", validateErr)
+	}
+}
+
+func TestIAMRoleTrustedPrincipals(t *testing.T) {
+	roleDefinition := &IAMRoleDefinition{
+		TrustedPrincipals: []string{EdgeLambdaPrincipal, LambdaPrincipal},
+	}
+	if err := roleDefinition.validate(); err != nil {
+		t.Fatalf("Failed to accept trusted principals: %s", err)
+	}
+	roleResource := roleDefinition.toResource(nil, nil, logrus.New())
+	policyJSON, policyJSONErr := json.Marshal(roleResource.AssumeRolePolicyDocument)
+	if policyJSONErr != nil {
+		t.Fatalf("Failed to marshal assume role policy: %s", policyJSONErr)
+	}
+	if strings.Count(string(policyJSON), EdgeLambdaPrincipal) != 1 ||
+		strings.Count(string(policyJSON), `"`+LambdaPrincipal+`"`) != 1 {
+		t.Fatalf("Unexpected assume role policy: %s", policyJSON)
+	}
+	for _, eachPrincipal := range defaultAssumeRolePrincipals {
+		if !strings.Contains(string(policyJSON), `"`+eachPrincipal+`"`) {
+			t.Fatalf("Expected default principal %s: %s", eachPrincipal, policyJSON)
+		}
+	}
+	defaultPolicyJSON, _ := json.Marshal(AssumePolicyDocument)
+	if strings.Contains(string(defaultPolicyJSON), EdgeLambdaPrincipal) {
+		t.Fatalf("Expected the default AssumePolicyDocument to be unchanged: %s", defaultPolicyJSON)
+	}
+	roleDefinition.TrustedPrincipals = []string{"arn:aws:iam::123412341234:root"}
+	if err := roleDefinition.validate(); err == nil {
+		t.Fatalf("Failed to reject non-service principal")
+	}
+}
+
+func TestIAMRoleManagedPolicyArns(t *testing.T) {
+	vpcPolicyArn := "arn:aws:iam::aws:policy/service-role/AWSLambdaVPCAccessExecutionRole"
+	roleDefinition := &IAMRoleDefinition{
+		Privileges: []IAMRolePrivilege{
+			{
+				Actions:  []string{"s3:GetObject"},
+				Resource: "arn:aws:s3:::testBucket/*",
+			},
+		},
+		ManagedPolicyArns: []gocf.Stringable{
+			gocf.String(vpcPolicyArn),
+		},
+	}
+	if err := roleDefinition.validate(); err != nil {
+		t.Fatalf("Failed to accept managed policy ARNs: %s", err)
+	}
+	roleJSON, roleJSONErr := json.Marshal(roleDefinition.toResource(nil, nil, logrus.New()))
+	if roleJSONErr != nil {
+		t.Fatalf("Failed to marshal role: %s", roleJSONErr)
+	}
+	if !strings.Contains(string(roleJSON), `"ManagedPolicyArns":["`+vpcPolicyArn+`"]`) ||
+		!strings.Contains(string(roleJSON), "s3:GetObject") {
+		t.Fatalf("Expected managed and inline policies: %s", roleJSON)
+	}
+	roleDefinition.ManagedPolicyArns = []gocf.Stringable{nil}
+	if err := roleDefinition.validate(); err == nil {
+		t.Fatalf("Failed to reject nil managed policy ARN")
+	}
+}
+
+func TestProvisionedConcurrencyAlias(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options.ProvisionedConcurrency = &ProvisionedConcurrency{
+		AliasName:                       "live",
+		ProvisionedConcurrentExecutions: 5,
+	}
+	if errorText := validateLambdaFunctionOptions(lambdaFn); len(errorText) == 0 {
+		t.Fatalf("Failed to reject ProvisionedConcurrency without an Alias")
+	}
+	lambdaFn.Options.Alias = &FunctionAlias{
+		Name: "live",
+	}
+	if errorText := validateLambdaFunctionOptions(lambdaFn); len(errorText) != 0 {
+		t.Fatalf("Unexpected validation errors: %s", strings.Join(errorText, "\n"))
+	}
+	template := gocf.NewTemplate()
+	exportErr := lambdaFn.exportAlias("buildID", template)
+	if exportErr != nil {
+		t.Fatalf("Failed to export alias: %s", exportErr)
+	}
+	aliasResource, aliasResourceOk := template.Resources[lambdaFn.aliasLogicalName()].Properties.(*gocf.LambdaAlias)
+	if !aliasResourceOk ||
+		aliasResource.ProvisionedConcurrencyConfig == nil ||
+		aliasResource.ProvisionedConcurrencyConfig.ProvisionedConcurrentExecutions.Literal != 5 {
+		t.Fatalf("Expected provisioned concurrency on the live alias")
+	}
+	versionResourceName := CloudFormationResourceName("LambdaVersion",
+		lambdaFn.lambdaFunctionName(),
+		"buildID")
+	if template.Resources[versionResourceName].DeletionPolicy != "Retain" {
+		t.Fatalf("Expected the published version to be retained")
+	}
+	// The upper bound is enforced by the service
+	lambdaFn.Options.ProvisionedConcurrency.ProvisionedConcurrentExecutions = 5000
+	if errorText := validateLambdaFunctionOptions(lambdaFn); len(errorText) != 0 {
+		t.Fatalf("Unexpected validation errors: %s", strings.Join(errorText, "\n"))
+	}
+	lambdaFn.Options.ProvisionedConcurrency.ProvisionedConcurrentExecutions = 0
+	if errorText := validateLambdaFunctionOptions(lambdaFn); len(errorText) == 0 {
+		t.Fatalf("Failed to reject zero ProvisionedConcurrentExecutions")
+	}
+	lambdaFn.Options.ProvisionedConcurrency.ProvisionedConcurrentExecutions = 5
+	// Event sources target the alias rather than $LATEST
+	permResourceName, permErr := BasePermission{
+		SourceArn: "arn:aws:sns:us-west-2:123412341234:MyTopic",
+	}.export(gocf.String(SNSPrincipal),
+		nil,
+		lambdaFn.lambdaFunctionName(),
+		lambdaFn.LogicalResourceName(),
+		template,
+		"testBucket",
+		"testKey",
+		logrus.New())
+	if permErr != nil {
+		t.Fatalf("Failed to export permission: %s", permErr)
+	}
+	permResource := template.Resources[permResourceName].Properties.(gocf.LambdaPermission)
+	permFunctionName, _ := json.Marshal(permResource.FunctionName)
+	if !strings.Contains(string(permFunctionName), `"Ref":"`+lambdaFn.aliasLogicalName()+`"`) {
+		t.Fatalf("Expected permission to target the alias: %s", permFunctionName)
+	}
+	unaliasedTarget, _ := json.Marshal(lambdaTargetArn("unaliased",
+		"UnaliasedFunction",
+		template))
+	if !strings.Contains(string(unaliasedTarget), "Fn::GetAtt") {
+		t.Fatalf("Expected unaliased function ARN: %s", unaliasedTarget)
+	}
+}
+
+func TestReservedEnvironmentVariables(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options.Environment = map[string]*gocf.StringExpr{
+		"MY_VALUE":   gocf.String("value"),
+		"AWS_REGION": gocf.String("us-west-2"),
+	}
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 || !strings.Contains(errorText[0], "AWS_REGION") {
+		t.Fatalf("Expected reserved AWS_REGION error, got: %v", errorText)
+	}
+	t.Logf("Expected error: %s", errorText[0])
+}
+
+func TestSNSPermissionSubscription(t *testing.T) {
+	perm := SNSPermission{
+		BasePermission: BasePermission{
+			SourceArn: gocf.Ref("MyTopic").String(),
+		},
+		Subscription: &SNSSubscription{
+			FilterPolicy: `{"eventType": ["order_placed"]}`,
+		},
+	}
+	template := gocf.NewTemplate()
+	_, exportErr := perm.export("SNSService",
+		"snsProcessor",
+		"SNSProcessorLambda",
+		template,
+		"bucket",
+		"key",
+		logrus.New())
+	if exportErr != nil {
+		t.Fatalf("Failed to export SNS subscription: %s", exportErr)
+	}
+	subscriptionCount := 0
+	for _, eachResource := range template.Resources {
+		subscription, subscriptionOk := eachResource.Properties.(*gocf.SNSSubscription)
+		if !subscriptionOk {
+			continue
+		}
+		subscriptionCount++
+		if subscription.FilterPolicy == nil {
+			t.Fatalf("Expected SNS subscription FilterPolicy")
+		}
+	}
+	if subscriptionCount != 1 {
+		t.Fatalf("Expected 1 SNS subscription, got %d", subscriptionCount)
+	}
+
+	perm.Subscription.FilterPolicy = `{"eventType": "order_placed"}`
+	_, exportErr = perm.export("SNSService",
+		"snsProcessor",
+		"SNSProcessorLambda",
+		gocf.NewTemplate(),
+		"bucket",
+		"key",
+		logrus.New())
+	if exportErr == nil {
+		t.Fatalf("Failed to reject invalid SNS FilterPolicy")
+	}
+	t.Logf("Expected error: %s", exportErr)
+}
+
+func TestLayerLimit(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Layers = []gocf.Stringable{
+		gocf.Ref("ModelLayer"),
+		gocf.String("arn:aws:lambda:us-west-2:123412341234:layer:common:1"),
+	}
+	validateErr := validateSpartaPreconditions([]*LambdaAWSInfo{lambdaFn}, logrus.New())
+	if validateErr != nil {
+		t.Fatalf("Unexpected layer validation error: %s", validateErr)
+	}
+	for len(lambdaFn.Layers) <= lambdaMaxLayers {
+		lambdaFn.Layers = append(lambdaFn.Layers, gocf.String("arn:aws:lambda:us-west-2:123412341234:layer:extra:1"))
+	}
+	validateErr = validateSpartaPreconditions([]*LambdaAWSInfo{lambdaFn}, logrus.New())
+	if validateErr == nil || !strings.Contains(validateErr.Error(), "layers") {
+		t.Fatalf("Expected layer limit error, got: %v", validateErr)
+	}
+	t.Logf("Expected error: %s", validateErr)
+}
+
+func TestReservedConcurrency(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	if lambdaFn.Options.reservedConcurrency() != nil {
+		t.Fatalf("Expected unset reserved concurrency")
+	}
+	exportedFunctionJSON := func() string {
+		template := gocf.NewTemplate()
+		exportErr := lambdaFn.export("TestReservedConcurrency",
+			"testBucket",
+			"testKey",
+			"",
+			"buildID",
+			map[string]*gocf.StringExpr{
+				lambdaTestExecuteARN: gocf.String(lambdaTestExecuteARN),
+			},
+			template,
+			map[string]interface{}{},
+			logrus.New())
+		if exportErr != nil {
+			t.Fatalf("Failed to export lambda: %s", exportErr)
+		}
+		resource, exists := template.Resources[lambdaFn.LogicalResourceName()]
+		if !exists {
+			t.Fatalf("Failed to find lambda resource: %s", lambdaFn.LogicalResourceName())
+		}
+		functionJSON, functionJSONErr := json.Marshal(resource.Properties)
+		if functionJSONErr != nil {
+			t.Fatalf("Failed to marshal function: %s", functionJSONErr)
+		}
+		return string(functionJSON)
+	}
+	if strings.Contains(exportedFunctionJSON(), "ReservedConcurrentExecutions") {
+		t.Fatalf("Unexpected ReservedConcurrentExecutions: %s", exportedFunctionJSON())
+	}
+	// Zero reserved concurrency is exported to throttle the function
+	zero := int64(0)
+	lambdaFn.Options.ReservedConcurrency = &zero
+	if !strings.Contains(exportedFunctionJSON(), `"ReservedConcurrentExecutions":0`) {
+		t.Fatalf("Expected zero ReservedConcurrentExecutions: %s", exportedFunctionJSON())
+	}
+	lambdaFn.Options.ReservedConcurrentExecutions = 10
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 {
+		t.Fatalf("Expected ReservedConcurrency conflict error, got: %v", errorText)
+	}
+}
+
+func TestDeadLetterIAMStatement(t *testing.T) {
+	roleDefinition := &IAMRoleDefinition{}
+	options := &LambdaFunctionOptions{
+		DeadLetterConfigArn: gocf.String("arn:aws:sqs:us-west-2:123412341234:dlq"),
+	}
+	var dlqStatement *spartaIAM.PolicyStatement
+	for _, eachEntry := range roleDefinition.statementProvenance(options) {
+		if eachEntry.source == "DeadLetterConfigArn" {
+			dlqStatement = &eachEntry.statement
+		}
+	}
+	if dlqStatement == nil ||
+		len(dlqStatement.Action) != 1 ||
+		dlqStatement.Action[0] != "sqs:SendMessage" {
+		t.Fatalf("Expected sqs:SendMessage statement, got: %#v", dlqStatement)
+	}
+	refStatement := deadLetterIAMStatement(gocf.GetAtt("DeadLetterQueue", "Arn"))
+	if len(refStatement.Action) != 2 {
+		t.Fatalf("Expected SNS and SQS actions for a reference, got: %#v", refStatement.Action)
+	}
+}
+
+func TestKmsKeyIAMStatement(t *testing.T) {
+	kmsStatement := func(options *LambdaFunctionOptions) *spartaIAM.PolicyStatement {
+		roleDefinition := &IAMRoleDefinition{}
+		for _, eachEntry := range roleDefinition.statementProvenance(options) {
+			if eachEntry.source == "KmsKey" {
+				return &eachEntry.statement
+			}
+		}
+		return nil
+	}
+	testCases := []struct {
+		options  *LambdaFunctionOptions
+		expected string
+	}{
+		{&LambdaFunctionOptions{
+			KmsKeyArn: "arn:aws:kms:us-east-1:123412341234:key/literal",
+		}, `"arn:aws:kms:us-east-1:123412341234:key/literal"`},
+		{&LambdaFunctionOptions{
+			KmsKey: gocf.GetAtt("EnvironmentKey", "Arn"),
+		}, `{"Fn::GetAtt":["EnvironmentKey","Arn"]}`},
+		// KmsKey takes precedence
+		{&LambdaFunctionOptions{
+			KmsKeyArn: "arn:aws:kms:us-east-1:123412341234:key/literal",
+			KmsKey:    gocf.GetAtt("EnvironmentKey", "Arn"),
+		}, `{"Fn::GetAtt":["EnvironmentKey","Arn"]}`},
+	}
+	for _, eachTestCase := range testCases {
+		statement := kmsStatement(eachTestCase.options)
+		if statement == nil ||
+			len(statement.Action) != 1 ||
+			statement.Action[0] != "kms:Decrypt" {
+			t.Fatalf("Expected kms:Decrypt statement, got: %#v", statement)
+		}
+		resourceJSON, _ := json.Marshal(statement.Resource)
+		if string(resourceJSON) != eachTestCase.expected {
+			t.Fatalf("Expected kms:Decrypt on %s, got: %s", eachTestCase.expected, resourceJSON)
+		}
+		keyJSON, _ := json.Marshal(eachTestCase.options.kmsKey())
+		if string(keyJSON) != eachTestCase.expected {
+			t.Fatalf("Expected KmsKeyArn %s, got: %s", eachTestCase.expected, keyJSON)
+		}
+	}
+	if statement := kmsStatement(&LambdaFunctionOptions{}); statement != nil {
+		t.Fatalf("Unexpected kms:Decrypt statement for the default key: %#v", statement)
+	}
+}
+
+func TestTracingConfigMode(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options.TracingConfig = &gocf.LambdaFunctionTracingConfig{
+		Mode: gocf.String("Active"),
+	}
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 0 {
+		t.Fatalf("Unexpected TracingConfig error: %v", errorText)
+	}
+	lambdaFn.Options.TracingConfig.Mode = gocf.String("active")
+	errorText = validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 {
+		t.Fatalf("Expected TracingConfig Mode error, got: %v", errorText)
+	}
+}
+
+func TestVpcConfig(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	lambdaFn.Options.VpcConfig = &gocf.LambdaFunctionVPCConfig{
+		SubnetIDs: gocf.StringList(gocf.String("subnet-1234")),
+	}
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 || !strings.Contains(errorText[0], "security group") {
+		t.Fatalf("Expected security group error, got: %v", errorText)
+	}
+	lambdaFn.Options.VpcConfig.SecurityGroupIDs = gocf.Ref("SecurityGroupIDs").StringList()
+	errorText = validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 0 {
+		t.Fatalf("Unexpected VpcConfig error: %v", errorText)
+	}
+}
+
+func TestLogRetention(t *testing.T) {
+	lambdaFn := testLambdaStructData()[0]
+	template := gocf.NewTemplate()
+	if lambdaFn.exportLogGroup(gocf.String("MyFunction"), template) != "" {
+		t.Fatalf("Unexpected log group without a retention or metric filters")
+	}
+	lambdaFn.serviceLogRetentionInDays = 30
+	logGroupName := lambdaFn.exportLogGroup(gocf.String("MyFunction"), template)
+	logGroupResource, exists := template.Resources[logGroupName]
+	if !exists {
+		t.Fatalf("Failed to export log group for service log retention")
+	}
+	logGroup := logGroupResource.Properties.(*gocf.LogsLogGroup)
+	if logGroup.RetentionInDays.Literal != 30 {
+		t.Fatalf("Unexpected log retention: %#v", logGroup.RetentionInDays)
+	}
+
+	lambdaFn.Options.LogRetentionInDays = 45
+	errorText := validateLambdaFunctionOptions(lambdaFn)
+	if len(errorText) != 1 || !strings.Contains(errorText[0], "45 days") {
+		t.Fatalf("Failed to reject unsupported log retention: %v", errorText)
+	}
+	lambdaFn.Options.LogRetentionInDays = 7
+	if lambdaFn.logRetentionInDays() != 7 {
+		t.Fatalf("Function log retention didn't override the service default")
+	}
+}
+
+func TestLambdaFunctionTags(t *testing.T) {
+	lambdaFunctions := testLambdaStructData()
+	lambdaFunctions[0].serviceTags = map[string]string{
+		"Team": "platform",
+	}
+	lambdaFunctions[0].Tags = map[string]string{
+		"PII": "true",
+	}
+	lambdaFunctions[1].Tags = map[string]string{}
+	roleNameMap := map[string]*gocf.StringExpr{
+		lambdaTestExecuteARN: gocf.String(lambdaTestExecuteARN),
+	}
+	logger, _ := NewLogger("info")
+	template := gocf.NewTemplate()
+	for _, eachLambda := range lambdaFunctions {
+		exportErr := eachLambda.export("TestLambdaFunctionTags",
+			"testBucket",
+			"testKey",
+			"",
+			"buildID",
+			roleNameMap,
+			template,
+			map[string]interface{}{},
+			logger)
+		if exportErr != nil {
+			t.Fatalf("Failed to export lambda: %s", exportErr)
+		}
+	}
+	for index, eachLambda := range lambdaFunctions {
+		resource, exists := template.Resources[eachLambda.LogicalResourceName()]
+		if !exists {
+			t.Fatalf("Failed to find lambda resource: %s", eachLambda.LogicalResourceName())
+		}
+		resourceJSON, resourceJSONErr := json.Marshal(resource.Properties)
+		if resourceJSONErr != nil {
+			t.Fatalf("Failed to marshal lambda resource: %s", resourceJSONErr)
+		}
+		hasTags := strings.Contains(string(resourceJSON), `"Tags"`)
+		if index == 0 {
+			if !strings.Contains(string(resourceJSON), `{"Key":"PII","Value":"true"}`) ||
+				!strings.Contains(string(resourceJSON), `{"Key":"Team","Value":"platform"}`) {
+				t.Fatalf("Expected merged function tags: %s", string(resourceJSON))
+			}
+		} else if hasTags {
+			t.Fatalf("Expected empty function tags to be omitted: %s", string(resourceJSON))
+		}
+	}
+}
+
+func TestCommandLineFlagShorthands(t *testing.T) {
+	commands := map[string]*cobra.Command{
+		"version":   CommandLineOptions.Version,
+		"provision": CommandLineOptions.Provision,
+		"delete":    CommandLineOptions.Delete,
+		"execute":   CommandLineOptions.Execute,
+		"describe":  CommandLineOptions.Describe,
+		"explore":   CommandLineOptions.Explore,
+		"profile":   CommandLineOptions.Profile,
+		"status":    CommandLineOptions.Status,
+	}
+	for eachName, eachCommand := range commands {
+		// Cobra merges the root persistent flags into each command's
+		// flagset when the command runs and panics on shorthand conflicts
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("Failed to merge %s flags: %v", eachName, r)
+				}
+			}()
+			eachCommand.Flags().AddFlagSet(CommandLineOptions.Root.PersistentFlags())
+		}()
+	}
 }
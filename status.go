@@ -3,24 +3,190 @@
 package sparta
 
 import (
+	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/sts"
 	spartaAWS "github.com/mweagle/Sparta/aws"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Status produces a status report for the given stack
-func Status(serviceName string,
-	serviceDescription string,
+// StackOutput is a CloudFormation stack output value
+type StackOutput struct {
+	Key         string
+	Value       string
+	Description string `json:",omitempty"`
+	ExportName  string `json:",omitempty"`
+}
+
+// StackStatus is the status report for a provisioned service
+type StackStatus struct {
+	// ServiceName is the name of the stack
+	ServiceName string
+	// Region is the stack's region
+	Region string
+	// Exists is false if the stack doesn't exist, in which case
+	// the remaining fields are empty
+	Exists          bool
+	StackID         string
+	Description     string
+	Status          string
+	Reason          string `json:",omitempty"`
+	CreatedTime     time.Time
+	LastUpdatedTime *time.Time `json:",omitempty"`
+	DeletedTime     *time.Time `json:",omitempty"`
+	Parameters      map[string]string
+	Tags            map[string]string
+	// Outputs are ordered as returned by CloudFormation
+	Outputs []*StackOutput
+	// The Parameters and Tags keys in the order returned by
+	// CloudFormation
+	parameterKeys []string
+	tagKeys       []string
+	// redactor masks the values reported for the stack
+	redactor func(string) string
+}
+
+// utcTime returns the optional time in UTC
+func utcTime(value *time.Time) *time.Time {
+	if value == nil {
+		return nil
+	}
+	utcValue := value.UTC()
+	return &utcValue
+}
+
+// newStackStatus returns the StackStatus for the described stack,
+// with the redactor applied to the user values
+func newStackStatus(serviceName string,
+	region string,
+	stackInfo *cloudformation.Stack,
+	redactor func(string) string) *StackStatus {
+
+	report := &StackStatus{
+		ServiceName:     serviceName,
+		Region:          region,
+		Exists:          true,
+		StackID:         redactor(aws.StringValue(stackInfo.StackId)),
+		Description:     redactor(aws.StringValue(stackInfo.Description)),
+		Status:          aws.StringValue(stackInfo.StackStatus),
+		Reason:          redactor(aws.StringValue(stackInfo.StackStatusReason)),
+		CreatedTime:     aws.TimeValue(stackInfo.CreationTime).UTC(),
+		LastUpdatedTime: utcTime(stackInfo.LastUpdatedTime),
+		DeletedTime:     utcTime(stackInfo.DeletionTime),
+		Parameters:      make(map[string]string, len(stackInfo.Parameters)),
+		Tags:            make(map[string]string, len(stackInfo.Tags)),
+		Outputs:         make([]*StackOutput, 0, len(stackInfo.Outputs)),
+		redactor:        redactor,
+	}
+	for _, eachParam := range stackInfo.Parameters {
+		paramKey := aws.StringValue(eachParam.ParameterKey)
+		report.Parameters[paramKey] = redactor(aws.StringValue(eachParam.ParameterValue))
+		report.parameterKeys = append(report.parameterKeys, paramKey)
+	}
+	for _, eachTag := range stackInfo.Tags {
+		tagKey := aws.StringValue(eachTag.Key)
+		report.Tags[tagKey] = redactor(aws.StringValue(eachTag.Value))
+		report.tagKeys = append(report.tagKeys, tagKey)
+	}
+	for _, eachOutput := range stackInfo.Outputs {
+		report.Outputs = append(report.Outputs, &StackOutput{
+			Key:         aws.StringValue(eachOutput.OutputKey),
+			Value:       redactor(aws.StringValue(eachOutput.OutputValue)),
+			Description: aws.StringValue(eachOutput.Description),
+			ExportName:  aws.StringValue(eachOutput.ExportName),
+		})
+	}
+	return report
+}
+
+// Output returns the stack Output with the given key, or nil if the
+// stack doesn't define it
+func (status *StackStatus) Output(key string) *StackOutput {
+	for _, eachOutput := range status.Outputs {
+		if eachOutput.Key == key {
+			return eachOutput
+		}
+	}
+	return nil
+}
+
+// compileRedactPatterns compiles the Status redaction regular expressions
+func compileRedactPatterns(redactPatterns []string) ([]*regexp.Regexp, error) {
+	var errorText []string
+	compiled := make([]*regexp.Regexp, 0, len(redactPatterns))
+	for _, eachPattern := range redactPatterns {
+		expr, exprErr := regexp.Compile(eachPattern)
+		if exprErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Invalid redact pattern %s: %s", eachPattern, exprErr))
+			continue
+		}
+		compiled = append(compiled, expr)
+	}
+	if len(errorText) != 0 {
+		return nil, errors.New(strings.Join(errorText, "\n"))
+	}
+	return compiled, nil
+}
+
+// newStatusRedactor returns the function that masks the accountID and
+// every match of the redactPatterns. Masked values are replaced with
+// asterisks of the same length. An empty accountID isn't masked.
+func newStatusRedactor(accountID string,
+	redactPatterns []*regexp.Regexp) func(string) string {
+	return func(stringValue string) string {
+		if accountID != "" {
+			stringValue = strings.Replace(stringValue,
+				accountID,
+				strings.Repeat("*", len(accountID)),
+				-1)
+		}
+		for _, eachPattern := range redactPatterns {
+			stringValue = eachPattern.ReplaceAllStringFunc(stringValue,
+				func(match string) string {
+					return strings.Repeat("*", len(match))
+				})
+		}
+		return stringValue
+	}
+}
+
+// StatusReport returns the status of the given stack. If redact is true,
+// the AWS account ID is masked in the reported values.
+func StatusReport(serviceName string,
 	redact bool,
-	logger *logrus.Logger) error {
+	logger *logrus.Logger) (*StackStatus, error) {
+	return StatusReportWithRedactions(serviceName, redact, nil, logger)
+}
 
+// StatusReportWithRedactions returns the status of the given stack. If
+// redact is true, the AWS account ID and every match of the redactPatterns
+// regular expressions are masked in the reported values. Use
+// regexp.QuoteMeta to redact a literal string. The redactPatterns are
+// ignored if redact is false.
+func StatusReportWithRedactions(serviceName string,
+	redact bool,
+	redactPatterns []string,
+	logger *logrus.Logger) (*StackStatus, error) {
+
+	var compiledPatterns []*regexp.Regexp
+	if redact {
+		patterns, patternsErr := compileRedactPatterns(redactPatterns)
+		if patternsErr != nil {
+			return nil, patternsErr
+		}
+		compiledPatterns = patterns
+	}
 	awsSession := spartaAWS.NewSession(logger)
 	cfSvc := cloudformation.New(awsSession)
+	region := aws.StringValue(awsSession.Config.Region)
 
 	params := &cloudformation.DescribeStacksInput{
 		StackName: aws.String(serviceName),
@@ -29,13 +195,15 @@ func Status(serviceName string,
 
 	if describeStacksResponseErr != nil {
 		if strings.Contains(describeStacksResponseErr.Error(), "does not exist") {
-			logger.WithField("Region", *awsSession.Config.Region).Info("Stack does not exist")
-			return nil
+			return &StackStatus{
+				ServiceName: serviceName,
+				Region:      region,
+			}, nil
 		}
-		return describeStacksResponseErr
+		return nil, describeStacksResponseErr
 	}
 	if len(describeStacksResponse.Stacks) > 1 {
-		return errors.Errorf("More than 1 stack returned for %s. Count: %d",
+		return nil, errors.Errorf("More than 1 stack returned for %s. Count: %d",
 			serviceName,
 			len(describeStacksResponse.Stacks))
 	}
@@ -49,60 +217,316 @@ func Status(serviceName string,
 		stsSvc := sts.New(awsSession)
 		identityResponse, identityResponseErr := stsSvc.GetCallerIdentity(input)
 		if identityResponseErr != nil {
-			return identityResponseErr
+			return nil, identityResponseErr
 		}
-		redactedValue := strings.Repeat("*", len(*identityResponse.Account))
-		redactor = func(stringValue string) string {
-			return strings.Replace(stringValue,
-				*identityResponse.Account,
-				redactedValue,
-				-1)
+		redactor = newStatusRedactor(aws.StringValue(identityResponse.Account),
+			compiledPatterns)
+	}
+	return newStackStatus(serviceName,
+		region,
+		describeStacksResponse.Stacks[0],
+		redactor), nil
+}
+
+// logOrderedValues logs the map values in the keys order
+func logOrderedValues(keys []string, values map[string]string, logger *logrus.Logger) {
+	for _, eachKey := range keys {
+		logger.WithField("Value", values[eachKey]).Info(eachKey)
+	}
+}
+
+// StackEvent is a failed stack resource event
+type StackEvent struct {
+	Timestamp            time.Time
+	LogicalResourceID    string
+	ResourceType         string
+	ResourceStatus       string
+	ResourceStatusReason string `json:",omitempty"`
+}
+
+// describeStackEventsAPI is the DescribeStackEvents subset of the
+// CloudFormation API
+type describeStackEventsAPI interface {
+	DescribeStackEvents(*cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error)
+}
+
+// isStackCreationEvent returns true for the first event of the stack
+func isStackCreationEvent(event *cloudformation.StackEvent) bool {
+	return aws.StringValue(event.ResourceType) == "AWS::CloudFormation::Stack" &&
+		aws.StringValue(event.ResourceStatus) == cloudformation.ResourceStatusCreateInProgress &&
+		aws.StringValue(event.PhysicalResourceId) == aws.StringValue(event.StackId)
+}
+
+// failureStackEvents returns up to maxEvents of the most recent failed
+// resource events, ordered newest-last, with the redactor applied to the
+// status reason. Events are returned newest-first by DescribeStackEvents,
+// so pages are requested until there are enough failures or the stack
+// creation event is reached.
+func failureStackEvents(cfSvc describeStackEventsAPI,
+	stackName string,
+	maxEvents int,
+	redactor func(string) string) ([]*StackEvent, error) {
+
+	events := make([]*StackEvent, 0)
+	params := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	}
+	for {
+		resp, respErr := cfSvc.DescribeStackEvents(params)
+		if respErr != nil {
+			return nil, errors.Wrapf(respErr, "Failed to describe events for stack %s", stackName)
 		}
+		for _, eachEvent := range resp.StackEvents {
+			if strings.HasSuffix(aws.StringValue(eachEvent.ResourceStatus), "_FAILED") {
+				events = append(events, &StackEvent{
+					Timestamp:            aws.TimeValue(eachEvent.Timestamp).UTC(),
+					LogicalResourceID:    aws.StringValue(eachEvent.LogicalResourceId),
+					ResourceType:         aws.StringValue(eachEvent.ResourceType),
+					ResourceStatus:       aws.StringValue(eachEvent.ResourceStatus),
+					ResourceStatusReason: redactor(aws.StringValue(eachEvent.ResourceStatusReason)),
+				})
+			}
+			if len(events) >= maxEvents || isStackCreationEvent(eachEvent) {
+				resp.NextToken = nil
+				break
+			}
+		}
+		if resp.NextToken == nil {
+			break
+		}
+		params.NextToken = resp.NextToken
+	}
+	// Newest-last
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// Stack drift detection is bounded so that a stuck detection doesn't
+// block the status report indefinitely
+const (
+	driftDetectionPollInterval = 5 * time.Second
+	driftDetectionTimeout      = 5 * time.Minute
+)
+
+// StackResourceDrift is a stack resource whose actual configuration
+// differs from the template
+type StackResourceDrift struct {
+	LogicalResourceID   string
+	ResourceType        string
+	DriftStatus         string
+	PropertyDifferences []*cloudformation.PropertyDifference `json:",omitempty"`
+}
+
+// stackResourceDrifts returns the MODIFIED and DELETED stack resources
+// with the redactor applied to the expected and actual
+// property values
+func stackResourceDrifts(cfSvc spartaCF.StackDriftAPI,
+	stackName string,
+	redactor func(string) string,
+	pollInterval time.Duration,
+	timeout time.Duration,
+	logger *logrus.Logger) ([]*StackResourceDrift, error) {
+
+	resourceDrifts, resourceDriftsErr := spartaCF.StackResourceDrifts(cfSvc,
+		stackName,
+		[]string{
+			cloudformation.StackResourceDriftStatusModified,
+			cloudformation.StackResourceDriftStatusDeleted,
+		},
+		pollInterval,
+		timeout,
+		logger)
+	if resourceDriftsErr != nil {
+		return nil, resourceDriftsErr
+	}
+	drifts := make([]*StackResourceDrift, 0, len(resourceDrifts))
+	for _, eachDrift := range resourceDrifts {
+		differences := make([]*cloudformation.PropertyDifference, 0, len(eachDrift.PropertyDifferences))
+		for _, eachDiff := range eachDrift.PropertyDifferences {
+			differences = append(differences, &cloudformation.PropertyDifference{
+				PropertyPath:   eachDiff.PropertyPath,
+				DifferenceType: eachDiff.DifferenceType,
+				ExpectedValue:  aws.String(redactor(aws.StringValue(eachDiff.ExpectedValue))),
+				ActualValue:    aws.String(redactor(aws.StringValue(eachDiff.ActualValue))),
+			})
+		}
+		drifts = append(drifts, &StackResourceDrift{
+			LogicalResourceID:   aws.StringValue(eachDrift.LogicalResourceId),
+			ResourceType:        aws.StringValue(eachDrift.ResourceType),
+			DriftStatus:         aws.StringValue(eachDrift.StackResourceDriftStatus),
+			PropertyDifferences: differences,
+		})
+	}
+	return drifts, nil
+}
+
+// Status produces a status report for the given stack
+func Status(serviceName string,
+	serviceDescription string,
+	redact bool,
+	logger *logrus.Logger) error {
+	return StatusWithEvents(serviceName,
+		serviceDescription,
+		redact,
+		0,
+		logger)
+}
+
+// StatusWithEvents produces a status report for the given stack
+// followed by up to maxEvents of the most recent failed resource
+// events. A zero maxEvents value doesn't report events.
+func StatusWithEvents(serviceName string,
+	serviceDescription string,
+	redact bool,
+	maxEvents int,
+	logger *logrus.Logger) error {
+	return StatusWithRedactions(serviceName,
+		serviceDescription,
+		redact,
+		nil,
+		maxEvents,
+		logger)
+}
+
+// StatusWithRedactions produces a status report for the given stack
+// followed by up to maxEvents of the most recent failed resource events.
+// If redact is true, the AWS account ID and every match of the
+// redactPatterns regular expressions are masked in the report.
+func StatusWithRedactions(serviceName string,
+	serviceDescription string,
+	redact bool,
+	redactPatterns []string,
+	maxEvents int,
+	logger *logrus.Logger) error {
+	return StatusWithDrift(serviceName,
+		serviceDescription,
+		redact,
+		redactPatterns,
+		maxEvents,
+		false,
+		logger)
+}
+
+// StatusWithDrift produces the StatusWithRedactions report for the given
+// stack. If detectDrift is true, stack drift detection is run and each
+// MODIFIED or DELETED resource is logged with its property differences.
+// Drift detection that doesn't complete within five minutes is reported
+// as a warning rather than an error.
+func StatusWithDrift(serviceName string,
+	serviceDescription string,
+	redact bool,
+	redactPatterns []string,
+	maxEvents int,
+	detectDrift bool,
+	logger *logrus.Logger) error {
+
+	report, reportErr := StatusReportWithRedactions(serviceName,
+		redact,
+		redactPatterns,
+		logger)
+	if reportErr != nil {
+		return reportErr
+	}
+	if !report.Exists {
+		logger.WithField("Region", report.Region).Info("Stack does not exist")
+		return nil
 	}
 
 	// Report on what's up with the stack...
 	logSectionHeader("Stack Summary", dividerLength, logger)
-	stackInfo := describeStacksResponse.Stacks[0]
-	logger.WithField("Id", redactor(*stackInfo.StackId)).Info("StackId")
-	logger.WithField("Description", redactor(*stackInfo.Description)).Info("Description")
-	logger.WithField("State", *stackInfo.StackStatus).Info("Status")
-	if stackInfo.StackStatusReason != nil {
-		logger.WithField("Reason", *stackInfo.StackStatusReason).Info("Reason")
+	logger.WithField("Id", report.StackID).Info("StackId")
+	logger.WithField("Description", report.Description).Info("Description")
+	logger.WithField("State", report.Status).Info("Status")
+	if report.Reason != "" {
+		logger.WithField("Reason", report.Reason).Info("Reason")
 	}
-	logger.WithField("Time", stackInfo.CreationTime.UTC().String()).Info("Created")
-	if stackInfo.LastUpdatedTime != nil {
-		logger.WithField("Time", stackInfo.LastUpdatedTime.UTC().String()).Info("Last Update")
+	logger.WithField("Time", report.CreatedTime.String()).Info("Created")
+	if report.LastUpdatedTime != nil {
+		logger.WithField("Time", report.LastUpdatedTime.String()).Info("Last Update")
 	}
-	if stackInfo.DeletionTime != nil {
-		logger.WithField("Time", stackInfo.DeletionTime.UTC().String()).Info("Deleted")
+	if report.DeletedTime != nil {
+		logger.WithField("Time", report.DeletedTime.String()).Info("Deleted")
 	}
 
 	logger.Info()
-	if len(stackInfo.Parameters) != 0 {
+	if len(report.Parameters) != 0 {
 		logSectionHeader("Parameters", dividerLength, logger)
-		for _, eachParam := range stackInfo.Parameters {
-			logger.WithField("Value",
-				redactor(*eachParam.ParameterValue)).Info(*eachParam.ParameterKey)
-		}
+		logOrderedValues(report.parameterKeys, report.Parameters, logger)
 		logger.Info()
 	}
-	if len(stackInfo.Tags) != 0 {
+	if len(report.Tags) != 0 {
 		logSectionHeader("Tags", dividerLength, logger)
-		for _, eachTag := range stackInfo.Tags {
-			logger.WithField("Value",
-				redactor(*eachTag.Value)).Info(*eachTag.Key)
-		}
+		logOrderedValues(report.tagKeys, report.Tags, logger)
 		logger.Info()
 	}
-	if len(stackInfo.Outputs) != 0 {
+	if len(report.Outputs) != 0 {
 		logSectionHeader("Outputs", dividerLength, logger)
-		for _, eachOutput := range stackInfo.Outputs {
-			statement := logger.WithField("Value",
-				redactor(*eachOutput.OutputValue))
-			if eachOutput.ExportName != nil {
-				statement.WithField("ExportName", *eachOutput.ExportName)
+		for _, eachOutput := range report.Outputs {
+			statement := logger.WithField("Value", eachOutput.Value)
+			if eachOutput.ExportName != "" {
+				statement = statement.WithField("ExportName", eachOutput.ExportName)
+			}
+			statement.Info(eachOutput.Key)
+		}
+		logger.Info()
+	}
+	if maxEvents > 0 {
+		awsSession := spartaAWS.NewSession(logger)
+		events, eventsErr := failureStackEvents(cloudformation.New(awsSession),
+			serviceName,
+			maxEvents,
+			report.redactor)
+		if eventsErr != nil {
+			return eventsErr
+		}
+		if len(events) != 0 {
+			logSectionHeader("Failure Events", dividerLength, logger)
+			for _, eachEvent := range events {
+				logger.WithFields(logrus.Fields{
+					"Time":   eachEvent.Timestamp.String(),
+					"Status": eachEvent.ResourceStatus,
+					"Type":   eachEvent.ResourceType,
+					"Reason": eachEvent.ResourceStatusReason,
+				}).Info(eachEvent.LogicalResourceID)
+			}
+			logger.Info()
+		}
+	}
+	if detectDrift {
+		logSectionHeader("Drift", dividerLength, logger)
+		awsSession := spartaAWS.NewSession(logger)
+		drifts, driftsErr := stackResourceDrifts(cloudformation.New(awsSession),
+			serviceName,
+			report.redactor,
+			driftDetectionPollInterval,
+			driftDetectionTimeout,
+			logger)
+		if driftsErr != nil {
+			if errors.Cause(driftsErr) != spartaCF.ErrDriftDetectionTimeout {
+				return driftsErr
+			}
+			logger.WithField("Error", driftsErr).
+				Warn("Stack drift detection is still running. Check the CloudFormation console for the results.")
+			return nil
+		}
+		if len(drifts) == 0 {
+			logger.Info("No stack drift detected")
+		}
+		for _, eachDrift := range drifts {
+			logger.WithFields(logrus.Fields{
+				"Type":   eachDrift.ResourceType,
+				"Status": eachDrift.DriftStatus,
+			}).Warn(eachDrift.LogicalResourceID)
+			for _, eachDiff := range eachDrift.PropertyDifferences {
+				logger.WithFields(logrus.Fields{
+					"PropertyPath": aws.StringValue(eachDiff.PropertyPath),
+					"Difference":   aws.StringValue(eachDiff.DifferenceType),
+					"Expected":     aws.StringValue(eachDiff.ExpectedValue),
+					"Actual":       aws.StringValue(eachDiff.ActualValue),
+				}).Warn(eachDrift.LogicalResourceID)
 			}
-			statement.Info(*eachOutput.OutputKey)
 		}
 		logger.Info()
 	}
@@ -0,0 +1,311 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// listServicesDefaultConcurrency is the default number of concurrent
+	// DescribeStacks requests
+	listServicesDefaultConcurrency = 4
+	// listServicesDefaultMaxRetries is the default number of retries,
+	// with exponential backoff, for throttled requests
+	listServicesDefaultMaxRetries = 8
+)
+
+// ListServicesOptions scopes and bounds the ListServices query
+type ListServicesOptions struct {
+	// StatusFilter is the optional set of stack statuses to include (eg,
+	// "UPDATE_COMPLETE"). Deleted stacks are excluded if empty.
+	StatusFilter []string
+	// TagSelector is the optional set of stack tags that must all
+	// be present with the given values
+	TagSelector map[string]string
+	// MaxResults is the optional maximum number of services to return
+	MaxResults int
+	// Concurrency is the maximum number of concurrent DescribeStacks
+	// requests for deleted stacks, which aren't included in the paged
+	// DescribeStacks results. Defaults to 4.
+	Concurrency int
+	// MaxRetries is the maximum number of retries for throttled
+	// requests. Defaults to 8.
+	MaxRetries int
+	// Timeout is the optional limit for the entire query
+	Timeout time.Duration
+}
+
+// ServiceSummary is a Sparta service returned by ListServices
+type ServiceSummary struct {
+	StackName   string
+	StackID     string
+	Status      string
+	BuildID     string
+	LastUpdated time.Time
+	Tags        map[string]string
+}
+
+func (options *ListServicesOptions) matchesTags(tags map[string]string) bool {
+	for eachKey, eachValue := range options.TagSelector {
+		tagValue, tagExists := tags[eachKey]
+		if !tagExists || tagValue != eachValue {
+			return false
+		}
+	}
+	return true
+}
+
+// listServicesAPI is the CloudFormation subset used by ListServices
+type listServicesAPI interface {
+	ListStacksPagesWithContext(aws.Context,
+		*cloudformation.ListStacksInput,
+		func(*cloudformation.ListStacksOutput, bool) bool,
+		...request.Option) error
+	DescribeStacksPagesWithContext(aws.Context,
+		*cloudformation.DescribeStacksInput,
+		func(*cloudformation.DescribeStacksOutput, bool) bool,
+		...request.Option) error
+	DescribeStacksWithContext(aws.Context,
+		*cloudformation.DescribeStacksInput,
+		...request.Option) (*cloudformation.DescribeStacksOutput, error)
+}
+
+// stackTagMap returns the stack tags keyed by tag name
+func stackTagMap(stack *cloudformation.Stack) map[string]string {
+	tags := make(map[string]string, len(stack.Tags))
+	for _, eachTag := range stack.Tags {
+		tags[aws.StringValue(eachTag.Key)] = aws.StringValue(eachTag.Value)
+	}
+	return tags
+}
+
+// describeDeletedStackTags describes the deleted stacks, which aren't
+// included in the paged DescribeStacks results, by StackId with
+// bounded concurrency
+func describeDeletedStackTags(ctx context.Context,
+	cfSvc listServicesAPI,
+	stackIDs []string,
+	concurrency int,
+	retryOption request.Option) (map[string]map[string]string, error) {
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	stackTags := make(map[string]map[string]string, len(stackIDs))
+	semaphore := make(chan struct{}, concurrency)
+	for _, eachStackID := range stackIDs {
+		// Don't block on the semaphore if the query was cancelled
+		acquired := false
+		select {
+		case semaphore <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if !acquired {
+			break
+		}
+		wg.Add(1)
+		go func(stackID string) {
+			defer wg.Done()
+			defer func() {
+				<-semaphore
+			}()
+			describeOutput, describeErr := cfSvc.DescribeStacksWithContext(ctx,
+				&cloudformation.DescribeStacksInput{
+					StackName: aws.String(stackID),
+				},
+				retryOption)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if describeErr != nil {
+				if firstErr == nil {
+					firstErr = errors.Wrapf(describeErr, "Failed to describe stack %s", stackID)
+				}
+				return
+			}
+			for _, eachStack := range describeOutput.Stacks {
+				stackTags[stackID] = stackTagMap(eachStack)
+			}
+		}(eachStackID)
+	}
+	wg.Wait()
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = errors.Wrapf(ctx.Err(), "Failed to describe stacks")
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return stackTags, nil
+}
+
+// ListServices returns the Sparta services in the current account and
+// region. The stack summaries are paginated via NextToken and the tags
+// of the active stacks are read from the paged DescribeStacks results.
+// Throttled requests are retried with exponential backoff.
+func ListServices(ctx context.Context,
+	options *ListServicesOptions,
+	logger *logrus.Logger) ([]*ServiceSummary, error) {
+	awsSession := spartaAWS.NewSession(logger)
+	return listServices(ctx, cloudformation.New(awsSession), options, logger)
+}
+
+func listServices(ctx context.Context,
+	cfSvc listServicesAPI,
+	options *ListServicesOptions,
+	logger *logrus.Logger) ([]*ServiceSummary, error) {
+	if options == nil {
+		options = &ListServicesOptions{}
+	}
+	if options.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = listServicesDefaultConcurrency
+	}
+	maxRetries := options.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = listServicesDefaultMaxRetries
+	}
+	retryOption := func(r *request.Request) {
+		r.Retryer = client.DefaultRetryer{NumMaxRetries: maxRetries}
+	}
+
+	// Page through the stack summaries
+	listInput := &cloudformation.ListStacksInput{}
+	if len(options.StatusFilter) != 0 {
+		listInput.StackStatusFilter = aws.StringSlice(options.StatusFilter)
+	}
+	stackSummaries := make([]*cloudformation.StackSummary, 0)
+	activeStacks := false
+	listErr := cfSvc.ListStacksPagesWithContext(ctx,
+		listInput,
+		func(page *cloudformation.ListStacksOutput, lastPage bool) bool {
+			for _, eachSummary := range page.StackSummaries {
+				stackStatus := aws.StringValue(eachSummary.StackStatus)
+				if len(options.StatusFilter) == 0 &&
+					stackStatus == cloudformation.StackStatusDeleteComplete {
+					continue
+				}
+				activeStacks = activeStacks || stackStatus != cloudformation.StackStatusDeleteComplete
+				stackSummaries = append(stackSummaries, eachSummary)
+			}
+			return true
+		},
+		retryOption)
+	if listErr != nil {
+		return nil, errors.Wrapf(listErr, "Failed to list stacks")
+	}
+	sort.SliceStable(stackSummaries, func(i, j int) bool {
+		return aws.StringValue(stackSummaries[i].StackName) < aws.StringValue(stackSummaries[j].StackName)
+	})
+	logger.WithFields(logrus.Fields{
+		"StackCount": len(stackSummaries),
+	}).Debug("Listed stacks")
+
+	// The summaries don't include tags. Page through the active stacks
+	// rather than describing each one.
+	stackTags := make(map[string]map[string]string)
+	if activeStacks {
+		describeErr := cfSvc.DescribeStacksPagesWithContext(ctx,
+			&cloudformation.DescribeStacksInput{},
+			func(page *cloudformation.DescribeStacksOutput, lastPage bool) bool {
+				for _, eachStack := range page.Stacks {
+					stackTags[aws.StringValue(eachStack.StackId)] = stackTagMap(eachStack)
+				}
+				return true
+			},
+			retryOption)
+		if describeErr != nil {
+			return nil, errors.Wrapf(describeErr, "Failed to describe stacks")
+		}
+	}
+
+	// Visit the summaries in order s.t. deleted stacks past MaxResults
+	// aren't described
+	services := make([]*ServiceSummary, 0)
+	for start := 0; start < len(stackSummaries); start += concurrency {
+		end := start + concurrency
+		if end > len(stackSummaries) {
+			end = len(stackSummaries)
+		}
+		deletedStackIDs := make([]string, 0)
+		for _, eachSummary := range stackSummaries[start:end] {
+			if aws.StringValue(eachSummary.StackStatus) == cloudformation.StackStatusDeleteComplete {
+				deletedStackIDs = append(deletedStackIDs, aws.StringValue(eachSummary.StackId))
+			}
+		}
+		if len(deletedStackIDs) != 0 {
+			deletedTags, deletedTagsErr := describeDeletedStackTags(ctx,
+				cfSvc,
+				deletedStackIDs,
+				concurrency,
+				retryOption)
+			if deletedTagsErr != nil {
+				return nil, deletedTagsErr
+			}
+			for eachStackID, eachTags := range deletedTags {
+				stackTags[eachStackID] = eachTags
+			}
+		}
+		for _, eachSummary := range stackSummaries[start:end] {
+			tags := stackTags[aws.StringValue(eachSummary.StackId)]
+			buildID, isSparta := tags[SpartaTagBuildIDKey]
+			if !isSparta || !options.matchesTags(tags) {
+				continue
+			}
+			summary := &ServiceSummary{
+				StackName: aws.StringValue(eachSummary.StackName),
+				StackID:   aws.StringValue(eachSummary.StackId),
+				Status:    aws.StringValue(eachSummary.StackStatus),
+				BuildID:   buildID,
+				Tags:      tags,
+			}
+			if eachSummary.LastUpdatedTime != nil {
+				summary.LastUpdated = *eachSummary.LastUpdatedTime
+			} else if eachSummary.CreationTime != nil {
+				summary.LastUpdated = *eachSummary.CreationTime
+			}
+			services = append(services, summary)
+			if options.MaxResults > 0 && len(services) == options.MaxResults {
+				return services, nil
+			}
+		}
+	}
+	return services, nil
+}
+
+// StatusAll logs a one line status summary for each Sparta service
+// returned by ListServices
+func StatusAll(ctx context.Context,
+	options *ListServicesOptions,
+	logger *logrus.Logger) error {
+	services, servicesErr := ListServices(ctx, options, logger)
+	if servicesErr != nil {
+		return servicesErr
+	}
+	logSectionHeader("Services", dividerLength, logger)
+	for _, eachService := range services {
+		logger.WithFields(logrus.Fields{
+			"Status":      eachService.Status,
+			"BuildID":     eachService.BuildID,
+			"LastUpdated": eachService.LastUpdated.UTC().Format(time.RFC3339),
+		}).Info(eachService.StackName)
+	}
+	logger.WithField("Count", len(services)).Info("Services found")
+	return nil
+}
@@ -0,0 +1,410 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	spartaCF "github.com/mweagle/Sparta/aws/cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestStatusReport(t *testing.T) {
+	createdTime := time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC)
+	pacificTime := time.FixedZone("PDT", -7*60*60)
+	updatedTime := time.Date(2020, time.May, 2, 5, 0, 0, 0, pacificTime)
+	stackInfo := &cloudformation.Stack{
+		StackId:         aws.String("arn:aws:cloudformation:us-west-2:123412341234:stack/MyService/guid"),
+		Description:     aws.String("My service"),
+		StackStatus:     aws.String(cloudformation.StackStatusUpdateComplete),
+		CreationTime:    aws.Time(createdTime.In(pacificTime)),
+		LastUpdatedTime: aws.Time(updatedTime),
+		Parameters: []*cloudformation.Parameter{{
+			ParameterKey:   aws.String("Stage"),
+			ParameterValue: aws.String("prod"),
+		}, {
+			ParameterKey:   aws.String("BucketName"),
+			ParameterValue: aws.String("bucket"),
+		}},
+		Tags: []*cloudformation.Tag{{
+			Key:   aws.String(SpartaTagBuildIDKey),
+			Value: aws.String("buildID"),
+		}},
+		Outputs: []*cloudformation.Output{{
+			OutputKey:   aws.String("FunctionArn"),
+			OutputValue: aws.String("arn:aws:lambda:us-west-2:123412341234:function:MyFunction"),
+			ExportName:  aws.String("MyFunctionArn"),
+		}},
+	}
+	redactor := func(value string) string {
+		return strings.Replace(value, "123412341234", "************", -1)
+	}
+	report := newStackStatus("MyService", "us-west-2", stackInfo, redactor)
+	if !report.Exists ||
+		report.Status != cloudformation.StackStatusUpdateComplete ||
+		!report.CreatedTime.Equal(createdTime) ||
+		report.DeletedTime != nil ||
+		report.Parameters["Stage"] != "prod" ||
+		report.Tags[SpartaTagBuildIDKey] != "buildID" {
+		t.Fatalf("Unexpected status report: %#v", report)
+	}
+	// Times are reported in UTC
+	if report.CreatedTime.Location() != time.UTC ||
+		report.LastUpdatedTime == nil ||
+		report.LastUpdatedTime.Location() != time.UTC ||
+		!report.LastUpdatedTime.Equal(updatedTime) {
+		t.Fatalf("Expected UTC times: %s, %v", report.CreatedTime, report.LastUpdatedTime)
+	}
+	// Parameters are logged in the order returned by CloudFormation
+	if strings.Join(report.parameterKeys, ",") != "Stage,BucketName" {
+		t.Fatalf("Unexpected parameter order: %#v", report.parameterKeys)
+	}
+	if strings.Contains(report.StackID, "123412341234") ||
+		strings.Contains(report.Outputs[0].Value, "123412341234") {
+		t.Fatalf("Expected redacted account ID: %#v", report)
+	}
+	if report.Output("FunctionArn") != report.Outputs[0] ||
+		report.Output("Missing") != nil {
+		t.Fatalf("Unexpected output lookup: %#v", report.Outputs)
+	}
+	reportJSON, reportJSONErr := json.Marshal(report)
+	if reportJSONErr != nil {
+		t.Fatalf("Failed to marshal status report: %s", reportJSONErr)
+	}
+	if !strings.Contains(string(reportJSON), `"ExportName":"MyFunctionArn"`) ||
+		!strings.Contains(string(reportJSON), `"LastUpdatedTime":"2020-05-02T12:00:00Z"`) {
+		t.Fatalf("Unexpected status report JSON: %s", string(reportJSON))
+	}
+}
+
+type testStackEventsAPI struct {
+	pages    [][]*cloudformation.StackEvent
+	requests int
+}
+
+func (api *testStackEventsAPI) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	api.requests++
+	output := &cloudformation.DescribeStackEventsOutput{
+		StackEvents: api.pages[api.requests-1],
+	}
+	if api.requests < len(api.pages) {
+		output.NextToken = aws.String(fmt.Sprintf("page%d", api.requests))
+	}
+	return output, nil
+}
+
+func TestFailureStackEvents(t *testing.T) {
+	stackID := "arn:aws:cloudformation:us-west-2:123412341234:stack/MyService/guid"
+	event := func(logicalID string, status string) *cloudformation.StackEvent {
+		return &cloudformation.StackEvent{
+			StackId:            aws.String(stackID),
+			LogicalResourceId:  aws.String(logicalID),
+			PhysicalResourceId: aws.String(logicalID),
+			ResourceType:       aws.String("AWS::Lambda::Function"),
+			ResourceStatus:     aws.String(status),
+			ResourceStatusReason: aws.String(fmt.Sprintf("Resource handler returned message: %s failed for role arn:aws:iam::123412341234:role/MyRole",
+				logicalID)),
+			Timestamp: aws.Time(time.Now()),
+		}
+	}
+	creationEvent := event("MyService", cloudformation.ResourceStatusCreateInProgress)
+	creationEvent.ResourceType = aws.String("AWS::CloudFormation::Stack")
+	creationEvent.PhysicalResourceId = aws.String(stackID)
+
+	api := &testStackEventsAPI{
+		pages: [][]*cloudformation.StackEvent{
+			{
+				event("Newest", cloudformation.ResourceStatusUpdateFailed),
+				event("Complete", cloudformation.ResourceStatusUpdateComplete),
+			},
+			{
+				event("Older", cloudformation.ResourceStatusCreateFailed),
+				creationEvent,
+			},
+			{
+				event("Unreachable", cloudformation.ResourceStatusCreateFailed),
+			},
+		},
+	}
+	redactor := newStatusRedactor("123412341234", nil)
+	events, eventsErr := failureStackEvents(api, "MyService", 10, redactor)
+	if eventsErr != nil {
+		t.Fatalf("Failed to get failure events: %s", eventsErr)
+	}
+	if api.requests != 2 ||
+		len(events) != 2 ||
+		events[0].LogicalResourceID != "Older" ||
+		events[1].LogicalResourceID != "Newest" {
+		t.Fatalf("Unexpected failure events (requests: %d): %#v", api.requests, events)
+	}
+	for _, eachEvent := range events {
+		if strings.Contains(eachEvent.ResourceStatusReason, "123412341234") ||
+			!strings.Contains(eachEvent.ResourceStatusReason, "role/MyRole") {
+			t.Fatalf("Expected the account ID to be redacted: %s", eachEvent.ResourceStatusReason)
+		}
+	}
+	api.requests = 0
+	events, _ = failureStackEvents(api, "MyService", 1, redactor)
+	if api.requests != 1 || len(events) != 1 {
+		t.Fatalf("Expected paging to stop at maxEvents (requests: %d): %#v", api.requests, events)
+	}
+}
+
+type testListServicesAPI struct {
+	stacks          []*cloudformation.Stack
+	mutex           sync.Mutex
+	describeCalls   int
+	describedStacks []string
+}
+
+func (api *testListServicesAPI) ListStacksPagesWithContext(ctx aws.Context,
+	input *cloudformation.ListStacksInput,
+	fn func(*cloudformation.ListStacksOutput, bool) bool,
+	opts ...request.Option) error {
+	statusFilter := aws.StringValueSlice(input.StackStatusFilter)
+	for eachIndex, eachStack := range api.stacks {
+		if len(statusFilter) != 0 &&
+			!strings.Contains(strings.Join(statusFilter, ","), *eachStack.StackStatus) {
+			continue
+		}
+		page := &cloudformation.ListStacksOutput{
+			StackSummaries: []*cloudformation.StackSummary{{
+				StackId:      eachStack.StackId,
+				StackName:    eachStack.StackName,
+				StackStatus:  eachStack.StackStatus,
+				CreationTime: eachStack.CreationTime,
+			}},
+		}
+		if !fn(page, eachIndex == len(api.stacks)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (api *testListServicesAPI) DescribeStacksPagesWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStacksInput,
+	fn func(*cloudformation.DescribeStacksOutput, bool) bool,
+	opts ...request.Option) error {
+	api.describeCalls++
+	activeStacks := []*cloudformation.Stack{}
+	for _, eachStack := range api.stacks {
+		if *eachStack.StackStatus != cloudformation.StackStatusDeleteComplete {
+			activeStacks = append(activeStacks, eachStack)
+		}
+	}
+	fn(&cloudformation.DescribeStacksOutput{Stacks: activeStacks}, true)
+	return nil
+}
+
+func (api *testListServicesAPI) DescribeStacksWithContext(ctx aws.Context,
+	input *cloudformation.DescribeStacksInput,
+	opts ...request.Option) (*cloudformation.DescribeStacksOutput, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.describedStacks = append(api.describedStacks, aws.StringValue(input.StackName))
+	for _, eachStack := range api.stacks {
+		if aws.StringValue(eachStack.StackId) == aws.StringValue(input.StackName) {
+			return &cloudformation.DescribeStacksOutput{
+				Stacks: []*cloudformation.Stack{eachStack},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("Stack %s does not exist", aws.StringValue(input.StackName))
+}
+
+func TestListServices(t *testing.T) {
+	newStack := func(stackName string, stackStatus string, tags map[string]string) *cloudformation.Stack {
+		stack := &cloudformation.Stack{
+			StackId:      aws.String(fmt.Sprintf("arn:aws:cloudformation:us-west-2:123412341234:stack/%s/guid", stackName)),
+			StackName:    aws.String(stackName),
+			StackStatus:  aws.String(stackStatus),
+			CreationTime: aws.Time(time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC)),
+		}
+		for eachKey, eachValue := range tags {
+			stack.Tags = append(stack.Tags, &cloudformation.Tag{
+				Key:   aws.String(eachKey),
+				Value: aws.String(eachValue),
+			})
+		}
+		return stack
+	}
+	newAPI := func() *testListServicesAPI {
+		return &testListServicesAPI{
+			stacks: []*cloudformation.Stack{
+				newStack("ServiceB", cloudformation.StackStatusUpdateComplete,
+					map[string]string{SpartaTagBuildIDKey: "buildB", "team": "red"}),
+				newStack("Other", cloudformation.StackStatusCreateComplete, nil),
+				newStack("ServiceA", cloudformation.StackStatusCreateComplete,
+					map[string]string{SpartaTagBuildIDKey: "buildA", "team": "blue"}),
+				newStack("ServiceC", cloudformation.StackStatusDeleteComplete,
+					map[string]string{SpartaTagBuildIDKey: "buildC"}),
+				newStack("ServiceD", cloudformation.StackStatusDeleteComplete,
+					map[string]string{SpartaTagBuildIDKey: "buildD"}),
+			},
+		}
+	}
+	serviceNames := func(services []*ServiceSummary) string {
+		names := []string{}
+		for _, eachService := range services {
+			names = append(names, eachService.StackName)
+		}
+		return strings.Join(names, ",")
+	}
+	logger := logrus.New()
+
+	// Active stacks are described by the paged request
+	api := newAPI()
+	services, servicesErr := listServices(context.Background(), api, nil, logger)
+	if servicesErr != nil {
+		t.Fatalf("Failed to list services: %s", servicesErr)
+	}
+	if serviceNames(services) != "ServiceA,ServiceB" ||
+		services[0].BuildID != "buildA" ||
+		api.describeCalls != 1 ||
+		len(api.describedStacks) != 0 {
+		t.Fatalf("Unexpected services: %s (paged: %d, described: %v)",
+			serviceNames(services),
+			api.describeCalls,
+			api.describedStacks)
+	}
+
+	// Tag selector
+	services, servicesErr = listServices(context.Background(),
+		newAPI(),
+		&ListServicesOptions{TagSelector: map[string]string{"team": "red"}},
+		logger)
+	if servicesErr != nil || serviceNames(services) != "ServiceB" {
+		t.Fatalf("Unexpected tag selector services: %s (%v)", serviceNames(services), servicesErr)
+	}
+
+	// Deleted stacks past MaxResults aren't described
+	api = newAPI()
+	services, servicesErr = listServices(context.Background(),
+		api,
+		&ListServicesOptions{
+			StatusFilter: []string{cloudformation.StackStatusDeleteComplete},
+			MaxResults:   1,
+			Concurrency:  1,
+		},
+		logger)
+	if servicesErr != nil ||
+		serviceNames(services) != "ServiceC" ||
+		api.describeCalls != 0 ||
+		len(api.describedStacks) != 1 {
+		t.Fatalf("Unexpected deleted services: %s (%v, described: %v)",
+			serviceNames(services),
+			servicesErr,
+			api.describedStacks)
+	}
+
+	// A cancelled query doesn't wait on the semaphore
+	cancelledContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, servicesErr = listServices(cancelledContext,
+		newAPI(),
+		&ListServicesOptions{StatusFilter: []string{cloudformation.StackStatusDeleteComplete}},
+		logger)
+	if servicesErr == nil {
+		t.Fatalf("Expected a cancelled query to fail")
+	}
+}
+
+type testStackDriftAPI struct {
+	detectionStatus []string
+	statusRequests  int
+	driftFilters    []*string
+	drifts          []*cloudformation.StackResourceDrift
+}
+
+func (api *testStackDriftAPI) DetectStackDrift(input *cloudformation.DetectStackDriftInput) (*cloudformation.DetectStackDriftOutput, error) {
+	return &cloudformation.DetectStackDriftOutput{
+		StackDriftDetectionId: aws.String("detection"),
+	}, nil
+}
+
+func (api *testStackDriftAPI) DescribeStackDriftDetectionStatus(input *cloudformation.DescribeStackDriftDetectionStatusInput) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error) {
+	status := api.detectionStatus[len(api.detectionStatus)-1]
+	if api.statusRequests < len(api.detectionStatus) {
+		status = api.detectionStatus[api.statusRequests]
+	}
+	api.statusRequests++
+	return &cloudformation.DescribeStackDriftDetectionStatusOutput{
+		DetectionStatus: aws.String(status),
+	}, nil
+}
+
+func (api *testStackDriftAPI) DescribeStackResourceDrifts(input *cloudformation.DescribeStackResourceDriftsInput) (*cloudformation.DescribeStackResourceDriftsOutput, error) {
+	api.driftFilters = input.StackResourceDriftStatusFilters
+	return &cloudformation.DescribeStackResourceDriftsOutput{
+		StackResourceDrifts: api.drifts,
+	}, nil
+}
+
+func TestStackResourceDrifts(t *testing.T) {
+	logger := logrus.New()
+	api := &testStackDriftAPI{
+		detectionStatus: []string{
+			cloudformation.StackDriftDetectionStatusDetectionInProgress,
+			cloudformation.StackDriftDetectionStatusDetectionComplete,
+		},
+		drifts: []*cloudformation.StackResourceDrift{
+			{
+				LogicalResourceId:        aws.String("MyFunction"),
+				ResourceType:             aws.String("AWS::Lambda::Function"),
+				StackResourceDriftStatus: aws.String(cloudformation.StackResourceDriftStatusModified),
+				PropertyDifferences: []*cloudformation.PropertyDifference{
+					{
+						PropertyPath:   aws.String("/Role"),
+						DifferenceType: aws.String(cloudformation.DifferenceTypeNotEqual),
+						ExpectedValue:  aws.String("arn:aws:iam::123412341234:role/Expected"),
+						ActualValue:    aws.String("arn:aws:iam::123412341234:role/Actual"),
+					},
+				},
+			},
+		},
+	}
+	redactor := newStatusRedactor("123412341234", nil)
+	drifts, driftsErr := stackResourceDrifts(api, "MyService", redactor, 0, time.Minute, logger)
+	if driftsErr != nil {
+		t.Fatalf("Failed to get stack drifts: %s", driftsErr)
+	}
+	if api.statusRequests != 2 ||
+		len(drifts) != 1 ||
+		drifts[0].DriftStatus != cloudformation.StackResourceDriftStatusModified ||
+		len(drifts[0].PropertyDifferences) != 1 {
+		t.Fatalf("Unexpected stack drifts (requests: %d): %#v", api.statusRequests, drifts)
+	}
+	// The account ID is masked in the property values
+	for _, eachValue := range []*string{drifts[0].PropertyDifferences[0].ExpectedValue,
+		drifts[0].PropertyDifferences[0].ActualValue} {
+		if strings.Contains(aws.StringValue(eachValue), "123412341234") {
+			t.Fatalf("Expected the account ID to be redacted: %s", aws.StringValue(eachValue))
+		}
+	}
+	if !strings.Contains(aws.StringValue(api.drifts[0].PropertyDifferences[0].ExpectedValue), "123412341234") {
+		t.Fatalf("Expected the API response to be left as is")
+	}
+	if len(api.driftFilters) != 2 {
+		t.Fatalf("Expected MODIFIED and DELETED drift filters: %#v", api.driftFilters)
+	}
+
+	// Detection that never completes must time out
+	api = &testStackDriftAPI{
+		detectionStatus: []string{cloudformation.StackDriftDetectionStatusDetectionInProgress},
+	}
+	_, driftsErr = stackResourceDrifts(api, "MyService", redactor, time.Millisecond, 10*time.Millisecond, logger)
+	if errors.Cause(driftsErr) != spartaCF.ErrDriftDetectionTimeout {
+		t.Fatalf("Expected drift detection timeout. Found: %v", driftsErr)
+	}
+}
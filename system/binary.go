@@ -0,0 +1,67 @@
+package system
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// elfMachineForGOARCH maps the supported AWS Lambda GOARCH values to
+// the ELF machine type
+var elfMachineForGOARCH = map[string]elf.Machine{
+	"amd64": elf.EM_X86_64,
+	"arm64": elf.EM_AARCH64,
+}
+
+// VerifyLambdaBinary ensures that the binary at executablePath is a linux
+// ELF executable for the given GOARCH. Binaries that were accidentally
+// compiled for the host OS are rejected by AWS Lambda at invocation time
+// with an "exec format error".
+func VerifyLambdaBinary(executablePath string,
+	goArch string,
+	logger *logrus.Logger) error {
+
+	expectedMachine, expectedMachineExists := elfMachineForGOARCH[goArch]
+	if !expectedMachineExists {
+		return errors.Errorf("Unsupported AWS Lambda GOARCH: %s", goArch)
+	}
+	elfFile, elfFileErr := elf.Open(executablePath)
+	if elfFileErr != nil {
+		hostFormat := "unknown"
+		if machoFile, machoFileErr := macho.Open(executablePath); machoFileErr == nil {
+			hostFormat = "Mach-O (darwin)"
+			machoFile.Close()
+		} else if peFile, peFileErr := pe.Open(executablePath); peFileErr == nil {
+			hostFormat = "PE (windows)"
+			peFile.Close()
+		}
+		return errors.Errorf("Binary %s is not a linux ELF executable (format: %s). "+
+			"Ensure it is cross-compiled with GOOS=linux GOARCH=%s",
+			executablePath,
+			hostFormat,
+			goArch)
+	}
+	defer elfFile.Close()
+
+	if elfFile.OSABI != elf.ELFOSABI_NONE && elfFile.OSABI != elf.ELFOSABI_LINUX {
+		return errors.Errorf("Binary %s targets an unsupported OS ABI (%s). "+
+			"Ensure it is cross-compiled with GOOS=linux",
+			executablePath,
+			elfFile.OSABI)
+	}
+	if elfFile.Machine != expectedMachine {
+		return errors.Errorf("Binary %s targets %s, but AWS Lambda expects %s (GOARCH=%s)",
+			executablePath,
+			elfFile.Machine,
+			expectedMachine,
+			goArch)
+	}
+	logger.WithFields(logrus.Fields{
+		"Path":    executablePath,
+		"Machine": elfFile.Machine.String(),
+	}).Debug("Verified binary architecture")
+	return nil
+}
@@ -0,0 +1,26 @@
+package system
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVerifyLambdaBinary(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skipf("Skipping binary verification test for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	testBinary, testBinaryErr := os.Executable()
+	if testBinaryErr != nil {
+		t.Fatalf("Failed to find test binary: %s", testBinaryErr)
+	}
+	logger := logrus.New()
+	if err := VerifyLambdaBinary(testBinary, "amd64", logger); err != nil {
+		t.Fatalf("Failed to verify amd64 binary: %s", err)
+	}
+	if err := VerifyLambdaBinary(testBinary, "arm64", logger); err == nil {
+		t.Fatalf("Failed to reject amd64 binary for arm64 target")
+	}
+}
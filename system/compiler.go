@@ -0,0 +1,64 @@
+package system
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CompileSpec describes the binary to compile
+type CompileSpec struct {
+	// ServiceName is the name of the service, stamped into the binary
+	ServiceName string
+	// OutputPath is the requested path of the compiled binary
+	OutputPath string
+	// UseCGO is true for CGO enabled builds
+	UseCGO bool
+	// BuildID is stamped into the binary
+	BuildID string
+	// BuildTags are the optional, space separated, user build tags
+	BuildTags string
+	// LinkFlags are the optional user linker flags
+	LinkFlags string
+	// Noop is true for dry-run builds
+	Noop bool
+	// Options are the optional build options
+	Options *BuildOptions
+	// Logger to use for output
+	Logger *logrus.Logger
+}
+
+// Compiler is the interface for a toolchain that compiles the service
+// into a linux binary. Compile returns the path to the compiled binary,
+// which may differ from the requested spec.OutputPath.
+type Compiler interface {
+	Compile(ctx context.Context, spec *CompileSpec) (string, error)
+}
+
+// GoToolchainCompiler is the default Compiler that uses the
+// installed Go toolchain (or Docker for CGO builds)
+type GoToolchainCompiler struct {
+}
+
+// Compile satisfies the Compiler interface
+func (compiler *GoToolchainCompiler) Compile(ctx context.Context, spec *CompileSpec) (string, error) {
+	buildErr := BuildGoBinaryWithContext(ctx,
+		spec.ServiceName,
+		spec.OutputPath,
+		spec.UseCGO,
+		spec.BuildID,
+		spec.BuildTags,
+		spec.LinkFlags,
+		spec.Noop,
+		spec.Options,
+		spec.Logger)
+	if buildErr != nil {
+		return "", buildErr
+	}
+	return spec.OutputPath, nil
+}
+
+// NewGoToolchainCompiler returns the default Compiler
+func NewGoToolchainCompiler() Compiler {
+	return &GoToolchainCompiler{}
+}
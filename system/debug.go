@@ -0,0 +1,82 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SplitDebugSymbols copies the debug information in the unstripped
+// executablePath to debugPath and then strips executablePath in place. The
+// stripped binary includes a .gnu_debuglink section that references
+// debugPath. The operation requires `objcopy` from GNU binutils.
+func SplitDebugSymbols(executablePath string,
+	debugPath string,
+	logger *logrus.Logger) error {
+	objcopyPath, objcopyPathErr := exec.LookPath("objcopy")
+	if objcopyPathErr != nil {
+		return errors.Wrapf(objcopyPathErr,
+			"Failed to find `objcopy`, which is required to split debug symbols")
+	}
+	commands := [][]string{
+		{"--only-keep-debug", executablePath, debugPath},
+		{"--strip-all", "--add-gnu-debuglink=" + debugPath, executablePath},
+	}
+	for _, eachArgs := range commands {
+		cmd := exec.Command(objcopyPath, eachArgs...)
+		cmd.Env = os.Environ()
+		cmdErr := RunOSCommand(cmd, logger)
+		if cmdErr != nil {
+			return errors.Wrapf(cmdErr, "Failed to split debug symbols from %s", executablePath)
+		}
+	}
+	return nil
+}
+
+// FileSHA256 returns the hex encoded SHA-256 digest of the file contents
+func FileSHA256(filePath string) (string, error) {
+	/* #nosec */
+	file, fileErr := os.Open(filePath)
+	if fileErr != nil {
+		return "", fileErr
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, copyErr := io.Copy(hash, file)
+	if copyErr != nil {
+		return "", copyErr
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// CopyFile copies the sourcePath file to destPath, preserving the
+// source file mode
+func CopyFile(sourcePath string, destPath string) error {
+	/* #nosec */
+	source, sourceErr := os.Open(sourcePath)
+	if sourceErr != nil {
+		return sourceErr
+	}
+	defer source.Close()
+	sourceInfo, sourceInfoErr := source.Stat()
+	if sourceInfoErr != nil {
+		return sourceInfoErr
+	}
+	dest, destErr := os.OpenFile(destPath,
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY,
+		sourceInfo.Mode())
+	if destErr != nil {
+		return destErr
+	}
+	_, copyErr := io.Copy(dest, source)
+	closeErr := dest.Close()
+	if copyErr != nil {
+		return copyErr
+	}
+	return closeErr
+}
@@ -1,9 +1,14 @@
 package system
 
 import (
+	"bytes"
 	"io"
 	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -45,3 +50,82 @@ func RunAndCaptureOSCommand(cmd *exec.Cmd,
 	return cmd.Run()
 
 }
+
+// streamedOutputTailLength is the number of trailing output lines
+// included in the error if a streamed command fails
+const streamedOutputTailLength = 20
+
+// reStreamedNotice matches output lines that are logged at info level
+var reStreamedNotice = regexp.MustCompile(`(?i)(warning|deprecat)`)
+
+// lineLogger is an io.Writer that logs each complete line of output
+type lineLogger struct {
+	prefix  string
+	logger  *logrus.Logger
+	mutex   sync.Mutex
+	partial []byte
+	tail    []string
+}
+
+func (writer *lineLogger) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return
+	}
+	entry := writer.logger.WithField("Source", writer.prefix)
+	if reStreamedNotice.MatchString(line) {
+		entry.Info(line)
+	} else {
+		entry.Debug(line)
+	}
+	writer.tail = append(writer.tail, line)
+	if len(writer.tail) > streamedOutputTailLength {
+		writer.tail = writer.tail[1:]
+	}
+}
+
+func (writer *lineLogger) Write(p []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.partial = append(writer.partial, p...)
+	for {
+		newlineIndex := bytes.IndexByte(writer.partial, '\n')
+		if newlineIndex < 0 {
+			break
+		}
+		writer.logLine(string(writer.partial[:newlineIndex]))
+		writer.partial = writer.partial[newlineIndex+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs any incomplete final line
+func (writer *lineLogger) flush() {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.logLine(string(writer.partial))
+	writer.partial = nil
+}
+
+// RunStreamedOSCommand executes a system command and logs each line of
+// stdout and stderr as it's produced. Lines are logged at debug level,
+// except warnings and deprecation notices, which are logged at info
+// level. If the command fails, the returned error includes the
+// trailing output lines.
+func RunStreamedOSCommand(cmd *exec.Cmd, prefix string, logger *logrus.Logger) error {
+	outputWriter := &lineLogger{
+		prefix: prefix,
+		logger: logger,
+	}
+	cmdErr := RunAndCaptureOSCommand(cmd,
+		outputWriter,
+		outputWriter,
+		logger)
+	outputWriter.flush()
+	if cmdErr != nil && len(outputWriter.tail) != 0 {
+		return errors.Wrapf(cmdErr, "%s failed:\n%s",
+			prefix,
+			strings.Join(outputWriter.tail, "\n"))
+	}
+	return cmdErr
+}
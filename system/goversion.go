@@ -1,6 +1,7 @@
 package system
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"go/parser"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -74,6 +76,122 @@ func GoPath() string {
 	return gopath
 }
 
+// BuildOptions are optional settings for BuildGoBinaryWithOptions
+type BuildOptions struct {
+	// KeepDebugSymbols, if true, omits the `-s -w` linker flags so that
+	// the binary includes the symbol table and DWARF information
+	KeepDebugSymbols bool
+	// Environment are the module related environment variables (eg,
+	// GOFLAGS=-mod=vendor, GOPROXY=off) that override the ambient
+	// environment of the go generate and go build subprocesses
+	Environment map[string]string
+	// GOARCH is the target architecture (amd64 or arm64). Defaults
+	// to amd64.
+	GOARCH string
+}
+
+// buildEnvironmentKeys are the environment variables that
+// BuildOptions.Environment may override
+var buildEnvironmentKeys = map[string]bool{
+	"GOFLAGS":      true,
+	"GOPROXY":      true,
+	"GOSUMDB":      true,
+	"GONOSUMCHECK": true,
+	"GONOSUMDB":    true,
+	"GONOPROXY":    true,
+	"GOPRIVATE":    true,
+	"GOINSECURE":   true,
+}
+
+// validate ensures that only the supported environment variables
+// are overridden
+func (options *BuildOptions) validate() error {
+	if options == nil {
+		return nil
+	}
+	if options.GOARCH != "" {
+		if _, supported := elfMachineForGOARCH[options.GOARCH]; !supported {
+			return errors.Errorf("Unsupported AWS Lambda GOARCH: %s", options.GOARCH)
+		}
+	}
+	var unsupported []string
+	for eachKey := range options.Environment {
+		if !buildEnvironmentKeys[eachKey] {
+			unsupported = append(unsupported, eachKey)
+		}
+	}
+	if len(unsupported) != 0 {
+		sort.Strings(unsupported)
+		return errors.Errorf("Unsupported build environment variables: %s",
+			strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// goArch returns the target GOARCH
+func (options *BuildOptions) goArch() string {
+	if options == nil || options.GOARCH == "" {
+		return "amd64"
+	}
+	return options.GOARCH
+}
+
+// environ returns the ambient environment with the
+// BuildOptions.Environment overrides applied
+func (options *BuildOptions) environ() []string {
+	if options == nil || len(options.Environment) == 0 {
+		return os.Environ()
+	}
+	var environment []string
+	for _, eachPair := range os.Environ() {
+		eachKey := strings.SplitN(eachPair, "=", 2)[0]
+		if _, exists := options.Environment[eachKey]; !exists {
+			environment = append(environment, eachPair)
+		}
+	}
+	return append(environment, options.environmentPairs()...)
+}
+
+// environmentPairs returns the sorted KEY=VALUE overrides
+func (options *BuildOptions) environmentPairs() []string {
+	if options == nil {
+		return nil
+	}
+	pairs := make([]string, 0, len(options.Environment))
+	for eachKey, eachValue := range options.Environment {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", eachKey, eachValue))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// ensureOfflineModules fails fast if the build requires a network module
+// fetch while GOPROXY=off. Otherwise the failure surfaces only after
+// go generate has run.
+func ensureOfflineModules(environment []string, logger *logrus.Logger) error {
+	goProxy := ""
+	for _, eachPair := range environment {
+		if strings.HasPrefix(eachPair, "GOPROXY=") {
+			goProxy = strings.TrimPrefix(eachPair, "GOPROXY=")
+		}
+	}
+	if goProxy != "off" {
+		return nil
+	}
+	cmd := exec.Command("go", "list", "-deps", ".")
+	cmd.Env = environment
+	output, outputErr := cmd.CombinedOutput()
+	if outputErr != nil {
+		logger.WithFields(logrus.Fields{
+			"Output": string(output),
+		}).Error("Module dependencies are unavailable offline")
+		return errors.Errorf("Build requires a network module fetch, but GOPROXY=off. "+
+			"Vendor the dependencies or populate the module cache: %s",
+			strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // BuildGoBinary is a helper to build a go binary with the given options
 func BuildGoBinary(serviceName string,
 	executableOutput string,
@@ -83,22 +201,88 @@ func BuildGoBinary(serviceName string,
 	linkFlags string,
 	noop bool,
 	logger *logrus.Logger) error {
+	return BuildGoBinaryWithOptions(serviceName,
+		executableOutput,
+		useCGO,
+		buildID,
+		userSuppliedBuildTags,
+		linkFlags,
+		noop,
+		nil,
+		logger)
+}
+
+// BuildGoBinaryWithOptions is a helper to build a go binary with the given
+// options. A nil options value produces a stripped binary.
+func BuildGoBinaryWithOptions(serviceName string,
+	executableOutput string,
+	useCGO bool,
+	buildID string,
+	userSuppliedBuildTags string,
+	linkFlags string,
+	noop bool,
+	options *BuildOptions,
+	logger *logrus.Logger) error {
+	return BuildGoBinaryWithContext(context.Background(),
+		serviceName,
+		executableOutput,
+		useCGO,
+		buildID,
+		userSuppliedBuildTags,
+		linkFlags,
+		noop,
+		options,
+		logger)
+}
+
+// BuildGoBinaryWithContext is the BuildGoBinaryWithOptions implementation.
+// The `go generate` and `go build` (or `docker run`) commands are killed
+// if the ctx is cancelled before they complete.
+func BuildGoBinaryWithContext(ctx context.Context,
+	serviceName string,
+	executableOutput string,
+	useCGO bool,
+	buildID string,
+	userSuppliedBuildTags string,
+	linkFlags string,
+	noop bool,
+	options *BuildOptions,
+	logger *logrus.Logger) error {
 
 	// Before we do anything, let's make sure there's a `main` package in this directory.
 	ensureMainPackageErr := ensureMainEntrypoint(logger)
 	if ensureMainPackageErr != nil {
 		return ensureMainPackageErr
 	}
+	validateErr := options.validate()
+	if validateErr != nil {
+		return validateErr
+	}
+	// CGO builds run in a container with the host's architecture
+	// and can't be cross-compiled
+	if useCGO && options != nil && options.GOARCH != "" && options.GOARCH != runtime.GOARCH {
+		return errors.Errorf("CGO builds can't be cross-compiled. Target GOARCH=%s doesn't match the host GOARCH=%s",
+			options.GOARCH,
+			runtime.GOARCH)
+	}
+	buildEnvironment := options.environ()
+	offlineErr := ensureOfflineModules(buildEnvironment, logger)
+	if offlineErr != nil {
+		return offlineErr
+	}
 	// Go generate
-	cmd := exec.Command("go", "generate")
+	cmd := exec.CommandContext(ctx, "go", "generate")
 	if logger.Level == logrus.DebugLevel {
-		cmd = exec.Command("go", "generate", "-v", "-x")
+		cmd = exec.CommandContext(ctx, "go", "generate", "-v", "-x")
 	}
-	cmd.Env = os.Environ()
+	cmd.Env = buildEnvironment
 	commandString := fmt.Sprintf("%s", cmd.Args)
 	logger.Info(fmt.Sprintf("Running `%s`", strings.Trim(commandString, "[]")))
 	goGenerateErr := RunOSCommand(cmd, logger)
 	if nil != goGenerateErr {
+		if ctx.Err() != nil {
+			return errors.Wrapf(ctx.Err(), "go generate canceled")
+		}
 		return goGenerateErr
 	}
 	// TODO: Smaller binaries via linker flags
@@ -134,8 +318,11 @@ func BuildGoBinary(serviceName string,
 		"StampedServiceName": serviceName,
 		"StampedBuildID":     buildID,
 	}
+	if options == nil || !options.KeepDebugSymbols {
+		linkFlags = fmt.Sprintf("%s -s -w", linkFlags)
+	}
 	for eachFlag, eachValue := range linkerFlags {
-		linkFlags = fmt.Sprintf("%s -s -w -X github.com/mweagle/Sparta.%s=%s",
+		linkFlags = fmt.Sprintf("%s -X github.com/mweagle/Sparta.%s=%s",
 			linkFlags,
 			eachFlag,
 			eachValue)
@@ -182,6 +369,9 @@ func BuildGoBinary(serviceName string,
 			goosTarget = "linux"
 		}
 		goArch := os.Getenv("SPARTA_GOARCH")
+		if options != nil && options.GOARCH != "" {
+			goArch = options.GOARCH
+		}
 		if goArch == "" {
 			goArch = "amd64"
 		}
@@ -203,6 +393,10 @@ func BuildGoBinary(serviceName string,
 				spartaEnvVars = append(spartaEnvVars, "-e", eachPair)
 			}
 		}
+		// Build environment overrides
+		for _, eachPair := range options.environmentPairs() {
+			spartaEnvVars = append(spartaEnvVars, "-e", eachPair)
+		}
 		dockerBuildArgs := []string{
 			"run",
 			"--rm",
@@ -220,13 +414,13 @@ func BuildGoBinary(serviceName string,
 			"-buildmode=default",
 		)
 		dockerBuildArgs = append(dockerBuildArgs, userBuildFlags...)
-		cmd = exec.Command("docker", dockerBuildArgs...)
+		cmd = exec.CommandContext(ctx, "docker", dockerBuildArgs...)
 		cmd.Env = os.Environ()
 		logger.WithFields(logrus.Fields{
 			"Name": executableOutput,
 			"Args": dockerBuildArgs,
 		}).Info("Building `cgo` library in Docker")
-		cmdError = RunOSCommand(cmd, logger)
+		cmdError = RunStreamedOSCommand(cmd, "docker build", logger)
 
 		// If this succeeded, let's find the .h file and move it into the scratch
 		// Try to keep things tidy...
@@ -261,13 +455,16 @@ func BuildGoBinary(serviceName string,
 		}
 		buildArgs = append(buildArgs, userBuildFlags...)
 		buildArgs = append(buildArgs, ".")
-		cmd = exec.Command("go", buildArgs...)
-		cmd.Env = os.Environ()
-		cmd.Env = append(cmd.Env, "GOOS=linux", "GOARCH=amd64")
+		cmd = exec.CommandContext(ctx, "go", buildArgs...)
+		cmd.Env = buildEnvironment
+		cmd.Env = append(cmd.Env, "GOOS=linux", fmt.Sprintf("GOARCH=%s", options.goArch()))
 		logger.WithFields(logrus.Fields{
 			"Name": executableOutput,
 		}).Info("Compiling binary")
-		cmdError = RunOSCommand(cmd, logger)
+		cmdError = RunStreamedOSCommand(cmd, "go build", logger)
+	}
+	if cmdError != nil && ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "Build of %s canceled", executableOutput)
 	}
 	return cmdError
 }
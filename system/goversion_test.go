@@ -2,7 +2,9 @@ package system
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -25,3 +27,48 @@ func TestGoPath(t *testing.T) {
 		t.Fatalf("Failed to find `GOPATH` at: %s. Error: %s", goBinPath, statErr)
 	}
 }
+
+func TestRunStreamedOSCommand(t *testing.T) {
+	logger := logrus.New()
+	cmd := exec.Command("sh", "-c", "echo line1; echo 'warning: deprecated' 1>&2; exit 3")
+	err := RunStreamedOSCommand(cmd, "test", logger)
+	if err == nil {
+		t.Fatalf("Failed to return command error")
+	}
+	if !strings.Contains(err.Error(), "line1") ||
+		!strings.Contains(err.Error(), "warning: deprecated") {
+		t.Fatalf("Failed to include output in error: %s", err)
+	}
+}
+
+func TestBuildOptionsEnvironment(t *testing.T) {
+	options := &BuildOptions{
+		Environment: map[string]string{
+			"GOFLAGS": "-mod=vendor",
+			"GOPROXY": "off",
+		},
+	}
+	if validateErr := options.validate(); validateErr != nil {
+		t.Fatalf("Expected valid build environment: %s", validateErr)
+	}
+	goProxyCount := 0
+	for _, eachPair := range options.environ() {
+		if strings.HasPrefix(eachPair, "GOPROXY=") {
+			goProxyCount++
+			if eachPair != "GOPROXY=off" {
+				t.Fatalf("Expected GOPROXY override, got: %s", eachPair)
+			}
+		}
+	}
+	if goProxyCount != 1 {
+		t.Fatalf("Expected exactly one GOPROXY value, got: %d", goProxyCount)
+	}
+	invalidOptions := &BuildOptions{
+		Environment: map[string]string{
+			"GOOS": "darwin",
+		},
+	}
+	if validateErr := invalidOptions.validate(); validateErr == nil {
+		t.Fatalf("Failed to reject GOOS build environment override")
+	}
+}
@@ -0,0 +1,190 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// cycloneDXSpecVersion is the CycloneDX specification version
+// of the generated SBOM
+const cycloneDXSpecVersion = "1.4"
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXMetadata struct {
+	Component *cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXBOM struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    *cycloneDXMetadata   `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+// moduleInfo is a module reported by `go version -m`
+type moduleInfo struct {
+	Path    string
+	Version string
+	Sum     string
+	Replace *moduleInfo
+}
+
+// buildInfo is the build information reported by `go version -m`
+type buildInfo struct {
+	GoVersion string
+	Main      moduleInfo
+	Deps      []*moduleInfo
+}
+
+// parseBuildInfo parses the `go version -m` output. The module lines
+// are tab separated and a `=>` line describes the replacement of the
+// preceding dependency:
+//
+//	/path/to/binary: go1.13.7
+//		path	github.com/user/service
+//		mod	github.com/user/service	(devel)
+//		dep	github.com/pkg/errors	v0.9.1	h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=
+//		=>	github.com/pkg/errors	v0.8.1	h1:iURUrRGxPUNPdy5/HRSm+Yj6okJ6UtLINN0Q9M4+h3I=
+func parseBuildInfo(versionOutput string) (*buildInfo, error) {
+	info := &buildInfo{}
+	lines := strings.Split(strings.TrimSpace(versionOutput), "\n")
+	if len(lines) == 0 || !strings.Contains(lines[0], ": ") {
+		return nil, errors.Errorf("Unexpected go version output: %s", versionOutput)
+	}
+	info.GoVersion = strings.TrimSpace(lines[0][strings.LastIndex(lines[0], ": ")+2:])
+	for _, eachLine := range lines[1:] {
+		fields := strings.Split(strings.TrimSpace(eachLine), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		module := &moduleInfo{
+			Path: fields[1],
+		}
+		if len(fields) > 2 {
+			module.Version = fields[2]
+		}
+		if len(fields) > 3 {
+			module.Sum = fields[3]
+		}
+		switch fields[0] {
+		case "mod":
+			info.Main = *module
+		case "dep":
+			info.Deps = append(info.Deps, module)
+		case "=>":
+			if len(info.Deps) != 0 {
+				info.Deps[len(info.Deps)-1].Replace = module
+			}
+		}
+	}
+	if info.Main.Path == "" {
+		return nil, errors.Errorf("No module information found in go version output")
+	}
+	return info, nil
+}
+
+// moduleComponent returns the CycloneDX library component for the module
+func moduleComponent(path string, version string, sum string) cycloneDXComponent {
+	component := cycloneDXComponent{
+		Type:    "library",
+		Name:    path,
+		Version: version,
+		PURL:    fmt.Sprintf("pkg:golang/%s@%s", path, version),
+	}
+	// The go.sum h1: value is a SHA-256 digest of the module's dirhash
+	// summary rather than of the module content, so it's reported as
+	// a property instead of a component hash
+	if sum != "" {
+		component.Properties = []cycloneDXProperty{{
+			Name:  "golang:go.sum",
+			Value: sum,
+		}}
+	}
+	return component
+}
+
+// sbomDocument returns the CycloneDX JSON document for the build info.
+// The document doesn't include a timestamp so that the same binary
+// always produces the same document and digest.
+func sbomDocument(info *buildInfo) ([]byte, error) {
+	bom := cycloneDXBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: cycloneDXSpecVersion,
+		Version:     1,
+		Metadata: &cycloneDXMetadata{
+			Component: &cycloneDXComponent{
+				Type:    "application",
+				Name:    info.Main.Path,
+				Version: info.Main.Version,
+			},
+		},
+		Components: make([]cycloneDXComponent, 0),
+	}
+	for _, eachDep := range info.Deps {
+		dep := eachDep
+		// Replaced modules are reported with the replacement's version
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		bom.Components = append(bom.Components,
+			moduleComponent(eachDep.Path, dep.Version, dep.Sum))
+	}
+	return json.MarshalIndent(bom, "", " ")
+}
+
+// WriteSBOM reads the module information embedded in the Go binary at
+// executablePath via `go version -m` and writes a CycloneDX JSON
+// software bill of materials to sbomPath. The SHA256 digest of the
+// SBOM is returned.
+func WriteSBOM(executablePath string,
+	sbomPath string,
+	logger *logrus.Logger) (string, error) {
+	versionOutput, versionOutputErr := exec.Command("go",
+		"version",
+		"-m",
+		executablePath).CombinedOutput()
+	if versionOutputErr != nil {
+		return "", errors.Wrapf(versionOutputErr,
+			"Failed to read build info from %s: %s",
+			executablePath,
+			strings.TrimSpace(string(versionOutput)))
+	}
+	info, infoErr := parseBuildInfo(string(versionOutput))
+	if infoErr != nil {
+		return "", errors.Wrapf(infoErr,
+			"Failed to read build info from %s", executablePath)
+	}
+	bomBytes, bomBytesErr := sbomDocument(info)
+	if bomBytesErr != nil {
+		return "", bomBytesErr
+	}
+	writeErr := ioutil.WriteFile(sbomPath, bomBytes, 0644)
+	if writeErr != nil {
+		return "", errors.Wrapf(writeErr, "Failed to write SBOM to %s", sbomPath)
+	}
+	logger.WithFields(logrus.Fields{
+		"Path":       sbomPath,
+		"GoVersion":  info.GoVersion,
+		"Components": len(info.Deps),
+	}).Debug("Created SBOM")
+	return FileSHA256(sbomPath)
+}
@@ -0,0 +1,93 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testVersionOutput = `/tmp/service: go1.13.7
+	path	github.com/user/service
+	mod	github.com/user/service	(devel)	
+	dep	github.com/pkg/errors	v0.9.1	h1:FEBLx1zS214owpjy7qsBeixbURkuhQAwrK5UwLGTwt4=
+	=>	github.com/pkg/errors	v0.8.1	h1:iURUrRGxPUNPdy5/HRSm+Yj6okJ6UtLINN0Q9M4+h3I=
+	dep	github.com/sirupsen/logrus	v1.4.2	h1:SPIRibHv4MatM3XXNO2BJeFLZwZ2LvZgfQ5+UNI2im4=
+`
+
+func TestSBOMDocument(t *testing.T) {
+	info, infoErr := parseBuildInfo(testVersionOutput)
+	if infoErr != nil {
+		t.Fatalf("Failed to parse build info: %s", infoErr)
+	}
+	if info.GoVersion != "go1.13.7" ||
+		info.Main.Path != "github.com/user/service" ||
+		len(info.Deps) != 2 {
+		t.Fatalf("Unexpected build info: %#v", info)
+	}
+	bomBytes, bomBytesErr := sbomDocument(info)
+	if bomBytesErr != nil {
+		t.Fatalf("Failed to create SBOM: %s", bomBytesErr)
+	}
+	var bom cycloneDXBOM
+	unmarshalErr := json.Unmarshal(bomBytes, &bom)
+	if unmarshalErr != nil {
+		t.Fatalf("Failed to unmarshal SBOM: %s", unmarshalErr)
+	}
+	if len(bom.Components) != 2 {
+		t.Fatalf("Expected 2 SBOM components, got %d", len(bom.Components))
+	}
+	replaced := bom.Components[0]
+	if replaced.Name != "github.com/pkg/errors" ||
+		replaced.Version != "v0.8.1" ||
+		len(replaced.Properties) != 1 ||
+		replaced.Properties[0].Value != "h1:iURUrRGxPUNPdy5/HRSm+Yj6okJ6UtLINN0Q9M4+h3I=" {
+		t.Fatalf("Expected the replacement module version and go.sum value: %#v", replaced)
+	}
+	if strings.Contains(string(bomBytes), "SHA-256") {
+		t.Fatalf("Expected go.sum values to not be reported as SHA-256 hashes: %s", string(bomBytes))
+	}
+	// The document must be stable across builds
+	secondBytes, secondBytesErr := sbomDocument(info)
+	if secondBytesErr != nil {
+		t.Fatalf("Failed to create SBOM: %s", secondBytesErr)
+	}
+	if !bytes.Equal(bomBytes, secondBytes) {
+		t.Fatalf("Expected identical SBOM documents for the same build info")
+	}
+	_, invalidErr := parseBuildInfo("go: not a binary")
+	if invalidErr == nil {
+		t.Fatalf("Expected an error for output without module information")
+	}
+}
+
+func TestWriteSBOM(t *testing.T) {
+	testBinary, testBinaryErr := os.Executable()
+	if testBinaryErr != nil {
+		t.Fatalf("Failed to find test binary: %s", testBinaryErr)
+	}
+	outputDir, outputDirErr := ioutil.TempDir("", "sbom")
+	if outputDirErr != nil {
+		t.Fatalf("Failed to create output directory: %s", outputDirErr)
+	}
+	defer os.RemoveAll(outputDir)
+
+	logger := logrus.New()
+	sbomPath := filepath.Join(outputDir, "service.cdx.json")
+	firstDigest, firstDigestErr := WriteSBOM(testBinary, sbomPath, logger)
+	if firstDigestErr != nil {
+		t.Fatalf("Failed to write SBOM: %s", firstDigestErr)
+	}
+	secondDigest, secondDigestErr := WriteSBOM(testBinary, sbomPath, logger)
+	if secondDigestErr != nil {
+		t.Fatalf("Failed to write SBOM: %s", secondDigestErr)
+	}
+	if firstDigest != secondDigest {
+		t.Fatalf("Expected a stable SBOM digest, got %s and %s", firstDigest, secondDigest)
+	}
+}
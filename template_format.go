@@ -0,0 +1,156 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// yaml11AmbiguousScalars are the YAML 1.1 boolean and null values that
+// YAML 1.2 encoders emit unquoted, but that YAML 1.1 parsers
+// don't read as strings
+var yaml11AmbiguousScalars = map[string]bool{
+	"y": true, "Y": true, "yes": true, "Yes": true, "YES": true,
+	"n": true, "N": true, "no": true, "No": true, "NO": true,
+	"on": true, "On": true, "ON": true,
+	"off": true, "Off": true, "OFF": true,
+}
+
+// reYAML11Sexagesimal matches the YAML 1.1 base 60 numbers (eg, 1:30)
+var reYAML11Sexagesimal = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(:[0-5]?[0-9])+(\.[0-9_]*)?$`)
+
+// templateFormat returns the requested template format or the
+// default TemplateFormatJSON value
+func templateFormat(workflowHooks *WorkflowHooks) (string, error) {
+	if workflowHooks == nil || workflowHooks.TemplateFormat == "" {
+		return TemplateFormatJSON, nil
+	}
+	switch workflowHooks.TemplateFormat {
+	case TemplateFormatJSON, TemplateFormatYAML:
+		return workflowHooks.TemplateFormat, nil
+	default:
+		return "", errors.Errorf("Unsupported TemplateFormat: %s. Must be one of: %s, %s",
+			workflowHooks.TemplateFormat,
+			TemplateFormatJSON,
+			TemplateFormatYAML)
+	}
+}
+
+// blockStyleNode removes the JSON flow and quoting styles s.t. the
+// node is encoded as block YAML. Strings that YAML 1.1 parsers
+// would misread stay quoted.
+func blockStyleNode(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode &&
+		node.Tag == "!!str" &&
+		(yaml11AmbiguousScalars[node.Value] || reYAML11Sexagesimal.MatchString(node.Value)) {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	for _, eachNode := range node.Content {
+		blockStyleNode(eachNode)
+	}
+}
+
+// yamlTemplate converts the marshaled JSON template to YAML. Intrinsic
+// functions use the full (eg, Fn::GetAtt) rather than the short
+// form. Keys are emitted in the same order as the JSON template.
+func yamlTemplate(jsonTemplate []byte) ([]byte, error) {
+	var templateNode yaml.Node
+	unmarshalErr := yaml.Unmarshal(jsonTemplate, &templateNode)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse JSON template")
+	}
+	blockStyleNode(&templateNode)
+
+	var yamlBuffer bytes.Buffer
+	encoder := yaml.NewEncoder(&yamlBuffer)
+	encoder.SetIndent(2)
+	encodeErr := encoder.Encode(&templateNode)
+	if encodeErr != nil {
+		return nil, errors.Wrapf(encodeErr, "Failed to encode YAML template")
+	}
+	closeErr := encoder.Close()
+	if closeErr != nil {
+		return nil, errors.Wrapf(closeErr, "Failed to encode YAML template")
+	}
+	return yamlBuffer.Bytes(), nil
+}
+
+// expandShortFormIntrinsics rewrites the YAML short form intrinsic
+// functions (eg, !GetAtt Resource.Arn) to the full form mapping used
+// by the JSON template
+func expandShortFormIntrinsics(node *yaml.Node) {
+	for _, eachNode := range node.Content {
+		expandShortFormIntrinsics(eachNode)
+	}
+	if !strings.HasPrefix(node.Tag, "!") || strings.HasPrefix(node.Tag, "!!") {
+		return
+	}
+	functionName := strings.TrimPrefix(node.Tag, "!")
+	keyName := "Fn::" + functionName
+	if functionName == "Ref" || functionName == "Condition" {
+		keyName = functionName
+	}
+	valueNode := *node
+	switch valueNode.Kind {
+	case yaml.ScalarNode:
+		valueNode.Tag = "!!str"
+		// The short form GetAtt is a dotted scalar
+		if functionName == "GetAtt" {
+			nameParts := strings.SplitN(valueNode.Value, ".", 2)
+			valueNode = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+			for _, eachPart := range nameParts {
+				valueNode.Content = append(valueNode.Content, &yaml.Node{
+					Kind:  yaml.ScalarNode,
+					Tag:   "!!str",
+					Value: eachPart,
+				})
+			}
+		}
+	case yaml.SequenceNode:
+		valueNode.Tag = "!!seq"
+	case yaml.MappingNode:
+		valueNode.Tag = "!!map"
+	}
+	*node = yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{{
+			Kind:  yaml.ScalarNode,
+			Tag:   "!!str",
+			Value: keyName,
+		},
+			&valueNode},
+	}
+}
+
+// jsonTemplateBody returns the JSON version of a JSON or YAML template
+// body, such as the GetTemplate response for a stack that was
+// provisioned with TemplateFormatYAML
+func jsonTemplateBody(templateBody []byte) ([]byte, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(templateBody), []byte("{")) {
+		return templateBody, nil
+	}
+	var templateNode yaml.Node
+	unmarshalErr := yaml.Unmarshal(templateBody, &templateNode)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to parse YAML template")
+	}
+	expandShortFormIntrinsics(&templateNode)
+	var template interface{}
+	decodeErr := templateNode.Decode(&template)
+	if decodeErr != nil {
+		return nil, errors.Wrapf(decodeErr, "Failed to decode YAML template")
+	}
+	jsonBody, jsonBodyErr := json.Marshal(template)
+	if jsonBodyErr != nil {
+		return nil, errors.Wrapf(jsonBodyErr, "Failed to convert YAML template to JSON")
+	}
+	return jsonBody, nil
+}
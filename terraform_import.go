@@ -0,0 +1,192 @@
+// +build !lambdabinary
+
+package sparta
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// terraformResourceTypes maps the CloudFormation resource types whose
+// PhysicalResourceId is also the terraform import ID to the terraform
+// resource type. Resources whose import ID is a composite
+// (eg, AWS::Lambda::Permission) aren't supported.
+var terraformResourceTypes = map[string]string{
+	"AWS::ApiGateway::RestApi":         "aws_api_gateway_rest_api",
+	"AWS::CloudWatch::Alarm":           "aws_cloudwatch_metric_alarm",
+	"AWS::DynamoDB::Table":             "aws_dynamodb_table",
+	"AWS::Events::Rule":                "aws_cloudwatch_event_rule",
+	"AWS::IAM::ManagedPolicy":          "aws_iam_policy",
+	"AWS::IAM::Role":                   "aws_iam_role",
+	"AWS::Kinesis::Stream":             "aws_kinesis_stream",
+	"AWS::Lambda::EventSourceMapping":  "aws_lambda_event_source_mapping",
+	"AWS::Lambda::Function":            "aws_lambda_function",
+	"AWS::Logs::LogGroup":              "aws_cloudwatch_log_group",
+	"AWS::S3::Bucket":                  "aws_s3_bucket",
+	"AWS::SNS::Topic":                  "aws_sns_topic",
+	"AWS::SQS::Queue":                  "aws_sqs_queue",
+	"AWS::StepFunctions::StateMachine": "aws_sfn_state_machine",
+}
+
+var reTerraformNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// TerraformImport is the terraform import stub for a provisioned
+// stack resource
+type TerraformImport struct {
+	// LogicalResourceID is the CloudFormation logical resource ID
+	LogicalResourceID string
+	// ResourceType is the CloudFormation resource type
+	ResourceType string
+	// Address is the terraform resource address (eg, aws_iam_role.MyRole)
+	Address string
+	// ID is the terraform import ID
+	ID string
+}
+
+// String returns the terraform import command
+func (tfImport *TerraformImport) String() string {
+	return fmt.Sprintf("terraform import %s %s", tfImport.Address, tfImport.ID)
+}
+
+// terraformResourceName returns a terraform resource name for the
+// CloudFormation logical resource ID. Terraform names must start
+// with a letter or underscore.
+func terraformResourceName(logicalResourceID string) string {
+	name := reTerraformNameInvalidChars.ReplaceAllString(logicalResourceID, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || name[0] == '-' {
+		name = "_" + name
+	}
+	return name
+}
+
+// cloudFormationStackResourceType is the resource type of a nested stack
+const cloudFormationStackResourceType = "AWS::CloudFormation::Stack"
+
+// listStackResources returns the summaries of every resource in the stack,
+// including the resources of any nested stacks. Nested stack resources
+// have their LogicalResourceId prefixed with the parent logical IDs
+// so that the terraform addresses remain unique.
+func listStackResources(cfSvc cloudformationiface.CloudFormationAPI,
+	stackName string,
+	logicalIDPrefix string) ([]*cloudformation.StackResourceSummary, error) {
+
+	resources := make([]*cloudformation.StackResourceSummary, 0)
+	nestedStacks := make([]*cloudformation.StackResourceSummary, 0)
+	listErr := cfSvc.ListStackResourcesPages(&cloudformation.ListStackResourcesInput{
+		StackName: aws.String(stackName),
+	}, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+		for _, eachResource := range page.StackResourceSummaries {
+			if logicalIDPrefix != "" {
+				eachResource.LogicalResourceId = aws.String(logicalIDPrefix +
+					aws.StringValue(eachResource.LogicalResourceId))
+			}
+			if aws.StringValue(eachResource.ResourceType) == cloudFormationStackResourceType {
+				nestedStacks = append(nestedStacks, eachResource)
+				continue
+			}
+			resources = append(resources, eachResource)
+		}
+		return true
+	})
+	if listErr != nil {
+		return nil, errors.Wrapf(listErr,
+			"Failed to list resources for stack %s",
+			stackName)
+	}
+	for _, eachNestedStack := range nestedStacks {
+		physicalID := aws.StringValue(eachNestedStack.PhysicalResourceId)
+		resourceStatus := aws.StringValue(eachNestedStack.ResourceStatus)
+		if physicalID == "" ||
+			strings.HasPrefix(resourceStatus, "DELETE_") {
+			continue
+		}
+		nestedResources, nestedResourcesErr := listStackResources(cfSvc,
+			physicalID,
+			aws.StringValue(eachNestedStack.LogicalResourceId)+"_")
+		if nestedResourcesErr != nil {
+			return nil, nestedResourcesErr
+		}
+		resources = append(resources, nestedResources...)
+	}
+	return resources, nil
+}
+
+// terraformImports returns the sorted import stubs for the supported
+// resources together with the sorted descriptions of the
+// unsupported resources
+func terraformImports(resources []*cloudformation.StackResourceSummary) ([]*TerraformImport, []string) {
+	tfImports := make([]*TerraformImport, 0)
+	unsupported := make([]string, 0)
+	for _, eachResource := range resources {
+		logicalID := aws.StringValue(eachResource.LogicalResourceId)
+		physicalID := aws.StringValue(eachResource.PhysicalResourceId)
+		resourceStatus := aws.StringValue(eachResource.ResourceStatus)
+		if physicalID == "" ||
+			strings.HasPrefix(resourceStatus, "DELETE_") ||
+			strings.HasSuffix(resourceStatus, "_FAILED") {
+			continue
+		}
+		resourceType := aws.StringValue(eachResource.ResourceType)
+		tfType, tfTypeExists := terraformResourceTypes[resourceType]
+		if !tfTypeExists {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", logicalID, resourceType))
+			continue
+		}
+		tfImports = append(tfImports, &TerraformImport{
+			LogicalResourceID: logicalID,
+			ResourceType:      resourceType,
+			Address:           fmt.Sprintf("%s.%s", tfType, terraformResourceName(logicalID)),
+			ID:                physicalID,
+		})
+	}
+	sort.Slice(tfImports, func(i, j int) bool {
+		return tfImports[i].Address < tfImports[j].Address
+	})
+	sort.Strings(unsupported)
+	return tfImports, unsupported
+}
+
+// TerraformImports writes the terraform import command stubs for the
+// resources in the provisioned stack to the outputWriter, one command
+// per line. Resources in nested stacks are included. Resources without
+// a supported terraform type are logged and skipped. Only the import
+// commands are produced; the matching HCL resource blocks must be
+// authored separately.
+func TerraformImports(serviceName string,
+	outputWriter io.Writer,
+	logger *logrus.Logger) error {
+
+	awsSession := spartaAWS.NewSession(logger)
+	cfSvc := cloudformation.New(awsSession)
+	resources, resourcesErr := listStackResources(cfSvc, serviceName, "")
+	if resourcesErr != nil {
+		return resourcesErr
+	}
+	tfImports, unsupported := terraformImports(resources)
+	for _, eachResource := range unsupported {
+		logger.WithFields(logrus.Fields{
+			"Resource": eachResource,
+		}).Warn("Resource type not supported for terraform import")
+	}
+	for _, eachImport := range tfImports {
+		_, writeErr := fmt.Fprintln(outputWriter, eachImport.String())
+		if writeErr != nil {
+			return writeErr
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"Imports":     len(tfImports),
+		"Unsupported": len(unsupported),
+	}).Info("Terraform import commands complete")
+	return nil
+}
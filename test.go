@@ -138,7 +138,7 @@ func testProvisionEx(t *testing.T,
 	}
 	var templateWriter bytes.Buffer
 	err := Provision(true,
-		"SampleProvision",
+		"Sample",
 		"",
 		lambdaAWSInfos,
 		nil,
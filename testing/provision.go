@@ -62,7 +62,7 @@ func ProvisionEx(t *testing.T,
 	}
 	var templateWriter bytes.Buffer
 	err := sparta.Provision(true,
-		"SampleProvision",
+		"Sample",
 		"",
 		lambdaAWSInfos,
 		nil,
@@ -0,0 +1,344 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	sparta "github.com/mweagle/Sparta"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// reSubReference matches the ${Name} and ${Name.Attribute} references in an
+// Fn::Sub string. ${!Literal} values aren't references.
+var reSubReference = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+
+// templateLinter holds the marshaled template names that references
+// are resolved against
+type templateLinter struct {
+	parameters map[string]interface{}
+	resources  map[string]interface{}
+	conditions map[string]interface{}
+	errorText  []string
+}
+
+func (linter *templateLinter) errorf(format string, args ...interface{}) {
+	linter.errorText = append(linter.errorText, fmt.Sprintf(format, args...))
+}
+
+// sortedKeys returns the map keys in sorted order so that the reported
+// problems are stable
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for eachKey := range values {
+		keys = append(keys, eachKey)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// verifyRef ensures the Ref target is a Parameter, Resource, or
+// pseudo parameter
+func (linter *templateLinter) verifyRef(location string, target interface{}) {
+	targetName, targetNameOk := target.(string)
+	if !targetNameOk {
+		linter.errorf("%s has a non-string Ref: %#v", location, target)
+		return
+	}
+	if strings.HasPrefix(targetName, "AWS::") {
+		return
+	}
+	_, paramExists := linter.parameters[targetName]
+	_, resourceExists := linter.resources[targetName]
+	if !paramExists && !resourceExists {
+		linter.errorf("%s references undeclared Parameter or Resource: %s", location, targetName)
+	}
+}
+
+// verifyGetAtt ensures the Fn::GetAtt target is a Resource
+func (linter *templateLinter) verifyGetAtt(location string, target interface{}) {
+	resourceName := ""
+	switch typedTarget := target.(type) {
+	case string:
+		resourceName = strings.SplitN(typedTarget, ".", 2)[0]
+	case []interface{}:
+		if len(typedTarget) == 2 {
+			resourceName, _ = typedTarget[0].(string)
+		}
+	}
+	if resourceName == "" {
+		linter.errorf("%s has an invalid Fn::GetAtt: %#v", location, target)
+		return
+	}
+	if _, exists := linter.resources[resourceName]; !exists {
+		linter.errorf("%s Fn::GetAtt references undeclared Resource: %s", location, resourceName)
+	}
+}
+
+// verifySub ensures the Fn::Sub references are either Sub variables or
+// resolvable Ref/GetAtt targets
+func (linter *templateLinter) verifySub(location string, target interface{}) {
+	subString := ""
+	variables := map[string]interface{}{}
+	switch typedTarget := target.(type) {
+	case string:
+		subString = typedTarget
+	case []interface{}:
+		if len(typedTarget) == 2 {
+			subString, _ = typedTarget[0].(string)
+			variables, _ = typedTarget[1].(map[string]interface{})
+			linter.walk(location, typedTarget[1])
+		}
+	}
+	for _, eachMatch := range reSubReference.FindAllStringSubmatch(subString, -1) {
+		reference := strings.TrimSpace(eachMatch[1])
+		if _, exists := variables[reference]; exists {
+			continue
+		}
+		if strings.Contains(reference, ".") {
+			linter.verifyGetAtt(location, reference)
+		} else {
+			linter.verifyRef(location, reference)
+		}
+	}
+}
+
+// verifyCondition ensures the condition name is declared
+func (linter *templateLinter) verifyCondition(location string, conditionName interface{}) {
+	name, _ := conditionName.(string)
+	if _, exists := linter.conditions[name]; !exists {
+		linter.errorf("%s references undeclared Condition: %v", location, conditionName)
+	}
+}
+
+// walk recursively verifies the intrinsic function references in the
+// marshaled value
+func (linter *templateLinter) walk(location string, value interface{}) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		if len(typedValue) == 1 {
+			for eachKey, eachValue := range typedValue {
+				switch eachKey {
+				case "Ref":
+					linter.verifyRef(location, eachValue)
+					return
+				case "Fn::GetAtt":
+					linter.verifyGetAtt(location, eachValue)
+					return
+				case "Fn::Sub":
+					linter.verifySub(location, eachValue)
+					return
+				case "Fn::If":
+					if ifArgs, ifArgsOk := eachValue.([]interface{}); ifArgsOk && len(ifArgs) == 3 {
+						linter.verifyCondition(location, ifArgs[0])
+						linter.walk(location, ifArgs[1])
+						linter.walk(location, ifArgs[2])
+						return
+					}
+				case "Condition":
+					// Condition references in condition functions are
+					// strings, unlike IAM policy Condition blocks
+					if _, isName := eachValue.(string); isName {
+						linter.verifyCondition(location, eachValue)
+						return
+					}
+				}
+			}
+		}
+		for _, eachKey := range sortedKeys(typedValue) {
+			linter.walk(location, typedValue[eachKey])
+		}
+	case []interface{}:
+		for _, eachValue := range typedValue {
+			linter.walk(location, eachValue)
+		}
+	}
+}
+
+// editDistance returns the Levenshtein distance between the strings
+func editDistance(lhs string, rhs string) int {
+	previous := make([]int, len(rhs)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(lhs); i++ {
+		current := make([]int, len(rhs)+1)
+		current[0] = i
+		for j := 1; j <= len(rhs); j++ {
+			cost := 1
+			if lhs[i-1] == rhs[j-1] {
+				cost = 0
+			}
+			current[j] = current[j-1] + 1
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous = current
+	}
+	return previous[len(rhs)]
+}
+
+// verifyProperties ensures the required properties of known resource types
+// are present. Properties that aren't defined by the resource type, but are
+// similar to one that is, are reported as likely typos. Other unknown
+// properties are allowed since the schema may predate them.
+func (linter *templateLinter) verifyProperties(resourceName string,
+	resourceType string,
+	properties map[string]interface{}) {
+
+	typedResource := gocf.NewResourceByType(resourceType)
+	if typedResource == nil {
+		return
+	}
+	resourceStruct := reflect.TypeOf(typedResource).Elem()
+	knownProperties := make(map[string]bool, resourceStruct.NumField())
+	knownPropertyNames := make([]string, 0, resourceStruct.NumField())
+	for i := 0; i < resourceStruct.NumField(); i++ {
+		eachField := resourceStruct.Field(i)
+		propertyName := strings.Split(eachField.Tag.Get("json"), ",")[0]
+		if propertyName == "" || propertyName == "-" {
+			continue
+		}
+		knownProperties[propertyName] = true
+		knownPropertyNames = append(knownPropertyNames, propertyName)
+		if !strings.Contains(eachField.Tag.Get("validate"), "required") {
+			continue
+		}
+		if _, exists := properties[propertyName]; !exists {
+			linter.errorf("Resource %s (%s) is missing required property: %s",
+				resourceName,
+				resourceType,
+				propertyName)
+		}
+	}
+	// The closest known property is suggested. Ties are resolved in sorted
+	// order so that the suggestion is stable across runs.
+	sort.Strings(knownPropertyNames)
+	for _, eachProperty := range sortedKeys(properties) {
+		if knownProperties[eachProperty] {
+			continue
+		}
+		suggestion := ""
+		suggestionDistance := 0
+		for _, eachKnown := range knownPropertyNames {
+			distance := editDistance(eachKnown, eachProperty)
+			if strings.EqualFold(eachKnown, eachProperty) {
+				distance = 0
+			} else if len(eachProperty) <= 3 || distance > 2 {
+				continue
+			}
+			if suggestion == "" || distance < suggestionDistance {
+				suggestion = eachKnown
+				suggestionDistance = distance
+			}
+		}
+		if suggestion != "" {
+			linter.errorf("Resource %s (%s) has unknown property %s. Did you mean %s?",
+				resourceName,
+				resourceType,
+				eachProperty,
+				suggestion)
+		}
+	}
+}
+
+// lintTemplate returns the problems found in the template
+func lintTemplate(template *gocf.Template) ([]string, error) {
+	templateJSON, templateJSONErr := json.Marshal(template)
+	if templateJSONErr != nil {
+		return nil, errors.Wrapf(templateJSONErr, "Failed to marshal template")
+	}
+	var marshaled struct {
+		Parameters map[string]interface{}
+		Conditions map[string]interface{}
+		Resources  map[string]interface{}
+		Outputs    map[string]interface{}
+	}
+	unmarshalErr := json.Unmarshal(templateJSON, &marshaled)
+	if unmarshalErr != nil {
+		return nil, errors.Wrapf(unmarshalErr, "Failed to unmarshal template")
+	}
+	linter := &templateLinter{
+		parameters: marshaled.Parameters,
+		resources:  marshaled.Resources,
+		conditions: marshaled.Conditions,
+	}
+	for _, eachName := range sortedKeys(marshaled.Conditions) {
+		linter.walk(fmt.Sprintf("Condition %s", eachName), marshaled.Conditions[eachName])
+	}
+	for _, eachName := range sortedKeys(marshaled.Resources) {
+		resource, _ := marshaled.Resources[eachName].(map[string]interface{})
+		resourceType, _ := resource["Type"].(string)
+		location := fmt.Sprintf("Resource %s (%s)", eachName, resourceType)
+		if dependsOn, dependsOnOk := resource["DependsOn"].([]interface{}); dependsOnOk {
+			for _, eachDependency := range dependsOn {
+				dependencyName, _ := eachDependency.(string)
+				if _, exists := marshaled.Resources[dependencyName]; !exists {
+					linter.errorf("%s DependsOn undeclared Resource: %v", location, eachDependency)
+				}
+			}
+		}
+		if condition, conditionOk := resource["Condition"]; conditionOk {
+			linter.verifyCondition(location, condition)
+		}
+		properties, _ := resource["Properties"].(map[string]interface{})
+		linter.verifyProperties(eachName, resourceType, properties)
+		linter.walk(location, properties)
+	}
+	for _, eachName := range sortedKeys(marshaled.Outputs) {
+		location := fmt.Sprintf("Output %s", eachName)
+		output, _ := marshaled.Outputs[eachName].(map[string]interface{})
+		if condition, conditionOk := output["Condition"]; conditionOk {
+			linter.verifyCondition(location, condition)
+		}
+		linter.walk(location, output["Value"])
+		linter.walk(location, output["Export"])
+	}
+	return linter.errorText, nil
+}
+
+// TemplateLinter returns a validator that verifies the template without
+// calling AWS. Every Ref, Fn::GetAtt, Fn::Sub, and DependsOn reference must
+// be a declared Parameter, Resource, or pseudo parameter, every Condition
+// must be declared, and known resource types must define their required
+// properties. All of the problems are returned in a single error.
+func TemplateLinter() sparta.ServiceValidationHookHandler {
+
+	templateLinter := func(context map[string]interface{},
+		serviceName string,
+		template *gocf.Template,
+		S3Bucket string,
+		S3Key string,
+		buildID string,
+		awsSession *session.Session,
+		noop bool,
+		logger *logrus.Logger) error {
+
+		errorText, lintErr := lintTemplate(template)
+		if lintErr != nil {
+			return lintErr
+		}
+		if len(errorText) != 0 {
+			for _, eachProblem := range errorText {
+				logger.WithField("Problem", eachProblem).Error("Template lint failure")
+			}
+			return errors.Errorf("Template %s failed linting:\n%s",
+				serviceName,
+				strings.Join(errorText, "\n"))
+		}
+		logger.WithField("ResourceCount", len(template.Resources)).
+			Info("Template linting passed")
+		return nil
+	}
+	return sparta.ServiceValidationHookFunc(templateLinter)
+}
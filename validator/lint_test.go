@@ -0,0 +1,98 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	gocf "github.com/mweagle/go-cloudformation"
+)
+
+// misspelledTopic is an SNS topic with a misspelled TopicName property
+type misspelledTopic struct {
+	DisplayName interface{}      `json:"DisplayName,omitempty"`
+	TopicNme    *gocf.StringExpr `json:"TopicNme,omitempty"`
+}
+
+func (topic misspelledTopic) CfnResourceType() string {
+	return "AWS::SNS::Topic"
+}
+
+func (topic misspelledTopic) CfnResourceAttributes() []string {
+	return []string{"TopicName"}
+}
+
+func TestLintTemplate(t *testing.T) {
+	template := gocf.NewTemplate()
+	template.Parameters["Stage"] = &gocf.Parameter{
+		Type: "String",
+	}
+	template.AddResource("Role", &gocf.IAMRole{
+		AssumeRolePolicyDocument: map[string]interface{}{},
+	})
+	template.AddResource("Function", &gocf.LambdaFunction{
+		Code: &gocf.LambdaFunctionCode{
+			S3Bucket: gocf.Ref("MissingBucket").String(),
+			S3Key:    gocf.Join("/", gocf.Ref("Stage"), gocf.Ref("AWS::Region")),
+		},
+		Handler: gocf.String("bootstrap"),
+		Role:    gocf.GetAtt("Role", "Arn"),
+	})
+	dependentResource := template.AddResource("Topic", &misspelledTopic{
+		DisplayName: map[string]interface{}{
+			"Fn::Sub": []interface{}{
+				"${Stage}-${Prefix}-${!Literal}-${Function.Arn}-${MissingKey}",
+				map[string]interface{}{
+					"Prefix": gocf.Ref("Stage"),
+				},
+			},
+		},
+		TopicNme: gocf.GetAtt("MissingResource", "Arn"),
+	})
+	dependentResource.DependsOn = []string{"Function", "MissingDependency"}
+	template.Outputs["FunctionArn"] = &gocf.Output{
+		Value: gocf.GetAtt("Function", "Arn"),
+	}
+	template.Outputs["MissingOutput"] = &gocf.Output{
+		Value: gocf.Ref("MissingOutputRef"),
+	}
+	problems, problemsErr := lintTemplate(template)
+	if problemsErr != nil {
+		t.Fatalf("Failed to lint template: %s", problemsErr)
+	}
+	expected := []string{
+		"Resource Function (AWS::Lambda::Function) is missing required property: Runtime",
+		"undeclared Parameter or Resource: MissingBucket",
+		"undeclared Parameter or Resource: MissingKey",
+		"DependsOn undeclared Resource: MissingDependency",
+		"has unknown property TopicNme. Did you mean TopicName?",
+		"Fn::GetAtt references undeclared Resource: MissingResource",
+		"Output MissingOutput references undeclared Parameter or Resource: MissingOutputRef",
+	}
+	allProblems := strings.Join(problems, "\n")
+	for _, eachExpected := range expected {
+		if !strings.Contains(allProblems, eachExpected) {
+			t.Errorf("Expected lint problem: %s\nProblems:\n%s", eachExpected, allProblems)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Unexpected lint problems:\n%s", allProblems)
+	}
+}
+
+func TestLintPropertySuggestion(t *testing.T) {
+	// Tole is within two edits of both Code and Role
+	properties := map[string]interface{}{
+		"Code":    map[string]interface{}{},
+		"Handler": "main",
+		"Tole":    "arn",
+		"Runtime": "go1.x",
+	}
+	for i := 0; i != 10; i++ {
+		linter := &templateLinter{}
+		linter.verifyProperties("Function", "AWS::Lambda::Function", properties)
+		allProblems := strings.Join(linter.errorText, "\n")
+		if !strings.Contains(allProblems, "has unknown property Tole. Did you mean Role?") {
+			t.Fatalf("Expected the closest property suggestion:\n%s", allProblems)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package sparta
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	spartaAWS "github.com/mweagle/Sparta/aws"
+	gocf "github.com/mweagle/go-cloudformation"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WarmupDispatcherName is the name of the function returned by
+	// NewWarmupDispatcher
+	WarmupDispatcherName = "SpartaWarmupDispatcher"
+	// warmupClientContextKey is the ClientContext custom key set
+	// by the dispatcher
+	warmupClientContextKey = "spartaWarmup"
+	// warmupMaxConcurrency is the maximum number of concurrent
+	// invocations for a single function
+	warmupMaxConcurrency = 50
+	// warmupDispatcherTimeout is the dispatcher timeout in seconds
+	warmupDispatcherTimeout = 60
+)
+
+var reWarmupRate = regexp.MustCompile(`^rate\((\d+) (minute|minutes|hour|hours|day|days)\)$`)
+var reWarmupCron = regexp.MustCompile(`^cron\((.+)\)$`)
+
+// WarmupPayload is the event payload sent to each warmed function
+var WarmupPayload = []byte(`{"spartaWarmup":true}`)
+
+// WarmupSchedule is a keep-warm cadence and the functions it targets
+type WarmupSchedule struct {
+	// ScheduleExpression is the EventBridge rate() or cron() expression.
+	// Ref: https://docs.aws.amazon.com/eventbridge/latest/userguide/scheduled-events.html
+	ScheduleExpression string
+	// FunctionNames are the names of the functions to keep warm
+	FunctionNames []string
+	// Concurrency is the number of concurrent invocations of each
+	// function, which is the number of instances kept warm. Defaults to 1.
+	Concurrency int64
+}
+
+// WarmupConfig is the declarative keep-warm configuration for a service.
+// Each schedule creates an EventBridge rule that triggers the
+// warmup dispatcher.
+type WarmupConfig struct {
+	// Schedules is the rule name -> schedule
+	Schedules map[string]*WarmupSchedule
+}
+
+// warmupRequest is the dispatcher's rule input
+type warmupRequest struct {
+	FunctionNames []string `json:"functionNames"`
+	Concurrency   int64    `json:"concurrency"`
+}
+
+// warmupResponse is the dispatcher's result
+type warmupResponse struct {
+	Invocations int `json:"invocations"`
+}
+
+// validateScheduleExpression ensures that the expression is a well
+// formed EventBridge schedule
+func validateScheduleExpression(expression string) error {
+	if matches := reWarmupRate.FindStringSubmatch(expression); matches != nil {
+		value, valueErr := strconv.Atoi(matches[1])
+		if valueErr != nil || value <= 0 {
+			return errors.Errorf("Schedule %s rate value must be a positive integer", expression)
+		}
+		// AWS requires the singular unit iff the value is 1
+		singular := !strings.HasSuffix(matches[2], "s")
+		if singular != (value == 1) {
+			return errors.Errorf("Schedule %s must use the singular unit only for a value of 1", expression)
+		}
+		return nil
+	}
+	if matches := reWarmupCron.FindStringSubmatch(expression); matches != nil {
+		fields := strings.Fields(matches[1])
+		if len(fields) != 6 {
+			return errors.Errorf("Schedule %s cron expression must have 6 fields, got %d",
+				expression,
+				len(fields))
+		}
+		// One of day-of-month or day-of-week must be ?
+		if (fields[2] == "?") == (fields[4] == "?") {
+			return errors.Errorf("Schedule %s cron expression must use ? for exactly one of day-of-month or day-of-week",
+				expression)
+		}
+		return nil
+	}
+	return errors.Errorf("Schedule %s must be a rate() or cron() expression", expression)
+}
+
+// validate ensures the schedules are well formed
+func (config *WarmupConfig) validate() error {
+	if len(config.Schedules) == 0 {
+		return errors.New("WarmupConfig must define at least one schedule")
+	}
+	var errorText []string
+	for eachName, eachSchedule := range config.Schedules {
+		if eachSchedule == nil {
+			errorText = append(errorText, fmt.Sprintf("Warmup schedule %s is nil", eachName))
+			continue
+		}
+		scheduleErr := validateScheduleExpression(eachSchedule.ScheduleExpression)
+		if scheduleErr != nil {
+			errorText = append(errorText,
+				fmt.Sprintf("Warmup schedule %s: %s", eachName, scheduleErr))
+		}
+		if len(eachSchedule.FunctionNames) == 0 {
+			errorText = append(errorText,
+				fmt.Sprintf("Warmup schedule %s must target at least one function", eachName))
+		}
+		if eachSchedule.Concurrency < 0 || eachSchedule.Concurrency > warmupMaxConcurrency {
+			errorText = append(errorText,
+				fmt.Sprintf("Warmup schedule %s Concurrency (%d) must be in the range [0, %d]",
+					eachName,
+					eachSchedule.Concurrency,
+					warmupMaxConcurrency))
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// validateFunctions ensures that every function referenced by a
+// schedule is defined by the service
+func (config *WarmupConfig) validateFunctions(lambdaAWSInfos []*LambdaAWSInfo) error {
+	functionNames := make(map[string]bool)
+	for _, eachLambda := range lambdaAWSInfos {
+		if eachLambda.warmupConfig == nil {
+			functionNames[eachLambda.lambdaFunctionName()] = true
+		}
+	}
+	var errorText []string
+	for eachName, eachSchedule := range config.Schedules {
+		for _, eachFunctionName := range eachSchedule.FunctionNames {
+			if !functionNames[eachFunctionName] {
+				errorText = append(errorText,
+					fmt.Sprintf("Warmup schedule %s references an undefined function: %s",
+						eachName,
+						eachFunctionName))
+			}
+		}
+	}
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return errors.New(strings.Join(errorText, "\n"))
+	}
+	return nil
+}
+
+// warmupFunctionArn returns the ARN of the function in this stack
+func warmupFunctionArn(functionName string) *gocf.StringExpr {
+	return gocf.Join("",
+		gocf.String("arn:aws:lambda:"),
+		gocf.Ref("AWS::Region"),
+		gocf.String(":"),
+		gocf.Ref("AWS::AccountId"),
+		gocf.String(":function:"),
+		gocf.Ref("AWS::StackName"),
+		gocf.String(functionNameDelimiter),
+		gocf.String(awsLambdaInternalName(functionName)))
+}
+
+// IsWarmupEvent returns true if the invocation was made by the warmup
+// dispatcher. Handlers should return immediately for warmup events.
+func IsWarmupEvent(ctx context.Context) bool {
+	lambdaContext, lambdaContextOk := lambdacontext.FromContext(ctx)
+	if !lambdaContextOk || lambdaContext == nil {
+		return false
+	}
+	return lambdaContext.ClientContext.Custom[warmupClientContextKey] == "true"
+}
+
+// warmupDispatch invokes each function in parallel with the warmup payload
+func warmupDispatch(ctx context.Context, request warmupRequest) (*warmupResponse, error) {
+	logger, _ := ctx.Value(ContextKeyLogger).(*logrus.Logger)
+	if logger == nil {
+		logger = logrus.New()
+	}
+	clientContext, clientContextErr := json.Marshal(map[string]interface{}{
+		"custom": map[string]string{
+			warmupClientContextKey: "true",
+		},
+	})
+	if clientContextErr != nil {
+		return nil, clientContextErr
+	}
+	concurrency := request.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	lambdaSvc := lambda.New(spartaAWS.NewSession(logger))
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errorText []string
+	invocations := 0
+	for _, eachFunctionName := range request.FunctionNames {
+		physicalName := awsLambdaFunctionName(eachFunctionName).String().Literal
+		for i := int64(0); i < concurrency; i++ {
+			wg.Add(1)
+			go func(functionName string) {
+				defer wg.Done()
+				_, invokeErr := lambdaSvc.InvokeWithContext(ctx, &lambda.InvokeInput{
+					FunctionName:   aws.String(functionName),
+					ClientContext:  aws.String(base64.StdEncoding.EncodeToString(clientContext)),
+					InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+					Payload:        WarmupPayload,
+				})
+				mutex.Lock()
+				defer mutex.Unlock()
+				if invokeErr != nil {
+					errorText = append(errorText,
+						fmt.Sprintf("%s: %s", functionName, invokeErr))
+					return
+				}
+				invocations++
+			}(physicalName)
+		}
+	}
+	wg.Wait()
+	logger.WithFields(logrus.Fields{
+		"Functions":   request.FunctionNames,
+		"Invocations": invocations,
+		"Errors":      len(errorText),
+	}).Info("Warmup dispatch complete")
+	if len(errorText) != 0 {
+		sort.Strings(errorText)
+		return nil, errors.Errorf("Failed to warm functions:\n%s",
+			strings.Join(errorText, "\n"))
+	}
+	return &warmupResponse{Invocations: invocations}, nil
+}
+
+// NewWarmupDispatcher returns the function that keeps the functions
+// named by the config warm. Include the returned function in the
+// service's function list. Each schedule creates an EventBridge rule that
+// triggers the dispatcher, which then invokes the schedule's functions
+// in parallel. Targeted functions can use IsWarmupEvent to return early.
+func NewWarmupDispatcher(config *WarmupConfig) (*LambdaAWSInfo, error) {
+	if config == nil {
+		return nil, errors.New("WarmupConfig must not be nil")
+	}
+	validateErr := config.validate()
+	if validateErr != nil {
+		return nil, errors.Wrapf(validateErr, "Invalid WarmupConfig")
+	}
+	uniqueFunctionNames := make(map[string]bool)
+	rules := make(map[string]CloudWatchEventsRule)
+	for eachName, eachSchedule := range config.Schedules {
+		for _, eachFunctionName := range eachSchedule.FunctionNames {
+			uniqueFunctionNames[eachFunctionName] = true
+		}
+		ruleInput, ruleInputErr := json.Marshal(warmupRequest{
+			FunctionNames: eachSchedule.FunctionNames,
+			Concurrency:   eachSchedule.Concurrency,
+		})
+		if ruleInputErr != nil {
+			return nil, ruleInputErr
+		}
+		rules[eachName] = CloudWatchEventsRule{
+			Description:        fmt.Sprintf("Sparta warmup schedule: %s", eachName),
+			ScheduleExpression: eachSchedule.ScheduleExpression,
+			RuleTarget: &CloudWatchEventsRuleTarget{
+				Input: string(ruleInput),
+			},
+		}
+	}
+	// Sort the names s.t. the privileges are stable
+	functionNames := make([]string, 0, len(uniqueFunctionNames))
+	for eachName := range uniqueFunctionNames {
+		functionNames = append(functionNames, eachName)
+	}
+	sort.Strings(functionNames)
+	privileges := make([]IAMRolePrivilege, 0, len(functionNames))
+	for _, eachName := range functionNames {
+		privileges = append(privileges, IAMRolePrivilege{
+			Actions:  []string{"lambda:InvokeFunction"},
+			Resource: warmupFunctionArn(eachName),
+		})
+	}
+	dispatcher, dispatcherErr := NewAWSLambda(WarmupDispatcherName,
+		warmupDispatch,
+		IAMRoleDefinition{
+			Privileges: privileges,
+		})
+	if dispatcherErr != nil {
+		return nil, dispatcherErr
+	}
+	dispatcher.Options.Timeout = warmupDispatcherTimeout
+	dispatcher.Permissions = append(dispatcher.Permissions, CloudWatchEventsPermission{
+		Rules: rules,
+	})
+	dispatcher.warmupConfig = config
+	return dispatcher, nil
+}
@@ -0,0 +1,116 @@
+package zip
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// excludePattern is a single compiled exclude pattern
+type excludePattern struct {
+	negate  bool
+	dirOnly bool
+	matcher *regexp.Regexp
+}
+
+// ExcludeMatcher evaluates gitignore-style exclude patterns against slash
+// separated paths relative to the archive source root. As with gitignore:
+//
+//   - Blank lines and lines that begin with # are ignored
+//   - A leading ! re-includes paths excluded by an earlier pattern
+//   - A trailing / only matches directories
+//   - Patterns without a leading or middle / match at any depth
+//   - * and ? don't match /, while ** matches across directories
+//
+// The last matching pattern wins. Files in an excluded directory can't
+// be re-included, since the directory isn't walked.
+type ExcludeMatcher struct {
+	patterns []*excludePattern
+}
+
+// globRegexp returns the regular expression source for the glob
+func globRegexp(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		eachChar := glob[i]
+		switch {
+		case eachChar == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			i++
+			if i+1 < len(glob) && glob[i+1] == '/' {
+				i++
+				expr.WriteString("(.*/)?")
+			} else {
+				expr.WriteString(".*")
+			}
+		case eachChar == '*':
+			expr.WriteString("[^/]*")
+		case eachChar == '?':
+			expr.WriteString("[^/]")
+		case eachChar == '[' && strings.Contains(glob[i+1:], "]"):
+			classEnd := i + 1 + strings.Index(glob[i+1:], "]")
+			class := glob[i+1 : classEnd]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i = classEnd
+		case eachChar == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(eachChar)))
+		}
+	}
+	return expr.String()
+}
+
+// NewExcludeMatcher returns an ExcludeMatcher for the gitignore-style
+// patterns
+func NewExcludeMatcher(patterns []string) (*ExcludeMatcher, error) {
+	matcher := &ExcludeMatcher{}
+	for _, eachPattern := range patterns {
+		pattern := strings.TrimSpace(eachPattern)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		compiled := &excludePattern{}
+		if strings.HasPrefix(pattern, "!") {
+			compiled.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			compiled.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		// Patterns with a leading or middle separator are relative
+		// to the root, others match at any depth
+		prefix := "^(.*/)?"
+		if strings.Contains(pattern, "/") {
+			prefix = "^"
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		expr, exprErr := regexp.Compile(prefix + globRegexp(pattern) + "$")
+		if exprErr != nil {
+			return nil, errors.Wrapf(exprErr, "Invalid exclude pattern: %s", eachPattern)
+		}
+		compiled.matcher = expr
+		matcher.patterns = append(matcher.patterns, compiled)
+	}
+	return matcher, nil
+}
+
+// Excluded returns true if the slash separated relativePath should be
+// excluded from the archive
+func (matcher *ExcludeMatcher) Excluded(relativePath string, isDir bool) bool {
+	excluded := false
+	for _, eachPattern := range matcher.patterns {
+		if eachPattern.dirOnly && !isDir {
+			continue
+		}
+		if eachPattern.matcher.MatchString(relativePath) {
+			excluded = !eachPattern.negate
+		}
+	}
+	return excluded
+}
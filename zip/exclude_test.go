@@ -0,0 +1,48 @@
+package zip
+
+import (
+	"testing"
+)
+
+func TestExcludeMatcher(t *testing.T) {
+	matcher, matcherErr := NewExcludeMatcher([]string{
+		"# Editor and OS files",
+		".DS_Store",
+		"node_modules/",
+		"*.map",
+		"!vendor.js.map",
+		"/build/**/*.tmp",
+		"docs/[!r]*.md",
+	})
+	if matcherErr != nil {
+		t.Fatalf("Failed to create matcher: %s", matcherErr)
+	}
+	testCases := []struct {
+		path     string
+		isDir    bool
+		excluded bool
+	}{
+		{".DS_Store", false, true},
+		{"assets/img/.DS_Store", false, true},
+		{"node_modules", true, true},
+		{"assets/node_modules", true, true},
+		{"node_modules", false, false},
+		{"app.js.map", false, true},
+		{"js/app.js.map", false, true},
+		{"js/vendor.js.map", false, false},
+		{"app.js", false, false},
+		{"build/a.tmp", false, true},
+		{"build/nested/dir/a.tmp", false, true},
+		{"src/build/a.tmp", false, false},
+		{"docs/intro.md", false, true},
+		{"docs/readme.md", false, false},
+	}
+	for _, eachCase := range testCases {
+		if matcher.Excluded(eachCase.path, eachCase.isDir) != eachCase.excluded {
+			t.Errorf("Unexpected exclusion for %s (dir: %t). Expected: %t",
+				eachCase.path,
+				eachCase.isDir,
+				eachCase.excluded)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -16,6 +17,28 @@ import (
 // file being added to allow it to customize the ZIP archive values
 type FileHeaderAnnotator func(header *zip.FileHeader) (*zip.FileHeader, error)
 
+// ReproducibleModTime is the modification time of every entry in a
+// reproducible archive. It's the earliest MS-DOS timestamp.
+var ReproducibleModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ReproducibleAnnotator returns a FileHeaderAnnotator that applies the
+// optional annotator and then pins the entry modification time to
+// ReproducibleModTime. Together with the lexical order of the directory
+// walk, archives of identical inputs are byte-identical.
+func ReproducibleAnnotator(annotator FileHeaderAnnotator) FileHeaderAnnotator {
+	return func(header *zip.FileHeader) (*zip.FileHeader, error) {
+		if annotator != nil {
+			annotatedHeader, annotatedHeaderErr := annotator(header)
+			if annotatedHeaderErr != nil {
+				return nil, annotatedHeaderErr
+			}
+			header = annotatedHeader
+		}
+		header.Modified = ReproducibleModTime
+		return header, nil
+	}
+}
+
 // AnnotateAddToZip is an extended Zip writer that accepts an annotation function
 // to customize the FileHeader values written into the archive
 func AnnotateAddToZip(zipWriter *zip.Writer,
@@ -23,84 +46,137 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 	rootSource string,
 	annotator FileHeaderAnnotator,
 	logger *logrus.Logger) error {
+	return AnnotateAddToZipWithExcludes(zipWriter,
+		source,
+		rootSource,
+		annotator,
+		nil,
+		logger)
+}
 
-	linuxZipName := func(platformValue string) string {
-		return strings.Replace(platformValue, "\\", "/", -1)
-	}
+// AnnotateAddToZipWithExcludes is AnnotateAddToZip for a directory source
+// that skips the entries matching the gitignore-style excludes. The
+// patterns are evaluated against the path relative to the source.
+func AnnotateAddToZipWithExcludes(zipWriter *zip.Writer,
+	source string,
+	rootSource string,
+	annotator FileHeaderAnnotator,
+	excludes []string,
+	logger *logrus.Logger) error {
 
-	fullPathSource, err := filepath.Abs(source)
-	if nil != err {
-		return errors.Wrapf(err, "Failed to get absolute filepath")
+	excludeMatcher, excludeMatcherErr := NewExcludeMatcher(excludes)
+	if excludeMatcherErr != nil {
+		return excludeMatcherErr
 	}
-
-	appendFile := func(info os.FileInfo) error {
-		zipEntryName := info.Name()
-		if rootSource != "" {
-			zipEntryName = fmt.Sprintf("%s/%s", linuxZipName(rootSource), info.Name())
-		}
-		// Create a header for this zipFile, basically let's see
-		// if we can get the executable bits to travel along..
-		fileHeader, fileHeaderErr := zip.FileInfoHeader(info)
-		if fileHeaderErr != nil {
-			return fileHeaderErr
-		}
-		// Update the name to the proper thing...
-		fileHeader.Name = zipEntryName
-		if annotator != nil {
-			annotatedHeader, annotatedHeaderErr := annotator(fileHeader)
-			if annotatedHeaderErr != nil {
-				return errors.Wrapf(annotatedHeaderErr, "Failed to annotate Zip entry file header")
+	entryName := func(walkPath string, relativePath string, info os.FileInfo) string {
+		// A single file is named relative to the rootSource directory
+		if relativePath == "." && !info.IsDir() {
+			if rootSource == "" {
+				return info.Name()
 			}
-			fileHeader = annotatedHeader
+			return fmt.Sprintf("%s/%s", filepath.ToSlash(rootSource), info.Name())
 		}
+		platformName := strings.TrimPrefix(strings.TrimPrefix(walkPath, rootSource),
+			string(os.PathSeparator))
+		return filepath.ToSlash(platformName)
+	}
+	return addToZip(zipWriter, source, entryName, annotator, excludeMatcher, logger)
+}
 
-		logger.WithFields(logrus.Fields{
-			"Source": info.Name(),
-			"Header": fileHeader,
-		}).Debug("Adding ZIP")
+// AnnotateAddToZipAs adds the source file, or the directory contents
+// recursively, to the archive at the archivePath. Directory entries are
+// named relative to archivePath, which uses forward slashes. An empty
+// archivePath adds a file by its name or the directory contents
+// to the archive root.
+func AnnotateAddToZipAs(zipWriter *zip.Writer,
+	source string,
+	archivePath string,
+	annotator FileHeaderAnnotator,
+	logger *logrus.Logger) error {
 
-		// File info for the binary executable
-		binaryWriter, binaryWriterErr := zipWriter.CreateHeader(fileHeader)
-		if binaryWriterErr != nil {
-			return binaryWriterErr
-		}
-		/* #nosec */
-		reader, readerErr := os.Open(fullPathSource)
-		if readerErr != nil {
-			return readerErr
-		}
-		written, copyErr := io.Copy(binaryWriter, reader)
-		errClose := reader.Close()
-		if errClose != nil {
-			logger.WithFields(logrus.Fields{
-				"Error": errClose,
-			}).Warn("Failed to close Zip input stream")
+	archivePath = strings.Trim(filepath.ToSlash(archivePath), "/")
+	entryName := func(walkPath string, relativePath string, info os.FileInfo) string {
+		if relativePath == "." {
+			if !info.IsDir() && archivePath == "" {
+				return info.Name()
+			}
+			return archivePath
 		}
-		logger.WithFields(logrus.Fields{
-			"WrittenBytes": written,
-			"SourcePath":   fullPathSource,
-			"ZipName":      zipEntryName,
-		}).Debug("Archiving file")
-		return copyErr
+		return strings.TrimPrefix(fmt.Sprintf("%s/%s",
+			archivePath,
+			filepath.ToSlash(relativePath)), "/")
 	}
+	return addToZip(zipWriter, source, entryName, annotator, nil, logger)
+}
 
-	directoryWalker := func(path string, info os.FileInfo, err error) error {
+// zipEntryNamer returns the slash separated archive name of the walked
+// path. The relativePath is "." for the source itself.
+type zipEntryNamer func(walkPath string, relativePath string, info os.FileInfo) string
+
+// addToZip walks the source file or directory and adds each entry that
+// isn't excluded to the archive. Files are compressed. Directories get
+// a trailing slash entry, except for an empty name which is the
+// archive root.
+func addToZip(zipWriter *zip.Writer,
+	source string,
+	entryName zipEntryNamer,
+	annotator FileHeaderAnnotator,
+	excludeMatcher *ExcludeMatcher,
+	logger *logrus.Logger) error {
+
+	fullPathSource, err := filepath.Abs(source)
+	if nil != err {
+		return errors.Wrapf(err, "Failed to get absolute filepath")
+	}
+	fileInfo, err := os.Stat(fullPathSource)
+	if nil != err {
+		return errors.Wrapf(err, "Failed to get file information")
+	}
+	if !fileInfo.IsDir() && !fileInfo.Mode().IsRegular() {
+		return errors.Errorf("Invalid source type for ZIP entry: %s", source)
+	}
+
+	walker := func(eachPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		relativePath, relativePathErr := filepath.Rel(fullPathSource, eachPath)
+		if relativePathErr != nil {
+			return relativePathErr
+		}
+		if relativePath != "." &&
+			excludeMatcher != nil &&
+			excludeMatcher.Excluded(filepath.ToSlash(relativePath), info.IsDir()) {
+			logger.WithFields(logrus.Fields{
+				"Path": relativePath,
+			}).Debug("Excluding path from ZIP")
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Create a header for this entry, basically let's see
+		// if we can get the executable bits to travel along..
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to create FileInfoHeader")
 		}
-		// Normalize the Name
-		platformName := strings.TrimPrefix(strings.TrimPrefix(path, rootSource), string(os.PathSeparator))
-		header.Name = linuxZipName(platformName)
-
+		header.Name = entryName(eachPath, relativePath, info)
 		if info.IsDir() {
+			// The archive root doesn't need an entry
+			if header.Name == "" {
+				return nil
+			}
 			header.Name += "/"
 		} else {
 			header.Method = zip.Deflate
 		}
+		if annotator != nil {
+			header, err = annotator(header)
+			if err != nil {
+				return errors.Wrapf(err, "Failed to annotate Zip entry file header")
+			}
+		}
 		writer, err := zipWriter.CreateHeader(header)
 		if err != nil {
 			return err
@@ -109,42 +185,30 @@ func AnnotateAddToZip(zipWriter *zip.Writer,
 			return nil
 		}
 		/* #nosec */
-		file, err := os.Open(path)
+		file, err := os.Open(eachPath)
 		if err != nil {
-			return errors.Wrapf(err, "Failed to open file: %s", path)
+			return errors.Wrapf(err, "Failed to open file: %s", eachPath)
 		}
 		defer func() {
 			closeErr := file.Close()
 			if closeErr != nil {
 				logger.WithFields(logrus.Fields{
-					"error": closeErr,
-				}).Warn("Failed to close zip writer")
+					"Error": closeErr,
+				}).Warn("Failed to close Zip input stream")
 			}
 		}()
-
-		_, err = io.Copy(writer, file)
+		written, err := io.Copy(writer, file)
 		if err != nil {
 			return errors.Wrapf(err, "Failed to copy file contents")
 		}
+		logger.WithFields(logrus.Fields{
+			"WrittenBytes": written,
+			"SourcePath":   eachPath,
+			"ZipName":      header.Name,
+		}).Debug("Archiving file")
 		return nil
 	}
-
-	fileInfo, err := os.Stat(fullPathSource)
-	if nil != err {
-		return errors.Wrapf(err, "Failed to get file information")
-	}
-	switch mode := fileInfo.Mode(); {
-	case mode.IsDir():
-		err = filepath.Walk(fullPathSource, directoryWalker)
-	case mode.IsRegular():
-		err = appendFile(fileInfo)
-	default:
-		err = errors.New("Inavlid source type")
-	}
-	if err != nil {
-		return errors.Wrapf(err, "Failed to determine file mode")
-	}
-	return nil
+	return filepath.Walk(fullPathSource, walker)
 }
 
 // AddToZip creates a source object (either a file, or a directory that will be recursively
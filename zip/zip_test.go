@@ -0,0 +1,190 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReproducibleAnnotator(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "reproducible")
+	if sourceDirErr != nil {
+		t.Fatalf("Failed to create source directory: %s", sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	for _, eachName := range []string{"b.txt", "a.txt"} {
+		writeErr := ioutil.WriteFile(filepath.Join(sourceDir, eachName), []byte(eachName), 0644)
+		if writeErr != nil {
+			t.Fatalf("Failed to write %s: %s", eachName, writeErr)
+		}
+	}
+	archive := func() []byte {
+		var buffer bytes.Buffer
+		zipWriter := zip.NewWriter(&buffer)
+		addErr := AnnotateAddToZip(zipWriter,
+			sourceDir,
+			sourceDir,
+			ReproducibleAnnotator(nil),
+			logrus.New())
+		if addErr != nil {
+			t.Fatalf("Failed to create archive: %s", addErr)
+		}
+		closeErr := zipWriter.Close()
+		if closeErr != nil {
+			t.Fatalf("Failed to close archive: %s", closeErr)
+		}
+		return buffer.Bytes()
+	}
+	firstArchive := archive()
+	modTime := time.Now().Add(time.Hour)
+	chtimesErr := os.Chtimes(filepath.Join(sourceDir, "a.txt"), modTime, modTime)
+	if chtimesErr != nil {
+		t.Fatalf("Failed to update modification time: %s", chtimesErr)
+	}
+	secondArchive := archive()
+	if !bytes.Equal(firstArchive, secondArchive) {
+		t.Fatalf("Expected byte-identical archives")
+	}
+}
+
+func TestAnnotateAddToZipAs(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "addas")
+	if sourceDirErr != nil {
+		t.Fatalf("Failed to create source directory: %s", sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	mkdirErr := os.MkdirAll(filepath.Join(sourceDir, "html"), 0755)
+	if mkdirErr != nil {
+		t.Fatalf("Failed to create directory: %s", mkdirErr)
+	}
+	for _, eachName := range []string{"index.tmpl", filepath.Join("html", "page.tmpl")} {
+		writeErr := ioutil.WriteFile(filepath.Join(sourceDir, eachName), []byte(eachName), 0644)
+		if writeErr != nil {
+			t.Fatalf("Failed to write %s: %s", eachName, writeErr)
+		}
+	}
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	logger := logrus.New()
+	addErr := AnnotateAddToZipAs(zipWriter, sourceDir, "templates", nil, logger)
+	if addErr == nil {
+		addErr = AnnotateAddToZipAs(zipWriter,
+			filepath.Join(sourceDir, "index.tmpl"),
+			"",
+			nil,
+			logger)
+	}
+	if addErr != nil {
+		t.Fatalf("Failed to add to archive: %s", addErr)
+	}
+	zipWriter.Close()
+
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatalf("Failed to read archive: %s", zipReaderErr)
+	}
+	names := []string{}
+	for _, eachFile := range zipReader.File {
+		names = append(names, eachFile.Name)
+	}
+	expected := []string{"templates/",
+		"templates/html/",
+		"templates/html/page.tmpl",
+		"templates/index.tmpl",
+		"index.tmpl"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Unexpected archive entries: %v", names)
+	}
+}
+
+func TestAnnotateAddToZipWithExcludes(t *testing.T) {
+	sourceDir, sourceDirErr := ioutil.TempDir("", "excludes")
+	if sourceDirErr != nil {
+		t.Fatalf("Failed to create source directory: %s", sourceDirErr)
+	}
+	defer os.RemoveAll(sourceDir)
+	for _, eachName := range []string{
+		"index.html",
+		"js/app.js",
+		"js/app.js.map",
+		"js/vendor.js.map",
+		"js/node_modules/lib/index.js",
+	} {
+		sourcePath := filepath.Join(sourceDir, filepath.FromSlash(eachName))
+		mkdirErr := os.MkdirAll(filepath.Dir(sourcePath), 0755)
+		if mkdirErr != nil {
+			t.Fatalf("Failed to create directory: %s", mkdirErr)
+		}
+		writeErr := ioutil.WriteFile(sourcePath, []byte(eachName), 0644)
+		if writeErr != nil {
+			t.Fatalf("Failed to write %s: %s", eachName, writeErr)
+		}
+	}
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	addErr := AnnotateAddToZipWithExcludes(zipWriter,
+		sourceDir,
+		sourceDir,
+		nil,
+		[]string{"node_modules/", "*.map", "!vendor.js.map"},
+		logrus.New())
+	if addErr != nil {
+		t.Fatalf("Failed to add to archive: %s", addErr)
+	}
+	zipWriter.Close()
+
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatalf("Failed to read archive: %s", zipReaderErr)
+	}
+	names := []string{}
+	for _, eachFile := range zipReader.File {
+		if !eachFile.FileInfo().IsDir() {
+			names = append(names, eachFile.Name)
+		}
+	}
+	expected := []string{"index.html", "js/app.js", "js/vendor.js.map"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Unexpected archive entries: %v", names)
+	}
+}
+
+func TestAnnotateAddToZipFile(t *testing.T) {
+	sourceFile, sourceFileErr := ioutil.TempFile("", "binary")
+	if sourceFileErr != nil {
+		t.Fatalf("Failed to create source file: %s", sourceFileErr)
+	}
+	sourceFile.Write([]byte("binary"))
+	sourceFile.Close()
+	defer os.Remove(sourceFile.Name())
+
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	logger := logrus.New()
+	addErr := AnnotateAddToZip(zipWriter, sourceFile.Name(), "", nil, logger)
+	if addErr == nil {
+		addErr = AnnotateAddToZip(zipWriter, sourceFile.Name(), "bin", nil, logger)
+	}
+	if addErr != nil {
+		t.Fatalf("Failed to add to archive: %s", addErr)
+	}
+	zipWriter.Close()
+
+	zipReader, zipReaderErr := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if zipReaderErr != nil {
+		t.Fatalf("Failed to read archive: %s", zipReaderErr)
+	}
+	baseName := filepath.Base(sourceFile.Name())
+	if len(zipReader.File) != 2 ||
+		zipReader.File[0].Name != baseName ||
+		zipReader.File[1].Name != "bin/"+baseName {
+		t.Fatalf("Unexpected archive entries: %#v", zipReader.File)
+	}
+}